@@ -26,4 +26,5 @@ func Execute() {
 
 func init() {
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	rootCmd.PersistentFlags().String("node", os.Getenv("BUDGIE_NODE"), "Act on another node: a node ID or host[:port] (default $BUDGIE_NODE)")
 }
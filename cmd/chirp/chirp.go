@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"net"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
@@ -13,9 +13,14 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/zarigata/budgie/internal/api"
+	"github.com/zarigata/budgie/internal/cmdutil"
+	"github.com/zarigata/budgie/internal/config"
 	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/internal/node"
+	"github.com/zarigata/budgie/internal/placement"
 	"github.com/zarigata/budgie/internal/runtime"
 	budgiesync "github.com/zarigata/budgie/internal/sync"
+	"github.com/zarigata/budgie/internal/ui"
 	"github.com/zarigata/budgie/pkg/types"
 )
 
@@ -90,6 +95,7 @@ func listContainers() error {
 var (
 	syncVolumes bool
 	dryRun      bool
+	bwLimit     int
 )
 
 func joinContainer(containerID string) error {
@@ -150,24 +156,23 @@ func joinContainer(containerID string) error {
 		return fmt.Errorf("failed to create manager: %w", err)
 	}
 
-	// Step 3: Create replica container configuration
-	fmt.Println("\n[3/5] Creating replica container...")
+	if node.IsCordoned(dataDir) {
+		return fmt.Errorf("this node is cordoned; run \"budgie node uncordon\" first")
+	}
 
-	// Create local volume directories
-	localVolumePath := filepath.Join(dataDir, "volumes", target.ID[:12])
-	if err := os.MkdirAll(localVolumePath, 0755); err != nil {
-		return fmt.Errorf("failed to create volume directory: %w", err)
+	labels := node.Labels(config.Get().Node.Labels)
+	if err := placement.Check(target.Placement, labels, manager.RunningServices()); err != nil {
+		return fmt.Errorf("cannot join %s on this node: %w", target.Name, err)
 	}
 
-	hostname, _ := os.Hostname()
+	// Step 3: Create replica container configuration
+	fmt.Println("\n[3/5] Creating replica container...")
 
-	replica := &types.Container{
-		ID:        types.GenerateContainerID(),
-		Name:      fmt.Sprintf("%s-replica", target.Name),
-		State:     types.StateCreating,
-		Image: types.ImageConfig{
-			DockerImage: target.Image,
-		},
+	replica, err := node.NewReplica(node.ReplicaSpec{
+		PrimaryID:   target.ID,
+		PrimaryNode: target.NodeID,
+		Name:        target.Name,
+		Image:       types.ImageConfig{DockerImage: target.Image},
 		Ports: []types.PortMapping{
 			{
 				ContainerPort: target.Port,
@@ -175,17 +180,14 @@ func joinContainer(containerID string) error {
 				Protocol:      "tcp",
 			},
 		},
-		Volumes: []types.VolumeMapping{
-			{
-				Source: localVolumePath,
-				Target: "/data",
-				Mode:   "rw",
-			},
-		},
-		NodeID:    hostname,
-		Peers:     []string{target.NodeID},
-		CreatedAt: time.Now(),
+		Placement:  target.Placement,
+		ReplicaSet: target.ReplicaSetID(),
+		Epoch:      target.Epoch,
+	}, dataDir, node.LocalID())
+	if err != nil {
+		return err
 	}
+	localVolumePath := replica.Volumes[0].Source
 
 	fmt.Printf("    Replica ID: %s\n", replica.ShortID())
 
@@ -201,25 +203,11 @@ func joinContainer(containerID string) error {
 	// Step 5: Sync volumes if enabled
 	if syncVolumes {
 		fmt.Println("\n[5/5] Syncing volumes from primary...")
-		syncAddr := fmt.Sprintf("%s:%d", remoteIP, budgiesync.DefaultSyncPort)
-
-		conn, err := net.DialTimeout("tcp", syncAddr, 10*time.Second)
-		if err != nil {
-			fmt.Printf("    Warning: Could not connect to sync server at %s: %v\n", syncAddr, err)
-			fmt.Println("    Volume sync skipped. Container created without data.")
+		if err := pullVolume(target.ID, remoteIP, localVolumePath); err != nil {
+			fmt.Printf("    Warning: Volume sync failed: %v\n", err)
+			fmt.Println("    Container created without data.")
 		} else {
-			defer conn.Close()
-
-			syncMgr, err := budgiesync.NewSyncManager(localVolumePath)
-			if err != nil {
-				fmt.Printf("    Warning: Failed to create sync manager: %v\n", err)
-			} else {
-				if err := syncMgr.ReceiveVolume(conn); err != nil {
-					fmt.Printf("    Warning: Volume sync failed: %v\n", err)
-				} else {
-					fmt.Println("    Volume data synchronized successfully")
-				}
-			}
+			fmt.Println("    Volume data synchronized successfully")
 		}
 	} else {
 		fmt.Println("\n[5/5] Volume sync skipped (use --sync to enable)")
@@ -232,6 +220,7 @@ func joinContainer(containerID string) error {
 	}
 
 	// Announce replica on network
+	disc.SetNodeLabels(labels)
 	if err := disc.AnnounceContainer(replica); err != nil {
 		fmt.Printf("Warning: Failed to announce replica: %v\n", err)
 	}
@@ -244,6 +233,86 @@ func joinContainer(containerID string) error {
 	return nil
 }
 
+// pullVolume copies the primary's volume into the replica's, within the
+// bandwidth limit and showing the progress of the transfer
+func pullVolume(primaryID, remoteIP, localPath string) error {
+	cfg := config.Get()
+	client, err := budgiesync.NewTLSClient(cmdutil.SyncTLSConfig(cfg))
+	if err != nil {
+		return err
+	}
+	addr := net.JoinHostPort(remoteIP, strconv.Itoa(cfg.SyncPort))
+	conn, err := client.Dial(addr, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("could not connect to sync server at %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	mgr, err := budgiesync.NewSyncManager(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to create sync manager: %w", err)
+	}
+	mgr.SetNodeID(node.LocalID())
+	limit := cfg.Sync.MaxBandwidth
+	if bwLimit > 0 {
+		limit = bwLimit
+	}
+	mgr.SetLimiter(budgiesync.NewLimiter(int64(limit) << 10))
+
+	view := &progressView{description: "    Volume data"}
+	mgr.SetProgress(view.update)
+	err = mgr.PullVolume(conn, primaryID, "")
+	view.finish()
+	return err
+}
+
+// progressView shows a transfer's progress, redrawing it in place on a
+// terminal and only once it is done otherwise
+type progressView struct {
+	description string
+	progress    *ui.TransferProgress
+	start       time.Time
+	drawn       time.Time
+	lines       int
+}
+
+func (p *progressView) update(done, total int64) {
+	if total == 0 {
+		return
+	}
+	if p.progress == nil {
+		progress := ui.NewTransferProgress(p.description, total)
+		p.progress, p.start = &progress, time.Now()
+	}
+	p.progress.Update(done, time.Since(p.start).Milliseconds())
+
+	if isTerminal() && time.Since(p.drawn) >= 200*time.Millisecond {
+		p.draw()
+	}
+}
+
+// finish draws the final progress
+func (p *progressView) finish() {
+	if p.progress != nil {
+		p.draw()
+	}
+}
+
+func (p *progressView) draw() {
+	if p.lines > 0 {
+		fmt.Printf("\033[%dA\033[J", p.lines)
+	}
+	view := p.progress.View()
+	fmt.Println(view)
+	p.lines = strings.Count(view, "\n") + 1
+	p.drawn = time.Now()
+}
+
+func isTerminal() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 func GetChirpCmd() *cobra.Command {
 	return chirpCmd
 }
@@ -252,4 +321,5 @@ func init() {
 	chirpCmd.Aliases = []string{"discover", "join"}
 	chirpCmd.Flags().BoolVarP(&syncVolumes, "sync", "s", false, "Sync volumes from primary node")
 	chirpCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be done without making changes")
+	chirpCmd.Flags().IntVar(&bwLimit, "bwlimit", 0, "Limit volume sync bandwidth in KiB/s (default sync.max_bandwidth)")
 }
@@ -8,6 +8,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/zarigata/budgie/internal/cmdutil"
+	"github.com/zarigata/budgie/internal/node"
 	"github.com/zarigata/budgie/internal/runtime"
 )
 
@@ -29,7 +30,8 @@ Use -i for interactive mode (keeps STDIN open).
 Use -t to allocate a pseudo-TTY.
 Use -u to specify the user to run as.
 Use -w to specify the working directory.
-Use -e to set environment variables.`,
+Use -e to set environment variables.
+Use --node to run the command on another node (without -i, -t or -d).`,
 	Args: cobra.MinimumNArgs(2),
 	RunE: execCommand,
 }
@@ -38,6 +40,14 @@ func execCommand(cmd *cobra.Command, args []string) error {
 	containerID := args[0]
 	execArgs := args[1:]
 
+	client, err := cmdutil.RemoteNode(cmd)
+	if err != nil {
+		return err
+	}
+	if client != nil {
+		return execRemote(cmd, client, containerID, execArgs)
+	}
+
 	// Initialize command context
 	cmdCtx, err := cmdutil.NewCommandContext()
 	if err != nil {
@@ -81,6 +91,31 @@ func execCommand(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// execRemote runs the command on the node named by --node. Only
+// non-interactive commands are supported there.
+func execRemote(cmd *cobra.Command, client *node.Client, containerID string, execArgs []string) error {
+	if interactive || tty || detach {
+		return fmt.Errorf("-i, -t and -d are not supported with --node")
+	}
+
+	req := node.ExecRequest{
+		Cmd:     execArgs,
+		User:    user,
+		WorkDir: workdir,
+		Env:     envVars,
+	}
+	exitCode, err := client.Exec(context.Background(), containerID, req, os.Stdout)
+	if err != nil {
+		return fmt.Errorf("exec on %s failed: %w", cmdutil.NodeName(cmd), err)
+	}
+
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+
+	return nil
+}
+
 func GetExecCmd() *cobra.Command {
 	return execCmd
 }
@@ -1,6 +1,7 @@
 package inspect
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -24,6 +25,7 @@ var inspectCmd = &cobra.Command{
 
 You can specify multiple container IDs to inspect.
 Use --format to extract specific fields using Go templates.
+Use --node to inspect containers on another node.
 
 Examples:
   budgie inspect mycontainer
@@ -35,8 +37,7 @@ Examples:
 }
 
 func inspectContainers(cmd *cobra.Command, args []string) error {
-	// Initialize command context
-	cmdCtx, err := cmdutil.NewCommandContext()
+	find, err := containerFinder(cmd)
 	if err != nil {
 		return err
 	}
@@ -45,7 +46,7 @@ func inspectContainers(cmd *cobra.Command, args []string) error {
 	var errors []string
 
 	for _, idOrName := range args {
-		ctr, err := cmdutil.FindContainer(cmdCtx.Manager, idOrName)
+		ctr, err := find(idOrName)
 		if err != nil {
 			errors = append(errors, fmt.Sprintf("%s: %v", idOrName, err))
 			continue
@@ -194,6 +195,26 @@ func formatPortBindingsForInspect(ports []types.PortMapping) map[string]interfac
 	return result
 }
 
+// containerFinder looks containers up on the node named by --node, or on
+// this machine
+func containerFinder(cmd *cobra.Command) (func(string) (*types.Container, error), error) {
+	client, err := cmdutil.RemoteNode(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if client != nil {
+		return func(idOrName string) (*types.Container, error) {
+			return client.Inspect(context.Background(), idOrName)
+		}, nil
+	}
+
+	cmdCtx, err := cmdutil.NewCommandContext()
+	if err != nil {
+		return nil, err
+	}
+	return cmdCtx.Manager.Find, nil
+}
+
 func GetInspectCmd() *cobra.Command {
 	return inspectCmd
 }
@@ -26,26 +26,14 @@ var logsCmd = &cobra.Command{
 	Long: `Fetch the logs of a container.
 
 Use --follow to stream logs in real-time.
-Use --tail to show only the last N lines.`,
+Use --tail to show only the last N lines.
+Use --node to fetch logs from another node.`,
 	Args: cobra.ExactArgs(1),
 	RunE: fetchLogs,
 }
 
 func fetchLogs(cmd *cobra.Command, args []string) error {
 	containerID := args[0]
-
-	// Initialize command context
-	cmdCtx, err := cmdutil.NewCommandContext()
-	if err != nil {
-		return err
-	}
-
-	// Find container by ID prefix or name
-	ctr, err := cmdutil.FindContainer(cmdCtx.Manager, containerID)
-	if err != nil {
-		return err
-	}
-
 	ctx := context.Background()
 
 	// Parse --since flag if provided
@@ -59,15 +47,15 @@ func fetchLogs(cmd *cobra.Command, args []string) error {
 	}
 
 	// Get logs reader
-	reader, err := cmdCtx.Runtime.Logs(ctx, ctr.ID, follow, tail)
+	reader, shortID, err := openLogs(cmd, ctx, containerID)
 	if err != nil {
-		return fmt.Errorf("failed to get logs: %w", err)
+		return err
 	}
 	defer reader.Close()
 
 	// Stream logs to stdout
 	if follow {
-		fmt.Fprintf(os.Stderr, "Streaming logs for %s (Ctrl+C to stop)...\n", ctr.ShortID())
+		fmt.Fprintf(os.Stderr, "Streaming logs for %s (Ctrl+C to stop)...\n", shortID)
 	}
 
 	// Use buffered reader for line-by-line processing if timestamps or since is set
@@ -97,6 +85,40 @@ func fetchLogs(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// openLogs opens the logs of a container on the node named by --node, or on
+// this machine, returning them with a short name for the container
+func openLogs(cmd *cobra.Command, ctx context.Context, containerID string) (io.ReadCloser, string, error) {
+	client, err := cmdutil.RemoteNode(cmd)
+	if err != nil {
+		return nil, "", err
+	}
+	if client != nil {
+		reader, err := client.Logs(ctx, containerID, follow, tail)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to get logs from %s: %w", cmdutil.NodeName(cmd), err)
+		}
+		return reader, containerID, nil
+	}
+
+	// Initialize command context
+	cmdCtx, err := cmdutil.NewCommandContext()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Find container by ID prefix or name
+	ctr, err := cmdutil.FindContainer(cmdCtx.Manager, containerID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	reader, err := cmdCtx.Runtime.Logs(ctx, ctr.ID, follow, tail)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get logs: %w", err)
+	}
+	return reader, ctr.ShortID(), nil
+}
+
 func GetLogsCmd() *cobra.Command {
 	return logsCmd
 }
@@ -0,0 +1,585 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/zarigata/budgie/internal/api"
+	"github.com/zarigata/budgie/internal/cluster"
+	"github.com/zarigata/budgie/internal/cmdutil"
+	"github.com/zarigata/budgie/internal/config"
+	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/internal/events"
+	budgienode "github.com/zarigata/budgie/internal/node"
+	"github.com/zarigata/budgie/internal/proxy"
+	budgiesync "github.com/zarigata/budgie/internal/sync"
+)
+
+var nodeCmd = &cobra.Command{
+	Use:   "node",
+	Short: "Manage this machine as a budgie node",
+	Long: `Manage this machine as a node that other budgie machines can schedule
+containers onto.`,
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Advertise capacity, join the cluster and accept scheduled containers",
+	Long: `Serve runs the node API and announces this node's capacity over mDNS
+(CPUs, memory, running containers and labels), so that
+"budgie run --place auto" on other machines can place containers here.
+
+It also joins the cluster: nodes found over mDNS or listed in node.seeds
+exchange heartbeats, and the live node with the lowest ID is elected leader.
+Only the leader heals replicated services, so two nodes never replace the
+same replica.
+
+Local containers are announced with their replication role. When the node
+running a primary fails, the surviving replica with the lowest node ID is
+promoted: it stops receiving data, pushes its volumes to the other replicas
+and serves as the primary. A returning old primary rejoins as a replica.`,
+	Args: cobra.NoArgs,
+	RunE: serveNode,
+}
+
+var lsCmd = &cobra.Command{
+	Use:     "ls",
+	Aliases: []string{"list"},
+	Short:   "List cluster members",
+	Long: `List the members of the cluster as seen by the local node (or the node at
+--addr), with their state, role and when their heartbeat was last seen.`,
+	Args: cobra.NoArgs,
+	RunE: listNodes,
+}
+
+var drainCmd = &cobra.Command{
+	Use:   "drain [node]",
+	Short: "Move containers off this node and stop it accepting new ones",
+	Long: `Drain prepares this node for maintenance. It cordons the node so that no
+new containers are placed on it, moves replicated containers to peers and
+then stops local containers, dependents before their dependencies.
+
+A replicated container moves by handing its primary role to a replica on
+another node, which "budgie node drain" creates first, as "budgie chirp
+join" would, if the replica set has none. Its volumes are synced to the
+replica before the hand-over.
+
+The node argument, or --node, names the node to drain and defaults to this
+node. Other nodes are drained through their node API, which needs the
+operator role there.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: drainNode,
+}
+
+var cordonCmd = &cobra.Command{
+	Use:   "cordon [node]",
+	Short: "Stop this node accepting new containers",
+	Long: `Cordon marks this node as unschedulable: the scheduler skips it, its node
+API rejects submitted containers and chirp join refuses to run here.
+Running containers are left alone.
+
+The node argument, or --node, defaults to this node.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: cordonNode,
+}
+
+var uncordonCmd = &cobra.Command{
+	Use:   "uncordon [node]",
+	Short: "Let this node accept containers again",
+	Long: `Uncordon reverses cordon and drain: the node accepts new containers again
+and the containers a drain stopped are started in dependency order.
+Containers that were moved come back as replicas of their new primary.
+
+The node argument, or --node, defaults to this node.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: uncordonNode,
+}
+
+var (
+	apiPort      int
+	clusterAddr  string
+	outputFormat string
+	drainForce   bool
+)
+
+func serveNode(cmd *cobra.Command, args []string) error {
+	cmdCtx, err := cmdutil.NewCommandContext()
+	if err != nil {
+		return err
+	}
+	cfg := cmdCtx.Config
+
+	port := cfg.Node.APIPort
+	if cmd.Flags().Changed("port") {
+		port = apiPort
+	}
+
+	tlsConfig, err := cmdutil.TLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	nodeID := budgienode.LocalID()
+	labels := budgienode.Labels(cfg.Node.Labels)
+	info := func() (discovery.NodeInfo, error) {
+		current, err := budgienode.LocalInfo(cmdCtx.Manager, port, labels)
+		current.ClusterPort = cfg.Node.ClusterPort
+		current.Cordoned = budgienode.IsCordoned(cmdCtx.DataDir)
+		return current, err
+	}
+
+	auth, err := budgienode.NewAuthorizer(cfg.Node.Roles)
+	if err != nil {
+		return err
+	}
+
+	// Health of local containers, reported by budgie ps --all-nodes
+	health := api.NewHealthCheckMonitor(cmdCtx.Manager, nil)
+	health.Start()
+	defer health.Stop()
+
+	// Load balancer in front of services, which budgie update and failover
+	// register replicas with
+	lb, stopProxy, err := startProxy(cfg.Proxy)
+	if err != nil {
+		return err
+	}
+	defer stopProxy()
+
+	disc := discovery.NewDiscoveryService()
+	server := budgienode.NewServer(cmdCtx.Manager, cmdCtx.DataDir, info)
+	server.SetAuthorizer(auth)
+	server.SetHealthMonitor(health)
+	server.SetBackends(lb)
+	server.SetPeers(budgienode.NewDiscoveredPeers(disc, tlsConfig, time.Duration(cfg.Discovery.Timeout)*time.Second))
+	if err := server.Listen(net.JoinHostPort("", strconv.Itoa(port)), tlsConfig); err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	// Cluster membership
+	agentCfg := cluster.DefaultConfig(nodeID)
+	agentCfg.BindAddr = net.JoinHostPort("", strconv.Itoa(cfg.Node.ClusterPort))
+	agentCfg.TLS = tlsConfig
+	agentCfg.Events = events.NewLog(cmdCtx.DataDir)
+
+	agent := cluster.NewAgent(agentCfg)
+	if err := agent.Start(); err != nil {
+		return err
+	}
+	defer agent.Stop()
+
+	if len(cfg.Node.Seeds) > 0 {
+		if err := agent.Join(cfg.Node.Seeds...); err != nil {
+			logrus.Warnf("%v", err)
+		}
+	}
+
+	// Every node heals the services it runs; only the leader places missing
+	// replicas on the nodes with the most room
+	peers := func(service string) (int, error) {
+		return disc.CountInstances(service, nodeID, time.Duration(cfg.Discovery.Timeout)*time.Second)
+	}
+	controller := api.NewReplicaController(cmdCtx.Manager, api.NewRestartMonitor(cmdCtx.Manager), peers)
+	controller.SetNodeLabels(labels)
+	controller.SetPlacer(budgienode.NewPlacer(disc, nodeID, time.Duration(cfg.Discovery.Timeout)*time.Second, tlsConfig).Place)
+	controller.SetLeaderCheck(agent.IsLeader)
+	controller.Start()
+	defer controller.Stop()
+
+	// Failover of replicated containers
+	var volumes api.VolumeSync
+	syncServer, err := budgiesync.NewTLSServer(cfg.SyncPort, cmdutil.SyncTLSConfig(cfg))
+	if err != nil {
+		logrus.Warnf("Volume sync disabled: %v", err)
+	} else {
+		// Serve manifests for budgie volume verify and pulls for budgie chirp
+		// --sync to peers with a cluster certificate, reading the state
+		// afresh as containers may be started by other commands
+		syncServer.SetVolumeLookup(func(containerID, target string) (string, error) {
+			manager, err := api.NewContainerManager(cmdCtx.Runtime, cmdCtx.DataDir)
+			if err != nil {
+				return "", err
+			}
+			ctr, err := manager.Get(containerID)
+			if err != nil {
+				return "", err
+			}
+			return budgienode.VolumePath(ctr, target)
+		})
+		go syncServer.Start()
+		defer syncServer.Stop()
+
+		syncClient, err := budgiesync.NewTLSClient(cmdutil.SyncTLSConfig(cfg))
+		if err != nil {
+			return err
+		}
+		// Primaries keep pushing changes to their replicas, and replicas
+		// push changes to bidirectional volumes back
+		volumeSync := budgienode.NewVolumeSync(syncServer.Server, syncClient, cfg.SyncPort)
+		volumeSync.SetNodeID(nodeID)
+		volumeSync.EnableReplication(time.Duration(cfg.Sync.Debounce)*time.Millisecond, time.Duration(cfg.Sync.MaxDelay)*time.Second)
+		volumeSync.EnableBidirectional(nodeID, cmdCtx.DataDir, agentCfg.Events)
+		volumeSync.LimitBandwidth(budgiesync.NewLimiter(int64(cfg.Sync.MaxBandwidth) << 10))
+		server.SetReplicationStatus(volumeSync.Status)
+		volumes = volumeSync
+		server.SetVolumeSync(volumes)
+	}
+
+	view := budgienode.NewClusterView(agent, disc, time.Duration(cfg.Discovery.Timeout)*time.Second)
+	failover := api.NewFailoverController(cmdCtx.Manager, nodeID, view, volumes, lb, agentCfg.Events)
+	failover.Start()
+	defer failover.Stop()
+
+	announcer := discovery.NewNodeAnnouncer()
+	defer announcer.Shutdown()
+
+	disc.SetNodeLabels(labels)
+	defer disc.Shutdown()
+	announced := make(map[string]bool)
+
+	announce := func() {
+		current, err := info()
+		if err != nil {
+			logrus.Warnf("Failed to read node capacity: %v", err)
+			return
+		}
+		if err := announcer.Announce(current); err != nil {
+			logrus.Warnf("Failed to announce node: %v", err)
+		}
+
+		// Re-announce containers so that role changes are seen by peers
+		running := make(map[string]bool)
+		for _, ctr := range cmdCtx.Manager.List() {
+			if !ctr.IsRunning() {
+				continue
+			}
+			running[ctr.ID] = true
+			if err := disc.AnnounceContainer(ctr); err != nil {
+				logrus.Warnf("Failed to announce container %s: %v", ctr.ShortID(), err)
+			}
+		}
+		for id := range announced {
+			if !running[id] {
+				disc.WithdrawContainer(id)
+			}
+		}
+		announced = running
+	}
+	announce()
+
+	interval := time.Duration(cfg.Node.AnnounceInterval) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	go joinDiscovered(disc, agent, nodeID)
+
+	fmt.Printf("🐦 Node %s serving on port %d (cluster %s)\n", nodeID, port, agent.Addr())
+	fmt.Println("Press Ctrl+C to stop...")
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	for {
+		select {
+		case <-ticker.C:
+			announce()
+			go joinDiscovered(disc, agent, nodeID)
+		case <-sigChan:
+			fmt.Println("\nLeaving cluster...")
+			return nil
+		}
+	}
+}
+
+// startProxy starts the load balancer and, with proxy.port set, serves the
+// configured routes on it. The returned function stops serving and drains
+// the backends.
+func startProxy(cfg config.ProxyConfig) (*proxy.ContainerProxy, func(), error) {
+	lb := proxy.NewContainerProxy(proxy.LoadBalancerType(cfg.Type))
+	for host, service := range cfg.Routes {
+		if err := lb.AddRoute(proxy.Route{Host: host, ContainerID: service}); err != nil {
+			lb.Shutdown()
+			return nil, nil, err
+		}
+	}
+	lb.StartHealthCheck(cfg.HealthCheckInterval)
+
+	if cfg.Port <= 0 {
+		return lb, lb.Shutdown, nil
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(cfg.Port)))
+	if err != nil {
+		lb.Shutdown()
+		return nil, nil, fmt.Errorf("failed to listen on proxy port %d: %w", cfg.Port, err)
+	}
+	srv := &http.Server{Handler: lb.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.Errorf("Proxy failed: %v", err)
+		}
+	}()
+	logrus.Infof("Proxy listening on %s", listener.Addr())
+
+	stop := func() {
+		// In-flight requests finish while the backends drain
+		go srv.Shutdown(context.Background())
+		lb.Shutdown()
+		srv.Close()
+	}
+	return lb, stop, nil
+}
+
+// joinDiscovered joins cluster nodes found over mDNS that the agent does not know yet
+func joinDiscovered(disc *discovery.DiscoveryService, agent *cluster.Agent, nodeID string) {
+	nodes, err := disc.DiscoverNodes(3 * time.Second)
+	if err != nil {
+		logrus.Debugf("Node discovery failed: %v", err)
+		return
+	}
+
+	known := make(map[string]bool)
+	for _, m := range agent.Members() {
+		if m.State == cluster.StateAlive || m.State == cluster.StateSuspect {
+			known[m.ID] = true
+		}
+	}
+
+	var addrs []string
+	for _, n := range nodes {
+		if n.ID == nodeID || known[n.ID] || n.ClusterPort == 0 || n.Address == "" {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(n.Address, strconv.Itoa(n.ClusterPort)))
+	}
+
+	if len(addrs) > 0 {
+		if err := agent.Join(addrs...); err != nil {
+			logrus.Debugf("%v", err)
+		}
+	}
+}
+
+// remoteNode returns a client for the node named by the node argument or
+// --node, and its name, or nil when that is this node
+func remoteNode(cmd *cobra.Command, args []string) (*budgienode.Client, string, error) {
+	name := cmdutil.NodeName(cmd)
+	if len(args) > 0 {
+		if name != "" && name != args[0] {
+			return nil, "", fmt.Errorf("node %s does not match --node %s", args[0], name)
+		}
+		name = args[0]
+	}
+
+	nodeID := budgienode.LocalID()
+	if name == "" || name == nodeID {
+		return nil, nodeID, nil
+	}
+	client, err := cmdutil.ResolveNode(name)
+	return client, name, err
+}
+
+// nodeDrainer drains or uncordons a node, either this one or another
+// through its node API
+type nodeDrainer interface {
+	Drain(ctx context.Context, force bool) ([]budgienode.DrainStep, error)
+	Uncordon(ctx context.Context) ([]budgienode.DrainStep, error)
+}
+
+// drainerFor returns the drainer of the node the command names. A local
+// drainer only reaches peers when withPeers is set.
+func drainerFor(cmd *cobra.Command, args []string, withPeers bool) (nodeDrainer, string, error) {
+	client, nodeID, err := remoteNode(cmd, args)
+	if err != nil {
+		return nil, "", err
+	}
+	if client != nil {
+		return client, nodeID, nil
+	}
+
+	cmdCtx, err := cmdutil.NewCommandContext()
+	if err != nil {
+		return nil, "", err
+	}
+	if !withPeers {
+		return budgienode.NewDrainer(cmdCtx.Manager, cmdCtx.DataDir, nodeID, nil, nil), nodeID, nil
+	}
+	cfg := cmdCtx.Config
+
+	tlsConfig, err := cmdutil.TLSConfig(cfg)
+	if err != nil {
+		return nil, "", err
+	}
+	syncClient, err := budgiesync.NewTLSClient(cmdutil.SyncTLSConfig(cfg))
+	if err != nil {
+		return nil, "", err
+	}
+
+	disc := discovery.NewDiscoveryService()
+	peers := budgienode.NewDiscoveredPeers(disc, tlsConfig, time.Duration(cfg.Discovery.Timeout)*time.Second)
+	volumes := budgienode.NewVolumeSync(nil, syncClient, cfg.SyncPort)
+	volumes.SetNodeID(nodeID)
+
+	return budgienode.NewDrainer(cmdCtx.Manager, cmdCtx.DataDir, nodeID, peers, volumes), nodeID, nil
+}
+
+func drainNode(cmd *cobra.Command, args []string) error {
+	drainer, nodeID, err := drainerFor(cmd, args, true)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Draining node %s...\n", nodeID)
+	steps, err := drainer.Drain(context.Background(), drainForce)
+	printSteps(steps)
+	if err != nil {
+		return fmt.Errorf("%w; the node stays cordoned", err)
+	}
+
+	fmt.Printf("✅ Node %s drained\n", nodeID)
+	return nil
+}
+
+func cordonNode(cmd *cobra.Command, args []string) error {
+	client, nodeID, err := remoteNode(cmd, args)
+	if err != nil {
+		return err
+	}
+
+	if client != nil {
+		err = client.Cordon(context.Background())
+	} else {
+		_, err = budgienode.Cordon(cmdutil.GetDataDir())
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Node %s cordoned\n", nodeID)
+	return nil
+}
+
+func uncordonNode(cmd *cobra.Command, args []string) error {
+	drainer, nodeID, err := drainerFor(cmd, args, false)
+	if err != nil {
+		return err
+	}
+
+	steps, err := drainer.Uncordon(context.Background())
+	printSteps(steps)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Node %s uncordoned\n", nodeID)
+	return nil
+}
+
+// printSteps prints what a drain or uncordon did
+func printSteps(steps []budgienode.DrainStep) {
+	for _, step := range steps {
+		id := cmdutil.FormatContainerID(step.ContainerID)
+		if step.Node != "" {
+			fmt.Printf("  %s %s (%s) to %s\n", step.Action, step.Name, id, step.Node)
+		} else {
+			fmt.Printf("  %s %s (%s)\n", step.Action, step.Name, id)
+		}
+	}
+}
+
+func listNodes(cmd *cobra.Command, args []string) error {
+	cfg := config.Get()
+
+	addr := clusterAddr
+	if addr == "" {
+		addr = net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.Node.ClusterPort))
+	}
+
+	tlsConfig, err := cmdutil.TLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	members, err := cluster.Query(addr, tlsConfig, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to query cluster at %s (is \"budgie node serve\" running?): %w", addr, err)
+	}
+
+	if outputFormat == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(members)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "NODE ID\tADDRESS\tSTATE\tROLE\tLAST SEEN")
+
+	for _, m := range members {
+		role := string(m.Role)
+		if role == "" {
+			role = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", m.ID, m.Address, m.State, role, formatLastSeen(m.LastSeen))
+	}
+
+	return w.Flush()
+}
+
+// formatLastSeen formats a heartbeat time with second precision
+func formatLastSeen(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	d := time.Since(t)
+	switch {
+	case d < time.Second:
+		return "now"
+	case d < time.Minute:
+		return fmt.Sprintf("%ds ago", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	default:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	}
+}
+
+func GetNodeCmd() *cobra.Command {
+	return nodeCmd
+}
+
+func init() {
+	serveCmd.Flags().IntVarP(&apiPort, "port", "p", 0, "Node API port (default from config node.api_port)")
+
+	lsCmd.Flags().StringVar(&clusterAddr, "addr", "", "Cluster address of the node to ask (default this node)")
+	lsCmd.Flags().StringVar(&outputFormat, "format", "table", "Output format: table or json")
+
+	drainCmd.Flags().BoolVar(&drainForce, "force", false, "Stop containers that could not be moved to a peer")
+
+	nodeCmd.AddCommand(serveCmd)
+	nodeCmd.AddCommand(lsCmd)
+	nodeCmd.AddCommand(drainCmd)
+	nodeCmd.AddCommand(cordonCmd)
+	nodeCmd.AddCommand(uncordonCmd)
+}
@@ -1,6 +1,8 @@
 package ps
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
@@ -10,14 +12,20 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/zarigata/budgie/internal/api"
+	"github.com/zarigata/budgie/internal/cmdutil"
+	"github.com/zarigata/budgie/internal/config"
+	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/internal/node"
 	"github.com/zarigata/budgie/internal/runtime"
 	"github.com/zarigata/budgie/pkg/types"
 )
 
 var (
-	all    bool
-	quiet  bool
-	format string
+	all         bool
+	quiet       bool
+	format      string
+	allNodes    bool
+	nodeTimeout time.Duration
 )
 
 var psCmd = &cobra.Command{
@@ -25,29 +33,31 @@ var psCmd = &cobra.Command{
 	Short: "List containers",
 	Long: `List all containers running on this machine.
 
+Use --node to list the containers of another node.
+Use --all-nodes to list the containers of every discovered node in one table.
 Use --all to show stopped containers as well.
-Use --quiet to only display container IDs.`,
+Use --quiet to only display container IDs.
+Use --format json for output to use in scripts.`,
 	RunE: listContainers,
 }
 
 func listContainers(cmd *cobra.Command, args []string) error {
-	rt, err := runtime.GetDefaultRuntime()
-	if err != nil {
-		return fmt.Errorf("failed to get runtime: %w", err)
+	if format != "" && format != "json" {
+		return fmt.Errorf("unsupported format %q: only json is supported", format)
 	}
 
-	dataDir := os.Getenv("BUDGIE_DATA_DIR")
-	if dataDir == "" {
-		dataDir = "/var/lib/budgie"
+	if allNodes {
+		if cmdutil.NodeName(cmd) != "" {
+			return fmt.Errorf("--all-nodes cannot be combined with --node")
+		}
+		return listAllNodes()
 	}
 
-	manager, err := api.NewContainerManager(rt, dataDir)
+	containers, err := loadContainers(cmd)
 	if err != nil {
-		return fmt.Errorf("failed to create manager: %w", err)
+		return err
 	}
 
-	containers := manager.List()
-
 	// Filter containers based on flags
 	var filtered []*types.Container
 	for _, ctr := range containers {
@@ -56,6 +66,13 @@ func listContainers(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	if format == "json" {
+		if filtered == nil {
+			filtered = []*types.Container{}
+		}
+		return printJSON(filtered)
+	}
+
 	if len(filtered) == 0 {
 		if all {
 			fmt.Println("No containers found")
@@ -82,15 +99,11 @@ func listContainers(cmd *cobra.Command, args []string) error {
 		ports := formatPorts(ctr.Ports)
 		created := formatTimeAgo(ctr.CreatedAt)
 		status := formatStatus(ctr)
-		image := ctr.Image.DockerImage
-		if len(image) > 30 {
-			image = image[:27] + "..."
-		}
 
 		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
 			ctr.ShortID(),
 			ctr.Name,
-			image,
+			formatImage(ctr.Image.DockerImage),
 			status,
 			ports,
 			created)
@@ -99,6 +112,163 @@ func listContainers(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// listAllNodes lists the containers of every discovered node, and of this
+// machine if it is not serving the node API, in one table. Nodes that cannot
+// be reached are reported below the table.
+func listAllNodes() error {
+	cfg := config.Get()
+	tlsConfig, err := cmdutil.TLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := discovery.NewDiscoveryService().DiscoverNodes(time.Duration(cfg.Discovery.Timeout) * time.Second)
+	if err != nil {
+		return fmt.Errorf("node discovery failed: %w", err)
+	}
+
+	clients := make(map[string]*node.Client)
+	for _, n := range nodes {
+		// Each node is seen once per announced address
+		if _, ok := clients[n.ID]; !ok {
+			clients[n.ID] = node.NewClient(n.Address, n.APIPort, tlsConfig)
+		}
+	}
+
+	results := node.ListAll(context.Background(), clients, nodeTimeout)
+	if _, ok := clients[node.LocalID()]; !ok {
+		results = append([]node.NodeContainers{listLocal()}, results...)
+	}
+
+	failed := 0
+	for i := range results {
+		if results[i].Error != "" {
+			failed++
+		}
+		var filtered []node.ContainerSummary
+		for _, ctr := range results[i].Containers {
+			if all || ctr.State == types.StateRunning {
+				filtered = append(filtered, ctr)
+			}
+		}
+		results[i].Containers = filtered
+	}
+
+	if format == "json" {
+		return printJSON(results)
+	}
+
+	if quiet {
+		for _, result := range results {
+			for _, ctr := range result.Containers {
+				fmt.Println(ctr.ShortID())
+			}
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintf(w, "NODE\tCONTAINER ID\tNAME\tIMAGE\tSTATUS\tHEALTH\tRESTARTS\tPORTS\n")
+		for _, result := range results {
+			for _, ctr := range result.Containers {
+				health := ctr.Health
+				if health == "" {
+					health = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n",
+					result.Node,
+					ctr.ShortID(),
+					ctr.Name,
+					formatImage(ctr.Image.DockerImage),
+					formatStatus(&ctr.Container),
+					health,
+					ctr.RestartCount,
+					formatPorts(ctr.Ports))
+			}
+		}
+		w.Flush()
+	}
+
+	for _, result := range results {
+		if result.Error != "" {
+			fmt.Fprintf(os.Stderr, "⚠️  %s: %s\n", result.Node, result.Error)
+		}
+	}
+	if failed == len(results) {
+		return fmt.Errorf("no node could be listed")
+	}
+	return nil
+}
+
+// listLocal lists this machine's containers for a cluster-wide listing
+func listLocal() node.NodeContainers {
+	result := node.NodeContainers{Node: node.LocalID()}
+	manager, err := localManager()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for _, ctr := range manager.List() {
+		result.Containers = append(result.Containers, node.ContainerSummary{Container: *ctr})
+	}
+	return result
+}
+
+// loadContainers lists the containers of the node named by --node, or of
+// this machine
+func loadContainers(cmd *cobra.Command) ([]*types.Container, error) {
+	client, err := cmdutil.RemoteNode(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if client != nil {
+		summaries, err := client.List(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to list containers on %s: %w", cmdutil.NodeName(cmd), err)
+		}
+		containers := make([]*types.Container, len(summaries))
+		for i := range summaries {
+			containers[i] = &summaries[i].Container
+		}
+		return containers, nil
+	}
+
+	manager, err := localManager()
+	if err != nil {
+		return nil, err
+	}
+	return manager.List(), nil
+}
+
+func localManager() (*api.ContainerManager, error) {
+	rt, err := runtime.GetDefaultRuntime()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get runtime: %w", err)
+	}
+
+	dataDir := os.Getenv("BUDGIE_DATA_DIR")
+	if dataDir == "" {
+		dataDir = "/var/lib/budgie"
+	}
+
+	manager, err := api.NewContainerManager(rt, dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create manager: %w", err)
+	}
+	return manager, nil
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func formatImage(image string) string {
+	if len(image) > 30 {
+		return image[:27] + "..."
+	}
+	return image
+}
+
 func formatPorts(ports []types.PortMapping) string {
 	if len(ports) == 0 {
 		return "-"
@@ -183,5 +353,7 @@ func GetPsCmd() *cobra.Command {
 func init() {
 	psCmd.Flags().BoolVarP(&all, "all", "a", false, "Show all containers (including stopped)")
 	psCmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Only display container IDs")
-	psCmd.Flags().StringVar(&format, "format", "", "Format output using Go template")
+	psCmd.Flags().StringVar(&format, "format", "", "Output format: json")
+	psCmd.Flags().BoolVar(&allNodes, "all-nodes", false, "List the containers of every discovered node")
+	psCmd.Flags().DurationVar(&nodeTimeout, "timeout", 5*time.Second, "Time each node has to answer with --all-nodes")
 }
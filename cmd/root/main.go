@@ -10,12 +10,16 @@ import (
 	"github.com/zarigata/budgie/cmd/logs"
 	"github.com/zarigata/budgie/cmd/nest"
 	"github.com/zarigata/budgie/cmd/network"
+	"github.com/zarigata/budgie/cmd/node"
 	"github.com/zarigata/budgie/cmd/ps"
 	"github.com/zarigata/budgie/cmd/pull"
 	"github.com/zarigata/budgie/cmd/rm"
 	"github.com/zarigata/budgie/cmd/run"
+	"github.com/zarigata/budgie/cmd/scale"
 	"github.com/zarigata/budgie/cmd/secret"
 	"github.com/zarigata/budgie/cmd/stop"
+	"github.com/zarigata/budgie/cmd/update"
+	"github.com/zarigata/budgie/cmd/volume"
 )
 
 func main() {
@@ -35,6 +39,10 @@ func main() {
 	rootCmd.AddCommand(images.GetImagesCmd())
 	rootCmd.AddCommand(network.GetNetworkCmd())
 	rootCmd.AddCommand(secret.GetSecretCmd())
+	rootCmd.AddCommand(update.GetUpdateCmd())
+	rootCmd.AddCommand(scale.GetScaleCmd())
+	rootCmd.AddCommand(node.GetNodeCmd())
+	rootCmd.AddCommand(volume.GetVolumeCmd())
 
 	rootCmd.Execute()
 }
@@ -4,18 +4,31 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/zarigata/budgie/internal/api"
 	"github.com/zarigata/budgie/internal/bundle"
+	"github.com/zarigata/budgie/internal/cmdutil"
+	"github.com/zarigata/budgie/internal/config"
+	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/internal/events"
+	"github.com/zarigata/budgie/internal/node"
+	"github.com/zarigata/budgie/internal/placement"
 	"github.com/zarigata/budgie/internal/runtime"
+	"github.com/zarigata/budgie/internal/scheduler"
+	"github.com/zarigata/budgie/pkg/types"
 )
 
+const placeLocal = "local"
+
 var (
-	detach bool
-	name   string
+	detach      bool
+	name        string
+	place       string
+	constraints []string
 )
 
 var runCmd = &cobra.Command{
@@ -25,7 +38,13 @@ var runCmd = &cobra.Command{
 	Long: `Run creates and starts a new budgie container from the specified .bun file.
 
 The container will be announced on the local network via mDNS, allowing
-other machines to discover and replicate it.`,
+other machines to discover and replicate it.
+
+With --place auto, the container is scheduled onto the node with the most
+free capacity among those running "budgie node serve", honoring the bundle's
+resource limits and placement rules plus any --constraint node labels.
+--place <node-id> submits it to a specific node, as does the global --node,
+which also accepts the host[:port] of a node API.`,
 	Args: cobra.ExactArgs(1),
 	RunE: runContainer,
 }
@@ -50,6 +69,17 @@ func runContainer(cmd *cobra.Command, args []string) error {
 	fmt.Printf("📁 Volumes: %d mounts\n", len(bun.Volumes))
 	fmt.Printf("🔧 Environment: %d variables\n", len(bun.Env))
 
+	if nodeName := cmdutil.NodeName(cmd); nodeName != "" {
+		if cmd.Flags().Changed("place") {
+			return fmt.Errorf("--place cannot be combined with --node")
+		}
+		return runOnNode(cmd, filename)
+	}
+
+	if place != placeLocal {
+		return runRemote(filename, bun)
+	}
+
 	rt, err := runtime.GetDefaultRuntime()
 	if err != nil {
 		return fmt.Errorf("failed to get runtime: %w", err)
@@ -68,6 +98,12 @@ func runContainer(cmd *cobra.Command, args []string) error {
 	ctr := bun.ToContainer(filename)
 	ctx := context.Background()
 
+	labels := node.Labels(config.Get().Node.Labels)
+	req := placement.WithConstraints(ctr.Placement, constraints)
+	if err := placement.Check(req, labels, manager.RunningServices()); err != nil {
+		return fmt.Errorf("cannot run %s on this node: %w (use --place auto to pick another node)", ctr.Name, err)
+	}
+
 	fmt.Printf("\nCreating container...\n")
 	if err := manager.Create(ctx, ctr); err != nil {
 		return err
@@ -80,7 +116,29 @@ func runContainer(cmd *cobra.Command, args []string) error {
 
 	fmt.Printf("\n✅ Container %s is now running\n", ctr.ShortID())
 
+	var controller *api.ReplicaController
+	if ctr.Replicas != nil {
+		controller = api.NewReplicaController(manager, nil, nil)
+		controller.SetNodeLabels(labels)
+		if err := controller.ReconcileService(ctx, ctr.Name); err != nil {
+			return fmt.Errorf("failed to start replicas: %w", err)
+		}
+		fmt.Printf("🐦 %d replicas of %s running\n", len(manager.ServiceReplicas(ctr.Name)), ctr.Name)
+	}
+
 	if !detach {
+		if controller != nil {
+			controller.Start()
+			defer controller.Stop()
+
+			if ctr.Replicas.Autoscale != nil {
+				metrics := api.NewRuntimeMetrics(manager, rt, nil)
+				autoscaler := api.NewAutoscaler(manager, controller, metrics, events.NewLog(dataDir))
+				autoscaler.Start()
+				defer autoscaler.Stop()
+			}
+		}
+
 		fmt.Println("\nPress Ctrl+C to stop container...")
 		<-ctx.Done()
 		fmt.Println("\nStopping container...")
@@ -96,6 +154,98 @@ func runContainer(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// runRemote schedules the bundle onto a peer node and submits it there
+func runRemote(filename string, bun *bundle.Bundle) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+
+	cfg := config.Get()
+	timeout := time.Duration(cfg.Discovery.Timeout) * time.Second
+	disc := discovery.NewDiscoveryService()
+
+	fmt.Printf("\nLooking for nodes...\n")
+	nodes, err := disc.DiscoverNodes(timeout)
+	if err != nil {
+		return fmt.Errorf("node discovery failed: %w", err)
+	}
+
+	target, err := pickNode(disc, nodes, bun.ToContainer(filename), timeout)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig, err := cmdutil.TLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nSubmitting to node %s (%s)...\n", target.ID, target.Address)
+	client := node.NewClient(target.Address, target.APIPort, tlsConfig)
+	resp, err := client.Run(context.Background(), filepath.Base(filename), data)
+	if err != nil {
+		return fmt.Errorf("failed to run on node %s: %w", target.ID, err)
+	}
+
+	fmt.Printf("\n✅ Container %s is now running on %s\n", cmdutil.FormatContainerID(resp.ID), resp.NodeID)
+	return nil
+}
+
+// runOnNode submits the bundle to the node named by --node
+func runOnNode(cmd *cobra.Command, filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed to read bundle: %w", err)
+	}
+
+	client, err := cmdutil.RemoteNode(cmd)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nSubmitting to node %s...\n", cmdutil.NodeName(cmd))
+	resp, err := client.Run(context.Background(), filepath.Base(filename), data)
+	if err != nil {
+		return fmt.Errorf("failed to run on node %s: %w", cmdutil.NodeName(cmd), err)
+	}
+
+	fmt.Printf("\n✅ Container %s is now running on %s\n", cmdutil.FormatContainerID(resp.ID), resp.NodeID)
+	return nil
+}
+
+// pickNode chooses the node named by --place, or schedules one for "auto"
+func pickNode(disc *discovery.DiscoveryService, nodes []discovery.NodeInfo, ctr *types.Container, timeout time.Duration) (*discovery.NodeInfo, error) {
+	if place != "auto" {
+		for i := range nodes {
+			if nodes[i].ID == place {
+				return &nodes[i], nil
+			}
+		}
+		return nil, fmt.Errorf("node %s not found", place)
+	}
+
+	// Services already running on each node, for affinity and spreading
+	running := scheduler.Services{}
+	containers, err := disc.DiscoverContainers(timeout)
+	if err != nil {
+		return nil, fmt.Errorf("discovery failed: %w", err)
+	}
+	seen := make(map[string]bool)
+	for _, c := range containers {
+		if !seen[c.ID] {
+			seen[c.ID] = true
+			running.Add(c.NodeID, c.Name)
+		}
+	}
+
+	chosen, err := scheduler.Schedule(nodes, scheduler.RequestFor(ctr, constraints), running)
+	if err != nil {
+		return nil, err
+	}
+	return &chosen.Node, nil
+}
+
 func GetRunCmd() *cobra.Command {
 	return runCmd
 }
@@ -103,4 +253,6 @@ func GetRunCmd() *cobra.Command {
 func init() {
 	runCmd.Flags().BoolVarP(&detach, "detach", "d", false, "Run container in background")
 	runCmd.Flags().StringVarP(&name, "name", "n", "", "Assign a name to the container")
+	runCmd.Flags().StringVar(&place, "place", placeLocal, "Where to run: local, auto, or a node ID")
+	runCmd.Flags().StringArrayVar(&constraints, "constraint", nil, "Node label constraint such as disk==ssd or arch!=arm (repeatable)")
 }
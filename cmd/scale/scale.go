@@ -0,0 +1,84 @@
+package scale
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zarigata/budgie/internal/api"
+	"github.com/zarigata/budgie/internal/cmdutil"
+	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/internal/node"
+)
+
+var (
+	countPeers bool
+)
+
+var scaleCmd = &cobra.Command{
+	Use:   "scale <service> <replicas>",
+	Short: "Set the number of replicas of a service",
+	Long: `Scale sets how many instances of a replicated service should run.
+
+The count must be within the replicas.min/max bounds of the service's bundle.
+Replicas are started or stopped on this node right away; with --peers,
+instances discovered on other nodes count towards the total.`,
+	Example: `  budgie scale web 3`,
+	Args:    cobra.ExactArgs(2),
+	RunE:    scaleService,
+}
+
+func scaleService(cmd *cobra.Command, args []string) error {
+	service := args[0]
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid replica count: %s", args[1])
+	}
+
+	cmdCtx, err := cmdutil.NewCommandContext()
+	if err != nil {
+		return err
+	}
+
+	if err := cmdCtx.Manager.SetDesiredReplicas(service, n); err != nil {
+		return err
+	}
+
+	var peers api.PeerCounter
+	if countPeers && cmdCtx.Config.Discovery.Enabled {
+		replicas := cmdCtx.Manager.ServiceReplicas(service)
+		nodeID := ""
+		if len(replicas) > 0 {
+			nodeID = replicas[0].NodeID
+		}
+		timeout := time.Duration(cmdCtx.Config.Discovery.Timeout) * time.Second
+
+		disc := discovery.NewDiscoveryService()
+		defer disc.Shutdown()
+		peers = func(service string) (int, error) {
+			return disc.CountInstances(service, nodeID, timeout)
+		}
+	}
+
+	fmt.Printf("⚖️  Scaling %s to %d replicas...\n", service, n)
+
+	controller := api.NewReplicaController(cmdCtx.Manager, api.NewRestartMonitor(cmdCtx.Manager), peers)
+	controller.SetNodeLabels(node.Labels(cmdCtx.Config.Node.Labels))
+	if err := controller.ReconcileService(context.Background(), service); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Service %s has %d replicas running on this node\n", service, len(cmdCtx.Manager.ServiceReplicas(service)))
+	return nil
+}
+
+func GetScaleCmd() *cobra.Command {
+	return scaleCmd
+}
+
+func init() {
+	scaleCmd.Flags().BoolVar(&countPeers, "peers", false, "Count instances discovered on other nodes")
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/zarigata/budgie/internal/api"
+	"github.com/zarigata/budgie/internal/cmdutil"
 	"github.com/zarigata/budgie/internal/runtime"
 	"github.com/zarigata/budgie/pkg/types"
 )
@@ -22,7 +23,8 @@ var stopCmd = &cobra.Command{
 	Short: "Stop a running container",
 	Long: `Stop a running container gracefully.
 
-You can specify a timeout with --timeout flag. Default is 10 seconds.`,
+You can specify a timeout with --timeout flag. Default is 10 seconds.
+Use --node to stop a container on another node.`,
 	Args: cobra.ExactArgs(1),
 	RunE: stopContainer,
 }
@@ -30,6 +32,25 @@ You can specify a timeout with --timeout flag. Default is 10 seconds.`,
 func stopContainer(cmd *cobra.Command, args []string) error {
 	containerID := args[0]
 
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	client, err := cmdutil.RemoteNode(cmd)
+	if err != nil {
+		return err
+	}
+	if client != nil {
+		fmt.Printf("🛑 Stopping container %s on %s...\n", containerID, cmdutil.NodeName(cmd))
+		ctr, err := client.Stop(context.Background(), containerID, timeout)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Container %s stopped\n", ctr.ShortID())
+		printContainerInfo(ctr)
+		return nil
+	}
+
 	rt, err := runtime.GetDefaultRuntime()
 	if err != nil {
 		return fmt.Errorf("failed to get runtime: %w", err)
@@ -56,10 +77,6 @@ func stopContainer(cmd *cobra.Command, args []string) error {
 
 	fmt.Printf("🛑 Stopping container %s...\n", ctr.ShortID())
 
-	if timeout == 0 {
-		timeout = 10 * time.Second
-	}
-
 	if err := manager.Stop(context.Background(), ctr.ID, timeout); err != nil {
 		return err
 	}
@@ -0,0 +1,148 @@
+package update
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zarigata/budgie/internal/api"
+	"github.com/zarigata/budgie/internal/bundle"
+	"github.com/zarigata/budgie/internal/cmdutil"
+	budgienode "github.com/zarigata/budgie/internal/node"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+var (
+	image          string
+	bundleFile     string
+	maxSurge       int
+	maxUnavailable int
+	healthTimeout  time.Duration
+	stopTimeout    time.Duration
+)
+
+var updateCmd = &cobra.Command{
+	Use:   "update <service>",
+	Short: "Roll out a new image or bundle to a replicated service",
+	Long: `Update replaces the running replicas of a service one batch at a time.
+
+Each new replica is started and must pass its health check before an old
+replica is drained and stopped. If a new replica fails, the rollout is rolled
+back and the original replicas are restored. Replicas join and leave the load
+balancer of "budgie node serve" on this machine, which must be running.
+Replicas that publish a host port cannot surge: update them with
+--max-surge 0 --max-unavailable 1.
+
+Batch sizes come from the bundle's update section and can be overridden with
+--max-surge and --max-unavailable.`,
+	Example: `  budgie update web --image nginx:1.27
+  budgie update web --file web.bun --max-surge 2`,
+	Args: cobra.ExactArgs(1),
+	RunE: updateService,
+}
+
+func updateService(cmd *cobra.Command, args []string) error {
+	service := args[0]
+
+	if (image == "") == (bundleFile == "") {
+		return fmt.Errorf("exactly one of --image or --file is required")
+	}
+
+	cmdCtx, err := cmdutil.NewCommandContext()
+	if err != nil {
+		return err
+	}
+
+	replicas := cmdCtx.Manager.ServiceReplicas(service)
+	if len(replicas) == 0 {
+		return fmt.Errorf("no running replicas of service %s", service)
+	}
+
+	template, err := buildTemplate(service, replicas[len(replicas)-1])
+	if err != nil {
+		return err
+	}
+
+	cfg := api.RolloutConfigFor(template)
+	if cmd.Flags().Changed("max-surge") {
+		cfg.MaxSurge = maxSurge
+	}
+	if cmd.Flags().Changed("max-unavailable") {
+		cfg.MaxUnavailable = maxUnavailable
+	}
+	if healthTimeout > 0 {
+		cfg.HealthTimeout = healthTimeout
+	}
+	if stopTimeout > 0 {
+		cfg.StopTimeout = stopTimeout
+	}
+
+	ctx := context.Background()
+
+	tlsConfig, err := cmdutil.TLSConfig(cmdCtx.Config)
+	if err != nil {
+		return err
+	}
+	client := budgienode.NewClient("localhost", cmdCtx.Config.Node.APIPort, tlsConfig)
+	if _, err := client.Info(ctx); err != nil {
+		return fmt.Errorf("load balancer of budgie node serve unavailable: %w", err)
+	}
+
+	fmt.Printf("🖼️  Pulling %s...\n", template.Image.DockerImage)
+	if _, err := cmdCtx.Runtime.Pull(ctx, template.Image.DockerImage); err != nil {
+		return fmt.Errorf("failed to pull image: %w", err)
+	}
+
+	healthMonitor := api.NewHealthCheckMonitor(cmdCtx.Manager, nil)
+	healthMonitor.Start()
+	defer healthMonitor.Stop()
+
+	fmt.Printf("🔄 Updating %d replicas of %s (max surge %d, max unavailable %d)\n",
+		len(replicas), service, cfg.MaxSurge, cfg.MaxUnavailable)
+
+	updater := api.NewRollingUpdater(cmdCtx.Manager, budgienode.NewBackends(client), healthMonitor.WaitHealthy)
+	result, err := updater.Update(ctx, service, template, cfg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Service %s updated: %d replicas now run %s\n", service, result.Replaced, template.Image.DockerImage)
+	for _, id := range result.Created {
+		fmt.Printf("  %s\n", cmdutil.FormatContainerID(id))
+	}
+
+	return nil
+}
+
+// buildTemplate returns the configuration new replicas are created from
+func buildTemplate(service string, current *types.Container) (*types.Container, error) {
+	if bundleFile != "" {
+		bun, err := bundle.Parse(bundleFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse bundle: %w", err)
+		}
+		template := bun.ToContainer(bundleFile)
+		// Replicas are grouped by name, so the bundle keeps the service's name
+		template.Name = service
+		return template, nil
+	}
+
+	template := *current
+	template.Image.DockerImage = image
+	return &template, nil
+}
+
+func GetUpdateCmd() *cobra.Command {
+	return updateCmd
+}
+
+func init() {
+	updateCmd.Flags().StringVarP(&image, "image", "i", "", "New image for the service")
+	updateCmd.Flags().StringVarP(&bundleFile, "file", "f", "", "Bundle file with the new service definition")
+	updateCmd.Flags().IntVar(&maxSurge, "max-surge", 1, "Replicas that may be added above the current count")
+	updateCmd.Flags().IntVar(&maxUnavailable, "max-unavailable", 0, "Replicas that may be unavailable during the update")
+	updateCmd.Flags().DurationVar(&healthTimeout, "health-timeout", 0, "Time each new replica has to become healthy")
+	updateCmd.Flags().DurationVarP(&stopTimeout, "timeout", "t", 0, "Timeout before forcefully killing old replicas")
+}
@@ -0,0 +1,399 @@
+package volume
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zarigata/budgie/internal/cmdutil"
+	"github.com/zarigata/budgie/internal/config"
+	"github.com/zarigata/budgie/internal/discovery"
+	budgienode "github.com/zarigata/budgie/internal/node"
+	budgiesync "github.com/zarigata/budgie/internal/sync"
+)
+
+var (
+	pause        bool
+	forceRestore bool
+	keep         int
+	maxAge       int
+)
+
+var volumeCmd = &cobra.Command{
+	Use:   "volume",
+	Short: "Inspect, snapshot and restore container volumes",
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <container>",
+	Short: "Compare a replica's volumes with its primary's",
+	Long: `Verify hashes every file in the rw volumes of a local replica and compares
+them with the primary's, as reported by the sync server of the primary's node.
+Files that are missing, extra or have different content are listed, and the
+command fails if any are found.`,
+	Args: cobra.ExactArgs(1),
+	RunE: verifyVolume,
+}
+
+func verifyVolume(cmd *cobra.Command, args []string) error {
+	cmdCtx, err := cmdutil.NewCommandContext()
+	if err != nil {
+		return err
+	}
+	cfg := cmdCtx.Config
+
+	ctr, err := cmdutil.FindContainer(cmdCtx.Manager, args[0])
+	if err != nil {
+		return err
+	}
+	if !ctr.IsReplica() {
+		return fmt.Errorf("container %s is not a replica", ctr.ShortID())
+	}
+
+	timeout := time.Duration(cfg.Discovery.Timeout) * time.Second
+	primary, err := budgienode.PrimaryOf(discovery.NewDiscoveryService(), timeout, ctr)
+	if err != nil {
+		return err
+	}
+
+	client, err := budgiesync.NewTLSClient(cmdutil.SyncTLSConfig(cfg))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Verifying %s against primary %s on %s...\n", ctr.ShortID(), cmdutil.FormatContainerID(primary.ContainerID), primary.NodeID)
+	volumes := budgienode.NewVolumeSync(nil, client, cfg.SyncPort)
+	volumes.SetNodeID(budgienode.LocalID())
+	diffs, err := volumes.Verify(ctr, primary)
+	if err != nil {
+		return err
+	}
+
+	if len(diffs) == 0 {
+		fmt.Println("✅ Volumes match the primary")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(w, "PATH\tPROBLEM\n")
+	for _, d := range diffs {
+		fmt.Fprintf(w, "%s\t%s\n", d.Path, d.Reason)
+	}
+	w.Flush()
+
+	return fmt.Errorf("%d files differ from the primary", len(diffs))
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status [container]",
+	Short: "Show how far replicas are behind their primary",
+	Long: `Status lists the replicas of the primaries on this node, or on the node
+given with --node, with the changes they have not acknowledged yet and how
+long the oldest of them has been waiting.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: volumeStatus,
+}
+
+func volumeStatus(cmd *cobra.Command, args []string) error {
+	client, err := cmdutil.NodeClient(cmd)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	statuses, err := client.Replication(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get replication status: %w", err)
+	}
+
+	if len(args) == 1 {
+		var filtered []budgienode.ReplicationStatus
+		for _, st := range statuses {
+			if st.Name == args[0] || strings.HasPrefix(st.ContainerID, args[0]) {
+				filtered = append(filtered, st)
+			}
+		}
+		statuses = filtered
+	}
+
+	if len(statuses) == 0 {
+		fmt.Println("No volumes are replicated from this node")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(w, "CONTAINER\tVOLUME\tREPLICA\tPENDING\tLAG\tLAST SYNC\tERROR\n")
+	for _, st := range statuses {
+		replica := st.ReplicaNode
+		if replica == "" {
+			replica = st.Address
+		}
+		lastSync := "-"
+		if !st.LastSync.IsZero() {
+			lastSync = time.Since(st.LastSync).Round(time.Second).String() + " ago"
+		}
+		problem := st.Error
+		if problem == "" {
+			problem = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			st.Name,
+			st.Volume,
+			replica,
+			st.Pending,
+			st.Lag.Round(time.Millisecond),
+			lastSync,
+			problem)
+	}
+	return w.Flush()
+}
+
+var snapshotCmd = &cobra.Command{
+	Use:   "snapshot <container>",
+	Short: "Snapshot a container's volumes",
+	Long: `Snapshot copies the rw volumes of a container into the snapshot store under
+the data directory. File contents are stored once per SHA-256, so snapshots
+of a volume only take space for the files that changed between them.
+
+Use --pause to freeze the container while the snapshot is taken, so that
+files written together are captured together. Older snapshots are pruned
+afterwards according to snapshots.keep and snapshots.max_age.`,
+	Args: cobra.ExactArgs(1),
+	RunE: createSnapshot,
+}
+
+// snapshotStore opens the snapshot store of the data directory
+func snapshotStore(dataDir string) (*budgiesync.SnapshotStore, error) {
+	return budgiesync.NewSnapshotStore(filepath.Join(dataDir, "snapshots"))
+}
+
+// retention returns the configured retention policy, overridden by the
+// --keep and --max-age flags if set
+func retention(cmd *cobra.Command, cfg *config.Config) budgiesync.RetentionPolicy {
+	policy := budgiesync.RetentionPolicy{
+		Keep:   cfg.Snapshots.Keep,
+		MaxAge: time.Duration(cfg.Snapshots.MaxAge) * 24 * time.Hour,
+	}
+	if cmd.Flags().Changed("keep") {
+		policy.Keep = keep
+	}
+	if cmd.Flags().Changed("max-age") {
+		policy.MaxAge = time.Duration(maxAge) * 24 * time.Hour
+	}
+	return policy
+}
+
+func createSnapshot(cmd *cobra.Command, args []string) error {
+	cmdCtx, err := cmdutil.NewCommandContext()
+	if err != nil {
+		return err
+	}
+
+	ctr, err := cmdutil.FindContainer(cmdCtx.Manager, args[0])
+	if err != nil {
+		return err
+	}
+	store, err := snapshotStore(cmdCtx.DataDir)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	paused := pause && ctr.IsRunning()
+	if paused {
+		if err := cmdCtx.Runtime.Pause(ctx, ctr.ID); err != nil {
+			return fmt.Errorf("failed to pause container: %w", err)
+		}
+	}
+	snap, err := store.Create(ctr, paused)
+	if paused {
+		if rerr := cmdCtx.Runtime.Resume(ctx, ctr.ID); rerr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to resume container %s: %v\n", ctr.ShortID(), rerr)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to snapshot %s: %w", ctr.ShortID(), err)
+	}
+
+	fmt.Printf("✅ Snapshot %s of %s: %d volumes, %s\n", snap.ID, ctr.ShortID(), len(snap.Volumes), formatSize(snap.Size()))
+
+	removed, err := store.Prune(retention(cmd, cmdCtx.Config))
+	if err != nil {
+		return fmt.Errorf("failed to prune snapshots: %w", err)
+	}
+	if len(removed) > 0 {
+		fmt.Printf("Pruned %d old snapshots\n", len(removed))
+	}
+	return nil
+}
+
+var snapshotsCmd = &cobra.Command{
+	Use:   "snapshots [container]",
+	Short: "List volume snapshots",
+	Long: `Snapshots lists the volume snapshots on this node, oldest first, or only
+those of the container given by name or ID prefix. The container need not
+exist anymore.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: listSnapshots,
+}
+
+func listSnapshots(cmd *cobra.Command, args []string) error {
+	store, err := snapshotStore(cmdutil.GetDataDir())
+	if err != nil {
+		return err
+	}
+	snaps, err := store.List("")
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	if len(args) == 1 {
+		var filtered []*budgiesync.Snapshot
+		for _, snap := range snaps {
+			if snap.Name == args[0] || strings.HasPrefix(snap.ContainerID, args[0]) {
+				filtered = append(filtered, snap)
+			}
+		}
+		snaps = filtered
+	}
+
+	if len(snaps) == 0 {
+		fmt.Println("No snapshots")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintf(w, "SNAPSHOT ID\tCONTAINER\tNAME\tCREATED\tVOLUMES\tSIZE\tPAUSED\n")
+	for _, snap := range snaps {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%v\n",
+			snap.ID,
+			cmdutil.FormatContainerID(snap.ContainerID),
+			snap.Name,
+			time.Since(snap.Created).Round(time.Second).String()+" ago",
+			len(snap.Volumes),
+			formatSize(snap.Size()),
+			snap.Paused)
+	}
+	return w.Flush()
+}
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove old volume snapshots",
+	Long: `Prune removes the snapshots the retention policy does not keep, and the
+file contents only they used. The policy is snapshots.keep and
+snapshots.max_age from the configuration, or --keep and --max-age. The newest
+snapshot of each container is always kept.`,
+	Args: cobra.NoArgs,
+	RunE: pruneSnapshots,
+}
+
+func pruneSnapshots(cmd *cobra.Command, args []string) error {
+	store, err := snapshotStore(cmdutil.GetDataDir())
+	if err != nil {
+		return err
+	}
+
+	removed, err := store.Prune(retention(cmd, config.Get()))
+	for _, snap := range removed {
+		fmt.Printf("Removed snapshot %s of %s\n", snap.ID, cmdutil.FormatContainerID(snap.ContainerID))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to prune snapshots: %w", err)
+	}
+	if len(removed) == 0 {
+		fmt.Println("No snapshots to prune")
+	}
+	return nil
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore <snapshot> [container]",
+	Short: "Restore a container's volumes from a snapshot",
+	Long: `Restore makes the volumes of a container match a snapshot: changed and
+removed files are restored, and files that were not in the snapshot are
+removed. The volumes are those of the snapshotted container, or of the
+container given, matched by target.
+
+The container must be stopped, unless --force is given.`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: restoreSnapshot,
+}
+
+func restoreSnapshot(cmd *cobra.Command, args []string) error {
+	cmdCtx, err := cmdutil.NewCommandContext()
+	if err != nil {
+		return err
+	}
+
+	store, err := snapshotStore(cmdCtx.DataDir)
+	if err != nil {
+		return err
+	}
+	snap, err := store.Get(args[0])
+	if err != nil {
+		return err
+	}
+
+	target := snap.ContainerID
+	if len(args) == 2 {
+		target = args[1]
+	}
+	ctr, err := cmdutil.FindContainer(cmdCtx.Manager, target)
+	if err != nil {
+		return err
+	}
+	if !forceRestore {
+		if err := cmdutil.RequireStopped(ctr); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Restoring snapshot %s into %s...\n", snap.ID, ctr.ShortID())
+	if err := store.Restore(snap, ctr); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Restored %d volumes of %s\n", len(snap.Volumes), ctr.ShortID())
+	return nil
+}
+
+// formatSize formats bytes into human-readable size
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+func GetVolumeCmd() *cobra.Command {
+	return volumeCmd
+}
+
+func init() {
+	volumeCmd.AddCommand(verifyCmd)
+	volumeCmd.AddCommand(statusCmd)
+	volumeCmd.AddCommand(snapshotCmd)
+	volumeCmd.AddCommand(snapshotsCmd)
+	volumeCmd.AddCommand(restoreCmd)
+	snapshotsCmd.AddCommand(pruneCmd)
+
+	snapshotCmd.Flags().BoolVar(&pause, "pause", false, "Pause the container while the snapshot is taken")
+	restoreCmd.Flags().BoolVarP(&forceRestore, "force", "f", false, "Restore into a running container")
+	for _, c := range []*cobra.Command{snapshotCmd, pruneCmd} {
+		c.Flags().IntVar(&keep, "keep", 0, "Newest snapshots to keep per container, 0 for unlimited (default snapshots.keep)")
+		c.Flags().IntVar(&maxAge, "max-age", 0, "Days to keep snapshots, 0 for unlimited (default snapshots.max_age)")
+	}
+}
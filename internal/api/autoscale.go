@@ -0,0 +1,369 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/zarigata/budgie/internal/events"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// autoscaleTolerance is how far a metric may stray from its target before
+// the autoscaler reacts, to avoid flapping around the target
+const autoscaleTolerance = 0.1
+
+// ScaleDecision is the outcome of one autoscaler evaluation
+type ScaleDecision struct {
+	Service     string
+	Current     int // Desired replicas before the evaluation
+	Recommended int // Replicas the current metrics call for
+	Target      int // Desired replicas after the evaluation
+	Reason      string
+}
+
+// Scaled reports whether the evaluation changed the replica count
+func (d *ScaleDecision) Scaled() bool {
+	return d.Target != d.Current
+}
+
+// Autoscaler adjusts the desired replica count of services with an
+// autoscale configuration based on their load
+type Autoscaler struct {
+	manager    *ContainerManager
+	controller *ReplicaController
+	metrics    MetricsSource
+	events     events.Recorder
+	interval   time.Duration
+	now        func() time.Time
+	mu         sync.Mutex
+	state      map[string]*scaleState
+	stopChan   chan struct{}
+	wg         sync.WaitGroup
+}
+
+// scaleState remembers recent recommendations and scaling times of a service
+type scaleState struct {
+	recommendations []recommendation
+	lastScaleUp     time.Time
+	lastScaleDown   time.Time
+}
+
+type recommendation struct {
+	at       time.Time
+	replicas int
+}
+
+// NewAutoscaler creates an autoscaler. controller applies new replica counts
+// right away and may be nil; recorder may be nil to only log decisions.
+func NewAutoscaler(manager *ContainerManager, controller *ReplicaController, metrics MetricsSource, recorder events.Recorder) *Autoscaler {
+	return &Autoscaler{
+		manager:    manager,
+		controller: controller,
+		metrics:    metrics,
+		events:     recorder,
+		interval:   15 * time.Second,
+		now:        time.Now,
+		state:      make(map[string]*scaleState),
+		stopChan:   make(chan struct{}),
+	}
+}
+
+// DefaultAutoscaleConfig returns the cooldowns and stabilization windows used
+// when a bundle does not set them
+func DefaultAutoscaleConfig() types.AutoscaleConfig {
+	return types.AutoscaleConfig{
+		ScaleUpCooldown:        time.Minute,
+		ScaleDownCooldown:      3 * time.Minute,
+		ScaleUpStabilization:   0,
+		ScaleDownStabilization: 5 * time.Minute,
+	}
+}
+
+func withAutoscaleDefaults(cfg types.AutoscaleConfig) types.AutoscaleConfig {
+	defaults := DefaultAutoscaleConfig()
+	if cfg.ScaleUpCooldown == 0 {
+		cfg.ScaleUpCooldown = defaults.ScaleUpCooldown
+	}
+	if cfg.ScaleDownCooldown == 0 {
+		cfg.ScaleDownCooldown = defaults.ScaleDownCooldown
+	}
+	if cfg.ScaleDownStabilization == 0 {
+		cfg.ScaleDownStabilization = defaults.ScaleDownStabilization
+	}
+	return cfg
+}
+
+// Start begins evaluating autoscaled services periodically
+func (a *Autoscaler) Start() {
+	a.wg.Add(1)
+	go a.monitor()
+	logrus.Info("Autoscaler started")
+}
+
+// Stop stops the autoscaler
+func (a *Autoscaler) Stop() {
+	close(a.stopChan)
+	a.wg.Wait()
+	logrus.Info("Autoscaler stopped")
+}
+
+func (a *Autoscaler) monitor() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stopChan:
+			return
+		case <-ticker.C:
+			a.EvaluateAll(context.Background())
+		}
+	}
+}
+
+// EvaluateAll evaluates every service that has an autoscale configuration
+func (a *Autoscaler) EvaluateAll(ctx context.Context) {
+	services := make(map[string]bool)
+	for _, ctr := range a.manager.List() {
+		if ctr.Replicas != nil && ctr.Replicas.Autoscale != nil {
+			services[ctr.Name] = true
+		}
+	}
+
+	for service := range services {
+		if _, err := a.Evaluate(ctx, service); err != nil {
+			logrus.Errorf("Failed to autoscale %s: %v", service, err)
+		}
+	}
+}
+
+// Evaluate compares a service's load with its targets and scales it within
+// replicas.min/max, subject to cooldowns and stabilization windows
+func (a *Autoscaler) Evaluate(ctx context.Context, service string) (*ScaleDecision, error) {
+	replicas := a.manager.serviceContainers(service)
+	if len(replicas) == 0 {
+		return nil, fmt.Errorf("no replicas of service %s", service)
+	}
+
+	bounds := replicas[len(replicas)-1].Replicas
+	if bounds == nil || bounds.Autoscale == nil {
+		return nil, fmt.Errorf("service %s has no autoscale configuration", service)
+	}
+	if bounds.Max <= 0 {
+		return nil, fmt.Errorf("autoscaling %s requires replicas.max", service)
+	}
+	cfg := withAutoscaleDefaults(*bounds.Autoscale)
+
+	load, err := a.metrics.ServiceLoad(ctx, service)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metrics: %w", err)
+	}
+
+	current := DesiredReplicas(bounds)
+	decision := &ScaleDecision{Service: service, Current: current, Recommended: current, Target: current}
+
+	if load.Replicas == 0 {
+		decision.Reason = "no running replicas"
+		return decision, nil
+	}
+
+	recommended, reason := recommendReplicas(cfg, load)
+	if recommended == 0 {
+		decision.Reason = "no metrics available"
+		return decision, nil
+	}
+	recommended = clamp(recommended, bounds.Min, bounds.Max)
+	decision.Recommended = recommended
+	decision.Reason = reason
+
+	now := a.now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	st := a.stateFor(service)
+	st.add(now, recommended, maxDuration(cfg.ScaleUpStabilization, cfg.ScaleDownStabilization))
+
+	var target int
+	switch {
+	case recommended > current:
+		// Only scale up as far as every recommendation in the window agrees
+		target = st.lowest(now.Add(-cfg.ScaleUpStabilization))
+		if target <= current {
+			decision.Reason = "scale up held by stabilization window: " + reason
+			return decision, nil
+		}
+		if now.Sub(st.lastScaleUp) < cfg.ScaleUpCooldown {
+			decision.Reason = "scale up held by cooldown: " + reason
+			return decision, nil
+		}
+
+	case recommended < current:
+		// Only scale down as far as the highest recent recommendation allows
+		target = st.highest(now.Add(-cfg.ScaleDownStabilization))
+		if target >= current {
+			decision.Reason = "scale down held by stabilization window: " + reason
+			return decision, nil
+		}
+		lastScale := st.lastScaleDown
+		if st.lastScaleUp.After(lastScale) {
+			lastScale = st.lastScaleUp
+		}
+		if now.Sub(lastScale) < cfg.ScaleDownCooldown {
+			decision.Reason = "scale down held by cooldown: " + reason
+			return decision, nil
+		}
+
+	default:
+		return decision, nil
+	}
+
+	if err := a.manager.SetDesiredReplicas(service, target); err != nil {
+		return nil, err
+	}
+	if target > current {
+		st.lastScaleUp = now
+	} else {
+		st.lastScaleDown = now
+	}
+	decision.Target = target
+
+	a.record(decision, load)
+
+	if a.controller != nil {
+		if err := a.controller.ReconcileService(ctx, service); err != nil {
+			return decision, fmt.Errorf("failed to apply replica count: %w", err)
+		}
+	}
+
+	return decision, nil
+}
+
+func (a *Autoscaler) record(decision *ScaleDecision, load *ServiceLoad) {
+	e := events.Event{
+		Time:    a.now(),
+		Kind:    events.KindScale,
+		Subject: decision.Service,
+		Message: fmt.Sprintf("scaled from %d to %d replicas: %s", decision.Current, decision.Target, decision.Reason),
+		Details: map[string]string{
+			"from":     strconv.Itoa(decision.Current),
+			"to":       strconv.Itoa(decision.Target),
+			"replicas": strconv.Itoa(load.Replicas),
+		},
+	}
+	for metric, value := range load.Values {
+		e.Details[string(metric)] = strconv.FormatFloat(value, 'f', 1, 64)
+	}
+
+	if a.events != nil {
+		a.events.Record(e)
+	} else {
+		logrus.Infof("Autoscaler: %s %s", e.Subject, e.Message)
+	}
+}
+
+func (a *Autoscaler) stateFor(service string) *scaleState {
+	st, ok := a.state[service]
+	if !ok {
+		st = &scaleState{}
+		a.state[service] = st
+	}
+	return st
+}
+
+// add stores a recommendation and forgets those older than keep
+func (s *scaleState) add(now time.Time, replicas int, keep time.Duration) {
+	cutoff := now.Add(-keep)
+	kept := s.recommendations[:0]
+	for _, r := range s.recommendations {
+		if !r.at.Before(cutoff) {
+			kept = append(kept, r)
+		}
+	}
+	s.recommendations = append(kept, recommendation{at: now, replicas: replicas})
+}
+
+// lowest returns the smallest recommendation made since cutoff
+func (s *scaleState) lowest(cutoff time.Time) int {
+	n := math.MaxInt
+	for _, r := range s.recommendations {
+		if !r.at.Before(cutoff) && r.replicas < n {
+			n = r.replicas
+		}
+	}
+	return n
+}
+
+// highest returns the largest recommendation made since cutoff
+func (s *scaleState) highest(cutoff time.Time) int {
+	n := 0
+	for _, r := range s.recommendations {
+		if !r.at.Before(cutoff) && r.replicas > n {
+			n = r.replicas
+		}
+	}
+	return n
+}
+
+// recommendReplicas returns the replica count that brings every configured
+// metric to its target, or 0 when none of them can be measured
+func recommendReplicas(cfg types.AutoscaleConfig, load *ServiceLoad) (int, string) {
+	targets := map[Metric]float64{
+		MetricCPU:      cfg.TargetCPUPercent,
+		MetricMemory:   cfg.TargetMemoryPercent,
+		MetricRequests: cfg.TargetRequestsPerSecond,
+	}
+
+	best := 0
+	var reasons []string
+	for metric, target := range targets {
+		value, ok := load.Values[metric]
+		if target <= 0 || !ok {
+			continue
+		}
+
+		perReplica := value
+		if metric == MetricRequests {
+			perReplica = value / float64(load.Replicas)
+		}
+
+		n := load.Replicas
+		if ratio := perReplica / target; math.Abs(ratio-1) > autoscaleTolerance {
+			n = int(math.Ceil(float64(load.Replicas) * ratio))
+		}
+		if n > best {
+			best = n
+		}
+		reasons = append(reasons, fmt.Sprintf("%s %.1f (target %.1f)", metric, perReplica, target))
+	}
+
+	sort.Strings(reasons)
+	return best, strings.Join(reasons, ", ")
+}
+
+func clamp(n, min, max int) int {
+	if n < min {
+		return min
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
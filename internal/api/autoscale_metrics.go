@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	budgieruntime "github.com/zarigata/budgie/internal/runtime"
+)
+
+// Metric names a load measurement the autoscaler can target
+type Metric string
+
+const (
+	MetricCPU      Metric = "cpu"      // Average CPU per replica, percent of one core
+	MetricMemory   Metric = "memory"   // Average memory per replica, percent of its limit
+	MetricRequests Metric = "requests" // Requests per second across all replicas
+)
+
+// ServiceLoad is a load sample for the running replicas of a service.
+// Metrics that could not be measured are missing from Values.
+type ServiceLoad struct {
+	Replicas int
+	Values   map[Metric]float64
+}
+
+// MetricsSource provides load samples to the autoscaler
+type MetricsSource interface {
+	ServiceLoad(ctx context.Context, service string) (*ServiceLoad, error)
+}
+
+// RequestCounter reports the cumulative number of requests a service's load
+// balancer pool has handled. *proxy.ContainerProxy satisfies it.
+type RequestCounter interface {
+	Requests(service string) int64
+}
+
+// RuntimeMetrics is a MetricsSource that reads cgroup statistics from the
+// runtime and request counts from the load balancer. Rates are computed
+// between consecutive samples, so the first sample of a replica has no CPU
+// or request rate.
+type RuntimeMetrics struct {
+	manager  *ContainerManager
+	runtime  budgieruntime.Runtime
+	requests RequestCounter
+	mu       sync.Mutex
+	cpu      map[string]*budgieruntime.ContainerStats
+	reqs     map[string]requestSample
+}
+
+type requestSample struct {
+	count int64
+	at    time.Time
+}
+
+// NewRuntimeMetrics creates a metrics source; requests may be nil when no
+// load balancer fronts the services
+func NewRuntimeMetrics(manager *ContainerManager, rt budgieruntime.Runtime, requests RequestCounter) *RuntimeMetrics {
+	return &RuntimeMetrics{
+		manager:  manager,
+		runtime:  rt,
+		requests: requests,
+		cpu:      make(map[string]*budgieruntime.ContainerStats),
+		reqs:     make(map[string]requestSample),
+	}
+}
+
+// ServiceLoad samples the running replicas of a service
+func (rm *RuntimeMetrics) ServiceLoad(ctx context.Context, service string) (*ServiceLoad, error) {
+	running := rm.manager.ServiceReplicas(service)
+	load := &ServiceLoad{Replicas: len(running), Values: make(map[Metric]float64)}
+
+	rm.mu.Lock()
+	defer rm.mu.Unlock()
+
+	var cpuSum, memSum float64
+	var cpuN, memN int
+	seen := make(map[string]bool)
+
+	for _, ctr := range running {
+		seen[ctr.ID] = true
+
+		stats, err := rm.runtime.Stats(ctx, ctr.ID)
+		if err != nil {
+			logrus.Debugf("No stats for replica %s: %v", ctr.ShortID(), err)
+			continue
+		}
+
+		if prev := rm.cpu[ctr.ID]; prev != nil {
+			if wall := stats.Timestamp.Sub(prev.Timestamp); wall > 0 {
+				cpuSum += float64(stats.CPUUsage-prev.CPUUsage) / float64(wall) * 100
+				cpuN++
+			}
+		}
+		rm.cpu[ctr.ID] = stats
+
+		limit := stats.MemoryLimit
+		if limit == 0 && ctr.Resources != nil {
+			limit = ctr.Resources.MemoryLimit
+		}
+		if limit > 0 {
+			memSum += float64(stats.MemoryUsage) / float64(limit) * 100
+			memN++
+		}
+	}
+
+	// Forget replicas that are gone
+	for id := range rm.cpu {
+		if !seen[id] {
+			delete(rm.cpu, id)
+		}
+	}
+
+	if cpuN > 0 {
+		load.Values[MetricCPU] = cpuSum / float64(cpuN)
+	}
+	if memN > 0 {
+		load.Values[MetricMemory] = memSum / float64(memN)
+	}
+
+	if rm.requests != nil {
+		now := time.Now()
+		count := rm.requests.Requests(service)
+		if prev, ok := rm.reqs[service]; ok && now.After(prev.at) && count >= prev.count {
+			load.Values[MetricRequests] = float64(count-prev.count) / now.Sub(prev.at).Seconds()
+		}
+		rm.reqs[service] = requestSample{count: count, at: now}
+	}
+
+	return load, nil
+}
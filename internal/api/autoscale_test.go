@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zarigata/budgie/internal/events"
+	budgieruntime "github.com/zarigata/budgie/internal/runtime"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// fakeMetrics reports fixed per-service values for the running replicas
+type fakeMetrics struct {
+	manager *ContainerManager
+	values  map[Metric]float64
+}
+
+func (f *fakeMetrics) ServiceLoad(ctx context.Context, service string) (*ServiceLoad, error) {
+	values := make(map[Metric]float64)
+	for k, v := range f.values {
+		values[k] = v
+	}
+	return &ServiceLoad{Replicas: len(f.manager.ServiceReplicas(service)), Values: values}, nil
+}
+
+type eventCapture struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (c *eventCapture) Record(e events.Event) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append(c.events, e)
+}
+
+type autoscaleFixture struct {
+	manager *ContainerManager
+	metrics *fakeMetrics
+	events  *eventCapture
+	scaler  *Autoscaler
+	clock   time.Time
+}
+
+func newAutoscaleFixture(t *testing.T, min, max int, cfg types.AutoscaleConfig) *autoscaleFixture {
+	t.Helper()
+	m, _ := newTestManager(t)
+	ctr := startReplicatedService(t, m, min, max)
+	ctr.Replicas.Autoscale = &cfg
+
+	f := &autoscaleFixture{
+		manager: m,
+		metrics: &fakeMetrics{manager: m, values: make(map[Metric]float64)},
+		events:  &eventCapture{},
+		clock:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	controller := NewReplicaController(m, nil, nil)
+	controller.ReconcileService(context.Background(), "web")
+
+	f.scaler = NewAutoscaler(m, controller, f.metrics, f.events)
+	f.scaler.now = func() time.Time { return f.clock }
+	return f
+}
+
+func (f *autoscaleFixture) evaluate(t *testing.T) *ScaleDecision {
+	t.Helper()
+	decision, err := f.scaler.Evaluate(context.Background(), "web")
+	if err != nil {
+		t.Fatalf("Evaluate failed: %v", err)
+	}
+	return decision
+}
+
+func (f *autoscaleFixture) running() int {
+	return len(f.manager.ServiceReplicas("web"))
+}
+
+func TestAutoscaler_ScalesUpOnCPU(t *testing.T) {
+	f := newAutoscaleFixture(t, 2, 10, types.AutoscaleConfig{TargetCPUPercent: 60})
+	f.metrics.values[MetricCPU] = 90
+
+	decision := f.evaluate(t)
+	if decision.Target != 3 || f.running() != 3 {
+		t.Errorf("Expected scale from 2 to 3 replicas, got target %d with %d running", decision.Target, f.running())
+	}
+
+	if len(f.events.events) != 1 || f.events.events[0].Kind != events.KindScale {
+		t.Fatalf("Expected one scale event, got %+v", f.events.events)
+	}
+	if details := f.events.events[0].Details; details["from"] != "2" || details["to"] != "3" || details["cpu"] != "90.0" {
+		t.Errorf("Unexpected event details: %v", details)
+	}
+}
+
+func TestAutoscaler_RespectsMax(t *testing.T) {
+	f := newAutoscaleFixture(t, 1, 4, types.AutoscaleConfig{TargetRequestsPerSecond: 10})
+	f.metrics.values[MetricRequests] = 500
+
+	if decision := f.evaluate(t); decision.Target != 4 {
+		t.Errorf("Expected scale to max 4, got %d", decision.Target)
+	}
+}
+
+func TestAutoscaler_ToleranceAvoidsFlapping(t *testing.T) {
+	f := newAutoscaleFixture(t, 2, 10, types.AutoscaleConfig{TargetCPUPercent: 60})
+	f.metrics.values[MetricCPU] = 64
+
+	if decision := f.evaluate(t); decision.Scaled() {
+		t.Errorf("Expected no change within tolerance, got %+v", decision)
+	}
+}
+
+func TestAutoscaler_ScaleUpCooldown(t *testing.T) {
+	f := newAutoscaleFixture(t, 1, 10, types.AutoscaleConfig{
+		TargetCPUPercent: 50,
+		ScaleUpCooldown:  time.Minute,
+	})
+	f.metrics.values[MetricCPU] = 100
+
+	if decision := f.evaluate(t); decision.Target != 2 {
+		t.Fatalf("Expected first scale up to 2, got %d", decision.Target)
+	}
+
+	f.clock = f.clock.Add(30 * time.Second)
+	if decision := f.evaluate(t); decision.Scaled() {
+		t.Errorf("Expected scale up within cooldown to be held, got %+v", decision)
+	}
+
+	f.clock = f.clock.Add(31 * time.Second)
+	if decision := f.evaluate(t); decision.Target != 4 {
+		t.Errorf("Expected scale up to 4 after cooldown, got %d", decision.Target)
+	}
+}
+
+func TestAutoscaler_ScaleDownStabilization(t *testing.T) {
+	f := newAutoscaleFixture(t, 1, 10, types.AutoscaleConfig{
+		TargetCPUPercent:       50,
+		ScaleDownCooldown:      time.Second,
+		ScaleDownStabilization: 5 * time.Minute,
+	})
+	f.manager.SetDesiredReplicas("web", 4)
+	f.scaler.controller.ReconcileService(context.Background(), "web")
+
+	// A busy sample, then the load drops
+	f.metrics.values[MetricCPU] = 50
+	f.evaluate(t)
+
+	f.clock = f.clock.Add(time.Minute)
+	f.metrics.values[MetricCPU] = 10
+	if decision := f.evaluate(t); decision.Scaled() {
+		t.Errorf("Expected scale down to be held by the stabilization window, got %+v", decision)
+	}
+
+	f.clock = f.clock.Add(5 * time.Minute)
+	decision := f.evaluate(t)
+	if decision.Target != 1 || f.running() != 1 {
+		t.Errorf("Expected scale down to 1 after the window, got target %d with %d running", decision.Target, f.running())
+	}
+}
+
+func TestAutoscaler_NoMetrics(t *testing.T) {
+	f := newAutoscaleFixture(t, 2, 10, types.AutoscaleConfig{TargetCPUPercent: 60})
+
+	if decision := f.evaluate(t); decision.Scaled() || decision.Reason != "no metrics available" {
+		t.Errorf("Expected no change without metrics, got %+v", decision)
+	}
+}
+
+// statsRuntime reports a fixed amount of CPU time per call
+type statsRuntime struct {
+	*fakeRuntime
+	mu    sync.Mutex
+	usage time.Duration
+	at    time.Time
+}
+
+func (s *statsRuntime) Stats(ctx context.Context, id string) (*budgieruntime.ContainerStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.usage += 500 * time.Millisecond
+	s.at = s.at.Add(time.Second)
+	return &budgieruntime.ContainerStats{
+		CPUUsage:    s.usage,
+		MemoryUsage: 256,
+		MemoryLimit: 1024,
+		Timestamp:   s.at,
+	}, nil
+}
+
+type fixedRequests struct{ count int64 }
+
+func (r *fixedRequests) Requests(service string) int64 { return r.count }
+
+func TestRuntimeMetrics_ServiceLoad(t *testing.T) {
+	rt := &statsRuntime{fakeRuntime: newFakeRuntime(), at: time.Now()}
+	m, err := NewContainerManager(rt, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	startReplicatedService(t, m, 1, 1)
+
+	requests := &fixedRequests{}
+	source := NewRuntimeMetrics(m, rt, requests)
+
+	load, _ := source.ServiceLoad(context.Background(), "web")
+	if _, ok := load.Values[MetricCPU]; ok {
+		t.Error("Expected no CPU rate from the first sample")
+	}
+	if load.Values[MetricMemory] != 25 {
+		t.Errorf("Expected 25%% memory, got %v", load.Values[MetricMemory])
+	}
+
+	requests.count = 100
+	load, _ = source.ServiceLoad(context.Background(), "web")
+	if load.Values[MetricCPU] != 50 {
+		t.Errorf("Expected 50%% CPU, got %v", load.Values[MetricCPU])
+	}
+	if load.Values[MetricRequests] <= 0 {
+		t.Errorf("Expected a request rate, got %v", load.Values[MetricRequests])
+	}
+}
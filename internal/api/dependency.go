@@ -175,3 +175,35 @@ func (dr *DependencyResolver) GetDependents(name string, dependenciesMap map[str
 	}
 	return dependents
 }
+
+// StopOrder returns containers ordered so that dependents stop before the
+// containers they depend on. Dependencies outside the list are ignored, and
+// instances sharing a name stop together.
+func (dr *DependencyResolver) StopOrder(containers []*types.Container) ([]*types.Container, error) {
+	byName := make(map[string][]*types.Container)
+	for _, ctr := range containers {
+		byName[ctr.Name] = append(byName[ctr.Name], ctr)
+	}
+
+	graph := NewDependencyGraph()
+	for _, instances := range byName {
+		var deps []string
+		for _, dep := range instances[0].DependsOn {
+			if _, ok := byName[dep]; ok {
+				deps = append(deps, dep)
+			}
+		}
+		graph.AddContainer(instances[0], deps)
+	}
+
+	start, err := graph.GetStartOrder()
+	if err != nil {
+		return nil, err
+	}
+
+	order := make([]*types.Container, 0, len(containers))
+	for i := len(start) - 1; i >= 0; i-- {
+		order = append(order, byName[start[i].Name]...)
+	}
+	return order, nil
+}
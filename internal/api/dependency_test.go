@@ -259,3 +259,32 @@ func TestDependencyResolver_GetDependents(t *testing.T) {
 		t.Error("worker should depend on database")
 	}
 }
+
+func TestDependencyResolver_StopOrder(t *testing.T) {
+	dr := &DependencyResolver{}
+
+	db := &types.Container{ID: "db", Name: "database"}
+	web1 := &types.Container{ID: "web1", Name: "webapp", DependsOn: []string{"database", "redis"}}
+	web2 := &types.Container{ID: "web2", Name: "webapp", DependsOn: []string{"database", "redis"}}
+	proxy := &types.Container{ID: "proxy", Name: "proxy", DependsOn: []string{"webapp"}}
+
+	// redis is not in the list, so the dependency on it is ignored
+	order, err := dr.StopOrder([]*types.Container{db, web1, proxy, web2})
+	if err != nil {
+		t.Fatalf("StopOrder failed: %v", err)
+	}
+	if len(order) != 4 {
+		t.Fatalf("Expected 4 containers, got %d", len(order))
+	}
+
+	position := make(map[string]int)
+	for i, ctr := range order {
+		position[ctr.ID] = i
+	}
+	if position["proxy"] > position["web1"] || position["proxy"] > position["web2"] {
+		t.Error("proxy should stop before webapp")
+	}
+	if position["web1"] > position["db"] || position["web2"] > position["db"] {
+		t.Error("webapp should stop before database")
+	}
+}
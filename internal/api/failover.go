@@ -0,0 +1,486 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/zarigata/budgie/internal/events"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// ReplicaMember is a member of a replica set as announced by its node
+type ReplicaMember struct {
+	ContainerID string
+	NodeID      string
+	Role        types.ReplicationRole // Empty means primary
+	Epoch       uint64
+	Address     string // IP the member is announced on
+	Port        int    // Host port the member is announced on
+}
+
+// IsPrimary returns true if the member acts as primary
+func (m ReplicaMember) IsPrimary() bool {
+	return m.Role != types.RoleReplica
+}
+
+// ReplicaView reports the replica sets visible in the cluster and whether
+// nodes are alive
+type ReplicaView interface {
+	// ReplicaSets returns the announced members of every replica set, keyed by set ID
+	ReplicaSets() (map[string][]ReplicaMember, error)
+	NodeAlive(nodeID string) bool
+}
+
+// VolumeSync moves volume data between the members of a replica set. Data
+// flows from the primary, which pushes, to replicas, which receive. Replicas
+// push their bidirectional volumes back to the primary.
+type VolumeSync interface {
+	Push(ctr *types.Container, to ReplicaMember) error
+	// Replicate keeps pushing changes to the members a container has pushed
+	// to and stops pushing to others; an empty list stops replication
+	Replicate(ctr *types.Container, replicas []ReplicaMember)
+	Receive(ctr *types.Container) error
+	StopReceiving(ctr *types.Container)
+}
+
+// FailoverController promotes a replica when the primary of its replica set
+// is lost, and demotes a returning primary that was replaced, so that each
+// set has a single primary
+type FailoverController struct {
+	manager  *ContainerManager
+	nodeID   string
+	view     ReplicaView
+	volumes  VolumeSync
+	registry BackendRegistry
+	events   events.Recorder
+	interval time.Duration
+	drain    time.Duration
+	primary  map[string]ReplicaMember   // Last live primary seen per replica set
+	receive  map[string]bool            // Local replicas registered to receive data
+	synced   map[string]bool            // Remote members a local container has pushed to
+	pushing  map[string][]ReplicaMember // Members each local container replicates to
+	mu       sync.Mutex
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewFailoverController creates a failover controller for the containers of
+// this node. volumes, registry and recorder may be nil.
+func NewFailoverController(manager *ContainerManager, nodeID string, view ReplicaView, volumes VolumeSync, registry BackendRegistry, recorder events.Recorder) *FailoverController {
+	return &FailoverController{
+		manager:  manager,
+		nodeID:   nodeID,
+		view:     view,
+		volumes:  volumes,
+		registry: registry,
+		events:   recorder,
+		interval: 5 * time.Second,
+		drain:    30 * time.Second,
+		primary:  make(map[string]ReplicaMember),
+		receive:  make(map[string]bool),
+		synced:   make(map[string]bool),
+		pushing:  make(map[string][]ReplicaMember),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start begins watching replica sets
+func (fc *FailoverController) Start() {
+	fc.wg.Add(1)
+	go fc.monitor()
+	logrus.Info("Failover controller started")
+}
+
+// Stop stops the failover controller
+func (fc *FailoverController) Stop() {
+	close(fc.stopChan)
+	fc.wg.Wait()
+	logrus.Info("Failover controller stopped")
+}
+
+func (fc *FailoverController) monitor() {
+	defer fc.wg.Done()
+
+	ticker := time.NewTicker(fc.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-fc.stopChan:
+			return
+		case <-ticker.C:
+			if err := fc.Reconcile(context.Background()); err != nil {
+				logrus.Warnf("Failover check failed: %v", err)
+			}
+		}
+	}
+}
+
+// Reconcile checks every running local container against its replica set
+func (fc *FailoverController) Reconcile(ctx context.Context) error {
+	sets, err := fc.view.ReplicaSets()
+	if err != nil {
+		return fmt.Errorf("failed to list replica sets: %w", err)
+	}
+
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	running := make(map[string]bool)
+	for _, ctr := range fc.manager.List() {
+		if !ctr.IsRunning() {
+			continue
+		}
+		running[ctr.ID] = true
+
+		var others []ReplicaMember
+		for _, m := range sets[ctr.ReplicaSetID()] {
+			if m.ContainerID != ctr.ID {
+				others = append(others, m)
+			}
+		}
+
+		var err error
+		if ctr.IsReplica() {
+			err = fc.checkReplica(ctx, ctr, others)
+		} else {
+			err = fc.checkPrimary(ctx, ctr, others)
+		}
+		if err != nil {
+			logrus.Errorf("Failover of %s failed: %v", ctr.ShortID(), err)
+		}
+	}
+
+	// Stop replicating containers that stopped running
+	for id := range fc.pushing {
+		if !running[id] {
+			fc.stopPushing(&types.Container{ID: id})
+		}
+	}
+
+	return nil
+}
+
+// checkReplica follows the live primary of the set, or promotes this replica
+// when the primary's node is gone and this replica is the chosen successor
+func (fc *FailoverController) checkReplica(ctx context.Context, ctr *types.Container, others []ReplicaMember) error {
+	set := ctr.ReplicaSetID()
+
+	// Replicas started outside the controller, such as by chirp join or
+	// uncordon, receive data from the next check on
+	if fc.volumes != nil && !fc.receive[ctr.ID] {
+		if err := fc.volumes.Receive(ctr); err != nil {
+			logrus.Warnf("Failed to receive data for %s: %v", ctr.ShortID(), err)
+		} else {
+			fc.receive[ctr.ID] = true
+		}
+	}
+
+	if primary, ok := fc.livePrimary(others); ok {
+		fc.primary[set] = primary
+		fc.pushBack(ctr, primary)
+		if primary.Epoch == ctr.Epoch && len(ctr.Peers) > 0 && ctr.Peers[0] == primary.NodeID {
+			return nil
+		}
+		// A replica elsewhere was promoted: follow it from now on
+		if err := fc.manager.SetReplication(ctr.ID, types.RoleReplica, primary.Epoch, []string{primary.NodeID}); err != nil {
+			return err
+		}
+		fc.record(ctr, "following new primary", map[string]string{"primary": primary.NodeID, "epoch": strconv.FormatUint(primary.Epoch, 10)})
+		return nil
+	}
+
+	// The primary is not announced. Only fail over once its node is gone,
+	// not while it merely restarts the container.
+	oldNode := ""
+	if len(ctr.Peers) > 0 {
+		oldNode = ctr.Peers[0]
+	}
+	if oldNode != "" && fc.view.NodeAlive(oldNode) {
+		return nil
+	}
+
+	// Every replica picks the same successor: the live replica with the
+	// lowest node ID, then container ID
+	candidates := []ReplicaMember{{ContainerID: ctr.ID, NodeID: fc.nodeID, Role: types.RoleReplica, Epoch: ctr.Epoch}}
+	epoch := ctr.Epoch
+	for _, m := range others {
+		if m.Epoch > epoch {
+			epoch = m.Epoch
+		}
+		if !m.IsPrimary() && fc.view.NodeAlive(m.NodeID) {
+			candidates = append(candidates, m)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return before(candidates[i], candidates[j]) })
+	if candidates[0].ContainerID != ctr.ID {
+		return nil
+	}
+
+	return fc.promote(ctx, ctr, oldNode, epoch+1, candidates[1:])
+}
+
+// checkPrimary demotes this primary when another primary of the set has won
+func (fc *FailoverController) checkPrimary(ctx context.Context, ctr *types.Container, others []ReplicaMember) error {
+	self := ReplicaMember{ContainerID: ctr.ID, NodeID: fc.nodeID, Role: types.RolePrimary, Epoch: ctr.Epoch}
+
+	winner, ok := fc.livePrimary(others)
+	if ok && wins(winner, self) {
+		return fc.demote(ctx, ctr, winner)
+	}
+
+	// Push data to replicas that appeared since the last check, such as a
+	// former primary that rejoined
+	for _, m := range others {
+		if m.IsPrimary() || !fc.view.NodeAlive(m.NodeID) || fc.synced[m.ContainerID] {
+			continue
+		}
+		if fc.volumes != nil {
+			if err := fc.volumes.Push(ctr, m); err != nil {
+				logrus.Warnf("Failed to sync %s to replica on %s: %v", ctr.ShortID(), m.NodeID, err)
+				continue
+			}
+		}
+		fc.synced[m.ContainerID] = true
+
+		if !contains(ctr.Peers, m.NodeID) {
+			peers := append(append([]string(nil), ctr.Peers...), m.NodeID)
+			if err := fc.manager.SetReplication(ctr.ID, roleOf(ctr), ctr.Epoch, peers); err != nil {
+				return err
+			}
+		}
+	}
+
+	fc.replicate(ctr, others)
+	return nil
+}
+
+// replicate keeps the replicas that have been pushed to up to date. Replicas
+// that are no longer announced are dropped and get a full push if they
+// return; those on unreachable nodes catch up once they can be reached.
+func (fc *FailoverController) replicate(ctr *types.Container, others []ReplicaMember) {
+	if fc.volumes == nil {
+		return
+	}
+
+	announced := make(map[string]bool)
+	var replicas []ReplicaMember
+	for _, m := range others {
+		announced[m.ContainerID] = true
+		if !m.IsPrimary() && fc.synced[m.ContainerID] {
+			replicas = append(replicas, m)
+		}
+	}
+	for _, m := range fc.pushing[ctr.ID] {
+		if !announced[m.ContainerID] {
+			delete(fc.synced, m.ContainerID)
+		}
+	}
+
+	fc.volumes.Replicate(ctr, replicas)
+	if len(replicas) == 0 {
+		delete(fc.pushing, ctr.ID)
+	} else {
+		fc.pushing[ctr.ID] = replicas
+	}
+}
+
+// pushBack sends the bidirectional volumes of a replica to its primary and
+// keeps them replicated, so that changes made on the replica reach the set
+func (fc *FailoverController) pushBack(ctr *types.Container, primary ReplicaMember) {
+	if fc.volumes == nil || !ctr.HasBidirectionalVolumes() {
+		return
+	}
+	if !fc.synced[primary.ContainerID] {
+		if err := fc.volumes.Push(ctr, primary); err != nil {
+			logrus.Warnf("Failed to sync %s to primary on %s: %v", ctr.ShortID(), primary.NodeID, err)
+			return
+		}
+		fc.synced[primary.ContainerID] = true
+	}
+
+	// Stop pushing to a previous primary
+	for _, m := range fc.pushing[ctr.ID] {
+		if m.ContainerID != primary.ContainerID {
+			delete(fc.synced, m.ContainerID)
+		}
+	}
+	fc.volumes.Replicate(ctr, []ReplicaMember{primary})
+	fc.pushing[ctr.ID] = []ReplicaMember{primary}
+}
+
+// stopPushing stops replicating a container whose role changed
+func (fc *FailoverController) stopPushing(ctr *types.Container) {
+	if fc.volumes == nil {
+		return
+	}
+	fc.volumes.Replicate(ctr, nil)
+	for _, m := range fc.pushing[ctr.ID] {
+		delete(fc.synced, m.ContainerID)
+	}
+	delete(fc.pushing, ctr.ID)
+}
+
+// promote makes a replica the primary of its set: it stops receiving data,
+// pushes its volumes to the remaining replicas and takes over the proxy pool
+func (fc *FailoverController) promote(ctx context.Context, ctr *types.Container, oldNode string, epoch uint64, replicas []ReplicaMember) error {
+	var peers []string
+	for _, m := range replicas {
+		peers = append(peers, m.NodeID)
+	}
+	if oldNode != "" {
+		// The old primary rejoins as a replica when it comes back
+		peers = append(peers, oldNode)
+	}
+
+	if err := fc.manager.SetReplication(ctr.ID, types.RolePrimary, epoch, peers); err != nil {
+		return err
+	}
+
+	if fc.volumes != nil {
+		fc.volumes.StopReceiving(ctr)
+		delete(fc.receive, ctr.ID)
+		fc.stopPushing(ctr)
+		for _, m := range replicas {
+			if err := fc.volumes.Push(ctr, m); err != nil {
+				logrus.Warnf("Failed to sync %s to replica on %s: %v", ctr.ShortID(), m.NodeID, err)
+				continue
+			}
+			fc.synced[m.ContainerID] = true
+		}
+	}
+
+	service := ctr.ServiceName()
+	if fc.registry != nil {
+		if ip, port, ok := backendAddress(ctr); ok {
+			if err := fc.registry.AddServiceBackend(service, ip, port, ctr.Health); err != nil {
+				logrus.Warnf("Failed to add %s to pool %s: %v", ctr.ShortID(), service, err)
+			}
+		}
+		if old, ok := fc.primary[ctr.ReplicaSetID()]; ok && old.Address != "" {
+			// The old primary drains in the background
+			if _, err := fc.registry.StartDrain(service, old.Address, old.Port, fc.drain); err != nil {
+				logrus.Debugf("Old primary %s was not in pool %s: %v", old.NodeID, service, err)
+			}
+		}
+	}
+
+	fc.record(ctr, "promoted to primary", map[string]string{"previous": oldNode, "epoch": strconv.FormatUint(epoch, 10)})
+	return nil
+}
+
+// demote turns a replaced primary into a replica of the winner
+func (fc *FailoverController) demote(ctx context.Context, ctr *types.Container, winner ReplicaMember) error {
+	if err := fc.manager.SetReplication(ctr.ID, types.RoleReplica, winner.Epoch, []string{winner.NodeID}); err != nil {
+		return err
+	}
+	fc.primary[ctr.ReplicaSetID()] = winner
+
+	if fc.registry != nil {
+		if ip, port, ok := backendAddress(ctr); ok {
+			if _, err := fc.registry.StartDrain(ctr.ServiceName(), ip, port, fc.drain); err != nil {
+				logrus.Debugf("Replica %s was not in pool %s: %v", ctr.ShortID(), ctr.ServiceName(), err)
+			}
+		}
+	}
+
+	if fc.volumes != nil {
+		fc.stopPushing(ctr)
+		if err := fc.volumes.Receive(ctr); err != nil {
+			logrus.Warnf("Failed to receive data for %s: %v", ctr.ShortID(), err)
+		} else {
+			fc.receive[ctr.ID] = true
+		}
+	}
+
+	fc.record(ctr, "rejoined as replica", map[string]string{"primary": winner.NodeID, "epoch": strconv.FormatUint(winner.Epoch, 10)})
+	return nil
+}
+
+// livePrimary returns the winning primary among members on live nodes
+func (fc *FailoverController) livePrimary(members []ReplicaMember) (ReplicaMember, bool) {
+	var best ReplicaMember
+	found := false
+	for _, m := range members {
+		if !m.IsPrimary() || !fc.view.NodeAlive(m.NodeID) {
+			continue
+		}
+		if !found || wins(m, best) {
+			best, found = m, true
+		}
+	}
+	return best, found
+}
+
+func (fc *FailoverController) record(ctr *types.Container, message string, details map[string]string) {
+	if fc.events == nil {
+		logrus.Infof("Container %s %s", ctr.ShortID(), message)
+		return
+	}
+	details["container"] = ctr.ID
+	details["replica_set"] = ctr.ReplicaSetID()
+	fc.events.Record(events.Event{
+		Kind:    events.KindFailover,
+		Subject: ctr.ServiceName(),
+		Message: message,
+		Details: details,
+	})
+}
+
+// wins reports whether primary a takes precedence over primary b: the higher
+// epoch wins, and equal epochs are settled the same way on every node
+func wins(a, b ReplicaMember) bool {
+	if a.Epoch != b.Epoch {
+		return a.Epoch > b.Epoch
+	}
+	return before(a, b)
+}
+
+func before(a, b ReplicaMember) bool {
+	if a.NodeID != b.NodeID {
+		return a.NodeID < b.NodeID
+	}
+	return a.ContainerID < b.ContainerID
+}
+
+func roleOf(ctr *types.Container) types.ReplicationRole {
+	if ctr.Role == "" {
+		return types.RolePrimary
+	}
+	return ctr.Role
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SetReplication records the replication role of a container
+func (m *ContainerManager) SetReplication(id string, role types.ReplicationRole, epoch uint64, peers []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ctr, exists := m.containers[id]
+	if !exists {
+		return fmt.Errorf("container not found: %s", id)
+	}
+
+	ctr.Role = role
+	ctr.Epoch = epoch
+	ctr.Peers = peers
+	if ctr.ReplicaSet == "" {
+		ctr.ReplicaSet = ctr.ID
+	}
+
+	return m.saveState()
+}
@@ -0,0 +1,357 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zarigata/budgie/internal/events"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+type fakeView struct {
+	sets  map[string][]ReplicaMember
+	alive map[string]bool
+}
+
+func (v *fakeView) ReplicaSets() (map[string][]ReplicaMember, error) {
+	return v.sets, nil
+}
+
+func (v *fakeView) NodeAlive(nodeID string) bool {
+	return v.alive[nodeID]
+}
+
+// fakeVolumeSync records which containers push, replicate, receive and
+// stop receiving
+type fakeVolumeSync struct {
+	mu          sync.Mutex
+	pushed      []string
+	replicating map[string][]string // Container ID -> replica nodes
+	receiving   map[string]bool
+}
+
+func (s *fakeVolumeSync) Push(ctr *types.Container, to ReplicaMember) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pushed = append(s.pushed, to.NodeID)
+	return nil
+}
+
+func (s *fakeVolumeSync) Replicate(ctr *types.Container, replicas []ReplicaMember) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(replicas) == 0 {
+		delete(s.replicating, ctr.ID)
+		return
+	}
+	var nodes []string
+	for _, m := range replicas {
+		nodes = append(nodes, m.NodeID)
+	}
+	s.replicating[ctr.ID] = nodes
+}
+
+func (s *fakeVolumeSync) Receive(ctr *types.Container) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.receiving[ctr.ID] = true
+	return nil
+}
+
+func (s *fakeVolumeSync) StopReceiving(ctr *types.Container) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.receiving, ctr.ID)
+}
+
+const testReplicaSet = "set-1"
+
+func startReplicaMember(t *testing.T, m *ContainerManager, role types.ReplicationRole, epoch uint64, peers ...string) *types.Container {
+	t.Helper()
+	ctr := &types.Container{
+		ID:         types.GenerateContainerID(),
+		Name:       "db",
+		Image:      types.ImageConfig{DockerImage: "db:1"},
+		Ports:      []types.PortMapping{{ContainerPort: 5432, HostPort: 5432}},
+		Volumes:    []types.VolumeMapping{{Source: t.TempDir(), Target: "/data", Mode: "rw"}},
+		Role:       role,
+		ReplicaSet: testReplicaSet,
+		Epoch:      epoch,
+		Peers:      peers,
+		CreatedAt:  time.Now(),
+	}
+	if err := m.Create(context.Background(), ctr); err != nil {
+		t.Fatalf("Failed to create container: %v", err)
+	}
+	if err := m.Start(context.Background(), ctr.ID); err != nil {
+		t.Fatalf("Failed to start container: %v", err)
+	}
+	return ctr
+}
+
+type failoverFixture struct {
+	manager  *ContainerManager
+	view     *fakeView
+	volumes  *fakeVolumeSync
+	registry *fakeRegistry
+	events   *eventCapture
+	fc       *FailoverController
+}
+
+func newFailoverFixture(t *testing.T, nodeID string) *failoverFixture {
+	t.Helper()
+	m, _ := newTestManager(t)
+	f := &failoverFixture{
+		manager:  m,
+		view:     &fakeView{sets: make(map[string][]ReplicaMember), alive: map[string]bool{nodeID: true}},
+		volumes:  &fakeVolumeSync{replicating: make(map[string][]string), receiving: make(map[string]bool)},
+		registry: &fakeRegistry{},
+		events:   &eventCapture{},
+	}
+	f.fc = NewFailoverController(m, nodeID, f.view, f.volumes, f.registry, f.events)
+	return f
+}
+
+func (f *failoverFixture) reconcile(t *testing.T) {
+	t.Helper()
+	if err := f.fc.Reconcile(context.Background()); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+}
+
+func TestFailover_PromotesWhenPrimaryNodeDies(t *testing.T) {
+	f := newFailoverFixture(t, "node-b")
+	ctr := startReplicaMember(t, f.manager, types.RoleReplica, 1, "node-a")
+	f.volumes.receiving[ctr.ID] = true
+
+	primary := ReplicaMember{ContainerID: "primary", NodeID: "node-a", Role: types.RolePrimary, Epoch: 1, Address: "10.0.0.1", Port: 5432}
+	f.view.sets[testReplicaSet] = []ReplicaMember{primary, {ContainerID: ctr.ID, NodeID: "node-b", Role: types.RoleReplica, Epoch: 1}}
+	f.view.alive["node-a"] = true
+
+	f.reconcile(t)
+	if got, _ := f.manager.Get(ctr.ID); !got.IsReplica() {
+		t.Fatal("Expected no promotion while the primary is alive")
+	}
+
+	// The primary node dies and stops being announced
+	f.view.alive["node-a"] = false
+	f.view.sets[testReplicaSet] = f.view.sets[testReplicaSet][1:]
+	f.reconcile(t)
+
+	got, _ := f.manager.Get(ctr.ID)
+	if got.IsReplica() || got.Epoch != 2 {
+		t.Fatalf("Expected promotion to primary at epoch 2, got role=%s epoch=%d", got.Role, got.Epoch)
+	}
+	if len(got.Peers) != 1 || got.Peers[0] != "node-a" {
+		t.Errorf("Expected the old primary to be kept as a peer, got %v", got.Peers)
+	}
+	if f.volumes.receiving[ctr.ID] {
+		t.Error("Expected the promoted container to stop receiving data")
+	}
+
+	want := []string{"add 5432", "drain 5432"}
+	if len(f.registry.events) != len(want) || f.registry.events[0] != want[0] || f.registry.events[1] != want[1] {
+		t.Errorf("Expected pool events %v, got %v", want, f.registry.events)
+	}
+	if len(f.events.events) != 1 || f.events.events[0].Kind != events.KindFailover {
+		t.Errorf("Expected one failover event, got %+v", f.events.events)
+	}
+}
+
+func TestFailover_WaitsWhilePrimaryNodeIsAlive(t *testing.T) {
+	f := newFailoverFixture(t, "node-b")
+	ctr := startReplicaMember(t, f.manager, types.RoleReplica, 1, "node-a")
+
+	// The primary container is restarting, so it is not announced
+	f.view.alive["node-a"] = true
+	f.reconcile(t)
+
+	if got, _ := f.manager.Get(ctr.ID); !got.IsReplica() {
+		t.Error("Expected no promotion while the primary node is alive")
+	}
+}
+
+func TestFailover_LowestReplicaIsPromoted(t *testing.T) {
+	f := newFailoverFixture(t, "node-c")
+	ctr := startReplicaMember(t, f.manager, types.RoleReplica, 1, "node-a")
+
+	other := ReplicaMember{ContainerID: "other", NodeID: "node-b", Role: types.RoleReplica, Epoch: 1, Address: "10.0.0.2"}
+	f.view.sets[testReplicaSet] = []ReplicaMember{other}
+	f.view.alive["node-b"] = true
+
+	f.reconcile(t)
+	if got, _ := f.manager.Get(ctr.ID); !got.IsReplica() {
+		t.Fatal("Expected node-b to be promoted instead of node-c")
+	}
+
+	// node-b dies before promoting itself
+	f.view.alive["node-b"] = false
+	f.reconcile(t)
+	if got, _ := f.manager.Get(ctr.ID); got.IsReplica() {
+		t.Error("Expected node-c to be promoted once node-b is gone")
+	}
+}
+
+func TestFailover_PromotedPrimaryPushesToReplicas(t *testing.T) {
+	f := newFailoverFixture(t, "node-a")
+	startReplicaMember(t, f.manager, types.RoleReplica, 1, "node-old")
+
+	f.view.sets[testReplicaSet] = []ReplicaMember{{ContainerID: "other", NodeID: "node-b", Role: types.RoleReplica, Epoch: 1, Address: "10.0.0.2"}}
+	f.view.alive["node-b"] = true
+
+	f.reconcile(t)
+	if len(f.volumes.pushed) != 1 || f.volumes.pushed[0] != "node-b" {
+		t.Errorf("Expected a push to node-b, got %v", f.volumes.pushed)
+	}
+}
+
+func TestFailover_ReturningPrimaryDemotes(t *testing.T) {
+	f := newFailoverFixture(t, "node-a")
+	ctr := startReplicaMember(t, f.manager, types.RolePrimary, 1, "node-b")
+
+	// node-b took over while node-a was away
+	f.view.sets[testReplicaSet] = []ReplicaMember{{ContainerID: "new", NodeID: "node-b", Role: types.RolePrimary, Epoch: 2, Address: "10.0.0.2"}}
+	f.view.alive["node-b"] = true
+
+	f.reconcile(t)
+
+	got, _ := f.manager.Get(ctr.ID)
+	if !got.IsReplica() || got.Epoch != 2 || got.Peers[0] != "node-b" {
+		t.Fatalf("Expected demotion to a replica of node-b, got role=%s epoch=%d peers=%v", got.Role, got.Epoch, got.Peers)
+	}
+	if !f.volumes.receiving[ctr.ID] {
+		t.Error("Expected the demoted container to receive data")
+	}
+	if len(f.registry.events) != 1 || f.registry.events[0] != "drain 5432" {
+		t.Errorf("Expected the demoted container to be drained, got %v", f.registry.events)
+	}
+}
+
+func TestFailover_PrimaryPushesToReturningPeer(t *testing.T) {
+	f := newFailoverFixture(t, "node-b")
+	startReplicaMember(t, f.manager, types.RolePrimary, 2, "node-a")
+
+	// node-a was the primary before and is already a peer; it comes back as a replica
+	f.view.sets[testReplicaSet] = []ReplicaMember{{ContainerID: "old", NodeID: "node-a", Role: types.RoleReplica, Epoch: 2, Address: "10.0.0.1"}}
+	f.view.alive["node-a"] = true
+
+	f.reconcile(t)
+	f.reconcile(t)
+	if len(f.volumes.pushed) != 1 || f.volumes.pushed[0] != "node-a" {
+		t.Errorf("Expected a single push to node-a, got %v", f.volumes.pushed)
+	}
+}
+
+func TestFailover_PrimaryReplicatesToPushedReplicas(t *testing.T) {
+	f := newFailoverFixture(t, "node-a")
+	ctr := startReplicaMember(t, f.manager, types.RolePrimary, 1)
+
+	replica := ReplicaMember{ContainerID: "replica", NodeID: "node-b", Role: types.RoleReplica, Epoch: 1, Address: "10.0.0.2"}
+	f.view.sets[testReplicaSet] = []ReplicaMember{replica}
+	f.view.alive["node-b"] = true
+
+	f.reconcile(t)
+	if got := f.volumes.replicating[ctr.ID]; len(got) != 1 || got[0] != "node-b" {
+		t.Fatalf("Expected replication to node-b, got %v", got)
+	}
+
+	// An unreachable replica that is still announced keeps its place
+	f.view.alive["node-b"] = false
+	f.reconcile(t)
+	if got := f.volumes.replicating[ctr.ID]; len(got) != 1 {
+		t.Errorf("Expected replication to node-b to be kept, got %v", got)
+	}
+
+	// A replica that is gone is dropped, and pushed to again if it returns
+	f.view.sets[testReplicaSet] = nil
+	f.reconcile(t)
+	if got, ok := f.volumes.replicating[ctr.ID]; ok {
+		t.Errorf("Expected replication to stop, got %v", got)
+	}
+	f.view.sets[testReplicaSet] = []ReplicaMember{replica}
+	f.view.alive["node-b"] = true
+	f.reconcile(t)
+	if len(f.volumes.pushed) != 2 {
+		t.Errorf("Expected a second push to the returning replica, got %v", f.volumes.pushed)
+	}
+}
+
+func TestFailover_DemotedPrimaryStopsReplicating(t *testing.T) {
+	f := newFailoverFixture(t, "node-a")
+	ctr := startReplicaMember(t, f.manager, types.RolePrimary, 1)
+
+	f.view.sets[testReplicaSet] = []ReplicaMember{{ContainerID: "replica", NodeID: "node-b", Role: types.RoleReplica, Epoch: 1, Address: "10.0.0.2"}}
+	f.view.alive["node-b"] = true
+	f.reconcile(t)
+
+	// node-b was promoted meanwhile
+	f.view.sets[testReplicaSet] = []ReplicaMember{{ContainerID: "replica", NodeID: "node-b", Role: types.RolePrimary, Epoch: 2, Address: "10.0.0.2"}}
+	f.reconcile(t)
+
+	if got, ok := f.volumes.replicating[ctr.ID]; ok {
+		t.Errorf("Expected the demoted primary to stop replicating, got %v", got)
+	}
+}
+
+func TestFailover_PrimaryKeepsOlderEpochReplicas(t *testing.T) {
+	f := newFailoverFixture(t, "node-b")
+	ctr := startReplicaMember(t, f.manager, types.RolePrimary, 2)
+
+	// The old primary is still announced with its stale epoch
+	f.view.sets[testReplicaSet] = []ReplicaMember{{ContainerID: "old", NodeID: "node-a", Role: types.RolePrimary, Epoch: 1}}
+	f.view.alive["node-a"] = true
+
+	f.reconcile(t)
+	if got, _ := f.manager.Get(ctr.ID); got.IsReplica() {
+		t.Error("Expected the higher epoch primary to stay primary")
+	}
+}
+
+func TestFailover_ReplicaFollowsNewPrimary(t *testing.T) {
+	f := newFailoverFixture(t, "node-c")
+	ctr := startReplicaMember(t, f.manager, types.RoleReplica, 1, "node-a")
+
+	f.view.sets[testReplicaSet] = []ReplicaMember{{ContainerID: "new", NodeID: "node-b", Role: types.RolePrimary, Epoch: 2}}
+	f.view.alive["node-b"] = true
+
+	f.reconcile(t)
+
+	got, _ := f.manager.Get(ctr.ID)
+	if !got.IsReplica() || got.Epoch != 2 || got.Peers[0] != "node-b" {
+		t.Errorf("Expected to follow node-b at epoch 2, got role=%s epoch=%d peers=%v", got.Role, got.Epoch, got.Peers)
+	}
+}
+
+func TestFailover_ReplicaPushesBidirectionalVolumes(t *testing.T) {
+	f := newFailoverFixture(t, "node-c")
+	ctr := startReplicaMember(t, f.manager, types.RoleReplica, 1, "node-a")
+
+	f.view.sets[testReplicaSet] = []ReplicaMember{{ContainerID: "primary", NodeID: "node-a", Role: types.RolePrimary, Epoch: 1}}
+	f.view.alive["node-a"] = true
+
+	// Replicas of one-way volumes only receive
+	f.reconcile(t)
+	if len(f.volumes.pushed) != 0 {
+		t.Fatalf("Expected a one-way replica not to push, got %v", f.volumes.pushed)
+	}
+
+	ctr.Volumes[0].Sync = types.SyncBidirectional
+	f.reconcile(t)
+	f.reconcile(t)
+	if len(f.volumes.pushed) != 1 || f.volumes.pushed[0] != "node-a" {
+		t.Errorf("Expected one push to the primary, got %v", f.volumes.pushed)
+	}
+	if got := f.volumes.replicating[ctr.ID]; len(got) != 1 || got[0] != "node-a" {
+		t.Errorf("Expected replication to node-a, got %v", got)
+	}
+
+	// A new primary is pushed to instead
+	f.view.sets[testReplicaSet] = []ReplicaMember{{ContainerID: "new", NodeID: "node-b", Role: types.RolePrimary, Epoch: 2}}
+	f.view.alive["node-b"] = true
+	f.reconcile(t)
+	if got := f.volumes.replicating[ctr.ID]; len(got) != 1 || got[0] != "node-b" {
+		t.Errorf("Expected replication to move to node-b, got %v", got)
+	}
+}
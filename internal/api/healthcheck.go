@@ -3,7 +3,9 @@ package api
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
@@ -83,6 +85,22 @@ func (hm *HealthCheckMonitor) GetHealth(containerID string) *ContainerHealth {
 	return hm.health[containerID]
 }
 
+// Status returns the current health of a container, or HealthStatusNone if
+// it has no HTTP health check
+func (hm *HealthCheckMonitor) Status(ctr *types.Container) HealthStatus {
+	if ctr.Health == nil || ctr.Health.Path == "" {
+		return HealthStatusNone
+	}
+
+	health := hm.GetHealth(ctr.ID)
+	if health == nil {
+		return HealthStatusStarting
+	}
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	return health.Status
+}
+
 func (hm *HealthCheckMonitor) monitor() {
 	defer hm.wg.Done()
 
@@ -162,17 +180,9 @@ func (hm *HealthCheckMonitor) shouldRunHealthCheck(ctr *types.Container, health
 func (hm *HealthCheckMonitor) runHealthCheck(ctr *types.Container, health *ContainerHealth) {
 	start := time.Now()
 
-	// Determine health check URL
-	var checkURL string
-	for _, port := range ctr.Ports {
-		if port.HostPort > 0 {
-			checkURL = fmt.Sprintf("http://localhost:%d%s", port.HostPort, ctr.Health.Path)
-			break
-		}
-	}
-
-	if checkURL == "" {
-		// No exposed port, skip health check
+	checkURL, ok := healthCheckURL(ctr)
+	if !ok {
+		// No reachable port, skip health check
 		return
 	}
 
@@ -205,6 +215,21 @@ func (hm *HealthCheckMonitor) runHealthCheck(ctr *types.Container, health *Conta
 	}
 }
 
+// healthCheckURL returns where a container is probed: where the load
+// balancer reaches it, at its IP or else a published host port. Replicas that
+// surge during a rolling update publish no host port.
+func healthCheckURL(ctr *types.Container) (string, bool) {
+	ip, port, ok := backendAddress(ctr)
+	if !ok {
+		hostPort, found := fixedHostPort(ctr)
+		if !found {
+			return "", false
+		}
+		ip, port = "localhost", hostPort
+	}
+	return fmt.Sprintf("http://%s%s", net.JoinHostPort(ip, strconv.Itoa(port)), ctr.Health.Path), true
+}
+
 func (hm *HealthCheckMonitor) recordHealthCheck(ctr *types.Container, health *ContainerHealth, start time.Time, exitCode int, output string) {
 	health.mu.Lock()
 	defer health.mu.Unlock()
@@ -1,8 +1,11 @@
 package api
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"testing"
 	"time"
 
@@ -289,3 +292,42 @@ func TestContainerWithHealthCheck(t *testing.T) {
 	_ = expectedURL
 	_ = actualURL
 }
+
+func TestWaitHealthy_ProbesReplicaWithoutHostPort(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ready" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	// Like a surge replica: reachable at its IP, with no published host port
+	m, _ := newTestManager(t)
+	ctr := &types.Container{
+		ID:            types.GenerateContainerID(),
+		Name:          "web",
+		Image:         types.ImageConfig{DockerImage: "web:2"},
+		Ports:         []types.PortMapping{{ContainerPort: port}},
+		NetworkConfig: &types.NetworkConfig{IPAddress: u.Hostname()},
+		Health:        &types.HealthCheck{Path: "/ready", Interval: 100 * time.Millisecond},
+		CreatedAt:     time.Now(),
+	}
+	if err := m.Create(context.Background(), ctr); err != nil {
+		t.Fatalf("Failed to create replica: %v", err)
+	}
+	if err := m.Start(context.Background(), ctr.ID); err != nil {
+		t.Fatalf("Failed to start replica: %v", err)
+	}
+
+	monitor := NewHealthCheckMonitor(m, nil)
+	monitor.Start()
+	defer monitor.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := monitor.WaitHealthy(ctx, ctr); err != nil {
+		t.Fatalf("Expected the replica to become healthy, got %v", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -171,6 +172,40 @@ func (m *ContainerManager) List() []*types.Container {
 	return list
 }
 
+// Find returns the container with the given ID, ID prefix or name. It fails
+// if no container or more than one matches.
+func (m *ContainerManager) Find(idOrName string) (*types.Container, error) {
+	if ctr, err := m.Get(idOrName); err == nil {
+		return ctr, nil
+	}
+
+	var matches []*types.Container
+	for _, ctr := range m.List() {
+		if strings.HasPrefix(ctr.ID, idOrName) || ctr.Name == idOrName {
+			matches = append(matches, ctr)
+		}
+	}
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no such container: %s", idOrName)
+	}
+
+	if len(matches) > 1 {
+		var ids []string
+		for _, m := range matches {
+			ids = append(ids, m.ShortID())
+		}
+		return nil, fmt.Errorf("ambiguous container ID %q, matches: %s", idOrName, strings.Join(ids, ", "))
+	}
+
+	return matches[0], nil
+}
+
+// Runtime returns the runtime the manager runs containers with
+func (m *ContainerManager) Runtime() budgieruntime.Runtime {
+	return m.runtime
+}
+
 func (m *ContainerManager) loadState() error {
 	data, err := os.ReadFile(m.statePath)
 	if os.IsNotExist(err) {
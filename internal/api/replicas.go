@@ -0,0 +1,299 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/zarigata/budgie/internal/placement"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// PeerCounter reports how many instances of a service run on other nodes
+type PeerCounter func(service string) (int, error)
+
+// ReplicaPlacer starts an instance of a service on the peer the scheduler
+// picks for it. It returns false, without starting anything, when this node
+// is the best fit.
+type ReplicaPlacer func(ctx context.Context, template *types.Container) (bool, error)
+
+// LeaderCheck reports whether this node currently leads the cluster
+type LeaderCheck func() bool
+
+// ReplicaController keeps the number of running instances of each replicated
+// service at its desired count, within the bundle's replicas.min/max bounds
+type ReplicaController struct {
+	manager        *ContainerManager
+	restartMonitor *RestartMonitor
+	peers          PeerCounter
+	placer         ReplicaPlacer
+	labels         map[string]string
+	isLeader       LeaderCheck
+	stopChan       chan struct{}
+	wg             sync.WaitGroup
+	interval       time.Duration
+	stopTimeout    time.Duration
+}
+
+// NewReplicaController creates a replica controller. Dead replicas that
+// restartMonitor will bring back are not replaced; peers may be nil to only
+// count instances on this node.
+func NewReplicaController(manager *ContainerManager, restartMonitor *RestartMonitor, peers PeerCounter) *ReplicaController {
+	return &ReplicaController{
+		manager:        manager,
+		restartMonitor: restartMonitor,
+		peers:          peers,
+		stopChan:       make(chan struct{}),
+		interval:       10 * time.Second,
+		stopTimeout:    10 * time.Second,
+	}
+}
+
+// SetNodeLabels sets the labels of this node, checked against the placement
+// constraints of a service before creating replicas here
+func (rc *ReplicaController) SetNodeLabels(labels map[string]string) {
+	rc.labels = labels
+}
+
+// SetPlacer places missing replicas on peers, found through discovery, where
+// the scheduler finds more room than on this node
+func (rc *ReplicaController) SetPlacer(placer ReplicaPlacer) {
+	rc.placer = placer
+}
+
+// SetLeaderCheck makes only the leader place replicas on peers, so that
+// nodes do not race to fill the same gap elsewhere. Every node keeps
+// reconciling the services it runs, starting missing replicas locally.
+func (rc *ReplicaController) SetLeaderCheck(isLeader LeaderCheck) {
+	rc.isLeader = isLeader
+}
+
+// placesOnPeers returns true if missing replicas may be placed on peers
+func (rc *ReplicaController) placesOnPeers() bool {
+	return rc.placer != nil && (rc.isLeader == nil || rc.isLeader())
+}
+
+// DesiredReplicas returns how many instances of a service should run
+func DesiredReplicas(cfg *types.ReplicasConfig) int {
+	if cfg == nil {
+		return 1
+	}
+
+	n := cfg.Min
+	if cfg.Desired != nil {
+		n = *cfg.Desired
+	} else if n < 1 {
+		n = 1
+	}
+	if n < cfg.Min {
+		n = cfg.Min
+	}
+	if cfg.Max > 0 && n > cfg.Max {
+		n = cfg.Max
+	}
+
+	return n
+}
+
+// Start begins reconciling replicated services
+func (rc *ReplicaController) Start() {
+	rc.wg.Add(1)
+	go rc.monitor()
+	logrus.Info("Replica controller started")
+}
+
+// Stop stops the replica controller
+func (rc *ReplicaController) Stop() {
+	close(rc.stopChan)
+	rc.wg.Wait()
+	logrus.Info("Replica controller stopped")
+}
+
+func (rc *ReplicaController) monitor() {
+	defer rc.wg.Done()
+
+	ticker := time.NewTicker(rc.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rc.stopChan:
+			return
+		case <-ticker.C:
+			rc.Reconcile(context.Background())
+		}
+	}
+}
+
+// Reconcile brings every replicated service to its desired count
+func (rc *ReplicaController) Reconcile(ctx context.Context) {
+	services := make(map[string]bool)
+	for _, ctr := range rc.manager.List() {
+		if ctr.Replicas != nil {
+			services[ctr.Name] = true
+		}
+	}
+
+	for service := range services {
+		if err := rc.ReconcileService(ctx, service); err != nil {
+			logrus.Errorf("Failed to reconcile replicas of %s: %v", service, err)
+		}
+	}
+}
+
+// ReconcileService starts or stops instances of a service until the instances
+// here and on peers add up to the desired count. Missing instances are placed
+// on peers when a placer is set and this node leads, and on this node
+// otherwise.
+func (rc *ReplicaController) ReconcileService(ctx context.Context, service string) error {
+	replicas := rc.manager.serviceContainers(service)
+	if len(replicas) == 0 {
+		return fmt.Errorf("no replicas of service %s", service)
+	}
+
+	template := replicas[len(replicas)-1]
+	desired := DesiredReplicas(template.Replicas)
+
+	var running, dead []*types.Container
+	pending := 0
+	for _, ctr := range replicas {
+		switch {
+		case ctr.State == types.StateRunning:
+			running = append(running, ctr)
+		case ctr.State == types.StateStopped || ctr.State == types.StateFailed:
+			if rc.restartMonitor != nil && rc.restartMonitor.shouldRestart(ctr) {
+				pending++
+			} else {
+				dead = append(dead, ctr)
+			}
+		default:
+			pending++
+		}
+	}
+
+	remote := 0
+	if rc.peers != nil {
+		n, err := rc.peers(service)
+		if err != nil {
+			logrus.Warnf("Failed to count peer replicas of %s: %v", service, err)
+		} else {
+			remote = n
+		}
+	}
+
+	have := len(running) + pending + remote
+
+	var placementErr error
+	for i := have; i < desired; i++ {
+		if rc.placesOnPeers() {
+			placed, err := rc.placer(ctx, template)
+			if err != nil {
+				logrus.Warnf("Failed to place a replica of %s on a peer: %v", service, err)
+			}
+			if placed {
+				logrus.Infof("Placed replica of %s on a peer (%d/%d)", service, i+1, desired)
+				continue
+			}
+		}
+
+		if err := placement.Check(template.Placement, rc.labels, rc.manager.RunningServices()); err != nil {
+			placementErr = fmt.Errorf("cannot place more replicas of %s on this node: %w", service, err)
+			break
+		}
+
+		ctr := newReplica(template)
+		if err := rc.manager.Create(ctx, ctr); err != nil {
+			return err
+		}
+		if err := rc.manager.Start(ctx, ctr.ID); err != nil {
+			return err
+		}
+		logrus.Infof("Started replica %s of %s (%d/%d)", ctr.ShortID(), service, i+1, desired)
+	}
+
+	// Dead replicas nobody restarts have been replaced above
+	for _, ctr := range dead {
+		if err := rc.manager.Remove(ctx, ctr.ID); err != nil {
+			logrus.Warnf("Failed to remove dead replica %s: %v", ctr.ShortID(), err)
+		}
+	}
+
+	// Scale down newest first, but only instances on this node
+	for i := len(running) - 1; i >= 0 && have > desired; i-- {
+		ctr := running[i]
+		if err := rc.manager.Stop(ctx, ctr.ID, rc.stopTimeout); err != nil {
+			return err
+		}
+		if err := rc.manager.Remove(ctx, ctr.ID); err != nil {
+			return err
+		}
+		have--
+		logrus.Infof("Removed replica %s of %s (%d/%d)", ctr.ShortID(), service, have, desired)
+	}
+
+	return placementErr
+}
+
+// SetDesiredReplicas records how many instances of a service should run. The
+// count must be within the service's replicas.min/max bounds.
+func (m *ContainerManager) SetDesiredReplicas(service string, n int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var replicas []*types.Container
+	for _, ctr := range m.containers {
+		if ctr.Name == service {
+			replicas = append(replicas, ctr)
+		}
+	}
+	if len(replicas) == 0 {
+		return fmt.Errorf("no replicas of service %s", service)
+	}
+
+	bounds := replicas[0].Replicas
+	if bounds == nil {
+		return fmt.Errorf("service %s has no replicas configuration", service)
+	}
+	if n < bounds.Min || (bounds.Max > 0 && n > bounds.Max) {
+		return fmt.Errorf("replica count %d is outside the bounds [%d,%d] of service %s", n, bounds.Min, bounds.Max, service)
+	}
+
+	for _, ctr := range replicas {
+		cfg := *ctr.Replicas
+		cfg.Desired = &n
+		ctr.Replicas = &cfg
+	}
+
+	return m.saveState()
+}
+
+// RunningServices returns the number of running instances of each service
+func (m *ContainerManager) RunningServices() map[string]int {
+	services := make(map[string]int)
+	for _, ctr := range m.List() {
+		if ctr.IsRunning() {
+			services[ctr.Name]++
+		}
+	}
+	return services
+}
+
+// serviceContainers returns every replica of a service in any state, oldest first
+func (m *ContainerManager) serviceContainers(service string) []*types.Container {
+	var replicas []*types.Container
+	for _, ctr := range m.List() {
+		if ctr.Name == service {
+			replicas = append(replicas, ctr)
+		}
+	}
+
+	sort.Slice(replicas, func(i, j int) bool {
+		return replicas[i].CreatedAt.Before(replicas[j].CreatedAt)
+	})
+
+	return replicas
+}
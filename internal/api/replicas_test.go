@@ -0,0 +1,268 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+func startReplicatedService(t *testing.T, m *ContainerManager, min, max int) *types.Container {
+	t.Helper()
+	ctr := &types.Container{
+		ID:            types.GenerateContainerID(),
+		Name:          "web",
+		Image:         types.ImageConfig{DockerImage: "web:1"},
+		Replicas:      &types.ReplicasConfig{Min: min, Max: max},
+		RestartPolicy: &types.RestartPolicy{Name: "no"},
+		CreatedAt:     time.Now(),
+	}
+	if err := m.Create(context.Background(), ctr); err != nil {
+		t.Fatalf("Failed to create container: %v", err)
+	}
+	if err := m.Start(context.Background(), ctr.ID); err != nil {
+		t.Fatalf("Failed to start container: %v", err)
+	}
+	return ctr
+}
+
+func TestDesiredReplicas(t *testing.T) {
+	three, ten := 3, 10
+	tests := []struct {
+		cfg      *types.ReplicasConfig
+		expected int
+	}{
+		{nil, 1},
+		{&types.ReplicasConfig{}, 1},
+		{&types.ReplicasConfig{Min: 2, Max: 5}, 2},
+		{&types.ReplicasConfig{Min: 2, Max: 5, Desired: &three}, 3},
+		{&types.ReplicasConfig{Min: 2, Max: 5, Desired: &ten}, 5},
+	}
+
+	for _, tc := range tests {
+		if got := DesiredReplicas(tc.cfg); got != tc.expected {
+			t.Errorf("DesiredReplicas(%+v): got %d, want %d", tc.cfg, got, tc.expected)
+		}
+	}
+}
+
+func TestReplicaController_StartsMinReplicas(t *testing.T) {
+	m, _ := newTestManager(t)
+	startReplicatedService(t, m, 3, 5)
+
+	rc := NewReplicaController(m, nil, nil)
+	if err := rc.ReconcileService(context.Background(), "web"); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	if n := len(m.ServiceReplicas("web")); n != 3 {
+		t.Errorf("Expected 3 running replicas, got %d", n)
+	}
+}
+
+func TestReplicaController_ReplacesDeadReplicas(t *testing.T) {
+	m, _ := newTestManager(t)
+	startReplicatedService(t, m, 2, 4)
+
+	rc := NewReplicaController(m, NewRestartMonitor(m), nil)
+	rc.ReconcileService(context.Background(), "web")
+
+	victim := m.ServiceReplicas("web")[0]
+	m.Stop(context.Background(), victim.ID, time.Second)
+
+	if err := rc.ReconcileService(context.Background(), "web"); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	if n := len(m.ServiceReplicas("web")); n != 2 {
+		t.Errorf("Expected dead replica to be replaced, %d running", n)
+	}
+	if _, err := m.Get(victim.ID); err == nil {
+		t.Error("Expected the replaced replica to be removed")
+	}
+}
+
+func TestReplicaController_LeavesRestartableReplicas(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctr := startReplicatedService(t, m, 1, 2)
+	ctr.RestartPolicy = &types.RestartPolicy{Name: "always"}
+
+	m.Stop(context.Background(), ctr.ID, time.Second)
+
+	rc := NewReplicaController(m, NewRestartMonitor(m), nil)
+	rc.ReconcileService(context.Background(), "web")
+
+	if n := len(m.List()); n != 1 {
+		t.Errorf("Expected the restart monitor to own the stopped replica, got %d containers", n)
+	}
+}
+
+func TestReplicaController_Scale(t *testing.T) {
+	m, _ := newTestManager(t)
+	startReplicatedService(t, m, 1, 4)
+	rc := NewReplicaController(m, nil, nil)
+
+	if err := m.SetDesiredReplicas("web", 4); err != nil {
+		t.Fatalf("Failed to scale up: %v", err)
+	}
+	rc.ReconcileService(context.Background(), "web")
+	if n := len(m.ServiceReplicas("web")); n != 4 {
+		t.Errorf("Expected 4 replicas after scaling up, got %d", n)
+	}
+
+	oldest := m.ServiceReplicas("web")[0]
+	if err := m.SetDesiredReplicas("web", 2); err != nil {
+		t.Fatalf("Failed to scale down: %v", err)
+	}
+	rc.ReconcileService(context.Background(), "web")
+	replicas := m.ServiceReplicas("web")
+	if len(replicas) != 2 {
+		t.Errorf("Expected 2 replicas after scaling down, got %d", len(replicas))
+	}
+	if replicas[0].ID != oldest.ID {
+		t.Error("Expected the newest replicas to be removed first")
+	}
+
+	if err := m.SetDesiredReplicas("web", 5); err == nil {
+		t.Error("Expected a count above max to be rejected")
+	}
+	if err := m.SetDesiredReplicas("web", 0); err == nil {
+		t.Error("Expected a count below min to be rejected")
+	}
+}
+
+func TestReplicaController_CountsPeers(t *testing.T) {
+	m, _ := newTestManager(t)
+	startReplicatedService(t, m, 3, 5)
+
+	peers := func(service string) (int, error) { return 2, nil }
+	rc := NewReplicaController(m, nil, peers)
+	rc.ReconcileService(context.Background(), "web")
+
+	if n := len(m.ServiceReplicas("web")); n != 1 {
+		t.Errorf("Expected peers to cover 2 of 3 replicas, %d running locally", n)
+	}
+
+	failing := func(service string) (int, error) { return 0, fmt.Errorf("discovery unavailable") }
+	rc = NewReplicaController(m, nil, failing)
+	rc.ReconcileService(context.Background(), "web")
+
+	if n := len(m.ServiceReplicas("web")); n != 3 {
+		t.Errorf("Expected local replicas to make up for unreachable peers, got %d", n)
+	}
+}
+
+func TestReplicaController_PlacesOnPeers(t *testing.T) {
+	m, _ := newTestManager(t)
+	startReplicatedService(t, m, 4, 5)
+
+	// The scheduler picks a peer for the first replica, then this node, then fails
+	calls := 0
+	placer := func(ctx context.Context, template *types.Container) (bool, error) {
+		calls++
+		switch calls {
+		case 1:
+			return true, nil
+		case 2:
+			return false, nil
+		default:
+			return false, fmt.Errorf("no nodes available")
+		}
+	}
+	rc := NewReplicaController(m, nil, nil)
+	rc.SetPlacer(placer)
+	if err := rc.ReconcileService(context.Background(), "web"); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+
+	if calls != 3 {
+		t.Errorf("Expected a placement for each of the 3 missing replicas, got %d", calls)
+	}
+	if n := len(m.ServiceReplicas("web")); n != 3 {
+		t.Errorf("Expected 2 replicas started locally next to the first, got %d", n)
+	}
+}
+
+func TestReplicaController_RespectsPlacement(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctr := startReplicatedService(t, m, 3, 5)
+	ctr.Placement = &types.PlacementConfig{Constraints: []string{"disk==ssd"}}
+
+	rc := NewReplicaController(m, nil, nil)
+	rc.SetNodeLabels(map[string]string{"disk": "hdd"})
+	if err := rc.ReconcileService(context.Background(), "web"); err == nil {
+		t.Error("Expected an error when the node does not satisfy the constraints")
+	}
+	if n := len(m.ServiceReplicas("web")); n != 1 {
+		t.Errorf("Expected no replicas on a non-matching node, got %d running", n)
+	}
+
+	rc.SetNodeLabels(map[string]string{"disk": "ssd"})
+	if err := rc.ReconcileService(context.Background(), "web"); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if n := len(m.ServiceReplicas("web")); n != 3 {
+		t.Errorf("Expected 3 running replicas on a matching node, got %d", n)
+	}
+}
+
+func TestReplicaController_RespectsAntiAffinity(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctr := startReplicatedService(t, m, 3, 5)
+	ctr.Placement = &types.PlacementConfig{AntiAffinity: []string{"web"}}
+
+	rc := NewReplicaController(m, nil, nil)
+	if err := rc.ReconcileService(context.Background(), "web"); err == nil {
+		t.Error("Expected an error when anti-affinity prevents more local replicas")
+	}
+	if n := len(m.ServiceReplicas("web")); n != 1 {
+		t.Errorf("Expected a single replica per node, got %d", n)
+	}
+}
+
+func TestReplicaController_OnlyLeaderPlacesOnPeers(t *testing.T) {
+	m, _ := newTestManager(t)
+	startReplicatedService(t, m, 3, 5)
+
+	var leader atomic.Bool
+	var placed atomic.Int32
+	rc := NewReplicaController(m, nil, nil)
+	rc.interval = 10 * time.Millisecond
+	rc.SetPlacer(func(ctx context.Context, template *types.Container) (bool, error) {
+		placed.Add(1)
+		return true, nil
+	})
+	rc.SetLeaderCheck(leader.Load)
+	rc.Start()
+
+	// A follower still heals its own service, on this node
+	deadline := time.Now().Add(2 * time.Second)
+	for len(m.ServiceReplicas("web")) != 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	rc.Stop()
+	if n := len(m.ServiceReplicas("web")); n != 3 {
+		t.Errorf("Expected a follower to reconcile to 3 local replicas, got %d", n)
+	}
+	if n := placed.Load(); n != 0 {
+		t.Errorf("Expected a follower not to place replicas on peers, got %d", n)
+	}
+
+	// The leader places what is missing on peers
+	leader.Store(true)
+	if err := m.SetDesiredReplicas("web", 4); err != nil {
+		t.Fatalf("SetDesiredReplicas failed: %v", err)
+	}
+	if err := rc.ReconcileService(context.Background(), "web"); err != nil {
+		t.Fatalf("Reconcile failed: %v", err)
+	}
+	if n := placed.Load(); n != 1 {
+		t.Errorf("Expected the leader to place the missing replica on a peer, got %d", n)
+	}
+	if n := len(m.ServiceReplicas("web")); n != 3 {
+		t.Errorf("Expected no more local replicas, got %d", n)
+	}
+}
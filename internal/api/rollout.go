@@ -0,0 +1,385 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// RolloutConfig controls how a rolling update replaces the replicas of a service
+type RolloutConfig struct {
+	MaxSurge       int           // Extra replicas allowed above the current count
+	MaxUnavailable int           // Replicas allowed to be out of service at once
+	HealthTimeout  time.Duration // Time a new replica has to become ready
+	DrainTimeout   time.Duration // Time an old replica has to finish in-flight requests
+	StopTimeout    time.Duration // Graceful stop timeout for old replicas
+}
+
+// DefaultRolloutConfig returns settings that replace one replica at a time
+// without ever dropping below the current replica count
+func DefaultRolloutConfig() RolloutConfig {
+	return RolloutConfig{
+		MaxSurge:       1,
+		MaxUnavailable: 0,
+		HealthTimeout:  2 * time.Minute,
+		DrainTimeout:   30 * time.Second,
+		StopTimeout:    10 * time.Second,
+	}
+}
+
+// RolloutConfigFor applies a container's update settings on top of the defaults
+func RolloutConfigFor(ctr *types.Container) RolloutConfig {
+	cfg := DefaultRolloutConfig()
+	if ctr == nil || ctr.Update == nil {
+		return cfg
+	}
+
+	if ctr.Update.MaxSurge > 0 || ctr.Update.MaxUnavailable > 0 {
+		cfg.MaxSurge = ctr.Update.MaxSurge
+		cfg.MaxUnavailable = ctr.Update.MaxUnavailable
+	}
+	if ctr.Update.HealthTimeout > 0 {
+		cfg.HealthTimeout = ctr.Update.HealthTimeout
+	}
+
+	return cfg
+}
+
+func (c RolloutConfig) validate() error {
+	if c.MaxSurge < 0 || c.MaxUnavailable < 0 {
+		return fmt.Errorf("max_surge and max_unavailable must not be negative")
+	}
+	if c.MaxSurge == 0 && c.MaxUnavailable == 0 {
+		return fmt.Errorf("max_surge and max_unavailable cannot both be 0")
+	}
+	return nil
+}
+
+// BackendRegistry is the part of the load balancer a rolling update drives.
+// Backends are keyed by service name and health checked the way their
+// replica's bundle asks. *proxy.ContainerProxy satisfies it.
+type BackendRegistry interface {
+	AddServiceBackend(service, ip string, port int, health *types.HealthCheck) error
+	// StartDrain stops sending new requests to a backend and returns at once.
+	// The channel is closed once the backend has been removed, after its
+	// in-flight requests have finished or timeout has passed.
+	StartDrain(service, ip string, port int, timeout time.Duration) (<-chan struct{}, error)
+}
+
+// ReadinessCheck blocks until a started replica is ready to receive traffic
+type ReadinessCheck func(ctx context.Context, ctr *types.Container) error
+
+// RolloutResult describes a finished rolling update
+type RolloutResult struct {
+	Service  string
+	Replaced int
+	Created  []string // IDs of the new replicas
+}
+
+// RollingUpdater replaces the replicas of a service in batches
+type RollingUpdater struct {
+	manager  *ContainerManager
+	registry BackendRegistry
+	ready    ReadinessCheck
+}
+
+// NewRollingUpdater creates a rolling updater. registry may be nil when no load
+// balancer fronts the service; ready may be nil to only wait for the start.
+func NewRollingUpdater(manager *ContainerManager, registry BackendRegistry, ready ReadinessCheck) *RollingUpdater {
+	return &RollingUpdater{
+		manager:  manager,
+		registry: registry,
+		ready:    ready,
+	}
+}
+
+// ServiceReplicas returns the running replicas of a service, oldest first
+func (m *ContainerManager) ServiceReplicas(service string) []*types.Container {
+	var replicas []*types.Container
+	for _, ctr := range m.serviceContainers(service) {
+		if ctr.State == types.StateRunning {
+			replicas = append(replicas, ctr)
+		}
+	}
+	return replicas
+}
+
+// Update replaces every running replica of service with one built from template.
+// Each batch starts new replicas, waits for them to become ready and registers
+// them before the old replicas are drained and stopped. If a new replica fails,
+// all changes are rolled back and the old replicas are restored.
+func (u *RollingUpdater) Update(ctx context.Context, service string, template *types.Container, cfg RolloutConfig) (*RolloutResult, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	// Surge replicas run next to the replicas they replace
+	if port, ok := fixedHostPort(template); ok && cfg.MaxSurge > 0 {
+		return nil, fmt.Errorf("replicas of %s publish host port %d, which surge replicas cannot bind: update with max_surge 0 and max_unavailable 1 or more", service, port)
+	}
+
+	old := u.manager.ServiceReplicas(service)
+	if len(old) == 0 {
+		return nil, fmt.Errorf("no running replicas of service %s", service)
+	}
+
+	logrus.Infof("Rolling update of %s: %d replicas, image %s (max surge %d, max unavailable %d)",
+		service, len(old), template.Image.DockerImage, cfg.MaxSurge, cfg.MaxUnavailable)
+
+	var created, stopped []*types.Container
+
+	for done := 0; done < len(old); {
+		n := cfg.MaxSurge + cfg.MaxUnavailable
+		if remaining := len(old) - done; n > remaining {
+			n = remaining
+		}
+		early := cfg.MaxUnavailable
+		if early > n {
+			early = n
+		}
+		batch := old[done : done+n]
+
+		// Replicas that may be unavailable are taken out before their replacements start
+		retired, err := u.retire(ctx, service, batch[:early], cfg)
+		stopped = append(stopped, retired...)
+		if err != nil {
+			return nil, u.rollback(service, created, stopped, cfg, err)
+		}
+
+		for i := 0; i < n; i++ {
+			ctr, err := u.launch(ctx, service, template, cfg)
+			if ctr != nil {
+				created = append(created, ctr)
+			}
+			if err != nil {
+				return nil, u.rollback(service, created, stopped, cfg, err)
+			}
+		}
+
+		retired, err = u.retire(ctx, service, batch[early:], cfg)
+		stopped = append(stopped, retired...)
+		if err != nil {
+			return nil, u.rollback(service, created, stopped, cfg, err)
+		}
+
+		done += n
+		logrus.Infof("Rolling update of %s: %d/%d replicas replaced", service, done, len(old))
+	}
+
+	result := &RolloutResult{Service: service, Replaced: len(old)}
+	for _, ctr := range created {
+		result.Created = append(result.Created, ctr.ID)
+	}
+
+	for _, ctr := range stopped {
+		if err := u.manager.Remove(ctx, ctr.ID); err != nil {
+			logrus.Warnf("Failed to remove old replica %s: %v", ctr.ShortID(), err)
+		}
+	}
+
+	return result, nil
+}
+
+// launch creates and starts a new replica and registers it once it is ready.
+// The container is returned even on failure so that it can be cleaned up.
+func (u *RollingUpdater) launch(ctx context.Context, service string, template *types.Container, cfg RolloutConfig) (*types.Container, error) {
+	ctr := newReplica(template)
+
+	if err := u.manager.Create(ctx, ctr); err != nil {
+		return nil, fmt.Errorf("failed to create replica: %w", err)
+	}
+	if err := u.manager.Start(ctx, ctr.ID); err != nil {
+		return ctr, fmt.Errorf("failed to start replica %s: %w", ctr.ShortID(), err)
+	}
+
+	if u.ready != nil {
+		readyCtx, cancel := context.WithTimeout(ctx, cfg.HealthTimeout)
+		err := u.ready(readyCtx, ctr)
+		cancel()
+		if err != nil {
+			return ctr, fmt.Errorf("replica %s did not become healthy: %w", ctr.ShortID(), err)
+		}
+	}
+
+	if err := u.register(service, ctr); err != nil {
+		return ctr, err
+	}
+
+	logrus.Infof("Replica %s of %s is ready", ctr.ShortID(), service)
+	return ctr, nil
+}
+
+// retire drains old replicas from the load balancer, all at once, and stops
+// them. It returns the replicas it stopped, for a rollback to restart. If a
+// replica fails to stop, those drained but still running are registered again.
+func (u *RollingUpdater) retire(ctx context.Context, service string, ctrs []*types.Container, cfg RolloutConfig) ([]*types.Container, error) {
+	u.drain(service, ctrs, cfg.DrainTimeout)
+
+	for i, ctr := range ctrs {
+		if err := u.manager.Stop(ctx, ctr.ID, cfg.StopTimeout); err != nil {
+			stopped := ctrs[:i:i]
+			for _, left := range ctrs[i:] {
+				if !left.IsRunning() {
+					stopped = append(stopped, left)
+					continue
+				}
+				if err := u.register(service, left); err != nil {
+					logrus.Errorf("Failed to re-register old replica %s: %v", left.ShortID(), err)
+				}
+			}
+			return stopped, fmt.Errorf("failed to stop old replica %s: %w", ctr.ShortID(), err)
+		}
+		logrus.Infof("Retired replica %s of %s", ctr.ShortID(), service)
+	}
+	return ctrs, nil
+}
+
+// rollback removes the new replicas and restarts the old ones that were stopped
+func (u *RollingUpdater) rollback(service string, created, stopped []*types.Container, cfg RolloutConfig, cause error) error {
+	logrus.Warnf("Rolling update of %s failed, rolling back: %v", service, cause)
+	ctx := context.Background()
+
+	// Restore capacity first so the service is not left short while cleaning up
+	for _, ctr := range stopped {
+		if !ctr.IsRunning() {
+			if err := u.manager.Start(ctx, ctr.ID); err != nil {
+				logrus.Errorf("Failed to restart old replica %s: %v", ctr.ShortID(), err)
+				continue
+			}
+		}
+		if err := u.register(service, ctr); err != nil {
+			logrus.Errorf("Failed to re-register old replica %s: %v", ctr.ShortID(), err)
+		}
+	}
+
+	u.drain(service, created, cfg.DrainTimeout)
+	for _, ctr := range created {
+		if ctr.IsRunning() {
+			if err := u.manager.Stop(ctx, ctr.ID, cfg.StopTimeout); err != nil {
+				logrus.Errorf("Failed to stop new replica %s: %v", ctr.ShortID(), err)
+				continue
+			}
+		}
+		if err := u.manager.Remove(ctx, ctr.ID); err != nil {
+			logrus.Errorf("Failed to remove new replica %s: %v", ctr.ShortID(), err)
+		}
+	}
+
+	return fmt.Errorf("rolling update of %s rolled back: %w", service, cause)
+}
+
+func (u *RollingUpdater) register(service string, ctr *types.Container) error {
+	if u.registry == nil {
+		return nil
+	}
+	ip, port, ok := backendAddress(ctr)
+	if !ok {
+		return nil
+	}
+	if err := u.registry.AddServiceBackend(service, ip, port, ctr.Health); err != nil {
+		return fmt.Errorf("failed to register replica %s: %w", ctr.ShortID(), err)
+	}
+	return nil
+}
+
+// drain takes replicas out of the load balancer together and waits until all
+// of them have been removed
+func (u *RollingUpdater) drain(service string, ctrs []*types.Container, timeout time.Duration) {
+	if u.registry == nil {
+		return
+	}
+	var removed []<-chan struct{}
+	for _, ctr := range ctrs {
+		ip, port, ok := backendAddress(ctr)
+		if !ok {
+			continue
+		}
+		done, err := u.registry.StartDrain(service, ip, port, timeout)
+		if err != nil {
+			logrus.Debugf("Replica %s was not registered with the load balancer: %v", ctr.ShortID(), err)
+			continue
+		}
+		removed = append(removed, done)
+	}
+	for _, done := range removed {
+		<-done
+	}
+}
+
+// backendAddress returns where the load balancer reaches a replica: its own IP
+// when it has one, otherwise the published host port
+func backendAddress(ctr *types.Container) (string, int, bool) {
+	if len(ctr.Ports) == 0 {
+		return "", 0, false
+	}
+	port := ctr.Ports[0]
+
+	if ctr.NetworkConfig != nil && ctr.NetworkConfig.IPAddress != "" {
+		return ctr.NetworkConfig.IPAddress, port.ContainerPort, true
+	}
+	if port.HostPort > 0 {
+		return "127.0.0.1", port.HostPort, true
+	}
+	return "", 0, false
+}
+
+// fixedHostPort returns the host port a container publishes, which only one
+// of its replicas can bind at a time
+func fixedHostPort(ctr *types.Container) (int, bool) {
+	for _, port := range ctr.Ports {
+		if port.HostPort > 0 {
+			return port.HostPort, true
+		}
+	}
+	return 0, false
+}
+
+// newReplica copies a container configuration into a fresh, not yet created replica
+func newReplica(template *types.Container) *types.Container {
+	ctr := *template
+	ctr.ID = types.GenerateContainerID()
+	ctr.State = types.StateCreating
+	ctr.CreatedAt = time.Now()
+	ctr.StartedAt = time.Time{}
+	ctr.ExitedAt = time.Time{}
+	ctr.Pid = 0
+	ctr.RestartCount = 0
+	return &ctr
+}
+
+// WaitHealthy is a ReadinessCheck that waits for the monitor to report a
+// replica healthy. Replicas without an HTTP health check are ready once started.
+func (hm *HealthCheckMonitor) WaitHealthy(ctx context.Context, ctr *types.Container) error {
+	if ctr.Health == nil || ctr.Health.Path == "" {
+		return nil
+	}
+
+	hm.ResetHealth(ctr.ID)
+
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if health := hm.GetHealth(ctr.ID); health != nil {
+			health.mu.Lock()
+			status := health.Status
+			health.mu.Unlock()
+
+			switch status {
+			case HealthStatusHealthy:
+				return nil
+			case HealthStatusUnhealthy:
+				return fmt.Errorf("health check failed")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for health check: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
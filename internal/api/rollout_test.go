@@ -0,0 +1,372 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	budgieruntime "github.com/zarigata/budgie/internal/runtime"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// fakeRuntime tracks running containers and the lowest and highest counts seen
+type fakeRuntime struct {
+	mu         sync.Mutex
+	running    map[string]bool
+	minRunning int
+	maxRunning int
+	failStop   string // ID of a container that fails to stop
+}
+
+func newFakeRuntime() *fakeRuntime {
+	return &fakeRuntime{running: make(map[string]bool), minRunning: -1}
+}
+
+func (f *fakeRuntime) Create(ctx context.Context, ctr *types.Container) error { return nil }
+
+func (f *fakeRuntime) Start(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.running[id] = true
+	if n := len(f.running); n > f.maxRunning {
+		f.maxRunning = n
+	}
+	return nil
+}
+
+func (f *fakeRuntime) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if id == f.failStop {
+		return fmt.Errorf("stop failed")
+	}
+	delete(f.running, id)
+	if n := len(f.running); f.minRunning < 0 || n < f.minRunning {
+		f.minRunning = n
+	}
+	return nil
+}
+
+func (f *fakeRuntime) Delete(ctx context.Context, id string) error { return nil }
+func (f *fakeRuntime) Exists(id string) bool                       { return true }
+func (f *fakeRuntime) Status(ctx context.Context, id string) (string, error) {
+	return "running", nil
+}
+func (f *fakeRuntime) Stats(ctx context.Context, id string) (*budgieruntime.ContainerStats, error) {
+	return &budgieruntime.ContainerStats{Timestamp: time.Now()}, nil
+}
+func (f *fakeRuntime) Logs(ctx context.Context, id string, follow bool, tail int) (budgieruntime.LogReader, error) {
+	return nil, fmt.Errorf("not supported")
+}
+func (f *fakeRuntime) Exec(ctx context.Context, id string, cmd []string, stdin bool) (int, error) {
+	return 0, nil
+}
+func (f *fakeRuntime) ExecWithOptions(ctx context.Context, id string, opts budgieruntime.ExecOptions) (int, error) {
+	return 0, nil
+}
+func (f *fakeRuntime) Pull(ctx context.Context, imageName string) (*budgieruntime.ImageInfo, error) {
+	return &budgieruntime.ImageInfo{Name: imageName}, nil
+}
+func (f *fakeRuntime) ListImages(ctx context.Context) ([]*budgieruntime.ImageInfo, error) {
+	return nil, nil
+}
+func (f *fakeRuntime) RemoveImage(ctx context.Context, imageName string) error { return nil }
+func (f *fakeRuntime) Pause(ctx context.Context, id string) error              { return nil }
+func (f *fakeRuntime) Resume(ctx context.Context, id string) error             { return nil }
+
+// fakeRegistry records load balancer calls in order. Drains finish at once,
+// or when hold is closed if it is set.
+type fakeRegistry struct {
+	mu     sync.Mutex
+	events []string
+	hold   chan struct{}
+}
+
+func (r *fakeRegistry) AddServiceBackend(service, ip string, port int, health *types.HealthCheck) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf("add %d", port))
+	return nil
+}
+
+func (r *fakeRegistry) StartDrain(service, ip string, port int, timeout time.Duration) (<-chan struct{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf("drain %d", port))
+	if r.hold != nil {
+		return r.hold, nil
+	}
+	removed := make(chan struct{})
+	close(removed)
+	return removed, nil
+}
+
+func (r *fakeRegistry) count(prefix string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, e := range r.events {
+		if strings.HasPrefix(e, prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+var nextTestPort = 20000
+
+// startService runs n replicas of a service on distinct host ports
+func startService(t *testing.T, m *ContainerManager, service, image string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		nextTestPort++
+		ctr := &types.Container{
+			ID:        types.GenerateContainerID(),
+			Name:      service,
+			Image:     types.ImageConfig{DockerImage: image},
+			Ports:     []types.PortMapping{{ContainerPort: 80, HostPort: nextTestPort}},
+			CreatedAt: time.Now(),
+		}
+		if err := m.Create(context.Background(), ctr); err != nil {
+			t.Fatalf("Failed to create replica: %v", err)
+		}
+		if err := m.Start(context.Background(), ctr.ID); err != nil {
+			t.Fatalf("Failed to start replica: %v", err)
+		}
+	}
+}
+
+// rejectBrokenImage fails the readiness check of replicas running web:broken
+func rejectBrokenImage() ReadinessCheck {
+	return func(ctx context.Context, ctr *types.Container) error {
+		if ctr.Image.DockerImage == "web:broken" {
+			return fmt.Errorf("unhealthy")
+		}
+		return nil
+	}
+}
+
+func newTestManager(t *testing.T) (*ContainerManager, *fakeRuntime) {
+	t.Helper()
+	rt := newFakeRuntime()
+	m, err := NewContainerManager(rt, t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	return m, rt
+}
+
+func imagesOf(replicas []*types.Container) map[string]int {
+	images := make(map[string]int)
+	for _, ctr := range replicas {
+		images[ctr.Image.DockerImage]++
+	}
+	return images
+}
+
+func TestRollingUpdate_ReplacesAllReplicas(t *testing.T) {
+	m, rt := newTestManager(t)
+	startService(t, m, "web", "web:1", 3)
+
+	registry := &fakeRegistry{}
+	updater := NewRollingUpdater(m, registry, rejectBrokenImage())
+
+	template := &types.Container{
+		Name:          "web",
+		Image:         types.ImageConfig{DockerImage: "web:2"},
+		Ports:         []types.PortMapping{{ContainerPort: 8080}},
+		NetworkConfig: &types.NetworkConfig{IPAddress: "10.88.0.2"},
+	}
+
+	result, err := updater.Update(context.Background(), "web", template, DefaultRolloutConfig())
+	if err != nil {
+		t.Fatalf("Rolling update failed: %v", err)
+	}
+	if result.Replaced != 3 || len(result.Created) != 3 {
+		t.Errorf("Expected 3 replicas replaced, got %+v", result)
+	}
+
+	if images := imagesOf(m.ServiceReplicas("web")); images["web:2"] != 3 || len(images) != 1 {
+		t.Errorf("Expected 3 replicas of web:2, got %v", images)
+	}
+	if n := len(m.List()); n != 3 {
+		t.Errorf("Expected old replicas to be removed, %d containers remain", n)
+	}
+
+	// With max surge 1 and max unavailable 0 capacity never drops below 3
+	if rt.maxRunning > 4 || rt.minRunning < 3 {
+		t.Errorf("Expected 3-4 running replicas during rollout, got %d-%d", rt.minRunning, rt.maxRunning)
+	}
+
+	// Every batch registers its new replica before draining an old one
+	if len(registry.events) != 6 {
+		t.Fatalf("Expected 3 adds and 3 drains, got %v", registry.events)
+	}
+	for i := 0; i < len(registry.events); i += 2 {
+		if registry.events[i] != "add 8080" || registry.events[i+1][:5] != "drain" {
+			t.Errorf("Expected add before drain, got %v", registry.events)
+			break
+		}
+	}
+}
+
+func TestRollingUpdate_MaxUnavailable(t *testing.T) {
+	m, rt := newTestManager(t)
+	startService(t, m, "web", "web:1", 4)
+
+	updater := NewRollingUpdater(m, nil, nil)
+	template := &types.Container{Name: "web", Image: types.ImageConfig{DockerImage: "web:2"}}
+
+	cfg := DefaultRolloutConfig()
+	cfg.MaxSurge = 0
+	cfg.MaxUnavailable = 2
+
+	if _, err := updater.Update(context.Background(), "web", template, cfg); err != nil {
+		t.Fatalf("Rolling update failed: %v", err)
+	}
+
+	if rt.maxRunning > 4 || rt.minRunning < 2 {
+		t.Errorf("Expected 2-4 running replicas during rollout, got %d-%d", rt.minRunning, rt.maxRunning)
+	}
+	if images := imagesOf(m.ServiceReplicas("web")); images["web:2"] != 4 {
+		t.Errorf("Expected 4 replicas of web:2, got %v", images)
+	}
+}
+
+func TestRollingUpdate_DrainsBatchTogether(t *testing.T) {
+	m, _ := newTestManager(t)
+	startService(t, m, "web", "web:1", 2)
+
+	registry := &fakeRegistry{hold: make(chan struct{})}
+	updater := NewRollingUpdater(m, registry, nil)
+	template := &types.Container{Name: "web", Image: types.ImageConfig{DockerImage: "web:2"}}
+
+	cfg := DefaultRolloutConfig()
+	cfg.MaxSurge = 0
+	cfg.MaxUnavailable = 2
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := updater.Update(context.Background(), "web", template, cfg)
+		done <- err
+	}()
+
+	// Both old replicas start draining before either has been removed
+	deadline := time.Now().Add(5 * time.Second)
+	for registry.count("drain") < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected both replicas to drain at once, %d did", registry.count("drain"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(registry.hold)
+
+	if err := <-done; err != nil {
+		t.Fatalf("Rolling update failed: %v", err)
+	}
+}
+
+func TestRollingUpdate_RollsBackOnFailure(t *testing.T) {
+	m, _ := newTestManager(t)
+	startService(t, m, "web", "web:1", 3)
+
+	registry := &fakeRegistry{}
+	updater := NewRollingUpdater(m, registry, rejectBrokenImage())
+	template := &types.Container{
+		Name:  "web",
+		Image: types.ImageConfig{DockerImage: "web:broken"},
+		Ports: []types.PortMapping{{ContainerPort: 80, HostPort: 30001}},
+	}
+
+	cfg := DefaultRolloutConfig()
+	cfg.MaxSurge = 0
+	cfg.MaxUnavailable = 1
+
+	if _, err := updater.Update(context.Background(), "web", template, cfg); err == nil {
+		t.Fatal("Expected rolling update with an unhealthy image to fail")
+	}
+
+	if images := imagesOf(m.ServiceReplicas("web")); images["web:1"] != 3 || len(images) != 1 {
+		t.Errorf("Expected the 3 original replicas to be restored, got %v", images)
+	}
+	if n := len(m.List()); n != 3 {
+		t.Errorf("Expected failed replicas to be removed, %d containers remain", n)
+	}
+	for _, event := range registry.events {
+		if event == "add 30001" {
+			t.Errorf("Unhealthy replica should never be registered, got %v", registry.events)
+		}
+	}
+}
+
+func TestRollingUpdate_RetireReturnsOnlyStoppedReplicas(t *testing.T) {
+	m, rt := newTestManager(t)
+	startService(t, m, "web", "web:1", 3)
+	old := m.ServiceReplicas("web")
+	rt.failStop = old[1].ID
+
+	registry := &fakeRegistry{}
+	updater := NewRollingUpdater(m, registry, nil)
+
+	stopped, err := updater.retire(context.Background(), "web", old, DefaultRolloutConfig())
+	if err == nil {
+		t.Fatal("Expected retire to fail")
+	}
+	if len(stopped) != 1 || stopped[0] != old[0] {
+		t.Fatalf("Expected only the first replica to be stopped, got %d", len(stopped))
+	}
+
+	// The replicas drained but still running serve again
+	want := []string{
+		fmt.Sprintf("drain %d", old[0].Ports[0].HostPort),
+		fmt.Sprintf("drain %d", old[1].Ports[0].HostPort),
+		fmt.Sprintf("drain %d", old[2].Ports[0].HostPort),
+		fmt.Sprintf("add %d", old[1].Ports[0].HostPort),
+		fmt.Sprintf("add %d", old[2].Ports[0].HostPort),
+	}
+	if fmt.Sprint(registry.events) != fmt.Sprint(want) {
+		t.Errorf("Expected %v, got %v", want, registry.events)
+	}
+}
+
+func TestRollingUpdate_InvalidConfig(t *testing.T) {
+	m, _ := newTestManager(t)
+	startService(t, m, "web", "web:1", 1)
+
+	updater := NewRollingUpdater(m, nil, nil)
+	template := &types.Container{Name: "web", Image: types.ImageConfig{DockerImage: "web:2"}}
+
+	cfg := DefaultRolloutConfig()
+	cfg.MaxSurge = 0
+	if _, err := updater.Update(context.Background(), "web", template, cfg); err == nil {
+		t.Error("Expected max_surge=0 and max_unavailable=0 to be rejected")
+	}
+
+	if _, err := updater.Update(context.Background(), "api", template, DefaultRolloutConfig()); err == nil {
+		t.Error("Expected update of a service without replicas to fail")
+	}
+
+	// Surge replicas would bind the host port the old replicas hold
+	published := *template
+	published.Ports = []types.PortMapping{{ContainerPort: 80, HostPort: 30002}}
+	if _, err := updater.Update(context.Background(), "web", &published, DefaultRolloutConfig()); err == nil {
+		t.Error("Expected max_surge with a published host port to be rejected")
+	}
+	if images := imagesOf(m.ServiceReplicas("web")); images["web:1"] != 1 {
+		t.Errorf("Expected the rejected update to leave the replica alone, got %v", images)
+	}
+}
+
+func TestRolloutConfigFor(t *testing.T) {
+	cfg := RolloutConfigFor(&types.Container{Update: &types.UpdateConfig{MaxUnavailable: 2, HealthTimeout: time.Minute}})
+	if cfg.MaxSurge != 0 || cfg.MaxUnavailable != 2 || cfg.HealthTimeout != time.Minute {
+		t.Errorf("Update settings not applied: %+v", cfg)
+	}
+
+	if cfg := RolloutConfigFor(&types.Container{}); cfg.MaxSurge != 1 || cfg.MaxUnavailable != 0 {
+		t.Errorf("Expected defaults without update settings, got %+v", cfg)
+	}
+}
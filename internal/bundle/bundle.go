@@ -8,6 +8,7 @@ import (
 
 	"gopkg.in/yaml.v3"
 
+	"github.com/zarigata/budgie/internal/placement"
 	"github.com/zarigata/budgie/pkg/types"
 )
 
@@ -21,6 +22,8 @@ type Bundle struct {
 	EnvFile       string                  `yaml:"env_file"`
 	Health        *types.HealthCheck      `yaml:"healthcheck"`
 	Replicas      *types.ReplicasConfig   `yaml:"replicas"`
+	Update        *types.UpdateConfig     `yaml:"update"`
+	Placement     *types.PlacementConfig  `yaml:"placement"`
 	Resources     *types.ResourceLimits   `yaml:"resources"`
 	RestartPolicy *types.RestartPolicy    `yaml:"restart_policy"`
 	DependsOn     []string                `yaml:"depends_on"`
@@ -50,9 +53,39 @@ func Parse(path string) (*Bundle, error) {
 		return nil, fmt.Errorf("at least one port mapping is required")
 	}
 
+	if err := placement.Validate(bundle.Placement); err != nil {
+		return nil, fmt.Errorf("invalid placement: %w", err)
+	}
+
+	if err := validateVolumes(bundle.Volumes); err != nil {
+		return nil, err
+	}
+
 	return &bundle, nil
 }
 
+// validateVolumes checks the sync settings of volumes
+func validateVolumes(volumes []types.VolumeMapping) error {
+	for _, vol := range volumes {
+		switch vol.Sync {
+		case "", types.SyncOneWay:
+			if vol.Conflict != "" {
+				return fmt.Errorf("volume %s: conflict strategy requires bidirectional sync", vol.Target)
+			}
+		case types.SyncBidirectional:
+			if vol.Mode != "rw" {
+				return fmt.Errorf("volume %s: bidirectional sync requires mode rw", vol.Target)
+			}
+			if _, err := types.ParseConflictStrategy(string(vol.Conflict)); err != nil {
+				return fmt.Errorf("volume %s: %w", vol.Target, err)
+			}
+		default:
+			return fmt.Errorf("volume %s: unknown sync mode %q", vol.Target, vol.Sync)
+		}
+	}
+	return nil
+}
+
 func (b *Bundle) ToContainer(bundlePath string) *types.Container {
 	// Load environment from file if specified
 	env := b.Env
@@ -73,6 +106,8 @@ func (b *Bundle) ToContainer(bundlePath string) *types.Container {
 		Env:           env,
 		Health:        b.Health,
 		Replicas:      b.Replicas,
+		Update:        b.Update,
+		Placement:     b.Placement,
 		Resources:     b.Resources,
 		RestartPolicy: b.RestartPolicy,
 		BundlePath:    bundlePath,
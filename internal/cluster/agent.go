@@ -0,0 +1,473 @@
+package cluster
+
+import (
+	"crypto/tls"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/zarigata/budgie/internal/events"
+	budgiesync "github.com/zarigata/budgie/internal/sync"
+)
+
+// DefaultPort is the default port of the cluster endpoint
+const DefaultPort = 18735
+
+// Gossip is the payload exchanged between members. From is empty when the
+// sender only observes the cluster, as "budgie node ls" does.
+type Gossip struct {
+	From    string
+	Members []Member
+}
+
+// Config holds cluster agent settings
+type Config struct {
+	NodeID         string
+	BindAddr       string      // Address to listen on, e.g. ":18735"
+	AdvertiseAddr  string      // Address peers reach this node at; derived from the listener when empty
+	TLS            *tls.Config // Sync TLS configuration; nil for plain TCP
+	GossipInterval time.Duration
+	Fanout         int           // Peers contacted per gossip round
+	SuspectAfter   time.Duration // Silence before a member is suspected
+	DeadAfter      time.Duration // Silence before a member is declared dead
+	ReapAfter      time.Duration // Time dead and departed members are still listed
+	DialTimeout    time.Duration
+	Events         events.Recorder // Optional; receives join, leave, failure and leader events
+}
+
+// DefaultConfig returns the default agent configuration for a node
+func DefaultConfig(nodeID string) Config {
+	return Config{
+		NodeID:         nodeID,
+		BindAddr:       fmt.Sprintf(":%d", DefaultPort),
+		GossipInterval: time.Second,
+		Fanout:         3,
+		SuspectAfter:   5 * time.Second,
+		DeadAfter:      15 * time.Second,
+		ReapAfter:      5 * time.Minute,
+		DialTimeout:    3 * time.Second,
+	}
+}
+
+// Agent is this node's membership in the cluster
+type Agent struct {
+	cfg      Config
+	members  memberList
+	self     *Member
+	leader   string
+	started  time.Time
+	listener net.Listener
+	mu       sync.Mutex
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+	now      func() time.Time
+}
+
+// NewAgent creates a cluster agent
+func NewAgent(cfg Config) *Agent {
+	return &Agent{
+		cfg:      cfg,
+		members:  make(memberList),
+		stopChan: make(chan struct{}),
+		now:      time.Now,
+	}
+}
+
+// Start listens for gossip and begins exchanging heartbeats
+func (a *Agent) Start() error {
+	listener, err := net.Listen("tcp", a.cfg.BindAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", a.cfg.BindAddr, err)
+	}
+	if a.cfg.TLS != nil {
+		listener = tls.NewListener(listener, a.cfg.TLS)
+	}
+	a.listener = listener
+
+	address := a.cfg.AdvertiseAddr
+	if address == "" {
+		address = advertiseAddress(listener.Addr())
+	}
+
+	now := a.now()
+	a.mu.Lock()
+	a.started = now
+	// Seeding the heartbeat with the clock keeps it increasing across
+	// restarts, so peers that remember the old heartbeat accept the new one
+	a.self = &Member{
+		ID:        a.cfg.NodeID,
+		Address:   address,
+		Heartbeat: uint64(now.UnixNano()),
+		State:     StateAlive,
+		LastSeen:  now,
+	}
+	a.members[a.self.ID] = a.self
+	a.mu.Unlock()
+
+	a.wg.Add(2)
+	go a.accept()
+	go a.gossip()
+
+	logrus.Infof("Cluster agent %s listening on %s", a.cfg.NodeID, address)
+	return nil
+}
+
+// Join contacts the given cluster addresses. It succeeds if at least one
+// of them answered.
+func (a *Agent) Join(addrs ...string) error {
+	var errs []string
+	joined := 0
+
+	for _, addr := range addrs {
+		if err := a.pushPull(addr); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", addr, err))
+			continue
+		}
+		joined++
+	}
+
+	if joined == 0 && len(errs) > 0 {
+		return fmt.Errorf("failed to join cluster: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Stop leaves the cluster gracefully and stops the agent
+func (a *Agent) Stop() {
+	a.shutdown(true)
+}
+
+func (a *Agent) shutdown(leave bool) {
+	a.stopOnce.Do(func() {
+		if leave && a.self != nil {
+			a.mu.Lock()
+			a.self.Left = true
+			a.self.Heartbeat++
+			var peers []string
+			for _, m := range a.members {
+				if m.ID != a.self.ID && (m.State == StateAlive || m.State == StateSuspect) {
+					peers = append(peers, m.Address)
+				}
+			}
+			a.mu.Unlock()
+
+			for _, addr := range peers {
+				if err := a.pushPull(addr); err != nil {
+					logrus.Debugf("Failed to announce leave to %s: %v", addr, err)
+				}
+			}
+		}
+
+		close(a.stopChan)
+		if a.listener != nil {
+			a.listener.Close()
+		}
+		a.wg.Wait()
+	})
+}
+
+// Addr returns the address peers reach this node at
+func (a *Agent) Addr() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.self == nil {
+		return ""
+	}
+	return a.self.Address
+}
+
+// Members returns the local view of the cluster, sorted by ID
+func (a *Agent) Members() []Member {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.membersLocked()
+}
+
+// Leader returns the ID of the current leader, or "" while this node is
+// still learning about its peers
+func (a *Agent) Leader() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.leader
+}
+
+// IsLeader reports whether this node is the leader. It is a LeaderCheck for
+// controllers that must only run on one node.
+func (a *Agent) IsLeader() bool {
+	return a.Leader() == a.cfg.NodeID
+}
+
+func (a *Agent) membersLocked() []Member {
+	members := a.members.snapshot()
+	for i := range members {
+		switch {
+		case members[i].ID == a.leader:
+			members[i].Role = RoleLeader
+		case members[i].State == StateAlive || members[i].State == StateSuspect:
+			members[i].Role = RoleFollower
+		}
+	}
+	return members
+}
+
+// accept serves gossip from peers
+func (a *Agent) accept() {
+	defer a.wg.Done()
+
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			select {
+			case <-a.stopChan:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logrus.Debugf("Failed to accept cluster connection: %v", err)
+			continue
+		}
+
+		go a.serve(conn)
+	}
+}
+
+func (a *Agent) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(a.cfg.DialTimeout))
+
+	proto := budgiesync.NewProtocol(conn)
+	if _, err := proto.AcceptHandshake(budgiesync.NewHello(conn, a.cfg.NodeID)); err != nil {
+		logrus.Debugf("Cluster handshake with %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	msg, err := proto.Receive()
+	if err != nil {
+		logrus.Debugf("Failed to read gossip from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	gossip, ok := msg.Payload.(Gossip)
+	if msg.Type != budgiesync.MsgGossip || !ok {
+		proto.SendError(400, "unexpected message type")
+		return
+	}
+
+	a.mu.Lock()
+	if gossip.From != "" {
+		a.mergeLocked(gossip.Members)
+	}
+	reply := Gossip{From: a.cfg.NodeID, Members: a.membersLocked()}
+	a.mu.Unlock()
+
+	proto.Send(budgiesync.Message{Type: budgiesync.MsgGossip, Payload: reply})
+}
+
+// gossip runs heartbeat rounds until the agent stops
+func (a *Agent) gossip() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.cfg.GossipInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stopChan:
+			return
+		case <-ticker.C:
+			for _, addr := range a.tick() {
+				if err := a.pushPull(addr); err != nil {
+					logrus.Debugf("Gossip with %s failed: %v", addr, err)
+				}
+			}
+		}
+	}
+}
+
+// tick advances the local heartbeat, runs failure detection and returns the
+// addresses to gossip with this round
+func (a *Agent) tick() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := a.now()
+	a.self.Heartbeat++
+	a.self.LastSeen = now
+
+	a.report(a.members.detect(now, a.cfg.SuspectAfter, a.cfg.DeadAfter, a.cfg.ReapAfter))
+	a.electLocked()
+
+	var peers []string
+	for _, m := range a.members {
+		if m.ID != a.self.ID && (m.State == StateAlive || m.State == StateSuspect) {
+			peers = append(peers, m.Address)
+		}
+	}
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	if len(peers) > a.cfg.Fanout {
+		peers = peers[:a.cfg.Fanout]
+	}
+	return peers
+}
+
+// pushPull sends the local view to addr and merges the reply
+func (a *Agent) pushPull(addr string) error {
+	a.mu.Lock()
+	out := Gossip{From: a.cfg.NodeID, Members: a.members.snapshot()}
+	a.mu.Unlock()
+
+	reply, err := exchange(addr, out, a.cfg.TLS, a.cfg.DialTimeout)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.mergeLocked(reply.Members)
+	a.mu.Unlock()
+	return nil
+}
+
+func (a *Agent) mergeLocked(remote []Member) {
+	now := a.now()
+
+	// A peer holding a newer heartbeat for this node (say, one that declared
+	// us left) is refuted by jumping past it
+	for _, m := range remote {
+		if m.ID == a.self.ID && m.Heartbeat >= a.self.Heartbeat && !a.self.Left {
+			a.self.Heartbeat = m.Heartbeat + 1
+		}
+	}
+
+	a.report(a.members.merge(remote, a.self.ID, now))
+	a.electLocked()
+}
+
+// electLocked recomputes the leader. A node does not claim leadership until
+// it has been up long enough to hear from its peers.
+func (a *Agent) electLocked() {
+	leader := a.members.leader()
+	if leader == a.self.ID && a.now().Sub(a.started) < a.cfg.SuspectAfter {
+		leader = ""
+	}
+	if leader == a.leader {
+		return
+	}
+
+	a.leader = leader
+	if leader == "" {
+		return
+	}
+	a.record(leader, "elected leader", map[string]string{"observer": a.self.ID})
+}
+
+// report logs and records member state changes
+func (a *Agent) report(changed []Member) {
+	for _, m := range changed {
+		switch m.State {
+		case StateAlive:
+			a.record(m.ID, "joined", map[string]string{"address": m.Address})
+		case StateSuspect:
+			logrus.Warnf("Cluster member %s is not responding", m.ID)
+		case StateDead:
+			a.record(m.ID, "failed", map[string]string{"last_seen": m.LastSeen.Format(time.RFC3339)})
+		case StateLeft:
+			a.record(m.ID, "left", nil)
+		}
+	}
+}
+
+func (a *Agent) record(subject, message string, details map[string]string) {
+	if a.cfg.Events == nil {
+		logrus.Infof("Cluster member %s %s", subject, message)
+		return
+	}
+	a.cfg.Events.Record(events.Event{
+		Kind:    events.KindNode,
+		Subject: subject,
+		Message: message,
+		Details: details,
+	})
+}
+
+// Query returns the members known to the agent at addr without joining
+func Query(addr string, tlsConfig *tls.Config, timeout time.Duration) ([]Member, error) {
+	reply, err := exchange(addr, Gossip{}, tlsConfig, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Members, nil
+}
+
+// exchange sends one gossip message over the sync transport and returns the reply
+func exchange(addr string, out Gossip, tlsConfig *tls.Config, timeout time.Duration) (*Gossip, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	var err error
+	if tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	proto := budgiesync.NewProtocol(conn)
+	if _, err := proto.Handshake(budgiesync.NewHello(conn, out.From)); err != nil {
+		return nil, fmt.Errorf("cluster handshake failed: %w", err)
+	}
+	if err := proto.Send(budgiesync.Message{Type: budgiesync.MsgGossip, Payload: out}); err != nil {
+		return nil, fmt.Errorf("failed to send gossip: %w", err)
+	}
+
+	msg, err := proto.Receive()
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive gossip: %w", err)
+	}
+	if e, ok := msg.Payload.(budgiesync.ErrorMessage); ok {
+		return nil, fmt.Errorf("peer returned %d: %s", e.Code, e.Message)
+	}
+
+	reply, ok := msg.Payload.(Gossip)
+	if !ok {
+		return nil, fmt.Errorf("unexpected reply type %d", msg.Type)
+	}
+	return &reply, nil
+}
+
+// advertiseAddress picks the address peers should use for a listener bound
+// to addr, replacing an unspecified host with a local interface address
+func advertiseAddress(addr net.Addr) string {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || !tcpAddr.IP.IsUnspecified() {
+		return addr.String()
+	}
+
+	port := strconv.Itoa(tcpAddr.Port)
+	ifaces, err := net.InterfaceAddrs()
+	if err == nil {
+		for _, ifaceAddr := range ifaces {
+			if ipNet, ok := ifaceAddr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+				return net.JoinHostPort(ipNet.IP.String(), port)
+			}
+		}
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+func init() {
+	gob.Register(Gossip{})
+}
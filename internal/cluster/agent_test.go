@@ -0,0 +1,196 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestAgent(t *testing.T, id string) *Agent {
+	t.Helper()
+
+	cfg := DefaultConfig(id)
+	cfg.BindAddr = "127.0.0.1:0"
+	cfg.GossipInterval = 20 * time.Millisecond
+	cfg.SuspectAfter = 150 * time.Millisecond
+	cfg.DeadAfter = 300 * time.Millisecond
+	cfg.DialTimeout = 500 * time.Millisecond
+
+	a := NewAgent(cfg)
+	if err := a.Start(); err != nil {
+		t.Fatalf("Failed to start agent %s: %v", id, err)
+	}
+	t.Cleanup(func() { a.shutdown(false) })
+	return a
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+func stateOf(a *Agent, id string) State {
+	for _, m := range a.Members() {
+		if m.ID == id {
+			return m.State
+		}
+	}
+	return ""
+}
+
+func aliveCount(a *Agent) int {
+	n := 0
+	for _, m := range a.Members() {
+		if m.State == StateAlive {
+			n++
+		}
+	}
+	return n
+}
+
+// startCluster starts agents with the given IDs, each joining the previous one
+func startCluster(t *testing.T, ids ...string) []*Agent {
+	t.Helper()
+
+	var agents []*Agent
+	for i, id := range ids {
+		a := newTestAgent(t, id)
+		if i > 0 {
+			if err := a.Join(agents[i-1].Addr()); err != nil {
+				t.Fatalf("Join failed: %v", err)
+			}
+		}
+		agents = append(agents, a)
+	}
+
+	waitFor(t, "full membership", func() bool {
+		for _, a := range agents {
+			if aliveCount(a) != len(ids) {
+				return false
+			}
+		}
+		return true
+	})
+	return agents
+}
+
+func TestAgent_JoinAndElectLeader(t *testing.T) {
+	agents := startCluster(t, "node-b", "node-a", "node-c")
+
+	waitFor(t, "agreement on node-a as leader", func() bool {
+		for _, a := range agents {
+			if a.Leader() != "node-a" {
+				return false
+			}
+		}
+		return true
+	})
+
+	leaders := 0
+	for _, a := range agents {
+		if a.IsLeader() {
+			leaders++
+		}
+	}
+	if leaders != 1 {
+		t.Errorf("Expected exactly one leader, got %d", leaders)
+	}
+}
+
+func TestAgent_DetectsFailureAndReelects(t *testing.T) {
+	agents := startCluster(t, "node-a", "node-b", "node-c")
+	waitFor(t, "node-a elected", func() bool { return agents[1].Leader() == "node-a" })
+
+	agents[0].shutdown(false)
+
+	waitFor(t, "node-a declared dead", func() bool {
+		return stateOf(agents[1], "node-a") == StateDead && stateOf(agents[2], "node-a") == StateDead
+	})
+	waitFor(t, "node-b elected", func() bool {
+		return agents[1].IsLeader() && agents[2].Leader() == "node-b"
+	})
+}
+
+func TestAgent_LeaveIsSeenBeforeFailureDetection(t *testing.T) {
+	agents := startCluster(t, "node-a", "node-b")
+
+	left := time.Now()
+	agents[1].Stop()
+
+	if stateOf(agents[0], "node-b") != StateLeft {
+		t.Errorf("Expected node-b to have left, got %s", stateOf(agents[0], "node-b"))
+	}
+	if time.Since(left) >= agents[0].cfg.DeadAfter {
+		t.Error("Expected leaving to be faster than failure detection")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	agents := startCluster(t, "node-a", "node-b")
+	waitFor(t, "node-a elected", func() bool { return agents[1].Leader() == "node-a" })
+
+	members, err := Query(agents[1].Addr(), nil, time.Second)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if len(members) != 2 {
+		t.Fatalf("Expected 2 members, got %d", len(members))
+	}
+	if members[0].ID != "node-a" || members[0].Role != RoleLeader || members[1].Role != RoleFollower {
+		t.Errorf("Unexpected roles: %+v", members)
+	}
+
+	if n := len(agents[1].Members()); n != 2 {
+		t.Errorf("Expected the query not to add a member, got %d members", n)
+	}
+}
+
+func TestMemberList_Detect(t *testing.T) {
+	now := time.Now()
+	l := memberList{
+		"a": {ID: "a", State: StateAlive, LastSeen: now},
+		"b": {ID: "b", State: StateAlive, LastSeen: now.Add(-6 * time.Second)},
+		"c": {ID: "c", State: StateAlive, LastSeen: now.Add(-20 * time.Second)},
+		"d": {ID: "d", State: StateDead, LastSeen: now.Add(-time.Hour)},
+	}
+
+	changed := l.detect(now, 5*time.Second, 15*time.Second, 5*time.Minute)
+	if len(changed) != 2 {
+		t.Errorf("Expected 2 state changes, got %d", len(changed))
+	}
+	if l["b"].State != StateSuspect || l["c"].State != StateDead {
+		t.Errorf("Unexpected states: b=%s c=%s", l["b"].State, l["c"].State)
+	}
+	if _, ok := l["d"]; ok {
+		t.Error("Expected long-dead member to be reaped")
+	}
+	if leader := l.leader(); leader != "a" {
+		t.Errorf("Expected a to lead, got %s", leader)
+	}
+}
+
+func TestMemberList_MergeIgnoresStaleHeartbeats(t *testing.T) {
+	now := time.Now()
+	l := memberList{"a": {ID: "a", Heartbeat: 10, State: StateDead, LastSeen: now.Add(-time.Minute)}}
+
+	l.merge([]Member{{ID: "a", Heartbeat: 9, State: StateAlive}}, "self", now)
+	if l["a"].State != StateDead {
+		t.Error("Expected a stale heartbeat to be ignored")
+	}
+
+	l.merge([]Member{{ID: "a", Heartbeat: 11, State: StateAlive}}, "self", now)
+	if l["a"].State != StateAlive || !l["a"].LastSeen.Equal(now) {
+		t.Error("Expected a newer heartbeat to revive the member")
+	}
+
+	l.merge([]Member{{ID: "gone", Heartbeat: 1, State: StateDead}}, "self", now)
+	if _, ok := l["gone"]; ok {
+		t.Error("Expected dead members not to be learned from peers")
+	}
+}
@@ -0,0 +1,157 @@
+// Package cluster maintains membership among budgie nodes and elects a
+// single leader for controller work.
+//
+// Nodes gossip heartbeats over the sync transport: every interval each node
+// bumps its own heartbeat and exchanges its member list with a few random
+// peers. A member whose heartbeat stops advancing is suspected, then declared
+// dead. The leader is the live member with the lowest ID, so every node with
+// the same view agrees on it without a voting round.
+package cluster
+
+import (
+	"sort"
+	"time"
+)
+
+// State is the health of a member as seen by the local node
+type State string
+
+const (
+	StateAlive   State = "alive"
+	StateSuspect State = "suspect"
+	StateDead    State = "dead"
+	StateLeft    State = "left"
+)
+
+// Role is the part a member plays in the cluster
+type Role string
+
+const (
+	RoleLeader   Role = "leader"
+	RoleFollower Role = "follower"
+)
+
+// Member is a node of the cluster
+type Member struct {
+	ID        string    `json:"id"`
+	Address   string    `json:"address"`   // host:port of the member's cluster endpoint
+	Heartbeat uint64    `json:"heartbeat"` // Incremented by the member itself; higher values are newer
+	Left      bool      `json:"left"`      // Set by the member when it leaves gracefully
+	State     State     `json:"state"`
+	Role      Role      `json:"role,omitempty"`
+	LastSeen  time.Time `json:"last_seen"` // When the local node last saw the heartbeat advance
+}
+
+// memberList is the local view of the cluster, keyed by member ID
+type memberList map[string]*Member
+
+// merge folds a remote view into the local one and returns members whose
+// state changed. Remote LastSeen values are ignored: freshness is judged by
+// heartbeats advancing locally.
+func (l memberList) merge(remote []Member, selfID string, now time.Time) []Member {
+	var changed []Member
+
+	for _, m := range remote {
+		if m.ID == "" || m.ID == selfID {
+			continue
+		}
+
+		local, ok := l[m.ID]
+		if !ok {
+			// Only learn about members the sender believes are live
+			if m.Left || (m.State != StateAlive && m.State != StateSuspect) {
+				continue
+			}
+			l[m.ID] = &Member{
+				ID:        m.ID,
+				Address:   m.Address,
+				Heartbeat: m.Heartbeat,
+				State:     StateAlive,
+				LastSeen:  now,
+			}
+			changed = append(changed, *l[m.ID])
+			continue
+		}
+
+		if m.Heartbeat <= local.Heartbeat {
+			continue
+		}
+
+		previous := local.State
+		local.Heartbeat = m.Heartbeat
+		local.Address = m.Address
+		local.Left = m.Left
+		local.LastSeen = now
+		if m.Left {
+			local.State = StateLeft
+		} else {
+			local.State = StateAlive
+		}
+		if local.State != previous {
+			changed = append(changed, *local)
+		}
+	}
+
+	return changed
+}
+
+// detect updates member states from how long ago their heartbeat advanced,
+// forgets members that have been gone for reapAfter, and returns members
+// whose state changed
+func (l memberList) detect(now time.Time, suspectAfter, deadAfter, reapAfter time.Duration) []Member {
+	var changed []Member
+
+	for id, m := range l {
+		silent := now.Sub(m.LastSeen)
+
+		if (m.State == StateDead || m.State == StateLeft) && silent >= reapAfter {
+			delete(l, id)
+			continue
+		}
+		if m.State == StateLeft {
+			continue
+		}
+
+		state := StateAlive
+		switch {
+		case silent >= deadAfter:
+			state = StateDead
+		case silent >= suspectAfter:
+			state = StateSuspect
+		}
+
+		if state != m.State {
+			m.State = state
+			changed = append(changed, *m)
+		}
+	}
+
+	return changed
+}
+
+// leader returns the live member with the lowest ID. Suspected members still
+// count, so that a single missed heartbeat does not move leadership.
+func (l memberList) leader() string {
+	leader := ""
+	for id, m := range l {
+		if m.State != StateAlive && m.State != StateSuspect {
+			continue
+		}
+		if leader == "" || id < leader {
+			leader = id
+		}
+	}
+	return leader
+}
+
+// snapshot returns a copy of the members sorted by ID
+func (l memberList) snapshot() []Member {
+	members := make([]Member, 0, len(l))
+	for _, m := range l {
+		members = append(members, *m)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].ID < members[j].ID
+	})
+	return members
+}
@@ -2,13 +2,14 @@
 package cmdutil
 
 import (
+	"crypto/tls"
 	"fmt"
 	"os"
-	"strings"
 
 	"github.com/zarigata/budgie/internal/api"
 	"github.com/zarigata/budgie/internal/config"
 	"github.com/zarigata/budgie/internal/runtime"
+	budgiesync "github.com/zarigata/budgie/internal/sync"
 	"github.com/zarigata/budgie/pkg/types"
 )
 
@@ -51,34 +52,7 @@ func NewCommandContext() (*CommandContext, error) {
 // FindContainer finds a container by ID prefix or name.
 // It returns an error if no container is found or if the ID is ambiguous.
 func FindContainer(manager *api.ContainerManager, idOrName string) (*types.Container, error) {
-	// Try exact match first
-	if ctr, err := manager.Get(idOrName); err == nil {
-		return ctr, nil
-	}
-
-	// Try prefix match and name match
-	containers := manager.List()
-	var matches []*types.Container
-
-	for _, ctr := range containers {
-		if strings.HasPrefix(ctr.ID, idOrName) || ctr.Name == idOrName {
-			matches = append(matches, ctr)
-		}
-	}
-
-	if len(matches) == 0 {
-		return nil, fmt.Errorf("no such container: %s", idOrName)
-	}
-
-	if len(matches) > 1 {
-		var ids []string
-		for _, m := range matches {
-			ids = append(ids, m.ShortID())
-		}
-		return nil, fmt.Errorf("ambiguous container ID %q, matches: %s", idOrName, strings.Join(ids, ", "))
-	}
-
-	return matches[0], nil
+	return manager.Find(idOrName)
 }
 
 // FindContainers finds multiple containers by ID prefixes or names.
@@ -137,3 +111,20 @@ func RequireStopped(ctr *types.Container) error {
 	}
 	return nil
 }
+
+// TLSConfig builds the TLS configuration shared by the sync protocol and the
+// node API. It returns nil when TLS is disabled.
+func TLSConfig(cfg *config.Config) (*tls.Config, error) {
+	return budgiesync.NewTLSConfig(SyncTLSConfig(cfg))
+}
+
+// SyncTLSConfig returns the TLS settings of the configuration in the form the
+// sync server and client take
+func SyncTLSConfig(cfg *config.Config) budgiesync.TLSConfig {
+	return budgiesync.TLSConfig{
+		Enabled:  cfg.TLS.Enabled,
+		CertFile: cfg.TLS.CertFile,
+		KeyFile:  cfg.TLS.KeyFile,
+		CAFile:   cfg.TLS.CAFile,
+	}
+}
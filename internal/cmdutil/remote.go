@@ -0,0 +1,71 @@
+package cmdutil
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/zarigata/budgie/internal/config"
+	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/internal/node"
+)
+
+// NodeName returns the node named by the global --node flag, which defaults
+// to BUDGIE_NODE, or "" when commands act on the local node
+func NodeName(cmd *cobra.Command) string {
+	if f := cmd.Flag("node"); f != nil {
+		return f.Value.String()
+	}
+	return ""
+}
+
+// RemoteNode returns a client for the node named by --node or BUDGIE_NODE,
+// or nil when commands act on the local node. The name is a discovered node
+// ID or the host[:port] of a node API.
+func RemoteNode(cmd *cobra.Command) (*node.Client, error) {
+	name := NodeName(cmd)
+	if name == "" {
+		return nil, nil
+	}
+	return ResolveNode(name)
+}
+
+// ResolveNode returns a client for the node API of a node named by its
+// discovered ID or host[:port]
+func ResolveNode(name string) (*node.Client, error) {
+	cfg := config.Get()
+	tlsConfig, err := TLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []discovery.NodeInfo
+	if _, _, err := net.SplitHostPort(name); err != nil {
+		// Not host:port, so look for a node with that ID first
+		timeout := time.Duration(cfg.Discovery.Timeout) * time.Second
+		nodes, err = discovery.NewDiscoveryService().DiscoverNodes(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("node discovery failed: %w", err)
+		}
+	}
+
+	return node.Resolve(name, nodes, cfg.Node.APIPort, tlsConfig)
+}
+
+// NodeClient returns a client for the node named by --node or BUDGIE_NODE,
+// or else for the node API served on this machine
+func NodeClient(cmd *cobra.Command) (*node.Client, error) {
+	client, err := RemoteNode(cmd)
+	if err != nil || client != nil {
+		return client, err
+	}
+
+	cfg := config.Get()
+	tlsConfig, err := TLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return node.NewClient("localhost", cfg.Node.APIPort, tlsConfig), nil
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -23,9 +24,21 @@ type Config struct {
 	// TLS configuration for sync protocol
 	TLS TLSConfig `yaml:"tls"`
 
+	// Volume replication configuration
+	Sync SyncConfig `yaml:"sync"`
+
+	// Volume snapshot retention
+	Snapshots SnapshotConfig `yaml:"snapshots"`
+
 	// Discovery configuration
 	Discovery DiscoveryConfig `yaml:"discovery"`
 
+	// Node API configuration
+	Node NodeConfig `yaml:"node"`
+
+	// Load balancer in front of services
+	Proxy ProxyConfig `yaml:"proxy"`
+
 	// Defaults for new containers
 	Defaults ContainerDefaults `yaml:"defaults"`
 
@@ -41,6 +54,19 @@ type TLSConfig struct {
 	CAFile   string `yaml:"ca_file"`
 }
 
+// SyncConfig holds settings for replicating volume changes
+type SyncConfig struct {
+	Debounce     int `yaml:"debounce"`      // milliseconds without changes before pushing them
+	MaxDelay     int `yaml:"max_delay"`     // seconds at most between a change and its push
+	MaxBandwidth int `yaml:"max_bandwidth"` // KiB per second for all volume sync traffic, 0 for unlimited
+}
+
+// SnapshotConfig holds how many volume snapshots are kept
+type SnapshotConfig struct {
+	Keep   int `yaml:"keep"`    // newest snapshots kept per container, 0 for unlimited
+	MaxAge int `yaml:"max_age"` // days a snapshot is kept, 0 for unlimited
+}
+
 // DiscoveryConfig holds discovery settings
 type DiscoveryConfig struct {
 	Enabled bool   `yaml:"enabled"`
@@ -48,6 +74,25 @@ type DiscoveryConfig struct {
 	Timeout int    `yaml:"timeout"` // seconds
 }
 
+// NodeConfig holds settings for the node API other nodes submit work to
+type NodeConfig struct {
+	APIPort          int               `yaml:"api_port"`
+	ClusterPort      int               `yaml:"cluster_port"`      // gossip port for cluster membership
+	Seeds            []string          `yaml:"seeds"`             // cluster addresses to join, e.g. 10.0.0.2:18735
+	AnnounceInterval int               `yaml:"announce_interval"` // seconds between capacity announcements
+	Labels           map[string]string `yaml:"labels"`            // e.g. disk: ssd; arch and os are added automatically
+	Roles            map[string]string `yaml:"roles"`             // certificate common name -> viewer or operator
+}
+
+// ProxyConfig holds settings for the load balancer budgie node serve runs in
+// front of services
+type ProxyConfig struct {
+	Type                string            `yaml:"type"`                  // round-robin or least-connections
+	Port                int               `yaml:"port"`                  // HTTP port routed traffic arrives on, 0 to not listen
+	Routes              map[string]string `yaml:"routes"`                // host name -> service
+	HealthCheckInterval time.Duration     `yaml:"health_check_interval"` // e.g. 30s, for services without their own interval
+}
+
 // ContainerDefaults holds default settings for new containers
 type ContainerDefaults struct {
 	RestartPolicy string `yaml:"restart_policy"` // "no", "always", "on-failure", "unless-stopped"
@@ -84,11 +129,27 @@ func DefaultConfig() *Config {
 		TLS: TLSConfig{
 			Enabled: false,
 		},
+		Sync: SyncConfig{
+			Debounce: 500,
+			MaxDelay: 5,
+		},
+		Snapshots: SnapshotConfig{
+			Keep: 5,
+		},
 		Discovery: DiscoveryConfig{
 			Enabled: true,
 			Domain:  "local",
 			Timeout: 10,
 		},
+		Node: NodeConfig{
+			APIPort:          18734,
+			ClusterPort:      18735,
+			AnnounceInterval: 30,
+		},
+		Proxy: ProxyConfig{
+			Type:                "round-robin",
+			HealthCheckInterval: 30 * time.Second,
+		},
 		Defaults: ContainerDefaults{
 			RestartPolicy: "no",
 			MaxRetries:    3,
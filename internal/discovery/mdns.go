@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,28 +16,34 @@ import (
 )
 
 type DiscoveryService struct {
-	servers []*mdns.Server
+	servers map[string][]*mdns.Server // container ID -> one server per announced port
+	labels  map[string]string
 	mu      sync.RWMutex
 }
 
 func NewDiscoveryService() *DiscoveryService {
 	return &DiscoveryService{
-		servers: make([]*mdns.Server, 0),
+		servers: make(map[string][]*mdns.Server),
 	}
 }
 
+// SetNodeLabels sets the labels of this node, announced with its containers
+func (d *DiscoveryService) SetNodeLabels(labels map[string]string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.labels = labels
+}
+
+// AnnounceContainer announces a container on every host port. Announcing a
+// container again replaces its previous announcement, e.g. after a role change.
 func (d *DiscoveryService) AnnounceContainer(ctr *types.Container) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	for _, port := range ctr.Ports {
-		txt := []string{
-			fmt.Sprintf("container_id=%s", ctr.ID),
-			fmt.Sprintf("node_id=%s", ctr.NodeID),
-			fmt.Sprintf("container_name=%s", ctr.Name),
-			fmt.Sprintf("image=%s", ctr.Image.DockerImage),
-		}
+	d.withdraw(ctr.ID)
+	txt := containerTXT(ctr, d.labels)
 
+	for _, port := range ctr.Ports {
 		serviceName := fmt.Sprintf("budgie-%s", ctr.ShortID())
 		localIPs := getLocalNetIPs()
 		service, err := mdns.NewMDNSService(
@@ -56,7 +64,7 @@ func (d *DiscoveryService) AnnounceContainer(ctr *types.Container) error {
 			return fmt.Errorf("failed to create mDNS server: %w", err)
 		}
 
-		d.servers = append(d.servers, server)
+		d.servers[ctr.ID] = append(d.servers[ctr.ID], server)
 
 		ips := getLocalIPs()
 		logrus.Infof("Announcing container %s on %s:%d", ctr.ShortID(), ips[0], port.HostPort)
@@ -65,6 +73,22 @@ func (d *DiscoveryService) AnnounceContainer(ctr *types.Container) error {
 	return nil
 }
 
+// WithdrawContainer stops announcing a container
+func (d *DiscoveryService) WithdrawContainer(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.withdraw(id)
+}
+
+func (d *DiscoveryService) withdraw(id string) {
+	for _, server := range d.servers[id] {
+		if err := server.Shutdown(); err != nil {
+			logrus.Warnf("Failed to withdraw announcement of %s: %v", id, err)
+		}
+	}
+	delete(d.servers, id)
+}
+
 func (d *DiscoveryService) DiscoverContainers(timeout time.Duration) ([]DiscoveredContainer, error) {
 	entries := make(chan *mdns.ServiceEntry)
 	var containers []DiscoveredContainer
@@ -105,64 +129,159 @@ func (d *DiscoveryService) DiscoverContainers(timeout time.Duration) ([]Discover
 	return containers, nil
 }
 
+// CountInstances counts the instances of a service announced by nodes other
+// than excludeNode. Containers announced on several ports are counted once.
+func (d *DiscoveryService) CountInstances(service, excludeNode string, timeout time.Duration) (int, error) {
+	containers, err := d.DiscoverContainers(timeout)
+	if err != nil {
+		return 0, err
+	}
+
+	seen := make(map[string]bool)
+	for _, ctr := range containers {
+		if ctr.Name == service && ctr.NodeID != excludeNode {
+			seen[ctr.ID] = true
+		}
+	}
+
+	return len(seen), nil
+}
+
 func (d *DiscoveryService) Shutdown() error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
 	var lastErr error
-	for _, server := range d.servers {
-		if server != nil {
+	for _, servers := range d.servers {
+		for _, server := range servers {
 			if err := server.Shutdown(); err != nil {
 				logrus.Errorf("Failed to shutdown mDNS server: %v", err)
 				lastErr = err
 			}
 		}
 	}
+	d.servers = make(map[string][]*mdns.Server)
 
 	return lastErr
 }
 
 type DiscoveredContainer struct {
-	ID      string
-	Name    string
-	NodeID  string
-	Image   string
-	IPs     []string
-	Port    int
-	NameTag string
+	ID         string
+	Name       string
+	NodeID     string
+	NodeLabels map[string]string
+	Image      string
+	IPs        []string
+	Port       int
+	NameTag    string
+	Placement  *types.PlacementConfig
+	Role       types.ReplicationRole
+	ReplicaSet string
+	Epoch      uint64
 }
 
-func parseEntry(entry *mdns.ServiceEntry) *DiscoveredContainer {
-	if entry.AddrV4 == nil {
-		return nil
+// ReplicaSetID returns the ID shared by a primary and its replicas
+func (c DiscoveredContainer) ReplicaSetID() string {
+	if c.ReplicaSet != "" {
+		return c.ReplicaSet
 	}
+	return c.ID
+}
+
+// containerTXT encodes a container, its placement and the labels of the
+// node running it as TXT fields
+func containerTXT(ctr *types.Container, labels map[string]string) []string {
+	txt := []string{
+		fmt.Sprintf("container_id=%s", ctr.ID),
+		fmt.Sprintf("node_id=%s", ctr.NodeID),
+		fmt.Sprintf("container_name=%s", ctr.Name),
+		fmt.Sprintf("image=%s", ctr.Image.DockerImage),
+	}
+
+	if ctr.Role != "" {
+		txt = append(txt,
+			fmt.Sprintf("role=%s", ctr.Role),
+			fmt.Sprintf("replica_set=%s", ctr.ReplicaSetID()),
+			fmt.Sprintf("epoch=%d", ctr.Epoch),
+		)
+	}
+
+	if p := ctr.Placement; p != nil {
+		for _, c := range p.Constraints {
+			txt = append(txt, fmt.Sprintf("constraint=%s", c))
+		}
+		for _, s := range p.Affinity {
+			txt = append(txt, fmt.Sprintf("affinity=%s", s))
+		}
+		for _, s := range p.AntiAffinity {
+			txt = append(txt, fmt.Sprintf("anti_affinity=%s", s))
+		}
+	}
+
+	return append(txt, labelTXT(labels)...)
+}
 
-	infoMap := make(map[string]string)
-	for _, field := range entry.InfoFields {
+// parseContainerTXT decodes a container announcement from TXT fields
+func parseContainerTXT(fields []string) *DiscoveredContainer {
+	ctr := &DiscoveredContainer{NodeLabels: make(map[string]string)}
+	placement := &types.PlacementConfig{}
+
+	for _, field := range fields {
 		key, val, ok := parseTxtField(field)
-		if ok {
-			infoMap[key] = val
+		if !ok {
+			continue
+		}
+
+		switch {
+		case key == "container_id":
+			ctr.ID = val
+		case key == "container_name":
+			ctr.Name = val
+		case key == "node_id":
+			ctr.NodeID = val
+		case key == "image":
+			ctr.Image = val
+		case key == "role":
+			ctr.Role = types.ReplicationRole(val)
+		case key == "replica_set":
+			ctr.ReplicaSet = val
+		case key == "epoch":
+			ctr.Epoch, _ = strconv.ParseUint(val, 10, 64)
+		case key == "constraint":
+			placement.Constraints = append(placement.Constraints, val)
+		case key == "affinity":
+			placement.Affinity = append(placement.Affinity, val)
+		case key == "anti_affinity":
+			placement.AntiAffinity = append(placement.AntiAffinity, val)
+		case strings.HasPrefix(key, labelPrefix):
+			ctr.NodeLabels[strings.TrimPrefix(key, labelPrefix)] = val
 		}
 	}
 
-	containerID, ok := infoMap["container_id"]
-	if !ok {
+	if ctr.ID == "" {
 		return nil
 	}
+	if len(placement.Constraints)+len(placement.Affinity)+len(placement.AntiAffinity) > 0 {
+		ctr.Placement = placement
+	}
 
-	ctr := &DiscoveredContainer{
-		ID:      containerID,
-		Name:    infoMap["container_name"],
-		NodeID:  infoMap["node_id"],
-		Image:   infoMap["image"],
-		Port:    entry.Port,
-		NameTag: entry.Name,
+	return ctr
+}
+
+func parseEntry(entry *mdns.ServiceEntry) *DiscoveredContainer {
+	if entry.AddrV4 == nil {
+		return nil
 	}
 
-	if entry.AddrV4 != nil {
-		ctr.IPs = []string{entry.AddrV4.String()}
+	ctr := parseContainerTXT(entry.InfoFields)
+	if ctr == nil {
+		return nil
 	}
 
+	ctr.Port = entry.Port
+	ctr.NameTag = entry.Name
+	ctr.IPs = []string{entry.AddrV4.String()}
+
 	return ctr
 }
 
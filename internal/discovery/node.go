@@ -0,0 +1,227 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/mdns"
+	"github.com/sirupsen/logrus"
+)
+
+// nodeServiceType is the mDNS service type under which nodes advertise capacity
+const nodeServiceType = "_budgie-node._tcp"
+
+// labelPrefix marks node labels in TXT records
+const labelPrefix = "label."
+
+// NodeInfo describes a node and its capacity
+type NodeInfo struct {
+	ID              string            `json:"id"`
+	Address         string            `json:"address"`
+	APIPort         int               `json:"api_port"`
+	ClusterPort     int               `json:"cluster_port,omitempty"` // Gossip port; 0 when the node is not in a cluster
+	CPUs            int               `json:"cpus"`
+	CPUReserved     float64           `json:"cpu_reserved"` // Cores reserved by running containers
+	MemoryTotal     int64             `json:"memory_total"`
+	MemoryAvailable int64             `json:"memory_available"`
+	Containers      int               `json:"containers"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Cordoned        bool              `json:"cordoned,omitempty"` // Set while the node refuses new placements
+}
+
+// TXT encodes the node information as mDNS TXT fields
+func (n NodeInfo) TXT() []string {
+	txt := []string{
+		fmt.Sprintf("node_id=%s", n.ID),
+		fmt.Sprintf("cpus=%d", n.CPUs),
+		fmt.Sprintf("cpu_reserved=%s", strconv.FormatFloat(n.CPUReserved, 'f', 2, 64)),
+		fmt.Sprintf("mem_total=%d", n.MemoryTotal),
+		fmt.Sprintf("mem_available=%d", n.MemoryAvailable),
+		fmt.Sprintf("containers=%d", n.Containers),
+	}
+	if n.ClusterPort > 0 {
+		txt = append(txt, fmt.Sprintf("cluster_port=%d", n.ClusterPort))
+	}
+	if n.Cordoned {
+		txt = append(txt, "cordoned=true")
+	}
+
+	return append(txt, labelTXT(n.Labels)...)
+}
+
+// labelTXT encodes node labels as TXT fields, sorted by key
+func labelTXT(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	txt := make([]string, 0, len(keys))
+	for _, k := range keys {
+		txt = append(txt, fmt.Sprintf("%s%s=%s", labelPrefix, k, labels[k]))
+	}
+	return txt
+}
+
+// ParseNodeTXT decodes node information from mDNS TXT fields
+func ParseNodeTXT(fields []string) (*NodeInfo, error) {
+	info := &NodeInfo{Labels: make(map[string]string)}
+
+	for _, field := range fields {
+		key, val, ok := parseTxtField(field)
+		if !ok {
+			continue
+		}
+
+		var err error
+		switch {
+		case key == "node_id":
+			info.ID = val
+		case key == "cpus":
+			info.CPUs, err = strconv.Atoi(val)
+		case key == "cpu_reserved":
+			info.CPUReserved, err = strconv.ParseFloat(val, 64)
+		case key == "mem_total":
+			info.MemoryTotal, err = strconv.ParseInt(val, 10, 64)
+		case key == "mem_available":
+			info.MemoryAvailable, err = strconv.ParseInt(val, 10, 64)
+		case key == "containers":
+			info.Containers, err = strconv.Atoi(val)
+		case key == "cluster_port":
+			info.ClusterPort, err = strconv.Atoi(val)
+		case key == "cordoned":
+			info.Cordoned, err = strconv.ParseBool(val)
+		case strings.HasPrefix(key, labelPrefix):
+			info.Labels[strings.TrimPrefix(key, labelPrefix)] = val
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+
+	if info.ID == "" {
+		return nil, fmt.Errorf("missing node_id")
+	}
+
+	return info, nil
+}
+
+// NodeAnnouncer advertises this node's capacity over mDNS
+type NodeAnnouncer struct {
+	server *mdns.Server
+	mu     sync.Mutex
+}
+
+// NewNodeAnnouncer creates an announcer that has not announced anything yet
+func NewNodeAnnouncer() *NodeAnnouncer {
+	return &NodeAnnouncer{}
+}
+
+// Announce (re)publishes the node information; the API port is the service port
+func (a *NodeAnnouncer) Announce(info NodeInfo) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	service, err := mdns.NewMDNSService(
+		fmt.Sprintf("budgie-node-%s", info.ID),
+		nodeServiceType,
+		"local.",
+		"",
+		info.APIPort,
+		getLocalNetIPs(),
+		info.TXT(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create mDNS service: %w", err)
+	}
+
+	server, err := mdns.NewServer(&mdns.Config{Zone: service})
+	if err != nil {
+		return fmt.Errorf("failed to create mDNS server: %w", err)
+	}
+
+	// Replace the previous announcement only once the new one is up
+	if a.server != nil {
+		a.server.Shutdown()
+	}
+	a.server = server
+
+	logrus.Debugf("Announced node %s (%d containers, %d bytes available)", info.ID, info.Containers, info.MemoryAvailable)
+	return nil
+}
+
+// Shutdown stops announcing the node
+func (a *NodeAnnouncer) Shutdown() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.server == nil {
+		return nil
+	}
+	err := a.server.Shutdown()
+	a.server = nil
+	return err
+}
+
+// DiscoverNodes queries the network for nodes advertising their capacity
+func (d *DiscoveryService) DiscoverNodes(timeout time.Duration) ([]NodeInfo, error) {
+	entries := make(chan *mdns.ServiceEntry)
+	nodes := make(map[string]NodeInfo)
+	var mu sync.Mutex
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for entry := range entries {
+			info, err := ParseNodeTXT(entry.InfoFields)
+			if err != nil {
+				logrus.Debugf("Ignoring node announcement %s: %v", entry.Name, err)
+				continue
+			}
+			if entry.AddrV4 != nil {
+				info.Address = entry.AddrV4.String()
+			} else if entry.AddrV6 != nil {
+				info.Address = entry.AddrV6.String()
+			}
+			info.APIPort = entry.Port
+
+			mu.Lock()
+			nodes[info.ID] = *info
+			mu.Unlock()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	params := &mdns.QueryParam{
+		Service:             nodeServiceType,
+		Domain:              "local",
+		Timeout:             timeout,
+		Entries:             entries,
+		WantUnicastResponse: false,
+	}
+
+	if err := mdns.Query(params); err != nil {
+		close(entries)
+		return nil, fmt.Errorf("mDNS query failed: %w", err)
+	}
+
+	<-ctx.Done()
+	close(entries)
+	<-done
+
+	list := make([]NodeInfo, 0, len(nodes))
+	for _, info := range nodes {
+		list = append(list, info)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+
+	return list, nil
+}
@@ -0,0 +1,79 @@
+package discovery
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+func TestContainerTXT_RoundTrip(t *testing.T) {
+	ctr := &types.Container{
+		ID:         "abc123",
+		Name:       "web",
+		NodeID:     "pi-1",
+		Image:      types.ImageConfig{DockerImage: "nginx:alpine"},
+		Role:       types.RoleReplica,
+		ReplicaSet: "def456",
+		Epoch:      2,
+		Placement: &types.PlacementConfig{
+			Constraints:  []string{"arch==arm64", "disk!=hdd"},
+			Affinity:     []string{"db"},
+			AntiAffinity: []string{"web"},
+		},
+	}
+	labels := map[string]string{"arch": "arm64", "disk": "ssd"}
+
+	got := parseContainerTXT(containerTXT(ctr, labels))
+	if got == nil {
+		t.Fatal("Expected the container to parse")
+	}
+	if got.ID != ctr.ID || got.Name != ctr.Name || got.NodeID != ctr.NodeID || got.Image != "nginx:alpine" {
+		t.Errorf("Unexpected container fields: %+v", got)
+	}
+	if got.Role != types.RoleReplica || got.ReplicaSetID() != "def456" || got.Epoch != 2 {
+		t.Errorf("Unexpected replication fields: role=%s set=%s epoch=%d", got.Role, got.ReplicaSetID(), got.Epoch)
+	}
+	if !reflect.DeepEqual(got.NodeLabels, labels) {
+		t.Errorf("Expected labels %v, got %v", labels, got.NodeLabels)
+	}
+	if !reflect.DeepEqual(got.Placement, ctr.Placement) {
+		t.Errorf("Expected placement %+v, got %+v", ctr.Placement, got.Placement)
+	}
+}
+
+func TestParseContainerTXT_WithoutPlacement(t *testing.T) {
+	got := parseContainerTXT([]string{"container_id=abc123", "container_name=web"})
+	if got == nil || got.Placement != nil {
+		t.Fatalf("Expected a container without placement, got %+v", got)
+	}
+	if got.Role != "" || got.ReplicaSetID() != "abc123" {
+		t.Errorf("Expected an unreplicated container to form its own set, got role=%q set=%s", got.Role, got.ReplicaSetID())
+	}
+
+	if parseContainerTXT([]string{"container_name=web"}) != nil {
+		t.Error("Expected entries without a container ID to be ignored")
+	}
+}
+
+func TestNodeTXT_RoundTrip(t *testing.T) {
+	info := NodeInfo{
+		ID:              "pi-1",
+		CPUs:            4,
+		CPUReserved:     1.5,
+		MemoryTotal:     8 << 30,
+		MemoryAvailable: 2 << 30,
+		Containers:      3,
+		ClusterPort:     18735,
+		Labels:          map[string]string{"arch": "arm64"},
+		Cordoned:        true,
+	}
+
+	got, err := ParseNodeTXT(info.TXT())
+	if err != nil {
+		t.Fatalf("ParseNodeTXT failed: %v", err)
+	}
+	if !reflect.DeepEqual(*got, info) {
+		t.Errorf("Expected %+v, got %+v", info, *got)
+	}
+}
@@ -0,0 +1,109 @@
+// Package events records notable cluster decisions, such as scaling, in an
+// append-only log so that they can be reviewed after the fact.
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Kind classifies an event
+type Kind string
+
+const (
+	KindScale    Kind = "scale"
+	KindNode     Kind = "node"
+	KindFailover Kind = "failover"
+	KindConflict Kind = "conflict" // Concurrent changes to a bidirectional volume
+)
+
+// Event is a single recorded decision
+type Event struct {
+	Time    time.Time         `json:"time"`
+	Kind    Kind              `json:"kind"`
+	Subject string            `json:"subject"` // Service, container or node the event is about
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Recorder records events
+type Recorder interface {
+	Record(e Event)
+}
+
+// Log is a Recorder that appends events to a JSON lines file
+type Log struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewLog creates an event log stored in dataDir
+func NewLog(dataDir string) *Log {
+	return &Log{path: filepath.Join(dataDir, "events.jsonl")}
+}
+
+// Record appends an event to the log. Failures to persist are logged, since
+// losing an event must never interrupt the decision it describes.
+func (l *Log) Record(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	logrus.Infof("[%s] %s: %s", e.Kind, e.Subject, e.Message)
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		logrus.Errorf("Failed to encode event: %v", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		logrus.Errorf("Failed to open event log: %v", err)
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		logrus.Errorf("Failed to write event: %v", err)
+	}
+}
+
+// List returns the recorded events of the given kind, or all events if kind is empty
+func (l *Log) List(kind Kind) ([]Event, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open event log: %w", err)
+	}
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			logrus.Warnf("Skipping malformed event: %v", err)
+			continue
+		}
+		if kind == "" || e.Kind == kind {
+			events = append(events, e)
+		}
+	}
+
+	return events, scanner.Err()
+}
@@ -0,0 +1,35 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestLog_RecordAndList(t *testing.T) {
+	log := NewLog(t.TempDir())
+
+	log.Record(Event{Kind: KindScale, Subject: "web", Message: "scaled from 2 to 3"})
+	log.Record(Event{Kind: "other", Subject: "db", Message: "something else"})
+
+	all, err := log.List("")
+	if err != nil {
+		t.Fatalf("Failed to list events: %v", err)
+	}
+	if len(all) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(all))
+	}
+	if all[0].Time.IsZero() {
+		t.Error("Expected event time to be filled in")
+	}
+
+	scale, _ := log.List(KindScale)
+	if len(scale) != 1 || scale[0].Subject != "web" {
+		t.Errorf("Expected only the scale event, got %+v", scale)
+	}
+}
+
+func TestLog_ListEmpty(t *testing.T) {
+	events, err := NewLog(t.TempDir()).List("")
+	if err != nil || len(events) != 0 {
+		t.Errorf("Expected no events and no error, got %v (%v)", events, err)
+	}
+}
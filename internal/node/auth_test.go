@@ -0,0 +1,211 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zarigata/budgie/internal/api"
+	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/internal/runtime"
+	budgiesync "github.com/zarigata/budgie/internal/sync"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// execRuntime serves logs and execs for the remote control tests
+type execRuntime struct {
+	stubRuntime
+}
+
+func (execRuntime) Logs(ctx context.Context, id string, follow bool, tail int) (runtime.LogReader, error) {
+	return io.NopCloser(strings.NewReader("line 1\nline 2\n")), nil
+}
+
+func (execRuntime) ExecWithOptions(ctx context.Context, id string, opts runtime.ExecOptions) (int, error) {
+	fmt.Fprintf(opts.Stdout, "ran %s\n", strings.Join(opts.Cmd, " "))
+	return 3, nil
+}
+
+// newTLSTestClient starts a node API over mutual TLS with the given roles and
+// returns a client holding a certificate for commonName. configure sets up
+// the server before it starts.
+func newTLSTestClient(t *testing.T, roles map[string]string, commonName string, configure ...func(*Server)) (*Client, *api.ContainerManager) {
+	t.Helper()
+
+	certDir := t.TempDir()
+	ca, err := budgiesync.LoadOrCreateCA(certDir)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	issue := func(host string) budgiesync.TLSConfig {
+		certFile := filepath.Join(certDir, host+".crt")
+		keyFile := filepath.Join(certDir, host+".key")
+		if err := ca.IssueCertificate([]string{host}, time.Hour, certFile, keyFile); err != nil {
+			t.Fatalf("Failed to issue certificate: %v", err)
+		}
+		return budgiesync.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile, CAFile: ca.CertFile}
+	}
+
+	serverTLS, err := budgiesync.NewTLSConfig(issue("127.0.0.1"))
+	if err != nil {
+		t.Fatalf("Failed to create server TLS config: %v", err)
+	}
+	clientTLS, err := budgiesync.NewTLSConfig(issue(commonName))
+	if err != nil {
+		t.Fatalf("Failed to create client TLS config: %v", err)
+	}
+
+	dataDir := t.TempDir()
+	manager, err := api.NewContainerManager(execRuntime{}, dataDir)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	auth, err := NewAuthorizer(roles)
+	if err != nil {
+		t.Fatalf("Failed to create authorizer: %v", err)
+	}
+
+	info := func() (discovery.NodeInfo, error) { return discovery.NodeInfo{ID: "node-a"}, nil }
+	server := NewServer(manager, dataDir, info)
+	server.SetAuthorizer(auth)
+	for _, fn := range configure {
+		fn(server)
+	}
+
+	ts := httptest.NewUnstartedServer(server.Handler())
+	ts.TLS = serverTLS
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	u, _ := url.Parse(ts.URL)
+	port, _ := strconv.Atoi(u.Port())
+	return NewClient(u.Hostname(), port, clientTLS), manager
+}
+
+func TestServer_RemoteControlRequiresCertificate(t *testing.T) {
+	client, _, _ := newTestClient(t)
+
+	_, err := client.List(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("Expected listing over plain HTTP to be forbidden, got %v", err)
+	}
+
+	// Node-to-node requests still work without TLS
+	if _, err := client.Info(context.Background()); err != nil {
+		t.Errorf("Expected info to work without TLS, got %v", err)
+	}
+}
+
+func TestServer_ViewerCanListButNotStop(t *testing.T) {
+	client, manager := newTLSTestClient(t, map[string]string{"alice": "viewer"}, "alice")
+	web := startLocal(t, manager, &types.Container{Name: "web"})
+
+	containers, err := client.List(context.Background())
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(containers) != 1 || containers[0].ID != web.ID {
+		t.Errorf("Expected web to be listed, got %+v", containers)
+	}
+
+	ctr, err := client.Inspect(context.Background(), "web")
+	if err != nil {
+		t.Fatalf("Inspect failed: %v", err)
+	}
+	if ctr.ID != web.ID {
+		t.Errorf("Expected to inspect %s, got %s", web.ID, ctr.ID)
+	}
+
+	_, err = client.Stop(context.Background(), "web", time.Second)
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected a viewer to be refused a stop, got %v", err)
+	}
+	if _, err := client.Run(context.Background(), "web.bun", []byte(testBundle)); err == nil {
+		t.Error("Expected a viewer to be refused a run")
+	}
+}
+
+func TestServer_RejectsCertificateWithoutRole(t *testing.T) {
+	client, _ := newTLSTestClient(t, map[string]string{"alice": "operator"}, "mallory")
+
+	_, err := client.Info(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("Expected a certificate without a role to be refused, got %v", err)
+	}
+}
+
+func TestServer_OperatorControlsContainers(t *testing.T) {
+	// With no roles, every certificate signed by the CA is an operator
+	client, manager := newTLSTestClient(t, nil, "bob")
+	web := startLocal(t, manager, &types.Container{Name: "web"})
+
+	var out bytes.Buffer
+	exitCode, err := client.Exec(context.Background(), web.ShortID(), ExecRequest{Cmd: []string{"ls", "/"}}, &out)
+	if err != nil {
+		t.Fatalf("Exec failed: %v", err)
+	}
+	if exitCode != 3 || out.String() != "ran ls /\n" {
+		t.Errorf("Expected exit code 3 and the command output, got %d %q", exitCode, out.String())
+	}
+
+	logs, err := client.Logs(context.Background(), "web", false, 0)
+	if err != nil {
+		t.Fatalf("Logs failed: %v", err)
+	}
+	data, _ := io.ReadAll(logs)
+	logs.Close()
+	if string(data) != "line 1\nline 2\n" {
+		t.Errorf("Unexpected logs: %q", data)
+	}
+
+	ctr, err := client.Stop(context.Background(), "web", time.Second)
+	if err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if ctr.IsRunning() {
+		t.Error("Expected the container to be stopped")
+	}
+	if _, err := client.Stop(context.Background(), "web", time.Second); err == nil || !strings.Contains(err.Error(), "409") {
+		t.Errorf("Expected stopping a stopped container to conflict, got %v", err)
+	}
+}
+
+func TestNewAuthorizer_RejectsUnknownRole(t *testing.T) {
+	if _, err := NewAuthorizer(map[string]string{"alice": "admin"}); err == nil {
+		t.Error("Expected an unknown role to be rejected")
+	}
+}
+
+func TestResolve(t *testing.T) {
+	nodes := []discovery.NodeInfo{{ID: "node-b", Address: "10.0.0.2", APIPort: 19000}}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"node-b", "http://10.0.0.2:19000"},
+		{"10.0.0.3:18000", "http://10.0.0.3:18000"},
+		{"box.local", "http://box.local:18734"},
+	}
+	for _, tt := range tests {
+		client, err := Resolve(tt.name, nodes, 18734, nil)
+		if err != nil {
+			t.Fatalf("Resolve(%q) failed: %v", tt.name, err)
+		}
+		if client.baseURL != tt.want {
+			t.Errorf("Resolve(%q): expected %s, got %s", tt.name, tt.want, client.baseURL)
+		}
+	}
+
+	if _, err := Resolve("box:http", nil, 18734, nil); err == nil {
+		t.Error("Expected an invalid port to be rejected")
+	}
+}
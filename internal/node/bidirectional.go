@@ -0,0 +1,88 @@
+package node
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/zarigata/budgie/internal/events"
+	budgiesync "github.com/zarigata/budgie/internal/sync"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// EnableBidirectional syncs volumes marked bidirectional both ways: replicas
+// push their changes to the primary, which passes them on. Versions are
+// kept in stateDir and conflicts are recorded as events. Without it, such
+// volumes are only pushed by the primary.
+func (s *VolumeSync) EnableBidirectional(nodeID, stateDir string, recorder events.Recorder) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodeID = nodeID
+	s.stateDir = stateDir
+	s.events = recorder
+}
+
+// pushes returns true if a container's volume is pushed from this node:
+// every rw volume of a primary, and the bidirectional ones of a replica
+func (s *VolumeSync) pushes(ctr *types.Container, vol types.VolumeMapping) bool {
+	if vol.Mode != "rw" {
+		return false
+	}
+	if !ctr.IsReplica() {
+		return true
+	}
+	_, ok := s.versioning(ctr, vol)
+	return ok
+}
+
+// versioning returns how a bidirectional volume of a local container is
+// versioned, or false if the volume is synced one way
+func (s *VolumeSync) versioning(ctr *types.Container, vol types.VolumeMapping) (budgiesync.Versioning, bool) {
+	if s.nodeID == "" || !vol.Bidirectional() {
+		return budgiesync.Versioning{}, false
+	}
+
+	source := volumeSource(vol)
+	sum := sha256.Sum256([]byte(source))
+	return budgiesync.Versioning{
+		NodeID:    s.nodeID,
+		IndexPath: filepath.Join(s.stateDir, "sync", hex.EncodeToString(sum[:8])+".json"),
+		Strategy:  vol.Conflict,
+		Primary:   !ctr.IsReplica(),
+		OnConflict: func(c budgiesync.Conflict) {
+			s.recordConflict(ctr, vol, c)
+		},
+	}, true
+}
+
+// recordConflict records a resolved conflict as an event
+func (s *VolumeSync) recordConflict(ctr *types.Container, vol types.VolumeMapping, c budgiesync.Conflict) {
+	file := path.Join(vol.Target, filepath.ToSlash(c.Path))
+	message := fmt.Sprintf("conflicting changes to %s, kept the version of %s", file, c.Winner)
+	if s.events == nil {
+		logrus.Warnf("Container %s had %s", ctr.ShortID(), message)
+		return
+	}
+
+	details := map[string]string{
+		"container": ctr.ID,
+		"volume":    vol.Target,
+		"path":      file,
+		"strategy":  string(c.Strategy),
+		"winner":    c.Winner,
+		"loser":     c.Loser,
+	}
+	if c.Copy != "" {
+		details["copy"] = path.Join(vol.Target, filepath.ToSlash(c.Copy))
+	}
+	s.events.Record(events.Event{
+		Kind:    events.KindConflict,
+		Subject: ctr.ServiceName(),
+		Message: message,
+		Details: details,
+	})
+}
@@ -0,0 +1,328 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// Client talks to the node API of a remote node
+type Client struct {
+	baseURL string
+	http    *http.Client
+	stream  *http.Client // No timeout, for following logs and exec output
+}
+
+// NewClient creates a client for the node API at address:port, using TLS
+// when tlsConfig is not nil
+func NewClient(address string, port int, tlsConfig *tls.Config) *Client {
+	scheme := "http"
+	transport := http.DefaultTransport
+	if tlsConfig != nil {
+		scheme = "https"
+		transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+
+	return &Client{
+		baseURL: fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(address, strconv.Itoa(port))),
+		http: &http.Client{
+			Transport: transport,
+			Timeout:   2 * time.Minute, // Submitting may include an image pull
+		},
+		stream: &http.Client{Transport: transport},
+	}
+}
+
+// Resolve returns a client for a node named by its discovered ID, or else
+// by host[:port] of its node API, using defaultPort when none is given
+func Resolve(name string, nodes []discovery.NodeInfo, defaultPort int, tlsConfig *tls.Config) (*Client, error) {
+	for _, n := range nodes {
+		if n.ID == name {
+			return NewClient(n.Address, n.APIPort, tlsConfig), nil
+		}
+	}
+
+	host, portStr, err := net.SplitHostPort(name)
+	if err != nil {
+		// No port given
+		return NewClient(name, defaultPort, tlsConfig), nil
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || host == "" {
+		return nil, fmt.Errorf("invalid node %q: not a discovered node or host:port", name)
+	}
+	return NewClient(host, port, tlsConfig), nil
+}
+
+// Info returns the remote node's capacity
+func (c *Client) Info(ctx context.Context) (*discovery.NodeInfo, error) {
+	var info discovery.NodeInfo
+	if err := c.do(ctx, http.MethodGet, "/v1/info", nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Run submits a bundle to the remote node and returns the started container
+func (c *Client) Run(ctx context.Context, filename string, data []byte) (*RunResponse, error) {
+	var resp RunResponse
+	req := RunRequest{Filename: filename, Bundle: data}
+	if err := c.do(ctx, http.MethodPost, "/v1/containers", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// RunInstance starts an instance of a service on the remote node, configured
+// like ctr
+func (c *Client) RunInstance(ctx context.Context, ctr *types.Container) (*RunResponse, error) {
+	var resp RunResponse
+	req := RunRequest{Instance: ctr}
+	if err := c.do(ctx, http.MethodPost, "/v1/containers", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Replicate asks the remote node to create a replica of a primary
+func (c *Client) Replicate(ctx context.Context, spec ReplicaSpec) (*RunResponse, error) {
+	var resp RunResponse
+	if err := c.do(ctx, http.MethodPost, "/v1/replicas", spec, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Promote hands the primary role to a replica on the remote node
+func (c *Client) Promote(ctx context.Context, req PromoteRequest) error {
+	var resp RunResponse
+	return c.do(ctx, http.MethodPost, "/v1/promote", req, &resp)
+}
+
+// List returns every container on the remote node
+func (c *Client) List(ctx context.Context) ([]ContainerSummary, error) {
+	var containers []ContainerSummary
+	if err := c.do(ctx, http.MethodGet, "/v1/containers", nil, &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// Replication returns how far the replicas of the remote node's primaries
+// are behind
+func (c *Client) Replication(ctx context.Context) ([]ReplicationStatus, error) {
+	var statuses []ReplicationStatus
+	if err := c.do(ctx, http.MethodGet, "/v1/replication", nil, &statuses); err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// AddBackend registers a replica of a service with the remote node's load
+// balancer
+func (c *Client) AddBackend(ctx context.Context, req BackendRequest) error {
+	var resp BackendRequest
+	return c.do(ctx, http.MethodPost, "/v1/backends", req, &resp)
+}
+
+// StartDrain stops the remote node's load balancer from sending requests to
+// a replica and returns once the node has accepted the drain. The returned
+// channel is closed once the replica has been removed, after its in-flight
+// requests have finished or req.Timeout has passed, or the node is lost.
+func (c *Client) StartDrain(ctx context.Context, req BackendRequest) (<-chan struct{}, error) {
+	resp, err := c.send(ctx, c.stream, http.MethodPost, "/v1/backends/drain", req)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make(chan struct{})
+	go func() {
+		defer close(removed)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	return removed, nil
+}
+
+// Cordon stops the remote node accepting new containers
+func (c *Client) Cordon(ctx context.Context) error {
+	_, err := c.nodeAction(ctx, "cordon", nil)
+	return err
+}
+
+// Drain drains the remote node, see Drainer.Drain. The steps taken are
+// returned along with the error of a drain that stopped partway.
+func (c *Client) Drain(ctx context.Context, force bool) ([]DrainStep, error) {
+	return c.nodeAction(ctx, "drain", DrainRequest{Force: force})
+}
+
+// Uncordon lets the remote node accept containers again and starts the
+// containers its drain stopped
+func (c *Client) Uncordon(ctx context.Context) ([]DrainStep, error) {
+	return c.nodeAction(ctx, "uncordon", nil)
+}
+
+// nodeAction asks the remote node to act on itself. A drain stops
+// containers one by one, so it has no overall timeout.
+func (c *Client) nodeAction(ctx context.Context, action string, body interface{}) ([]DrainStep, error) {
+	resp, err := c.send(ctx, c.stream, http.MethodPost, "/v1/node/"+action, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out DrainResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if out.Error != "" {
+		return out.Steps, errors.New(out.Error)
+	}
+	return out.Steps, nil
+}
+
+// Inspect returns a container on the remote node by ID, ID prefix or name
+func (c *Client) Inspect(ctx context.Context, idOrName string) (*types.Container, error) {
+	var ctr types.Container
+	if err := c.do(ctx, http.MethodGet, containerPath(idOrName, ""), nil, &ctr); err != nil {
+		return nil, err
+	}
+	return &ctr, nil
+}
+
+// Stop stops a container on the remote node
+func (c *Client) Stop(ctx context.Context, idOrName string, timeout time.Duration) (*types.Container, error) {
+	var ctr types.Container
+	path := fmt.Sprintf("%s?timeout=%d", containerPath(idOrName, "stop"), int(timeout.Seconds()))
+	if err := c.do(ctx, http.MethodPost, path, nil, &ctr); err != nil {
+		return nil, err
+	}
+	return &ctr, nil
+}
+
+// Logs streams the logs of a container on the remote node. The caller must
+// close the returned reader.
+func (c *Client) Logs(ctx context.Context, idOrName string, follow bool, tail int) (io.ReadCloser, error) {
+	path := fmt.Sprintf("%s?follow=%t&tail=%d", containerPath(idOrName, "logs"), follow, tail)
+	resp, err := c.send(ctx, c.stream, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Exec runs a command in a container on the remote node, copying its output
+// to out, and returns the command's exit code
+func (c *Client) Exec(ctx context.Context, idOrName string, req ExecRequest, out io.Writer) (int, error) {
+	resp, err := c.send(ctx, c.stream, http.MethodPost, containerPath(idOrName, "exec"), req)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(out, resp.Body); err != nil {
+		return -1, fmt.Errorf("failed to read exec output: %w", err)
+	}
+
+	// The trailer is only available once the body has been read
+	exitCode, err := strconv.Atoi(resp.Trailer.Get(exitCodeTrailer))
+	if err != nil {
+		return -1, fmt.Errorf("node did not report an exit code")
+	}
+	return exitCode, nil
+}
+
+func containerPath(idOrName, action string) string {
+	path := "/v1/containers/" + url.PathEscape(idOrName)
+	if action != "" {
+		path += "/" + action
+	}
+	return path
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	resp, err := c.send(ctx, c.http, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// send makes a request and turns error responses into errors. The caller
+// must close the body of a successful response.
+func (c *Client) send(ctx context.Context, client *http.Client, method, path string, body interface{}) (*http.Response, error) {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach node: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var e errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err == nil && e.Error != "" {
+			return nil, fmt.Errorf("node returned %d: %s", resp.StatusCode, e.Error)
+		}
+		return nil, fmt.Errorf("node returned %d", resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+// Backends is the load balancer of a node reached through its API, which
+// budgie update registers the replicas of a service with
+type Backends struct {
+	client *Client
+}
+
+// NewBackends returns the load balancer of the node client talks to
+func NewBackends(client *Client) *Backends {
+	return &Backends{client: client}
+}
+
+// AddServiceBackend sends requests for service to a replica at ip:port,
+// health checked as its bundle asks
+func (b *Backends) AddServiceBackend(service, ip string, port int, health *types.HealthCheck) error {
+	req := BackendRequest{Service: service, Address: ip, Port: port, Health: health}
+	return b.client.AddBackend(context.Background(), req)
+}
+
+// StartDrain stops sending requests for service to a replica, see
+// Client.StartDrain
+func (b *Backends) StartDrain(service, ip string, port int, timeout time.Duration) (<-chan struct{}, error) {
+	req := BackendRequest{Service: service, Address: ip, Port: port, Timeout: timeout}
+	return b.client.StartDrain(context.Background(), req)
+}
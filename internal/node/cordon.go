@@ -0,0 +1,75 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cordonFile holds the cordon state of the node under the data directory
+const cordonFile = "cordon.json"
+
+// CordonState records that a node refuses new placements, and which
+// containers a drain stopped so that uncordon can start them again
+type CordonState struct {
+	Since   time.Time `json:"since"`
+	Drained []string  `json:"drained,omitempty"` // Container IDs in the order they were stopped
+}
+
+// LoadCordon returns the cordon state of the node, or nil if it is not cordoned
+func LoadCordon(dataDir string) (*CordonState, error) {
+	data, err := os.ReadFile(filepath.Join(dataDir, cordonFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cordon state: %w", err)
+	}
+
+	var state CordonState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse cordon state: %w", err)
+	}
+	return &state, nil
+}
+
+// SaveCordon cordons the node with the given state
+func SaveCordon(dataDir string, state *CordonState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	return os.WriteFile(filepath.Join(dataDir, cordonFile), data, 0644)
+}
+
+// Cordon marks the node as refusing new placements. Cordoning an already
+// cordoned node keeps its state.
+func Cordon(dataDir string) (*CordonState, error) {
+	state, err := LoadCordon(dataDir)
+	if err != nil || state != nil {
+		return state, err
+	}
+
+	state = &CordonState{Since: time.Now()}
+	return state, SaveCordon(dataDir, state)
+}
+
+// Uncordon lets the node accept placements again
+func Uncordon(dataDir string) error {
+	err := os.Remove(filepath.Join(dataDir, cordonFile))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove cordon state: %w", err)
+	}
+	return nil
+}
+
+// IsCordoned returns true if the node refuses new placements
+func IsCordoned(dataDir string) bool {
+	_, err := os.Stat(filepath.Join(dataDir, cordonFile))
+	return err == nil
+}
@@ -0,0 +1,246 @@
+package node
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/zarigata/budgie/internal/api"
+	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/internal/scheduler"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// Peers is what a drain needs to know about, and ask of, other nodes
+type Peers interface {
+	Nodes() ([]discovery.NodeInfo, error)
+	ReplicaSets() (map[string][]api.ReplicaMember, error)
+	Replicate(ctx context.Context, node discovery.NodeInfo, spec ReplicaSpec) (*RunResponse, error)
+	Promote(ctx context.Context, node discovery.NodeInfo, req PromoteRequest) error
+}
+
+// DiscoveredPeers finds peers over mDNS and talks to their node API
+type DiscoveredPeers struct {
+	disc      *discovery.DiscoveryService
+	tlsConfig *tls.Config
+	timeout   time.Duration
+}
+
+// NewDiscoveredPeers creates peers found over mDNS within timeout
+func NewDiscoveredPeers(disc *discovery.DiscoveryService, tlsConfig *tls.Config, timeout time.Duration) *DiscoveredPeers {
+	return &DiscoveredPeers{disc: disc, tlsConfig: tlsConfig, timeout: timeout}
+}
+
+// Nodes returns the nodes announcing themselves
+func (p *DiscoveredPeers) Nodes() ([]discovery.NodeInfo, error) {
+	return p.disc.DiscoverNodes(p.timeout)
+}
+
+// ReplicaSets returns the announced containers grouped by replica set
+func (p *DiscoveredPeers) ReplicaSets() (map[string][]api.ReplicaMember, error) {
+	return replicaSets(p.disc, p.timeout)
+}
+
+// Replicate creates a replica on a node
+func (p *DiscoveredPeers) Replicate(ctx context.Context, node discovery.NodeInfo, spec ReplicaSpec) (*RunResponse, error) {
+	return NewClient(node.Address, node.APIPort, p.tlsConfig).Replicate(ctx, spec)
+}
+
+// Promote hands the primary role to a replica on a node
+func (p *DiscoveredPeers) Promote(ctx context.Context, node discovery.NodeInfo, req PromoteRequest) error {
+	return NewClient(node.Address, node.APIPort, p.tlsConfig).Promote(ctx, req)
+}
+
+// DrainStep reports what a drain or uncordon did with a container
+type DrainStep struct {
+	ContainerID string `json:"container_id"`
+	Name        string `json:"name"`
+	Action      string `json:"action"`         // "moved", "stopped" or "started"
+	Node        string `json:"node,omitempty"` // Node a moved container's primary role went to
+}
+
+// Drainer empties the local node before maintenance. Replicated primaries
+// hand their role to a replica on another node, which is created first if
+// needed, and every container is then stopped in reverse dependency order.
+type Drainer struct {
+	manager     *api.ContainerManager
+	dataDir     string
+	nodeID      string
+	peers       Peers
+	volumes     api.VolumeSync
+	stopTimeout time.Duration
+}
+
+// NewDrainer creates a drainer for the local node. volumes may be nil, in
+// which case moved containers start without their data.
+func NewDrainer(manager *api.ContainerManager, dataDir, nodeID string, peers Peers, volumes api.VolumeSync) *Drainer {
+	return &Drainer{
+		manager:     manager,
+		dataDir:     dataDir,
+		nodeID:      nodeID,
+		peers:       peers,
+		volumes:     volumes,
+		stopTimeout: 10 * time.Second,
+	}
+}
+
+// Drain cordons the node, moves replicated containers to peers and stops
+// local containers. A container that cannot be moved aborts the drain before
+// anything is stopped, unless force is set.
+func (d *Drainer) Drain(ctx context.Context, force bool) ([]DrainStep, error) {
+	state, err := Cordon(d.dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var running []*types.Container
+	for _, ctr := range d.manager.List() {
+		if ctr.IsRunning() {
+			running = append(running, ctr)
+		}
+	}
+
+	var steps []DrainStep
+	for _, ctr := range running {
+		if !replicated(ctr) || ctr.IsReplica() {
+			continue
+		}
+
+		node, err := d.move(ctx, ctr)
+		if err != nil {
+			if !force {
+				return steps, fmt.Errorf("failed to move %s (%s): %w", ctr.Name, ctr.ShortID(), err)
+			}
+			logrus.Warnf("Failed to move %s, stopping it anyway: %v", ctr.ShortID(), err)
+			continue
+		}
+		steps = append(steps, DrainStep{ContainerID: ctr.ID, Name: ctr.Name, Action: "moved", Node: node})
+	}
+
+	order, err := api.NewDependencyResolver(d.manager).StopOrder(running)
+	if err != nil {
+		return steps, err
+	}
+
+	for _, ctr := range order {
+		if err := d.manager.Stop(ctx, ctr.ID, d.stopTimeout); err != nil {
+			return steps, fmt.Errorf("failed to stop %s: %w", ctr.ShortID(), err)
+		}
+		state.Drained = append(state.Drained, ctr.ID)
+		if err := SaveCordon(d.dataDir, state); err != nil {
+			return steps, err
+		}
+		steps = append(steps, DrainStep{ContainerID: ctr.ID, Name: ctr.Name, Action: "stopped"})
+	}
+
+	return steps, nil
+}
+
+// Uncordon lets the node accept placements again and starts the containers
+// the drain stopped, in reverse stop order. Moved containers come back as
+// replicas of their new primary.
+func (d *Drainer) Uncordon(ctx context.Context) ([]DrainStep, error) {
+	state, err := LoadCordon(d.dataDir)
+	if err != nil || state == nil {
+		return nil, err
+	}
+
+	var steps []DrainStep
+	for i := len(state.Drained) - 1; i >= 0; i-- {
+		ctr, err := d.manager.Get(state.Drained[i])
+		if err != nil || ctr.IsRunning() {
+			// Removed or started since the drain
+			continue
+		}
+		if err := d.manager.Start(ctx, ctr.ID); err != nil {
+			return steps, fmt.Errorf("failed to start %s: %w", ctr.ShortID(), err)
+		}
+		steps = append(steps, DrainStep{ContainerID: ctr.ID, Name: ctr.Name, Action: "started"})
+	}
+
+	return steps, Uncordon(d.dataDir)
+}
+
+// move hands the primary role of a container to a replica on another node,
+// creating the replica if the set has none, and returns that node's ID
+func (d *Drainer) move(ctx context.Context, ctr *types.Container) (string, error) {
+	nodes, err := d.peers.Nodes()
+	if err != nil {
+		return "", fmt.Errorf("failed to discover nodes: %w", err)
+	}
+	sets, err := d.peers.ReplicaSets()
+	if err != nil {
+		return "", fmt.Errorf("failed to discover replicas: %w", err)
+	}
+
+	available := make(map[string]discovery.NodeInfo)
+	var candidates []discovery.NodeInfo
+	for _, n := range nodes {
+		if n.ID == d.nodeID {
+			continue
+		}
+		// The scheduler skips cordoned nodes itself, reporting why
+		candidates = append(candidates, n)
+		if !n.Cordoned {
+			available[n.ID] = n
+		}
+	}
+
+	// Prefer an existing replica, which already has most of the data
+	var replicas []api.ReplicaMember
+	for _, m := range sets[ctr.ReplicaSetID()] {
+		if _, ok := available[m.NodeID]; ok && !m.IsPrimary() && m.ContainerID != ctr.ID {
+			replicas = append(replicas, m)
+		}
+	}
+	sort.Slice(replicas, func(i, j int) bool { return replicas[i].NodeID < replicas[j].NodeID })
+
+	var target api.ReplicaMember
+	if len(replicas) > 0 {
+		target = replicas[0]
+	} else {
+		placement, err := scheduler.Schedule(candidates, scheduler.RequestFor(ctr, nil), nil)
+		if err != nil {
+			return "", err
+		}
+		resp, err := d.peers.Replicate(ctx, placement.Node, SpecFor(ctr, d.nodeID))
+		if err != nil {
+			return "", fmt.Errorf("failed to create replica on %s: %w", placement.Node.ID, err)
+		}
+		target = api.ReplicaMember{
+			ContainerID: resp.ID,
+			NodeID:      placement.Node.ID,
+			Role:        types.RoleReplica,
+			Epoch:       ctr.Epoch,
+			Address:     placement.Node.Address,
+		}
+	}
+
+	if d.volumes != nil {
+		if err := d.volumes.Push(ctr, target); err != nil {
+			return "", err
+		}
+	}
+
+	epoch := ctr.Epoch + 1
+	req := PromoteRequest{ContainerID: target.ContainerID, Epoch: epoch, Peers: []string{d.nodeID}}
+	if err := d.peers.Promote(ctx, available[target.NodeID], req); err != nil {
+		return "", fmt.Errorf("failed to promote replica on %s: %w", target.NodeID, err)
+	}
+
+	// The local copy rejoins as a replica after uncordon
+	if err := d.manager.SetReplication(ctr.ID, types.RoleReplica, epoch, []string{target.NodeID}); err != nil {
+		return "", err
+	}
+
+	return target.NodeID, nil
+}
+
+// replicated returns true if the container has copies that should survive a drain
+func replicated(ctr *types.Container) bool {
+	return ctr.Replicas != nil || ctr.Role != "" || ctr.ReplicaSet != ""
+}
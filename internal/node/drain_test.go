@@ -0,0 +1,197 @@
+package node
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zarigata/budgie/internal/api"
+	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// fakePeers is a single peer node served by a test node API
+type fakePeers struct {
+	nodes  []discovery.NodeInfo
+	sets   map[string][]api.ReplicaMember
+	client *Client
+}
+
+func (p *fakePeers) Nodes() ([]discovery.NodeInfo, error) { return p.nodes, nil }
+
+func (p *fakePeers) ReplicaSets() (map[string][]api.ReplicaMember, error) { return p.sets, nil }
+
+func (p *fakePeers) Replicate(ctx context.Context, node discovery.NodeInfo, spec ReplicaSpec) (*RunResponse, error) {
+	return p.client.Replicate(ctx, spec)
+}
+
+func (p *fakePeers) Promote(ctx context.Context, node discovery.NodeInfo, req PromoteRequest) error {
+	return p.client.Promote(ctx, req)
+}
+
+func startLocal(t *testing.T, m *api.ContainerManager, ctr *types.Container) *types.Container {
+	t.Helper()
+	ctr.ID = types.GenerateContainerID()
+	ctr.Image = types.ImageConfig{DockerImage: ctr.Name + ":1"}
+	ctr.CreatedAt = time.Now()
+	if err := m.Create(context.Background(), ctr); err != nil {
+		t.Fatalf("Failed to create container: %v", err)
+	}
+	if err := m.Start(context.Background(), ctr.ID); err != nil {
+		t.Fatalf("Failed to start container: %v", err)
+	}
+	return ctr
+}
+
+func newTestDrainer(t *testing.T, peers *fakePeers) (*Drainer, *api.ContainerManager, string) {
+	t.Helper()
+	dataDir := t.TempDir()
+	manager, err := api.NewContainerManager(stubRuntime{}, dataDir)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	return NewDrainer(manager, dataDir, "node-a", peers, nil), manager, dataDir
+}
+
+func TestDrainer_MovesReplicatedAndStopsInOrder(t *testing.T) {
+	client, remote, _ := newTestClient(t)
+	peers := &fakePeers{
+		nodes:  []discovery.NodeInfo{{ID: "node-a"}, {ID: "node-b", CPUs: 4}},
+		client: client,
+	}
+	d, local, dataDir := newTestDrainer(t, peers)
+
+	db := startLocal(t, local, &types.Container{Name: "db", Replicas: &types.ReplicasConfig{Min: 1, Max: 1}})
+	web := startLocal(t, local, &types.Container{Name: "web", DependsOn: []string{"db"}})
+
+	steps, err := d.Drain(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+	if len(steps) != 3 || steps[0].Action != "moved" || steps[0].Node != "node-b" {
+		t.Fatalf("Unexpected drain steps: %+v", steps)
+	}
+
+	// The peer now runs the primary of db's replica set
+	moved := remote.List()
+	if len(moved) != 1 || moved[0].IsReplica() || moved[0].ReplicaSetID() != db.ID || moved[0].Epoch != 1 {
+		t.Fatalf("Expected a promoted copy of db on the peer, got %+v", moved)
+	}
+
+	state, _ := LoadCordon(dataDir)
+	if state == nil || len(state.Drained) != 2 || state.Drained[0] != web.ID || state.Drained[1] != db.ID {
+		t.Fatalf("Expected web to stop before db, got %+v", state)
+	}
+	for _, ctr := range local.List() {
+		if ctr.IsRunning() {
+			t.Errorf("Expected %s to be stopped", ctr.Name)
+		}
+	}
+
+	steps, err = d.Uncordon(context.Background())
+	if err != nil {
+		t.Fatalf("Uncordon failed: %v", err)
+	}
+	if len(steps) != 2 || steps[0].ContainerID != db.ID {
+		t.Errorf("Expected db to start before web, got %+v", steps)
+	}
+	if IsCordoned(dataDir) {
+		t.Error("Expected the node to be uncordoned")
+	}
+	if got, _ := local.Get(db.ID); !got.IsRunning() || !got.IsReplica() || got.Peers[0] != "node-b" {
+		t.Errorf("Expected db to come back as a replica of node-b, got role=%s peers=%v", got.Role, got.Peers)
+	}
+}
+
+func TestDrainer_PrefersExistingReplica(t *testing.T) {
+	client, remote, _ := newTestClient(t)
+	peers := &fakePeers{nodes: []discovery.NodeInfo{{ID: "node-b"}, {ID: "node-c"}}, client: client}
+	d, local, _ := newTestDrainer(t, peers)
+
+	db := startLocal(t, local, &types.Container{Name: "db", Role: types.RolePrimary})
+	resp, err := client.Replicate(context.Background(), SpecFor(db, "node-a"))
+	if err != nil {
+		t.Fatalf("Replicate failed: %v", err)
+	}
+	peers.sets = map[string][]api.ReplicaMember{
+		db.ID: {{ContainerID: resp.ID, NodeID: "node-c", Role: types.RoleReplica}},
+	}
+
+	steps, err := d.Drain(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+	if steps[0].Node != "node-c" {
+		t.Errorf("Expected the existing replica on node-c to take over, got %s", steps[0].Node)
+	}
+	if n := len(remote.List()); n != 1 {
+		t.Errorf("Expected no new replica to be created, got %d containers", n)
+	}
+}
+
+func TestDrainer_AbortsWhenNothingCanTakeOver(t *testing.T) {
+	peers := &fakePeers{nodes: []discovery.NodeInfo{{ID: "node-b", Cordoned: true}}}
+	d, local, dataDir := newTestDrainer(t, peers)
+	db := startLocal(t, local, &types.Container{Name: "db", Role: types.RolePrimary})
+
+	_, err := d.Drain(context.Background(), false)
+	if err == nil || !strings.Contains(err.Error(), "cordoned") {
+		t.Fatalf("Expected the drain to fail on a cordoned peer, got %v", err)
+	}
+	if got, _ := local.Get(db.ID); !got.IsRunning() {
+		t.Error("Expected nothing to be stopped after a failed move")
+	}
+	if !IsCordoned(dataDir) {
+		t.Error("Expected the node to stay cordoned")
+	}
+
+	if _, err := d.Drain(context.Background(), true); err != nil {
+		t.Fatalf("Forced drain failed: %v", err)
+	}
+	if got, _ := local.Get(db.ID); got.IsRunning() {
+		t.Error("Expected a forced drain to stop the container")
+	}
+}
+
+func TestClient_DrainsAndUncordonsRemoteNode(t *testing.T) {
+	roles := map[string]string{"alice": "operator", "bob": "viewer"}
+	client, manager := newTLSTestClient(t, roles, "alice", func(s *Server) {
+		s.SetPeers(&fakePeers{})
+	})
+	web := startLocal(t, manager, &types.Container{Name: "web"})
+
+	steps, err := client.Drain(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+	if len(steps) != 1 || steps[0].ContainerID != web.ID || steps[0].Action != "stopped" {
+		t.Fatalf("Expected web to be stopped, got %+v", steps)
+	}
+	if ctr, _ := client.Inspect(context.Background(), "web"); ctr == nil || ctr.IsRunning() {
+		t.Errorf("Expected web to be stopped on the drained node, got %+v", ctr)
+	}
+	if _, err := client.Run(context.Background(), "web.bun", []byte(testBundle)); err == nil || !strings.Contains(err.Error(), "409") {
+		t.Errorf("Expected the drained node to be cordoned, got %v", err)
+	}
+
+	steps, err = client.Uncordon(context.Background())
+	if err != nil {
+		t.Fatalf("Uncordon failed: %v", err)
+	}
+	if len(steps) != 1 || steps[0].ContainerID != web.ID || steps[0].Action != "started" {
+		t.Fatalf("Expected web to be started, got %+v", steps)
+	}
+
+	if err := client.Cordon(context.Background()); err != nil {
+		t.Fatalf("Cordon failed: %v", err)
+	}
+	if _, err := client.Run(context.Background(), "web.bun", []byte(testBundle)); err == nil || !strings.Contains(err.Error(), "409") {
+		t.Errorf("Expected the node to be cordoned, got %v", err)
+	}
+
+	viewer, _ := newTLSTestClient(t, roles, "bob")
+	if _, err := viewer.Drain(context.Background(), false); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected a viewer to be refused a drain, got %v", err)
+	}
+}
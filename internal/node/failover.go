@@ -0,0 +1,278 @@
+package node
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zarigata/budgie/internal/api"
+	"github.com/zarigata/budgie/internal/cluster"
+	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/internal/events"
+	budgiesync "github.com/zarigata/budgie/internal/sync"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// ClusterView reports replica sets from mDNS announcements and node health
+// from cluster membership
+type ClusterView struct {
+	agent   *cluster.Agent
+	disc    *discovery.DiscoveryService
+	timeout time.Duration
+}
+
+// NewClusterView creates a replica view backed by the cluster agent and mDNS
+func NewClusterView(agent *cluster.Agent, disc *discovery.DiscoveryService, timeout time.Duration) *ClusterView {
+	return &ClusterView{agent: agent, disc: disc, timeout: timeout}
+}
+
+// ReplicaSets groups the announced containers by replica set
+func (v *ClusterView) ReplicaSets() (map[string][]api.ReplicaMember, error) {
+	return replicaSets(v.disc, v.timeout)
+}
+
+// replicaSets discovers containers and groups them by replica set
+func replicaSets(disc *discovery.DiscoveryService, timeout time.Duration) (map[string][]api.ReplicaMember, error) {
+	containers, err := disc.DiscoverContainers(timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make(map[string][]api.ReplicaMember)
+	seen := make(map[string]bool)
+	for _, c := range containers {
+		// Each container is seen once per announced IP
+		if seen[c.ID] {
+			continue
+		}
+		seen[c.ID] = true
+
+		member := api.ReplicaMember{
+			ContainerID: c.ID,
+			NodeID:      c.NodeID,
+			Role:        c.Role,
+			Epoch:       c.Epoch,
+			Port:        c.Port,
+		}
+		if len(c.IPs) > 0 {
+			member.Address = c.IPs[0]
+		}
+		sets[c.ReplicaSetID()] = append(sets[c.ReplicaSetID()], member)
+	}
+
+	return sets, nil
+}
+
+// NodeAlive returns true if the node is the local node or a live member
+func (v *ClusterView) NodeAlive(nodeID string) bool {
+	if nodeID == LocalID() {
+		return true
+	}
+	for _, m := range v.agent.Members() {
+		if m.ID == nodeID {
+			return m.State == cluster.StateAlive || m.State == cluster.StateSuspect
+		}
+	}
+	return false
+}
+
+// VolumeSync moves replica data over the sync server and client
+type VolumeSync struct {
+	server  *budgiesync.Server
+	client  *budgiesync.TLSClient
+	port    int
+	timeout time.Duration
+	limiter *budgiesync.Limiter // Shared by all sync connections, nil for no limit
+
+	// Continuous replication of local containers, when enabled
+	replicate bool
+	debounce  time.Duration
+	maxDelay  time.Duration
+	primaries map[string]*replication // By container ID
+	mu        sync.Mutex
+
+	// Bidirectional volumes, when enabled
+	nodeID   string
+	stateDir string
+	events   events.Recorder
+}
+
+// NewVolumeSync creates a volume sync that receives on server and pushes to
+// the sync port of peers. server may be nil when only pushing.
+func NewVolumeSync(server *budgiesync.Server, client *budgiesync.TLSClient, port int) *VolumeSync {
+	return &VolumeSync{
+		server:  server,
+		client:  client,
+		port:    port,
+		timeout: 10 * time.Second,
+	}
+}
+
+// SetNodeID names this node to the peers it syncs with, see
+// EnableBidirectional, which also sets it
+func (s *VolumeSync) SetNodeID(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodeID = id
+	if s.server != nil {
+		s.server.SetNodeID(id)
+	}
+}
+
+// LimitBandwidth caps the combined bandwidth of the volumes pushed and
+// received, see budgiesync.Limiter. Call it before pushing.
+func (s *VolumeSync) LimitBandwidth(l *budgiesync.Limiter) {
+	s.limiter = l
+	if s.server != nil {
+		s.server.SetLimiter(l)
+	}
+}
+
+// Push sends the rw volumes of a primary to a replica, or the bidirectional
+// volumes of a replica to its primary. With replication enabled, their
+// changes keep being pushed afterwards.
+func (s *VolumeSync) Push(ctr *types.Container, to api.ReplicaMember) error {
+	if to.Address == "" {
+		return fmt.Errorf("replica on %s has no address", to.NodeID)
+	}
+	addr := net.JoinHostPort(to.Address, strconv.Itoa(s.port))
+
+	if s.replicate {
+		return s.addReplica(ctr, to, addr)
+	}
+
+	for _, vol := range ctr.Volumes {
+		if !s.pushes(ctr, vol) {
+			continue
+		}
+
+		mgr, err := budgiesync.NewSyncManager(volumeSource(vol))
+		if err != nil {
+			return fmt.Errorf("failed to create sync manager for %s: %w", vol.Target, err)
+		}
+		mgr.SetLimiter(s.limiter)
+		mgr.SetNodeID(s.nodeID)
+		if v, ok := s.versioning(ctr, vol); ok {
+			if err := mgr.EnableVersioning(v); err != nil {
+				return fmt.Errorf("failed to version volume %s: %w", vol.Target, err)
+			}
+		}
+
+		conn, err := s.client.Dial(addr, s.timeout)
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s: %w", addr, err)
+		}
+		err = mgr.SendVolume(conn, budgiesync.Destination{ContainerID: to.ContainerID, VolumeTarget: vol.Target})
+		conn.Close()
+		if err != nil {
+			return fmt.Errorf("failed to sync volume %s: %w", vol.Target, err)
+		}
+	}
+
+	return nil
+}
+
+// Receive registers the rw volumes of a container to receive data
+func (s *VolumeSync) Receive(ctr *types.Container) error {
+	for _, vol := range ctr.Volumes {
+		if vol.Mode != "rw" {
+			continue
+		}
+		if v, ok := s.versioning(ctr, vol); ok {
+			s.server.RegisterBidirectionalVolume(ctr.ID, vol.Target, volumeSource(vol), v)
+		} else {
+			s.server.RegisterVolume(ctr.ID, vol.Target, volumeSource(vol))
+		}
+	}
+	return nil
+}
+
+// StopReceiving unregisters the volumes of a container
+func (s *VolumeSync) StopReceiving(ctr *types.Container) {
+	s.server.UnregisterVolume(ctr.ID)
+}
+
+// Verify compares the rw volumes of a replica with those of its primary,
+// returning the files that differ by their path in the container
+func (s *VolumeSync) Verify(ctr *types.Container, primary api.ReplicaMember) ([]budgiesync.FileDiff, error) {
+	if primary.Address == "" {
+		return nil, fmt.Errorf("primary on %s has no address", primary.NodeID)
+	}
+	addr := net.JoinHostPort(primary.Address, strconv.Itoa(s.port))
+
+	var diffs []budgiesync.FileDiff
+	for _, vol := range ctr.Volumes {
+		if vol.Mode != "rw" {
+			continue
+		}
+
+		conn, err := s.client.Dial(addr, s.timeout)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+		}
+		want, err := budgiesync.RequestManifest(conn, s.nodeID, primary.ContainerID, vol.Target)
+		conn.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get manifest of %s: %w", vol.Target, err)
+		}
+
+		mgr, err := budgiesync.NewSyncManager(volumeSource(vol))
+		if err != nil {
+			return nil, err
+		}
+		got, err := mgr.Signatures()
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash %s: %w", vol.Target, err)
+		}
+
+		for _, d := range budgiesync.CompareSignatures(want, got) {
+			d.Path = path.Join(vol.Target, filepath.ToSlash(d.Path))
+			diffs = append(diffs, d)
+		}
+	}
+
+	return diffs, nil
+}
+
+// PrimaryOf returns the primary of a replica's set with the highest epoch,
+// as announced over mDNS
+func PrimaryOf(disc *discovery.DiscoveryService, timeout time.Duration, ctr *types.Container) (api.ReplicaMember, error) {
+	sets, err := replicaSets(disc, timeout)
+	if err != nil {
+		return api.ReplicaMember{}, err
+	}
+
+	var primary api.ReplicaMember
+	found := false
+	for _, m := range sets[ctr.ReplicaSetID()] {
+		if m.IsPrimary() && m.ContainerID != ctr.ID && (!found || m.Epoch > primary.Epoch) {
+			primary, found = m, true
+		}
+	}
+	if !found {
+		return primary, fmt.Errorf("no primary of %s found", ctr.Name)
+	}
+	return primary, nil
+}
+
+// VolumePath returns the source path of the volume a container mounts at
+// target. An empty target is the first rw volume, which replicas mirror.
+func VolumePath(ctr *types.Container, target string) (string, error) {
+	for _, vol := range ctr.Volumes {
+		if vol.Target == target || target == "" && vol.Mode == "rw" {
+			return volumeSource(vol), nil
+		}
+	}
+	return "", fmt.Errorf("container %s has no volume at %s", ctr.ShortID(), target)
+}
+
+func volumeSource(vol types.VolumeMapping) string {
+	if abs, err := filepath.Abs(vol.Source); err == nil {
+		return abs
+	}
+	return vol.Source
+}
@@ -0,0 +1,293 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zarigata/budgie/internal/api"
+	"github.com/zarigata/budgie/internal/events"
+	budgiesync "github.com/zarigata/budgie/internal/sync"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// syncTLS returns mutual TLS settings for a sync server on 127.0.0.1 and a
+// client, with certificates from a fresh cluster CA
+func syncTLS(t *testing.T) (server, client budgiesync.TLSConfig) {
+	t.Helper()
+	certDir := t.TempDir()
+	ca, err := budgiesync.LoadOrCreateCA(certDir)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	issue := func(host string) budgiesync.TLSConfig {
+		certFile := filepath.Join(certDir, host+".crt")
+		keyFile := filepath.Join(certDir, host+".key")
+		if err := ca.IssueCertificate([]string{host}, time.Hour, certFile, keyFile); err != nil {
+			t.Fatalf("Failed to issue certificate: %v", err)
+		}
+		return budgiesync.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile, CAFile: ca.CertFile}
+	}
+	return issue("127.0.0.1"), issue("node-b")
+}
+
+func TestVolumeSync_VerifyReportsDifferences(t *testing.T) {
+	primaryDir, replicaDir := t.TempDir(), t.TempDir()
+	write := func(dir, name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	write(primaryDir, "same", "hello")
+	write(replicaDir, "same", "hello")
+	write(primaryDir, "changed", "abc")
+	write(replicaDir, "changed", "abd")
+	write(primaryDir, "missing", "x")
+	write(replicaDir, "extra", "y")
+
+	// Manifests are only served over mutual TLS
+	serverTLS, clientTLS := syncTLS(t)
+	server, err := budgiesync.NewTLSServer(0, serverTLS)
+	if err != nil {
+		t.Fatalf("Failed to create sync server: %v", err)
+	}
+	server.SetVolumeLookup(func(containerID, target string) (string, error) {
+		if containerID != "primary" || target != "/data" {
+			return "", fmt.Errorf("no volume %s for %s", target, containerID)
+		}
+		return primaryDir, nil
+	})
+	go server.Start()
+	defer server.Stop()
+
+	client, err := budgiesync.NewTLSClient(clientTLS)
+	if err != nil {
+		t.Fatalf("Failed to create sync client: %v", err)
+	}
+	port := server.Addr().(*net.TCPAddr).Port
+
+	replica := &types.Container{
+		ID:      "replica",
+		Volumes: []types.VolumeMapping{{Source: replicaDir, Target: "/data", Mode: "rw"}},
+	}
+	primary := api.ReplicaMember{ContainerID: "primary", NodeID: "node-a", Address: "127.0.0.1"}
+
+	diffs, err := NewVolumeSync(nil, client, port).Verify(replica, primary)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	want := []budgiesync.FileDiff{
+		{Path: "/data/changed", Reason: "content"},
+		{Path: "/data/extra", Reason: "extra"},
+		{Path: "/data/missing", Reason: "missing"},
+	}
+	if len(diffs) != len(want) {
+		t.Fatalf("Expected %d diffs, got %+v", len(want), diffs)
+	}
+	for i := range want {
+		if diffs[i] != want[i] {
+			t.Errorf("Diff %d: expected %+v, got %+v", i, want[i], diffs[i])
+		}
+	}
+
+	// An unknown primary is reported by the server
+	primary.ContainerID = "gone"
+	if _, err := NewVolumeSync(nil, client, port).Verify(replica, primary); err == nil {
+		t.Error("Expected Verify to fail for an unknown primary")
+	}
+}
+
+func TestVolumeSync_ReplicatesChangesAndReportsStatus(t *testing.T) {
+	primaryDir, replicaDir := t.TempDir(), t.TempDir()
+
+	// Pushes are only accepted over mutual TLS
+	serverTLS, clientTLS := syncTLS(t)
+	server, err := budgiesync.NewTLSServer(0, serverTLS)
+	if err != nil {
+		t.Fatalf("Failed to create sync server: %v", err)
+	}
+	server.RegisterVolume("replica", "/data", replicaDir)
+	go server.Start()
+	defer server.Stop()
+
+	client, err := budgiesync.NewTLSClient(clientTLS)
+	if err != nil {
+		t.Fatalf("Failed to create sync client: %v", err)
+	}
+	volumes := NewVolumeSync(nil, client, server.Addr().(*net.TCPAddr).Port)
+	volumes.EnableReplication(20*time.Millisecond, 100*time.Millisecond)
+
+	primary := &types.Container{
+		ID:      "primary",
+		Name:    "db",
+		Volumes: []types.VolumeMapping{{Source: primaryDir, Target: "/data", Mode: "rw"}},
+	}
+	replica := api.ReplicaMember{ContainerID: "replica", NodeID: "node-b", Address: "127.0.0.1"}
+	if err := volumes.Push(primary, replica); err != nil {
+		t.Fatalf("Push failed: %v", err)
+	}
+
+	// A change after the push reaches the replica on its own
+	os.WriteFile(filepath.Join(primaryDir, "later"), []byte("x"), 0644)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, err := os.ReadFile(filepath.Join(replicaDir, "later")); err == nil && string(data) == "x" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the change to be replicated")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The status is served by the node API
+	node := NewServer(nil, t.TempDir(), nil)
+	node.SetReplicationStatus(volumes.Status)
+	ts := httptest.NewServer(node.Handler())
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	statuses, err := NewClient(u.Hostname(), port, nil).Replication(context.Background())
+	if err != nil {
+		t.Fatalf("Replication failed: %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].Name != "db" || statuses[0].Volume != "/data" || statuses[0].ReplicaNode != "node-b" {
+		t.Fatalf("Unexpected replication status: %+v", statuses)
+	}
+
+	volumes.Replicate(primary, nil)
+	if got := volumes.Status(); len(got) != 0 {
+		t.Errorf("Expected replication to stop, got %+v", got)
+	}
+}
+
+// eventLog records events in memory
+type eventLog struct {
+	mu     sync.Mutex
+	events []events.Event
+}
+
+func (l *eventLog) Record(e events.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+}
+
+func (l *eventLog) list() []events.Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]events.Event(nil), l.events...)
+}
+
+// syncNode is a node with a sync server and a volume sync pushing to peer
+type syncNode struct {
+	server  *budgiesync.Server
+	volumes *VolumeSync
+	events  *eventLog
+	tls     budgiesync.TLSConfig // Client certificate for pushing to peers
+}
+
+func newSyncNode(t *testing.T, serverTLS, clientTLS budgiesync.TLSConfig) *syncNode {
+	t.Helper()
+	server, err := budgiesync.NewTLSServer(0, serverTLS)
+	if err != nil {
+		t.Fatalf("Failed to create sync server: %v", err)
+	}
+	go server.Start()
+	t.Cleanup(func() { server.Stop() })
+	return &syncNode{server: server.Server, events: &eventLog{}, tls: clientTLS}
+}
+
+func (n *syncNode) connect(t *testing.T, id string, peer *syncNode) {
+	t.Helper()
+	client, err := budgiesync.NewTLSClient(n.tls)
+	if err != nil {
+		t.Fatalf("Failed to create sync client: %v", err)
+	}
+	n.volumes = NewVolumeSync(n.server, client, peer.server.Addr().(*net.TCPAddr).Port)
+	n.volumes.EnableReplication(200*time.Millisecond, time.Second)
+	n.volumes.EnableBidirectional(id, t.TempDir(), n.events)
+}
+
+func waitForFile(t *testing.T, path, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, err := os.ReadFile(path); err == nil && string(data) == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s to contain %q", path, want)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestVolumeSync_BidirectionalVolumes(t *testing.T) {
+	primaryDir, replicaDir := t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(primaryDir, "doc"), []byte("original"), 0644)
+
+	serverTLS, clientTLS := syncTLS(t)
+	a, b := newSyncNode(t, serverTLS, clientTLS), newSyncNode(t, serverTLS, clientTLS)
+	a.connect(t, "node-a", b)
+	b.connect(t, "node-b", a)
+
+	vol := types.VolumeMapping{Target: "/uploads", Mode: "rw", Sync: types.SyncBidirectional}
+	primary := &types.Container{ID: types.GenerateContainerID(), Name: "app", Volumes: []types.VolumeMapping{vol}}
+	replica := &types.Container{ID: types.GenerateContainerID(), Name: "app-replica", Role: types.RoleReplica, Volumes: []types.VolumeMapping{vol}}
+	primary.Volumes[0].Source = primaryDir
+	replica.Volumes[0].Source = replicaDir
+
+	if err := b.volumes.Receive(replica); err != nil {
+		t.Fatalf("Receive failed: %v", err)
+	}
+	if err := a.volumes.Push(primary, api.ReplicaMember{ContainerID: replica.ID, NodeID: "node-b", Address: "127.0.0.1"}); err != nil {
+		t.Fatalf("Push to replica failed: %v", err)
+	}
+	if err := b.volumes.Push(replica, api.ReplicaMember{ContainerID: primary.ID, NodeID: "node-a", Address: "127.0.0.1"}); err != nil {
+		t.Fatalf("Push to primary failed: %v", err)
+	}
+	waitForFile(t, filepath.Join(replicaDir, "doc"), "original")
+
+	// Uploads to the replica reach the primary
+	os.WriteFile(filepath.Join(replicaDir, "upload"), []byte("from replica"), 0644)
+	waitForFile(t, filepath.Join(primaryDir, "upload"), "from replica")
+
+	// Both change the same file before either change is pushed: the later
+	// one wins on both sides and the conflict is recorded
+	now := time.Now()
+	os.WriteFile(filepath.Join(primaryDir, "doc"), []byte("from primary"), 0644)
+	os.Chtimes(filepath.Join(primaryDir, "doc"), now, now)
+	os.WriteFile(filepath.Join(replicaDir, "doc"), []byte("from replica"), 0644)
+	os.Chtimes(filepath.Join(replicaDir, "doc"), now.Add(time.Second), now.Add(time.Second))
+
+	waitForFile(t, filepath.Join(primaryDir, "doc"), "from replica")
+	waitForFile(t, filepath.Join(replicaDir, "doc"), "from replica")
+
+	// Events are recorded once the push that resolved the conflict completes
+	var conflicts []events.Event
+	deadline := time.Now().Add(5 * time.Second)
+	for len(conflicts) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the conflict to be recorded")
+		}
+		time.Sleep(20 * time.Millisecond)
+		for _, e := range append(a.events.list(), b.events.list()...) {
+			if e.Kind == events.KindConflict {
+				conflicts = append(conflicts, e)
+			}
+		}
+	}
+	if c := conflicts[0]; c.Subject != "app" || c.Details["path"] != "/uploads/doc" || c.Details["winner"] != "node-b" {
+		t.Errorf("Unexpected conflict event: %+v", c)
+	}
+}
@@ -0,0 +1,113 @@
+// Package node exposes this machine to other budgie nodes: it reports local
+// capacity and accepts bundles submitted by remote schedulers.
+package node
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	goruntime "runtime"
+	"strconv"
+	"strings"
+
+	"github.com/zarigata/budgie/internal/api"
+	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/internal/scheduler"
+)
+
+// LocalID returns this node's ID, which is its hostname
+func LocalID() string {
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "unknown"
+	}
+	return hostname
+}
+
+// Labels returns this node's labels: the built-in arch and os labels,
+// overridden by the labels configured under node.labels
+func Labels(configured map[string]string) map[string]string {
+	labels := map[string]string{
+		"arch": goruntime.GOARCH,
+		"os":   goruntime.GOOS,
+	}
+	for k, v := range configured {
+		labels[k] = v
+	}
+	return labels
+}
+
+// LocalInfo reports the capacity of this node. Memory and CPU requested by
+// running containers count as used even when they sit idle.
+func LocalInfo(manager *api.ContainerManager, apiPort int, labels map[string]string) (discovery.NodeInfo, error) {
+	info := discovery.NodeInfo{
+		ID:      LocalID(),
+		APIPort: apiPort,
+		CPUs:    goruntime.NumCPU(),
+		Labels:  labels,
+	}
+
+	total, available, err := readMemInfo("/proc/meminfo")
+	if err != nil {
+		return info, err
+	}
+	info.MemoryTotal = total
+
+	var reserved int64
+	for _, ctr := range manager.List() {
+		if !ctr.IsRunning() {
+			continue
+		}
+		info.Containers++
+		info.CPUReserved += scheduler.CPURequest(ctr.Resources)
+		reserved += scheduler.MemoryRequest(ctr.Resources)
+	}
+
+	info.MemoryAvailable = total - reserved
+	if available < info.MemoryAvailable {
+		info.MemoryAvailable = available
+	}
+	if info.MemoryAvailable < 0 {
+		info.MemoryAvailable = 0
+	}
+
+	return info, nil
+}
+
+// readMemInfo returns total and available memory in bytes
+func readMemInfo(path string) (int64, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read memory info: %w", err)
+	}
+	defer f.Close()
+
+	values := make(map[string]int64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Format: "MemTotal:       16318480 kB"
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		n, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		values[strings.TrimSuffix(fields[0], ":")] = n * 1024
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	total, ok := values["MemTotal"]
+	if !ok {
+		return 0, 0, fmt.Errorf("MemTotal not found in %s", path)
+	}
+	available, ok := values["MemAvailable"]
+	if !ok {
+		available = values["MemFree"]
+	}
+
+	return total, available, nil
+}
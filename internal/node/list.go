@@ -0,0 +1,56 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// NodeContainers is the listing of one node in a cluster-wide listing
+type NodeContainers struct {
+	Node       string             `json:"node"`
+	Containers []ContainerSummary `json:"containers"`
+	Error      string             `json:"error,omitempty"` // Set when the node could not be listed
+}
+
+// ListAll lists the containers of every node at once, keyed by node ID,
+// giving each node up to timeout to answer. A node that fails or times out
+// is reported with its error instead of failing the whole listing. Results
+// are sorted by node ID.
+func ListAll(ctx context.Context, clients map[string]*Client, timeout time.Duration) []NodeContainers {
+	results := make([]NodeContainers, 0, len(clients))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for id, client := range clients {
+		wg.Add(1)
+		go func(id string, client *Client) {
+			defer wg.Done()
+
+			nodeCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			result := NodeContainers{Node: id}
+			containers, err := client.List(nodeCtx)
+			switch {
+			case errors.Is(nodeCtx.Err(), context.DeadlineExceeded):
+				result.Error = fmt.Sprintf("timed out after %s", timeout)
+			case err != nil:
+				result.Error = err.Error()
+			default:
+				result.Containers = containers
+			}
+
+			mu.Lock()
+			results = append(results, result)
+			mu.Unlock()
+		}(id, client)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Node < results[j].Node })
+	return results
+}
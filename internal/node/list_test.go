@@ -0,0 +1,91 @@
+package node
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zarigata/budgie/internal/api"
+	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// clientFor returns a plain HTTP client for a test server
+func clientFor(t *testing.T, ts *httptest.Server) *Client {
+	t.Helper()
+	u, _ := url.Parse(ts.URL)
+	port, _ := strconv.Atoi(u.Port())
+	return NewClient(u.Hostname(), port, nil)
+}
+
+func TestListAll_ReportsPartialResults(t *testing.T) {
+	healthy, manager := newTLSTestClient(t, nil, "bob")
+	web := startLocal(t, manager, &types.Container{Name: "web"})
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(slow.Close)
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	downClient := clientFor(t, down)
+	down.Close()
+
+	clients := map[string]*Client{
+		"node-a": healthy,
+		"node-b": clientFor(t, slow),
+		"node-c": downClient,
+	}
+
+	start := time.Now()
+	results := ListAll(context.Background(), clients, 200*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the slow node to be cut off, took %s", elapsed)
+	}
+
+	if len(results) != 3 || results[0].Node != "node-a" || results[1].Node != "node-b" || results[2].Node != "node-c" {
+		t.Fatalf("Expected results for every node in order, got %+v", results)
+	}
+	if results[0].Error != "" || len(results[0].Containers) != 1 || results[0].Containers[0].ID != web.ID {
+		t.Errorf("Expected node-a to list web, got %+v", results[0])
+	}
+	if !strings.Contains(results[1].Error, "timed out") {
+		t.Errorf("Expected node-b to time out, got %q", results[1].Error)
+	}
+	if results[2].Error == "" || results[2].Containers != nil {
+		t.Errorf("Expected node-c to fail, got %+v", results[2])
+	}
+}
+
+func TestServer_SummaryIncludesHealth(t *testing.T) {
+	dataDir := t.TempDir()
+	manager, err := api.NewContainerManager(stubRuntime{}, dataDir)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	info := func() (discovery.NodeInfo, error) { return discovery.NodeInfo{}, nil }
+	server := NewServer(manager, dataDir, info)
+
+	checked := startLocal(t, manager, &types.Container{Name: "api", Health: &types.HealthCheck{Path: "/health"}})
+	unchecked := startLocal(t, manager, &types.Container{Name: "worker"})
+
+	if got := server.summarize(checked).Health; got != "" {
+		t.Errorf("Expected no health without a monitor, got %q", got)
+	}
+
+	server.SetHealthMonitor(api.NewHealthCheckMonitor(manager, nil))
+	if got := server.summarize(checked).Health; got != string(api.HealthStatusStarting) {
+		t.Errorf("Expected a checked container to be starting, got %q", got)
+	}
+	if got := server.summarize(unchecked).Health; got != "" {
+		t.Errorf("Expected no health for a container without a check, got %q", got)
+	}
+}
@@ -0,0 +1,72 @@
+package node
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/internal/scheduler"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// Placer schedules instances of services onto the nodes found through
+// discovery and starts them on peers through their node API
+type Placer struct {
+	disc      *discovery.DiscoveryService
+	nodeID    string
+	timeout   time.Duration
+	tlsConfig *tls.Config
+}
+
+// NewPlacer creates a placer for the node nodeID
+func NewPlacer(disc *discovery.DiscoveryService, nodeID string, timeout time.Duration, tlsConfig *tls.Config) *Placer {
+	return &Placer{disc: disc, nodeID: nodeID, timeout: timeout, tlsConfig: tlsConfig}
+}
+
+// Place starts an instance of a service on the node the scheduler picks, see
+// api.ReplicaPlacer. It returns false when the scheduler picks this node.
+func (p *Placer) Place(ctx context.Context, template *types.Container) (bool, error) {
+	nodes, err := p.disc.DiscoverNodes(p.timeout)
+	if err != nil {
+		return false, fmt.Errorf("node discovery failed: %w", err)
+	}
+
+	// Services already running on each node, for affinity and spreading
+	running := scheduler.Services{}
+	containers, err := p.disc.DiscoverContainers(p.timeout)
+	if err != nil {
+		return false, fmt.Errorf("discovery failed: %w", err)
+	}
+	seen := make(map[string]bool)
+	for _, c := range containers {
+		if !seen[c.ID] {
+			seen[c.ID] = true
+			running.Add(c.NodeID, c.Name)
+		}
+	}
+
+	chosen, err := scheduler.Schedule(nodes, scheduler.RequestFor(template, nil), running)
+	if err != nil {
+		return false, err
+	}
+	return p.placeOn(ctx, chosen.Node, template)
+}
+
+// placeOn starts an instance on node, unless it is this node
+func (p *Placer) placeOn(ctx context.Context, node discovery.NodeInfo, template *types.Container) (bool, error) {
+	if node.ID == p.nodeID {
+		return false, nil
+	}
+
+	client := NewClient(node.Address, node.APIPort, p.tlsConfig)
+	if _, err := client.RunInstance(ctx, template); err != nil {
+		return false, fmt.Errorf("failed to run on node %s: %w", node.ID, err)
+	}
+
+	logrus.Infof("Placed an instance of %s on node %s", template.Name, node.ID)
+	return true, nil
+}
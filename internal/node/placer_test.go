@@ -0,0 +1,33 @@
+package node
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+func TestPlacer_StartsInstancesOnPeers(t *testing.T) {
+	client, manager, _ := newTestClient(t)
+	u, _ := url.Parse(client.baseURL)
+	port, _ := strconv.Atoi(u.Port())
+	peer := discovery.NodeInfo{ID: "node-b", Address: u.Hostname(), APIPort: port}
+
+	template := &types.Container{Name: "web", Image: types.ImageConfig{DockerImage: "nginx:alpine"}}
+
+	p := NewPlacer(nil, "node-a", 0, nil)
+	if placed, err := p.placeOn(context.Background(), discovery.NodeInfo{ID: "node-a"}, template); placed || err != nil {
+		t.Fatalf("Expected this node to be left to the caller, got placed=%t err=%v", placed, err)
+	}
+
+	placed, err := p.placeOn(context.Background(), peer, template)
+	if err != nil || !placed {
+		t.Fatalf("Expected the instance to be placed on the peer, got placed=%t err=%v", placed, err)
+	}
+	if n := len(manager.List()); n != 1 {
+		t.Errorf("Expected 1 instance on the peer, got %d", n)
+	}
+}
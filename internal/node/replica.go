@@ -0,0 +1,93 @@
+package node
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// replicaVolumeTarget is where a replica mounts the data synced from its primary
+const replicaVolumeTarget = "/data"
+
+// ReplicaSpec describes the primary a replica is created for
+type ReplicaSpec struct {
+	PrimaryID    string                 `json:"primary_id"`
+	PrimaryNode  string                 `json:"primary_node"`
+	Name         string                 `json:"name"`
+	Image        types.ImageConfig      `json:"image"`
+	Ports        []types.PortMapping    `json:"ports,omitempty"`
+	Env          []string               `json:"env,omitempty"`
+	VolumeTarget string                 `json:"volume_target,omitempty"` // Defaults to /data
+	Resources    *types.ResourceLimits  `json:"resources,omitempty"`
+	Placement    *types.PlacementConfig `json:"placement,omitempty"`
+	ReplicaSet   string                 `json:"replica_set"`
+	Epoch        uint64                 `json:"epoch"`
+}
+
+// SpecFor describes a local primary container so that a peer can replicate it
+func SpecFor(ctr *types.Container, nodeID string) ReplicaSpec {
+	spec := ReplicaSpec{
+		PrimaryID:   ctr.ID,
+		PrimaryNode: nodeID,
+		Name:        ctr.ServiceName(),
+		Image:       ctr.Image,
+		Ports:       ctr.Ports,
+		Env:         ctr.Env,
+		Resources:   ctr.Resources,
+		Placement:   ctr.Placement,
+		ReplicaSet:  ctr.ReplicaSetID(),
+		Epoch:       ctr.Epoch,
+	}
+	for _, vol := range ctr.Volumes {
+		if vol.Mode == "rw" {
+			spec.VolumeTarget = vol.Target
+			break
+		}
+	}
+	return spec
+}
+
+// NewReplica builds the replica container for a spec, creating its volume
+// directory under dataDir
+func NewReplica(spec ReplicaSpec, dataDir, nodeID string) (*types.Container, error) {
+	if len(spec.PrimaryID) < 12 {
+		return nil, fmt.Errorf("invalid primary container ID: %q", spec.PrimaryID)
+	}
+
+	volumePath := filepath.Join(dataDir, "volumes", spec.PrimaryID[:12])
+	if err := os.MkdirAll(volumePath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create volume directory: %w", err)
+	}
+
+	target := spec.VolumeTarget
+	if target == "" {
+		target = replicaVolumeTarget
+	}
+
+	return &types.Container{
+		ID:        types.GenerateContainerID(),
+		Name:      fmt.Sprintf("%s-replica", spec.Name),
+		State:     types.StateCreating,
+		Image:     spec.Image,
+		Ports:     spec.Ports,
+		Env:       spec.Env,
+		Resources: spec.Resources,
+		Volumes: []types.VolumeMapping{
+			{
+				Source: volumePath,
+				Target: target,
+				Mode:   "rw",
+			},
+		},
+		NodeID:     nodeID,
+		Peers:      []string{spec.PrimaryNode},
+		Role:       types.RoleReplica,
+		ReplicaSet: spec.ReplicaSet,
+		Epoch:      spec.Epoch,
+		Placement:  spec.Placement,
+		CreatedAt:  time.Now(),
+	}, nil
+}
@@ -0,0 +1,172 @@
+package node
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/zarigata/budgie/internal/api"
+	budgiesync "github.com/zarigata/budgie/internal/sync"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// ReplicationStatus is how far a replica is behind one volume of a local
+// primary, or a primary behind a local replica's bidirectional volume
+type ReplicationStatus struct {
+	ContainerID string `json:"container_id"`
+	Name        string `json:"name"`
+	Volume      string `json:"volume"`
+	ReplicaNode string `json:"replica_node"` // Node pushed to
+	budgiesync.ReplicationStatus
+}
+
+// replication is the continuous replication of a local container's volumes
+type replication struct {
+	name      string
+	volumes   map[string]*budgiesync.Replicator // By target
+	nodes     map[string]string                 // Container pushed to -> node ID
+	receiving bool                              // A primary registered to receive its bidirectional volumes
+}
+
+// EnableReplication makes Push keep replicas up to date: each pushed volume
+// is watched and its changes are sent to the replica in batches, waiting
+// for debounce without changes or at most maxDelay
+func (s *VolumeSync) EnableReplication(debounce, maxDelay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replicate = true
+	s.debounce = debounce
+	s.maxDelay = maxDelay
+	s.primaries = make(map[string]*replication)
+}
+
+// addReplica sends the volumes a container pushes to another member of its
+// set and adds the member to their replicators. A primary also receives the
+// changes replicas make to its bidirectional volumes.
+func (s *VolumeSync) addReplica(ctr *types.Container, to api.ReplicaMember, addr string) error {
+	s.mu.Lock()
+	rep, ok := s.primaries[ctr.ID]
+	if !ok {
+		rep = &replication{
+			name:    ctr.Name,
+			volumes: make(map[string]*budgiesync.Replicator),
+			nodes:   make(map[string]string),
+		}
+		s.primaries[ctr.ID] = rep
+	}
+	for _, vol := range ctr.Volumes {
+		if !s.pushes(ctr, vol) || rep.volumes[vol.Target] != nil {
+			continue
+		}
+		r, err := budgiesync.NewReplicator(volumeSource(vol), vol.Target, s.debounce, s.maxDelay, s.dial)
+		if err != nil {
+			s.mu.Unlock()
+			return fmt.Errorf("failed to replicate volume %s: %w", vol.Target, err)
+		}
+		r.SetLimiter(s.limiter)
+		r.SetNodeID(s.nodeID)
+		if v, ok := s.versioning(ctr, vol); ok {
+			if err := r.EnableVersioning(v); err != nil {
+				r.Close()
+				s.mu.Unlock()
+				return fmt.Errorf("failed to version volume %s: %w", vol.Target, err)
+			}
+			if !ctr.IsReplica() && s.server != nil {
+				s.server.RegisterBidirectionalVolume(ctr.ID, vol.Target, volumeSource(vol), v)
+				rep.receiving = true
+			}
+		}
+		rep.volumes[vol.Target] = r
+	}
+	rep.nodes[to.ContainerID] = to.NodeID
+	volumes := make(map[string]*budgiesync.Replicator, len(rep.volumes))
+	for target, r := range rep.volumes {
+		volumes[target] = r
+	}
+	s.mu.Unlock()
+
+	for target, r := range volumes {
+		if err := r.AddPeer(to.ContainerID, addr); err != nil {
+			return fmt.Errorf("failed to sync volume %s: %w", target, err)
+		}
+	}
+	return nil
+}
+
+func (s *VolumeSync) dial(addr string) (net.Conn, error) {
+	return s.client.Dial(addr, s.timeout)
+}
+
+// Replicate keeps pushing the changes of a container to the given members
+// of its set and stops pushing to the others. Members are added by Push; an
+// empty list stops replication of the container.
+func (s *VolumeSync) Replicate(ctr *types.Container, replicas []api.ReplicaMember) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rep, ok := s.primaries[ctr.ID]
+	if !ok {
+		return
+	}
+	if len(replicas) == 0 {
+		for _, r := range rep.volumes {
+			r.Close()
+		}
+		if rep.receiving {
+			s.server.UnregisterVolume(ctr.ID)
+		}
+		delete(s.primaries, ctr.ID)
+		logrus.Debugf("Stopped replicating %s", ctr.ShortID())
+		return
+	}
+
+	keep := make(map[string]bool, len(replicas))
+	for _, m := range replicas {
+		keep[m.ContainerID] = true
+	}
+	for id := range rep.nodes {
+		if keep[id] {
+			continue
+		}
+		for _, r := range rep.volumes {
+			r.RemovePeer(id)
+		}
+		delete(rep.nodes, id)
+	}
+}
+
+// Status returns the replication status of every member pushed to, of every
+// replicated volume of the local containers
+func (s *VolumeSync) Status() []ReplicationStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var statuses []ReplicationStatus
+	for id, rep := range s.primaries {
+		for target, r := range rep.volumes {
+			for _, st := range r.Status() {
+				statuses = append(statuses, ReplicationStatus{
+					ContainerID:       id,
+					Name:              rep.name,
+					Volume:            target,
+					ReplicaNode:       rep.nodes[st.Peer],
+					ReplicationStatus: st,
+				})
+			}
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		a, b := statuses[i], statuses[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Volume != b.Volume {
+			return a.Volume < b.Volume
+		}
+		return a.ReplicaNode < b.ReplicaNode
+	})
+	return statuses
+}
@@ -0,0 +1,752 @@
+package node
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/zarigata/budgie/internal/api"
+	"github.com/zarigata/budgie/internal/bundle"
+	"github.com/zarigata/budgie/internal/discovery"
+	budgieruntime "github.com/zarigata/budgie/internal/runtime"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// maxBundleSize bounds the size of a submitted bundle
+const maxBundleSize = 1 << 20
+
+// RunRequest submits a bundle to be run on a node, or an instance of a
+// service a peer places there
+type RunRequest struct {
+	Filename string           `json:"filename"`           // Original file name, used to name the stored copy
+	Bundle   []byte           `json:"bundle"`             // Bundle YAML
+	Instance *types.Container `json:"instance,omitempty"` // Configuration of a service instance, instead of a bundle
+}
+
+// RunResponse reports the container a node started for a RunRequest
+type RunResponse struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	NodeID string `json:"node_id"`
+}
+
+// PromoteRequest hands the primary role of a replica set to a local replica
+type PromoteRequest struct {
+	ContainerID string   `json:"container_id"`
+	Epoch       uint64   `json:"epoch"` // Must be higher than the replica's current epoch
+	Peers       []string `json:"peers"` // Nodes the new primary replicates to
+}
+
+// BackendRequest adds a replica of a service to the node's load balancer, or
+// drains it
+type BackendRequest struct {
+	Service string             `json:"service"`
+	Address string             `json:"address"`
+	Port    int                `json:"port"`
+	Health  *types.HealthCheck `json:"health,omitempty"`  // How the replica's bundle asks to be health checked
+	Timeout time.Duration      `json:"timeout,omitempty"` // How long a drain waits for in-flight requests
+}
+
+// DrainRequest asks a node to drain itself
+type DrainRequest struct {
+	Force bool `json:"force,omitempty"` // Stop containers that could not be moved
+}
+
+// DrainResponse reports what a cordon, drain or uncordon did on a node. A
+// drain that stopped partway reports the steps it took and why it stopped.
+type DrainResponse struct {
+	NodeID string      `json:"node_id"`
+	Steps  []DrainStep `json:"steps,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// ContainerSummary is a container as listed by the node API
+type ContainerSummary struct {
+	types.Container
+	Health string `json:"health,omitempty"` // Empty without a health check or monitor
+}
+
+// ExecRequest runs a command in a container without a terminal
+type ExecRequest struct {
+	Cmd     []string `json:"cmd"`
+	User    string   `json:"user,omitempty"`
+	WorkDir string   `json:"workdir,omitempty"`
+	Env     []string `json:"env,omitempty"`
+}
+
+// exitCodeTrailer carries the exit code of an exec after its output
+const exitCodeTrailer = "X-Budgie-Exit-Code"
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server is the node API that remote schedulers submit bundles to
+type Server struct {
+	manager    *api.ContainerManager
+	dataDir    string
+	bundleDir  string
+	info       func() (discovery.NodeInfo, error)
+	volumes    api.VolumeSync
+	status     func() []ReplicationStatus
+	auth       *Authorizer
+	health     *api.HealthCheckMonitor
+	backends   api.BackendRegistry
+	peers      Peers
+	httpServer *http.Server
+	listener   net.Listener
+}
+
+// NewServer creates a node API server. Submitted bundles are stored under
+// dataDir; info reports the node's current capacity.
+func NewServer(manager *api.ContainerManager, dataDir string, info func() (discovery.NodeInfo, error)) *Server {
+	s := &Server{
+		manager:   manager,
+		dataDir:   dataDir,
+		bundleDir: filepath.Join(dataDir, "bundles"),
+		info:      info,
+		auth:      &Authorizer{roles: make(map[string]Role)},
+	}
+	s.httpServer = &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Handler returns the HTTP handler serving the node API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/info", s.handleInfo)
+	mux.HandleFunc("/v1/containers", s.handleContainers)
+	mux.HandleFunc("/v1/containers/", s.handleContainer)
+	mux.HandleFunc("/v1/replicas", s.handleReplica)
+	mux.HandleFunc("/v1/promote", s.handlePromote)
+	mux.HandleFunc("/v1/replication", s.handleReplication)
+	mux.HandleFunc("/v1/backends", s.handleBackends)
+	mux.HandleFunc("/v1/backends/drain", s.handleBackends)
+	mux.HandleFunc("/v1/node/", s.handleNode)
+	return mux
+}
+
+// SetVolumeSync lets replicas created through the API receive their
+// primary's data
+func (s *Server) SetVolumeSync(volumes api.VolumeSync) {
+	s.volumes = volumes
+}
+
+// SetReplicationStatus reports the replication of local primaries' volumes
+func (s *Server) SetReplicationStatus(status func() []ReplicationStatus) {
+	s.status = status
+}
+
+// SetHealthMonitor lets listings report the health of containers
+func (s *Server) SetHealthMonitor(monitor *api.HealthCheckMonitor) {
+	s.health = monitor
+}
+
+// SetBackends lets budgie update register the replicas it starts with the
+// node's load balancer
+func (s *Server) SetBackends(backends api.BackendRegistry) {
+	s.backends = backends
+}
+
+// SetPeers lets budgie node drain --node move replicated containers off
+// this node
+func (s *Server) SetPeers(peers Peers) {
+	s.peers = peers
+}
+
+// SetAuthorizer sets the roles client certificates are given
+func (s *Server) SetAuthorizer(auth *Authorizer) {
+	s.auth = auth
+}
+
+// Listen starts serving on addr, using TLS when tlsConfig is not nil
+func (s *Server) Listen(addr string, tlsConfig *tls.Config) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	s.listener = listener
+
+	go func() {
+		if err := s.httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.Errorf("Node API server failed: %v", err)
+		}
+	}()
+
+	logrus.Infof("Node API listening on %s", listener.Addr())
+	return nil
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Shutdown stops the server, waiting for in-flight requests
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+	if !s.authorize(w, r, RoleViewer, false) {
+		return
+	}
+
+	info, err := s.info()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+func (s *Server) handleReplication(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+	if !s.authorize(w, r, RoleViewer, false) {
+		return
+	}
+
+	statuses := []ReplicationStatus{}
+	if s.status != nil {
+		statuses = append(statuses, s.status()...)
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// handleBackends adds a backend on /v1/backends and drains one on
+// /v1/backends/drain. A drain is accepted at once and its response ends once
+// the backend has been removed, for clients that wait for it.
+func (s *Server) handleBackends(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+	if !s.authorize(w, r, RoleOperator, false) {
+		return
+	}
+	if s.backends == nil {
+		writeError(w, http.StatusNotImplemented, fmt.Errorf("node %s runs no load balancer", LocalID()))
+		return
+	}
+
+	var req BackendRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBundleSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if req.Service == "" || req.Address == "" || req.Port <= 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("service, address and port are required"))
+		return
+	}
+
+	if r.URL.Path == "/v1/backends" {
+		if err := s.backends.AddServiceBackend(req.Service, req.Address, req.Port, req.Health); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, req)
+		return
+	}
+
+	removed, err := s.backends.StartDrain(req.Service, req.Address, req.Port, req.Timeout)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, req)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	select {
+	case <-removed:
+	case <-r.Context().Done():
+	}
+}
+
+// handleNode cordons, drains or uncordons this node on /v1/node/{action}
+func (s *Server) handleNode(w http.ResponseWriter, r *http.Request) {
+	action := strings.TrimPrefix(r.URL.Path, "/v1/node/")
+	switch action {
+	case "cordon", "drain", "uncordon":
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown action %q", action))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+	if !s.authorize(w, r, RoleOperator, true) {
+		return
+	}
+
+	resp := DrainResponse{NodeID: LocalID()}
+	if action == "cordon" {
+		if _, err := Cordon(s.dataDir); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		logrus.Infof("Node %s cordoned", resp.NodeID)
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	var req DrainRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBundleSize)).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if action == "drain" && s.peers == nil {
+		writeError(w, http.StatusNotImplemented, fmt.Errorf("node %s cannot reach peers to move containers to", resp.NodeID))
+		return
+	}
+
+	manager, err := s.local()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	drainer := NewDrainer(manager, s.dataDir, resp.NodeID, s.peers, s.volumes)
+	if action == "drain" {
+		resp.Steps, err = drainer.Drain(r.Context(), req.Force)
+	} else {
+		resp.Steps, err = drainer.Uncordon(r.Context())
+	}
+	if err != nil {
+		logrus.Warnf("Failed to %s node %s: %v", action, resp.NodeID, err)
+		resp.Error = err.Error()
+	} else {
+		logrus.Infof("Node %s %sed", resp.NodeID, action)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleContainers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleList(w, r)
+	case http.MethodPost:
+		s.handleRun(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+	}
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, RoleOperator, false) {
+		return
+	}
+	if IsCordoned(s.dataDir) {
+		writeError(w, http.StatusConflict, fmt.Errorf("node %s is cordoned", LocalID()))
+		return
+	}
+
+	var req RunRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 2*maxBundleSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if req.Instance != nil {
+		if req.Instance.Name == "" {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("instance has no name"))
+			return
+		}
+	} else if len(req.Bundle) == 0 || len(req.Bundle) > maxBundleSize {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bundle must be between 1 and %d bytes", maxBundleSize))
+		return
+	}
+
+	resp, err := s.run(r.Context(), req)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// run stores a submitted bundle and starts a container from it
+func (s *Server) run(ctx context.Context, req RunRequest) (*RunResponse, error) {
+	if req.Instance != nil {
+		return s.runInstance(ctx, req.Instance)
+	}
+
+	if err := os.MkdirAll(s.bundleDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+
+	name := filepath.Base(req.Filename)
+	if name == "." || name == string(filepath.Separator) {
+		name = "bundle.bun"
+	}
+	path := filepath.Join(s.bundleDir, fmt.Sprintf("%d-%s", time.Now().UnixNano(), name))
+	if err := os.WriteFile(path, req.Bundle, 0600); err != nil {
+		return nil, fmt.Errorf("failed to store bundle: %w", err)
+	}
+
+	bun, err := bundle.Parse(path)
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to parse bundle: %w", err)
+	}
+
+	ctr := bun.ToContainer(path)
+	if err := s.manager.Create(ctx, ctr); err != nil {
+		return nil, err
+	}
+	if err := s.manager.Start(ctx, ctr.ID); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Started submitted container %s (%s)", ctr.ShortID(), ctr.Name)
+	return &RunResponse{ID: ctr.ID, Name: ctr.Name, NodeID: ctr.NodeID}, nil
+}
+
+// runInstance starts a fresh instance of a service from the configuration of
+// an instance on a peer
+func (s *Server) runInstance(ctx context.Context, instance *types.Container) (*RunResponse, error) {
+	ctr := &types.Container{
+		ID:            types.GenerateContainerID(),
+		Name:          instance.Name,
+		State:         types.StateCreating,
+		Image:         instance.Image,
+		Ports:         instance.Ports,
+		Volumes:       instance.Volumes,
+		Env:           instance.Env,
+		Health:        instance.Health,
+		Replicas:      instance.Replicas,
+		Update:        instance.Update,
+		Placement:     instance.Placement,
+		Resources:     instance.Resources,
+		RestartPolicy: instance.RestartPolicy,
+		DependsOn:     instance.DependsOn,
+		Network:       instance.Network,
+		NodeID:        LocalID(),
+		CreatedAt:     time.Now(),
+	}
+	if err := s.manager.Create(ctx, ctr); err != nil {
+		return nil, err
+	}
+	if err := s.manager.Start(ctx, ctr.ID); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Started placed instance %s of %s", ctr.ShortID(), ctr.Name)
+	return &RunResponse{ID: ctr.ID, Name: ctr.Name, NodeID: ctr.NodeID}, nil
+}
+
+func (s *Server) handleReplica(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+	if !s.authorize(w, r, RoleOperator, false) {
+		return
+	}
+	if IsCordoned(s.dataDir) {
+		writeError(w, http.StatusConflict, fmt.Errorf("node %s is cordoned", LocalID()))
+		return
+	}
+
+	var spec ReplicaSpec
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBundleSize)).Decode(&spec); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	resp, err := s.replicate(r.Context(), spec)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// replicate creates and starts a replica that receives its primary's data
+func (s *Server) replicate(ctx context.Context, spec ReplicaSpec) (*RunResponse, error) {
+	ctr, err := NewReplica(spec, s.dataDir, LocalID())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.manager.Create(ctx, ctr); err != nil {
+		return nil, err
+	}
+	if s.volumes != nil {
+		if err := s.volumes.Receive(ctr); err != nil {
+			return nil, fmt.Errorf("failed to receive data: %w", err)
+		}
+	}
+	if err := s.manager.Start(ctx, ctr.ID); err != nil {
+		return nil, err
+	}
+
+	logrus.Infof("Started replica %s of %s", ctr.ShortID(), spec.Name)
+	return &RunResponse{ID: ctr.ID, Name: ctr.Name, NodeID: ctr.NodeID}, nil
+}
+
+func (s *Server) handlePromote(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+	if !s.authorize(w, r, RoleOperator, false) {
+		return
+	}
+
+	var req PromoteRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBundleSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	ctr, err := s.manager.Get(req.ContainerID)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if !ctr.IsReplica() || req.Epoch <= ctr.Epoch {
+		writeError(w, http.StatusConflict, fmt.Errorf("container %s is not a replica behind epoch %d", ctr.ShortID(), req.Epoch))
+		return
+	}
+
+	if err := s.manager.SetReplication(ctr.ID, types.RolePrimary, req.Epoch, req.Peers); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if s.volumes != nil {
+		s.volumes.StopReceiving(ctr)
+	}
+
+	logrus.Infof("Container %s promoted to primary at epoch %d", ctr.ShortID(), req.Epoch)
+	writeJSON(w, http.StatusOK, RunResponse{ID: ctr.ID, Name: ctr.Name, NodeID: ctr.NodeID})
+}
+
+// local opens the container state afresh, so that containers started by
+// local CLI commands since the server started are included
+func (s *Server) local() (*api.ContainerManager, error) {
+	return api.NewContainerManager(s.manager.Runtime(), s.dataDir)
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, RoleViewer, true) {
+		return
+	}
+
+	manager, err := s.local()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	summaries := []ContainerSummary{}
+	for _, ctr := range manager.List() {
+		summaries = append(summaries, s.summarize(ctr))
+	}
+	writeJSON(w, http.StatusOK, summaries)
+}
+
+// summarize adds the health of running containers to a listing entry
+func (s *Server) summarize(ctr *types.Container) ContainerSummary {
+	summary := ContainerSummary{Container: *ctr}
+	if s.health != nil && ctr.IsRunning() {
+		if status := s.health.Status(ctr); status != api.HealthStatusNone {
+			summary.Health = string(status)
+		}
+	}
+	return summary
+}
+
+// handleContainer serves /v1/containers/{id} and its stop, logs and exec
+// actions
+func (s *Server) handleContainer(w http.ResponseWriter, r *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/containers/"), "/")
+
+	var method string
+	want := RoleViewer
+	switch action {
+	case "", "logs":
+		method = http.MethodGet
+	case "stop", "exec":
+		method = http.MethodPost
+		want = RoleOperator
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown action %q", action))
+		return
+	}
+	if r.Method != method {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method not allowed"))
+		return
+	}
+	if !s.authorize(w, r, want, true) {
+		return
+	}
+
+	manager, err := s.local()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	ctr, err := manager.Find(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	switch action {
+	case "":
+		writeJSON(w, http.StatusOK, ctr)
+	case "stop":
+		s.handleStop(w, r, manager, ctr)
+	case "logs":
+		s.handleLogs(w, r, ctr)
+	case "exec":
+		s.handleExec(w, r, ctr)
+	}
+}
+
+func (s *Server) handleStop(w http.ResponseWriter, r *http.Request, manager *api.ContainerManager, ctr *types.Container) {
+	if !ctr.IsRunning() {
+		writeError(w, http.StatusConflict, fmt.Errorf("container is not running: %s (current state: %s)", ctr.ShortID(), ctr.State))
+		return
+	}
+
+	timeout := 10 * time.Second
+	if v := r.URL.Query().Get("timeout"); v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil || seconds < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout %q", v))
+			return
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	if err := manager.Stop(r.Context(), ctr.ID, timeout); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	logrus.Infof("Stopped container %s on remote request", ctr.ShortID())
+	writeJSON(w, http.StatusOK, ctr)
+}
+
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request, ctr *types.Container) {
+	query := r.URL.Query()
+	follow := query.Get("follow") == "true"
+	tail, _ := strconv.Atoi(query.Get("tail"))
+
+	reader, err := s.manager.Runtime().Logs(r.Context(), ctr.ID, follow, tail)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to get logs: %w", err))
+		return
+	}
+	defer reader.Close()
+
+	// Unblock a following read once the client goes away
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-r.Context().Done():
+			reader.Close()
+		case <-done:
+		}
+	}()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(newFlushWriter(w), reader)
+}
+
+func (s *Server) handleExec(w http.ResponseWriter, r *http.Request, ctr *types.Container) {
+	var req ExecRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxBundleSize)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if len(req.Cmd) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no command given"))
+		return
+	}
+	if !ctr.IsRunning() {
+		writeError(w, http.StatusConflict, fmt.Errorf("container %s is not running", ctr.ShortID()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Trailer", exitCodeTrailer)
+	w.WriteHeader(http.StatusOK)
+
+	out := newFlushWriter(w)
+	exitCode, err := s.manager.Runtime().ExecWithOptions(r.Context(), ctr.ID, budgieruntime.ExecOptions{
+		Cmd:     req.Cmd,
+		User:    req.User,
+		WorkDir: req.WorkDir,
+		Env:     req.Env,
+		Stdout:  out,
+		Stderr:  out,
+	})
+	if err != nil {
+		fmt.Fprintf(out, "exec failed: %v\n", err)
+		exitCode = -1
+	}
+
+	logrus.Infof("Ran %q in container %s on remote request", strings.Join(req.Cmd, " "), ctr.ShortID())
+	w.Header().Set(exitCodeTrailer, strconv.Itoa(exitCode))
+}
+
+// flushWriter flushes every write so that streamed output arrives as it is
+// produced. Writes may come from the stdout and stderr copiers at once.
+type flushWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+	f  http.Flusher
+}
+
+func newFlushWriter(w http.ResponseWriter) *flushWriter {
+	f, _ := w.(http.Flusher)
+	return &flushWriter{w: w, f: f}
+}
+
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	n, err := fw.w.Write(p)
+	if fw.f != nil {
+		fw.f.Flush()
+	}
+	return n, err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
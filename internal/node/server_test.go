@@ -0,0 +1,243 @@
+package node
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zarigata/budgie/internal/api"
+	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/internal/proxy"
+	"github.com/zarigata/budgie/internal/runtime"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// stubRuntime accepts every container; unused methods panic via the nil embed
+type stubRuntime struct {
+	runtime.Runtime
+}
+
+func (stubRuntime) Create(ctx context.Context, ctr *types.Container) error { return nil }
+func (stubRuntime) Start(ctx context.Context, id string) error             { return nil }
+func (stubRuntime) Stop(ctx context.Context, id string, timeout time.Duration) error {
+	return nil
+}
+
+const testBundle = `version: "1.0"
+name: web
+image:
+  docker_image: nginx:alpine
+ports:
+  - container_port: 80
+    host_port: 8080
+`
+
+func newTestClient(t *testing.T) (*Client, *api.ContainerManager, string) {
+	t.Helper()
+
+	dataDir := t.TempDir()
+	manager, err := api.NewContainerManager(stubRuntime{}, dataDir)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+
+	info := func() (discovery.NodeInfo, error) {
+		return discovery.NodeInfo{ID: "node-a", CPUs: 4, Containers: len(manager.List())}, nil
+	}
+	ts := httptest.NewServer(NewServer(manager, dataDir, info).Handler())
+	t.Cleanup(ts.Close)
+
+	u, _ := url.Parse(ts.URL)
+	port, _ := strconv.Atoi(u.Port())
+	return NewClient(u.Hostname(), port, nil), manager, dataDir
+}
+
+func TestServer_Info(t *testing.T) {
+	client, _, _ := newTestClient(t)
+
+	info, err := client.Info(context.Background())
+	if err != nil {
+		t.Fatalf("Info failed: %v", err)
+	}
+	if info.ID != "node-a" || info.CPUs != 4 {
+		t.Errorf("Unexpected node info: %+v", info)
+	}
+}
+
+func TestServer_RunStartsSubmittedBundle(t *testing.T) {
+	client, manager, _ := newTestClient(t)
+
+	resp, err := client.Run(context.Background(), "web.bun", []byte(testBundle))
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if resp.Name != "web" {
+		t.Errorf("Expected container name web, got %s", resp.Name)
+	}
+
+	ctr, err := manager.Get(resp.ID)
+	if err != nil {
+		t.Fatalf("Submitted container not found: %v", err)
+	}
+	if !ctr.IsRunning() {
+		t.Errorf("Expected submitted container to be running, got %s", ctr.State)
+	}
+	if !strings.HasSuffix(ctr.BundlePath, "-web.bun") {
+		t.Errorf("Expected bundle to be stored on the node, got %s", ctr.BundlePath)
+	}
+}
+
+func TestServer_RunRejectsInvalidBundle(t *testing.T) {
+	client, manager, _ := newTestClient(t)
+
+	_, err := client.Run(context.Background(), "../../etc/bad.bun", []byte("name: web\n"))
+	if err == nil || !strings.Contains(err.Error(), "version") {
+		t.Errorf("Expected a bundle validation error, got %v", err)
+	}
+	if n := len(manager.List()); n != 0 {
+		t.Errorf("Expected no containers, got %d", n)
+	}
+}
+
+func TestServer_RejectsRunsWhenCordoned(t *testing.T) {
+	client, manager, dataDir := newTestClient(t)
+
+	if _, err := Cordon(dataDir); err != nil {
+		t.Fatalf("Cordon failed: %v", err)
+	}
+	_, err := client.Run(context.Background(), "web.bun", []byte(testBundle))
+	if err == nil || !strings.Contains(err.Error(), "409") {
+		t.Errorf("Expected a cordoned node to reject runs, got %v", err)
+	}
+	if n := len(manager.List()); n != 0 {
+		t.Errorf("Expected no containers, got %d", n)
+	}
+
+	if err := Uncordon(dataDir); err != nil {
+		t.Fatalf("Uncordon failed: %v", err)
+	}
+	if _, err := client.Run(context.Background(), "web.bun", []byte(testBundle)); err != nil {
+		t.Errorf("Expected an uncordoned node to accept runs, got %v", err)
+	}
+}
+
+func TestServer_ReplicateAndPromote(t *testing.T) {
+	client, manager, _ := newTestClient(t)
+	spec := ReplicaSpec{PrimaryID: types.GenerateContainerID(), PrimaryNode: "node-b", Name: "db", ReplicaSet: "set-1", Epoch: 3}
+
+	resp, err := client.Replicate(context.Background(), spec)
+	if err != nil {
+		t.Fatalf("Replicate failed: %v", err)
+	}
+	ctr, _ := manager.Get(resp.ID)
+	if !ctr.IsReplica() || ctr.ReplicaSetID() != "set-1" || ctr.Peers[0] != "node-b" || !ctr.IsRunning() {
+		t.Fatalf("Unexpected replica: role=%s set=%s peers=%v state=%s", ctr.Role, ctr.ReplicaSetID(), ctr.Peers, ctr.State)
+	}
+
+	stale := PromoteRequest{ContainerID: resp.ID, Epoch: 3}
+	if err := client.Promote(context.Background(), stale); err == nil {
+		t.Error("Expected a promotion without a newer epoch to be rejected")
+	}
+
+	if err := client.Promote(context.Background(), PromoteRequest{ContainerID: resp.ID, Epoch: 4, Peers: []string{"node-b"}}); err != nil {
+		t.Fatalf("Promote failed: %v", err)
+	}
+	ctr, _ = manager.Get(resp.ID)
+	if ctr.IsReplica() || ctr.Epoch != 4 {
+		t.Errorf("Expected a primary at epoch 4, got role=%s epoch=%d", ctr.Role, ctr.Epoch)
+	}
+}
+
+func TestServer_RegistersBackendsWithLoadBalancer(t *testing.T) {
+	client, manager, dataDir := newTestClient(t)
+	if err := NewBackends(client).AddServiceBackend("web", "10.88.0.2", 8080, nil); err == nil {
+		t.Error("Expected a node without a load balancer to refuse backends")
+	}
+
+	lb := proxy.NewContainerProxy(proxy.RoundRobin)
+	defer lb.Shutdown()
+	server := NewServer(manager, dataDir, nil)
+	server.SetBackends(lb)
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	port, _ := strconv.Atoi(u.Port())
+	backends := NewBackends(NewClient(u.Hostname(), port, nil))
+
+	if err := backends.AddServiceBackend("web", "10.88.0.2", 8080, &types.HealthCheck{Path: "/ready"}); err != nil {
+		t.Fatalf("AddServiceBackend failed: %v", err)
+	}
+	pools := lb.Metrics()
+	if len(pools) != 1 || pools[0].ContainerID != "web" || len(pools[0].Backends) != 1 || pools[0].Backends[0].URL != "http://10.88.0.2:8080" {
+		t.Fatalf("Expected the backend in the web pool, got %+v", pools)
+	}
+
+	removed, err := backends.StartDrain("web", "10.88.0.2", 8080, time.Second)
+	if err != nil {
+		t.Fatalf("StartDrain failed: %v", err)
+	}
+	<-removed
+	if pools := lb.Metrics(); len(pools) != 0 {
+		t.Errorf("Expected the backend to be removed, got %+v", pools)
+	}
+	if _, err := backends.StartDrain("web", "10.88.0.2", 8080, time.Second); err == nil {
+		t.Error("Expected draining an unknown backend to fail")
+	}
+}
+
+func TestReadMemInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "meminfo")
+	data := "MemTotal:       16000000 kB\nMemFree:         1000000 kB\nMemAvailable:    8000000 kB\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	total, available, err := readMemInfo(path)
+	if err != nil {
+		t.Fatalf("readMemInfo failed: %v", err)
+	}
+	if total != 16000000*1024 || available != 8000000*1024 {
+		t.Errorf("Unexpected memory info: total=%d available=%d", total, available)
+	}
+}
+
+func TestServer_RunStartsPlacedInstance(t *testing.T) {
+	client, manager, _ := newTestClient(t)
+	instance := &types.Container{
+		ID:       types.GenerateContainerID(),
+		Name:     "web",
+		State:    types.StateRunning,
+		Image:    types.ImageConfig{DockerImage: "nginx:alpine"},
+		Replicas: &types.ReplicasConfig{Min: 3, Max: 5},
+		Role:     types.RolePrimary,
+	}
+
+	resp, err := client.RunInstance(context.Background(), instance)
+	if err != nil {
+		t.Fatalf("RunInstance failed: %v", err)
+	}
+	if resp.ID == instance.ID {
+		t.Error("Expected the placed instance to get its own ID")
+	}
+
+	ctr, err := manager.Get(resp.ID)
+	if err != nil {
+		t.Fatalf("Placed instance not found: %v", err)
+	}
+	if !ctr.IsRunning() || ctr.Name != "web" || ctr.Replicas == nil || ctr.Replicas.Min != 3 {
+		t.Errorf("Expected a running instance of web with its replicas config, got %+v", ctr)
+	}
+	if ctr.Role != "" {
+		t.Errorf("Expected the instance not to join a replica set, got role %s", ctr.Role)
+	}
+
+	if _, err := client.RunInstance(context.Background(), &types.Container{}); err == nil {
+		t.Error("Expected an instance without a name to be rejected")
+	}
+}
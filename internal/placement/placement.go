@@ -0,0 +1,135 @@
+// Package placement evaluates the placement rules of a bundle against a
+// node: label constraints and affinity or anti-affinity to other services.
+package placement
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// Constraint operators
+const (
+	OpEqual    = "=="
+	OpNotEqual = "!="
+)
+
+// Constraint is a parsed node label expression such as "arch==arm64"
+type Constraint struct {
+	Key   string
+	Op    string
+	Value string
+}
+
+// ParseConstraint parses "key==value" or "key!=value". "key=value" is
+// accepted as a shorthand for "key==value".
+func ParseConstraint(expr string) (Constraint, error) {
+	var c Constraint
+
+	switch {
+	case strings.Contains(expr, OpNotEqual):
+		c.Op = OpNotEqual
+	case strings.Contains(expr, OpEqual):
+		c.Op = OpEqual
+	case strings.Contains(expr, "="):
+		c.Op = "="
+	default:
+		return c, fmt.Errorf("invalid constraint %q: expected key==value or key!=value", expr)
+	}
+
+	parts := strings.SplitN(expr, c.Op, 2)
+	c.Key = strings.TrimSpace(parts[0])
+	c.Value = strings.TrimSpace(parts[1])
+	if c.Op == "=" {
+		c.Op = OpEqual
+	}
+
+	if c.Key == "" || c.Value == "" || strings.ContainsAny(c.Value, "=!") {
+		return c, fmt.Errorf("invalid constraint %q: expected key==value or key!=value", expr)
+	}
+
+	return c, nil
+}
+
+// Matches reports whether a node with the given labels satisfies the constraint.
+// A missing label never equals a value, and always differs from it.
+func (c Constraint) Matches(labels map[string]string) bool {
+	value, ok := labels[c.Key]
+	if c.Op == OpNotEqual {
+		return !ok || value != c.Value
+	}
+	return ok && value == c.Value
+}
+
+func (c Constraint) String() string {
+	return c.Key + c.Op + c.Value
+}
+
+// Validate checks that every constraint in the placement parses
+func Validate(p *types.PlacementConfig) error {
+	if p == nil {
+		return nil
+	}
+	for _, expr := range p.Constraints {
+		if _, err := ParseConstraint(expr); err != nil {
+			return err
+		}
+	}
+	for _, service := range p.Affinity {
+		for _, other := range p.AntiAffinity {
+			if service == other {
+				return fmt.Errorf("service %s is listed in both affinity and anti_affinity", service)
+			}
+		}
+	}
+	return nil
+}
+
+// Check returns why a node cannot take a container with the given placement,
+// or nil if it can. services maps the services running on the node to their
+// number of instances.
+func Check(p *types.PlacementConfig, labels map[string]string, services map[string]int) error {
+	if p == nil {
+		return nil
+	}
+
+	for _, expr := range p.Constraints {
+		c, err := ParseConstraint(expr)
+		if err != nil {
+			return err
+		}
+		if !c.Matches(labels) {
+			return fmt.Errorf("constraint %s not satisfied", c)
+		}
+	}
+
+	for _, service := range p.Affinity {
+		if services[service] == 0 {
+			return fmt.Errorf("affinity: %s is not running here", service)
+		}
+	}
+
+	for _, service := range p.AntiAffinity {
+		if services[service] > 0 {
+			return fmt.Errorf("anti-affinity: %s is already running here", service)
+		}
+	}
+
+	return nil
+}
+
+// WithConstraints returns a copy of p with extra constraints appended
+func WithConstraints(p *types.PlacementConfig, constraints []string) *types.PlacementConfig {
+	if len(constraints) == 0 {
+		return p
+	}
+
+	merged := &types.PlacementConfig{}
+	if p != nil {
+		*merged = *p
+		merged.Constraints = append([]string(nil), p.Constraints...)
+	}
+	merged.Constraints = append(merged.Constraints, constraints...)
+	return merged
+}
@@ -0,0 +1,81 @@
+package placement
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+func TestParseConstraint(t *testing.T) {
+	tests := []struct {
+		expr    string
+		want    Constraint
+		wantErr bool
+	}{
+		{expr: "arch==arm64", want: Constraint{Key: "arch", Op: OpEqual, Value: "arm64"}},
+		{expr: "disk != hdd", want: Constraint{Key: "disk", Op: OpNotEqual, Value: "hdd"}},
+		{expr: "zone=a", want: Constraint{Key: "zone", Op: OpEqual, Value: "a"}},
+		{expr: "arch", wantErr: true},
+		{expr: "==arm64", wantErr: true},
+		{expr: "arch===arm64", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseConstraint(tt.expr)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseConstraint(%q): expected an error, got %+v", tt.expr, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseConstraint(%q) failed: %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseConstraint(%q) = %+v, want %+v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	labels := map[string]string{"arch": "arm64", "disk": "sd"}
+	services := map[string]int{"db": 1}
+
+	tests := []struct {
+		name      string
+		placement *types.PlacementConfig
+		wantErr   string
+	}{
+		{name: "no placement"},
+		{name: "constraint matched", placement: &types.PlacementConfig{Constraints: []string{"arch==arm64"}}},
+		{name: "constraint not matched", placement: &types.PlacementConfig{Constraints: []string{"disk==ssd"}}, wantErr: "disk==ssd"},
+		{name: "missing label differs", placement: &types.PlacementConfig{Constraints: []string{"gpu!=true"}}},
+		{name: "affinity matched", placement: &types.PlacementConfig{Affinity: []string{"db"}}},
+		{name: "affinity not matched", placement: &types.PlacementConfig{Affinity: []string{"cache"}}, wantErr: "affinity"},
+		{name: "anti-affinity violated", placement: &types.PlacementConfig{AntiAffinity: []string{"db"}}, wantErr: "anti-affinity"},
+	}
+
+	for _, tt := range tests {
+		err := Check(tt.placement, labels, services)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: expected error containing %q, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate(&types.PlacementConfig{Constraints: []string{"arch"}}); err == nil {
+		t.Error("Expected an invalid constraint to be rejected")
+	}
+	if err := Validate(&types.PlacementConfig{Affinity: []string{"db"}, AntiAffinity: []string{"db"}}); err == nil {
+		t.Error("Expected conflicting affinity rules to be rejected")
+	}
+}
@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// drainPollInterval is how often in-flight counters are checked while draining
+const drainPollInterval = 50 * time.Millisecond
+
+// DrainBackend stops sending new requests to a backend, waits up to timeout for
+// its in-flight requests and connections to finish, then removes it from the
+// pool. It blocks until the backend is removed; RemoveBackend and StartDrain
+// do not.
+func (p *ContainerProxy) DrainBackend(containerID, ip string, port int, timeout time.Duration) error {
+	removed, err := p.StartDrain(containerID, ip, port, timeout)
+	if err != nil {
+		return err
+	}
+	<-removed
+	return nil
+}
+
+// StartDrain stops sending new requests to a backend and returns at once. The
+// backend is removed from the pool in the background once its in-flight
+// requests and connections have finished, or timeout has passed, and the
+// returned channel is closed then. Draining a backend again returns the same
+// channel.
+func (p *ContainerProxy) StartDrain(containerID, ip string, port int, timeout time.Duration) (<-chan struct{}, error) {
+	pool, err := p.getPool(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	b := pool.find(fmt.Sprintf("http://%s:%d", ip, port))
+	if b == nil {
+		return nil, fmt.Errorf("backend not found")
+	}
+
+	if !b.startDrain() {
+		return b.removed, nil
+	}
+	logrus.Infof("Draining backend %s:%d for container %s (%d in flight)", ip, port, shortID(containerID), b.Conn.Load())
+
+	go func() {
+		if !b.waitIdle(time.Now().Add(timeout)) {
+			logrus.Warnf("Backend %s:%d for container %s still had %d in-flight requests after %s",
+				ip, port, shortID(containerID), b.Conn.Load(), timeout)
+		}
+		p.removeBackend(containerID, pool, b)
+		logrus.Infof("Removed backend %s:%d for container %s", ip, port, shortID(containerID))
+	}()
+
+	return b.removed, nil
+}
+
+// removeBackend takes a backend out of a container's pool and deletes the
+// pool with its last backend, so that a pool created for the container again
+// starts from the container's settings
+func (p *ContainerProxy) removeBackend(containerID string, pool *backendPool, b *backend) {
+	// Backends are only added with p.mu held, so an empty pool stays empty
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pool.remove(b)
+
+	pool.mu.RLock()
+	empty := len(pool.backends) == 0
+	pool.mu.RUnlock()
+	if empty && p.pools[containerID] == pool {
+		delete(p.pools, containerID)
+	}
+}
+
+// ReplaceBackend adds a new backend for a container and then drains the old one,
+// so that requests keep being served throughout the swap
+func (p *ContainerProxy) ReplaceBackend(containerID, oldIP string, oldPort int, newIP string, newPort int) error {
+	if err := p.AddBackend(containerID, newIP, newPort); err != nil {
+		return err
+	}
+
+	return p.DrainBackend(containerID, oldIP, oldPort, p.opts.DrainTimeout)
+}
+
+// drainAll drains every backend of every pool in parallel and removes them
+func (p *ContainerProxy) drainAll(timeout time.Duration) {
+	p.mu.RLock()
+	pools := make([]*backendPool, 0, len(p.pools))
+	for _, pool := range p.pools {
+		pools = append(pools, pool)
+	}
+	p.mu.RUnlock()
+
+	var backends []*backend
+	for _, pool := range pools {
+		pool.mu.RLock()
+		backends = append(backends, pool.backends...)
+		pool.mu.RUnlock()
+	}
+
+	for _, b := range backends {
+		b.startDrain()
+	}
+
+	deadline := time.Now().Add(timeout)
+	for _, b := range backends {
+		if !b.waitIdle(deadline) {
+			logrus.Warnf("Backend %s still had %d in-flight requests at shutdown", b.URL, b.Conn.Load())
+		}
+	}
+
+	for _, pool := range pools {
+		pool.mu.RLock()
+		remaining := append([]*backend(nil), pool.backends...)
+		pool.mu.RUnlock()
+
+		for _, b := range remaining {
+			pool.remove(b)
+		}
+	}
+}
+
+// startDrain marks the backend as draining; it returns false if it already was
+func (b *backend) startDrain() bool {
+	return b.draining.CompareAndSwap(false, true)
+}
+
+// waitIdle waits until the backend has no in-flight requests or the deadline passes
+func (b *backend) waitIdle(deadline time.Time) bool {
+	for b.Conn.Load() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(drainPollInterval)
+	}
+	return true
+}
+
+// find returns the backend at targetURL. A backend that is not draining is
+// preferred, so that an address added again while its old backend drains
+// resolves to the new one.
+func (pool *backendPool) find(targetURL string) *backend {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	var draining *backend
+	for _, b := range pool.backends {
+		if b.URL.String() != targetURL {
+			continue
+		}
+		if !b.draining.Load() {
+			return b
+		}
+		if draining == nil {
+			draining = b
+		}
+	}
+	return draining
+}
+
+// remove takes a backend out of the pool and signals connections still using it
+func (pool *backendPool) remove(b *backend) {
+	pool.mu.Lock()
+	for i, candidate := range pool.backends {
+		if candidate == b {
+			pool.backends = append(pool.backends[:i], pool.backends[i+1:]...)
+			break
+		}
+	}
+	pool.mu.Unlock()
+
+	b.removeOnce.Do(func() {
+		close(b.removed)
+	})
+}
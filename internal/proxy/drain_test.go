@@ -0,0 +1,266 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSlowBackend returns a backend whose responses block until release is closed
+func newSlowBackend(t *testing.T, started chan<- struct{}, release <-chan struct{}) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.Write([]byte("slow"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func backendAddr(t *testing.T, server *httptest.Server) (string, int) {
+	t.Helper()
+	host, portStr, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to parse server URL: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
+
+// serveAsync sends a request through the proxy in the background and reports its status
+func serveAsync(t *testing.T, p *ContainerProxy) <-chan int {
+	t.Helper()
+	handler, err := p.GetProxy(testContainerID)
+	if err != nil {
+		t.Fatalf("Failed to get proxy: %v", err)
+	}
+
+	codes := make(chan int, 1)
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		codes <- rec.Code
+	}()
+	return codes
+}
+
+func TestDrainBackend_WaitsForInFlightRequest(t *testing.T) {
+	p := NewContainerProxyWithOptions(RoundRobin, testOptions())
+	defer p.Shutdown()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	slow := newSlowBackend(t, started, release)
+	addTestBackend(t, p, slow)
+
+	codes := serveAsync(t, p)
+	<-started
+
+	host, port := backendAddr(t, slow)
+	drained := make(chan error, 1)
+	go func() { drained <- p.DrainBackend(testContainerID, host, port, 5*time.Second) }()
+
+	select {
+	case <-drained:
+		t.Fatal("Drain finished while a request was still in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if code := <-codes; code != http.StatusOK {
+		t.Errorf("Expected in-flight request to complete with 200, got %d", code)
+	}
+	if err := <-drained; err != nil {
+		t.Fatalf("Failed to drain backend: %v", err)
+	}
+	if _, err := p.getPool(testContainerID); err == nil {
+		t.Error("Expected the pool to go away with its only backend")
+	}
+}
+
+func TestStartDrain_ReturnsBeforeInFlightRequestFinishes(t *testing.T) {
+	p := NewContainerProxyWithOptions(RoundRobin, testOptions())
+	defer p.Shutdown()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	slow := newSlowBackend(t, started, release)
+	addTestBackend(t, p, slow)
+
+	codes := serveAsync(t, p)
+	<-started
+
+	host, port := backendAddr(t, slow)
+	removed, err := p.StartDrain(testContainerID, host, port, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to start draining: %v", err)
+	}
+	again, err := p.StartDrain(testContainerID, host, port, 5*time.Second)
+	if err != nil || again != removed {
+		t.Errorf("Expected draining again to return the same channel, got %v", err)
+	}
+
+	select {
+	case <-removed:
+		t.Fatal("Backend removed while a request was still in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if code := <-codes; code != http.StatusOK {
+		t.Errorf("Expected in-flight request to complete with 200, got %d", code)
+	}
+	select {
+	case <-removed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Backend was not removed once idle")
+	}
+}
+
+func TestRemoveBackend_ReAddedAddressResolvesToNewBackend(t *testing.T) {
+	p := NewContainerProxyWithOptions(RoundRobin, testOptions())
+	defer p.Shutdown()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+	slow := newSlowBackend(t, started, release)
+	addTestBackend(t, p, slow)
+
+	serveAsync(t, p)
+	<-started
+
+	// Removal returns while the request is still in flight
+	host, port := backendAddr(t, slow)
+	if err := p.RemoveBackend(testContainerID, host, port); err != nil {
+		t.Fatalf("Failed to remove backend: %v", err)
+	}
+	old := p.pools[testContainerID].find(slow.URL)
+	if old == nil || !old.draining.Load() {
+		t.Fatal("Expected the old backend to still be draining")
+	}
+
+	// The replica comes back at the same address before the drain ends
+	if err := p.AddBackend(testContainerID, host, port); err != nil {
+		t.Fatalf("Failed to add backend again: %v", err)
+	}
+	removed, err := p.StartDrain(testContainerID, host, port, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to drain the new backend: %v", err)
+	}
+	if removed == old.removed {
+		t.Fatal("Expected the address to resolve to the new backend, not the draining one")
+	}
+}
+
+func TestDrainBackend_NoNewRequests(t *testing.T) {
+	p := NewContainerProxyWithOptions(RoundRobin, testOptions())
+	defer p.Shutdown()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	slow := newSlowBackend(t, started, release)
+	addTestBackend(t, p, slow)
+	addTestBackend(t, p, newTestBackend(t, http.StatusOK))
+
+	// Keep the slow backend busy so that its drain stays pending
+	serveAsync(t, p)
+	<-started
+
+	host, port := backendAddr(t, slow)
+	drained := make(chan error, 1)
+	go func() { drained <- p.DrainBackend(testContainerID, host, port, 5*time.Second) }()
+
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		if code := serve(t, p, http.MethodGet); code != http.StatusOK {
+			t.Fatalf("Request %d: expected 200 from the remaining backend, got %d", i, code)
+		}
+	}
+
+	close(release)
+	if err := <-drained; err != nil {
+		t.Fatalf("Failed to drain backend: %v", err)
+	}
+}
+
+func TestDrainBackend_Timeout(t *testing.T) {
+	p := NewContainerProxyWithOptions(RoundRobin, testOptions())
+	defer p.Shutdown()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+	slow := newSlowBackend(t, started, release)
+	addTestBackend(t, p, slow)
+
+	serveAsync(t, p)
+	<-started
+
+	host, port := backendAddr(t, slow)
+	start := time.Now()
+	if err := p.DrainBackend(testContainerID, host, port, 150*time.Millisecond); err != nil {
+		t.Fatalf("Failed to drain backend: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Drain should give up after its timeout, took %v", elapsed)
+	}
+	if _, err := p.getPool(testContainerID); err == nil {
+		t.Error("Expected backend to be removed after the timeout")
+	}
+}
+
+func TestReplaceBackend(t *testing.T) {
+	p := NewContainerProxyWithOptions(RoundRobin, testOptions())
+	defer p.Shutdown()
+
+	old := newTestBackend(t, http.StatusServiceUnavailable)
+	addTestBackend(t, p, old)
+
+	replacement := newTestBackend(t, http.StatusOK)
+	oldHost, oldPort := backendAddr(t, old)
+	newHost, newPort := backendAddr(t, replacement)
+	if err := p.ReplaceBackend(testContainerID, oldHost, oldPort, newHost, newPort); err != nil {
+		t.Fatalf("Failed to replace backend: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := serve(t, p, http.MethodGet); code != http.StatusOK {
+			t.Fatalf("Request %d: expected 200 from the replacement, got %d", i, code)
+		}
+	}
+}
+
+func TestShutdown_WaitsForInFlightRequests(t *testing.T) {
+	p := NewContainerProxyWithOptions(RoundRobin, testOptions())
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	addTestBackend(t, p, newSlowBackend(t, started, release))
+
+	codes := serveAsync(t, p)
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		p.Shutdown()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		t.Fatal("Shutdown returned while a request was still in flight")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if code := <-codes; code != http.StatusOK {
+		t.Errorf("Expected in-flight request to complete with 200, got %d", code)
+	}
+	<-stopped
+}
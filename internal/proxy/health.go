@@ -0,0 +1,324 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// ProbeType selects how the HealthChecker probes the backends of a pool
+type ProbeType string
+
+const (
+	ProbeHTTP ProbeType = "http"
+	ProbeTCP  ProbeType = "tcp"
+)
+
+// maxHealthBody is how much of a health check response is read for body matching
+const maxHealthBody = 64 * 1024
+
+// StatusRange is an inclusive range of HTTP status codes
+type StatusRange struct {
+	Min int `yaml:"min" json:"min"`
+	Max int `yaml:"max" json:"max"`
+}
+
+func (r StatusRange) contains(code int) bool {
+	return code >= r.Min && code <= r.Max
+}
+
+// HealthCheckConfig configures active health checks for a backend pool
+type HealthCheckConfig struct {
+	Type           ProbeType     `yaml:"type" json:"type"`
+	Path           string        `yaml:"path" json:"path"`
+	ExpectedStatus StatusRange   `yaml:"expected_status" json:"expected_status"`
+	BodyMatch      string        `yaml:"body_match" json:"body_match"` // regular expression
+	Interval       time.Duration `yaml:"interval" json:"interval"`
+	Timeout        time.Duration `yaml:"timeout" json:"timeout"`
+	// HealthyThreshold is the number of consecutive passes before an inactive backend returns
+	HealthyThreshold int `yaml:"healthy_threshold" json:"healthy_threshold"`
+	// UnhealthyThreshold is the number of consecutive failures before a backend is taken out
+	UnhealthyThreshold int `yaml:"unhealthy_threshold" json:"unhealthy_threshold"`
+
+	bodyMatch *regexp.Regexp
+}
+
+// DefaultHealthCheckConfig returns the health check used for pools without explicit settings
+func DefaultHealthCheckConfig() HealthCheckConfig {
+	return HealthCheckConfig{
+		Type:               ProbeHTTP,
+		Path:               "/",
+		ExpectedStatus:     StatusRange{Min: 200, Max: 399},
+		Timeout:            2 * time.Second,
+		HealthyThreshold:   2,
+		UnhealthyThreshold: 3,
+	}
+}
+
+// HealthCheckFromContainer derives health check settings from a bundle's
+// healthcheck section. Containers without an HTTP path get a TCP connect check.
+func HealthCheckFromContainer(hc *types.HealthCheck) HealthCheckConfig {
+	cfg := DefaultHealthCheckConfig()
+	if hc == nil {
+		return cfg
+	}
+
+	if hc.Path != "" {
+		cfg.Path = hc.Path
+	} else {
+		cfg.Type = ProbeTCP
+	}
+	if hc.Interval > 0 {
+		cfg.Interval = hc.Interval
+	}
+	if hc.Timeout > 0 {
+		cfg.Timeout = hc.Timeout
+	}
+	if hc.Retries > 0 {
+		cfg.UnhealthyThreshold = hc.Retries
+	}
+
+	return cfg
+}
+
+// compile validates the configuration and fills in defaults
+func (c *HealthCheckConfig) compile() error {
+	defaults := DefaultHealthCheckConfig()
+
+	switch c.Type {
+	case "":
+		c.Type = ProbeHTTP
+	case ProbeHTTP, ProbeTCP:
+	default:
+		return fmt.Errorf("unknown health check type: %s", c.Type)
+	}
+
+	if c.Path == "" {
+		c.Path = defaults.Path
+	}
+	if c.ExpectedStatus == (StatusRange{}) {
+		c.ExpectedStatus = defaults.ExpectedStatus
+	}
+	if c.ExpectedStatus.Min > c.ExpectedStatus.Max {
+		return fmt.Errorf("invalid expected status range %d-%d", c.ExpectedStatus.Min, c.ExpectedStatus.Max)
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaults.Timeout
+	}
+	if c.HealthyThreshold <= 0 {
+		c.HealthyThreshold = defaults.HealthyThreshold
+	}
+	if c.UnhealthyThreshold <= 0 {
+		c.UnhealthyThreshold = defaults.UnhealthyThreshold
+	}
+
+	c.bodyMatch = nil
+	if c.BodyMatch != "" {
+		re, err := regexp.Compile(c.BodyMatch)
+		if err != nil {
+			return fmt.Errorf("invalid body match: %w", err)
+		}
+		c.bodyMatch = re
+	}
+
+	return nil
+}
+
+// SetHealthCheck changes how the backends of a container are health checked,
+// including those added with their bundle's health check
+func (p *ContainerProxy) SetHealthCheck(containerID string, cfg HealthCheckConfig) error {
+	if err := cfg.compile(); err != nil {
+		return err
+	}
+
+	pool, err := p.getPool(containerID)
+	if err != nil {
+		return err
+	}
+
+	pool.mu.Lock()
+	pool.health = cfg
+	for _, b := range pool.backends {
+		b.health = nil
+	}
+	pool.mu.Unlock()
+
+	return nil
+}
+
+type HealthChecker struct {
+	proxy    *ContainerProxy
+	interval time.Duration // used by health checks without their own interval
+	client   *http.Client
+	stop     chan struct{}
+}
+
+func (p *ContainerProxy) StartHealthCheck(interval time.Duration) {
+	p.health = &HealthChecker{
+		proxy:    p,
+		interval: interval,
+		client: &http.Client{
+			// Redirects are judged against the expected status range
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		stop: make(chan struct{}),
+	}
+
+	go p.health.Run()
+}
+
+func (h *HealthChecker) Run() {
+	// Tick often enough to honour short per-backend intervals
+	tick := time.Second
+	if h.interval > 0 && h.interval < tick {
+		tick = h.interval
+	}
+
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.stop:
+			return
+
+		case now := <-ticker.C:
+			h.checkAll(now)
+		}
+	}
+}
+
+func (h *HealthChecker) checkAll(now time.Time) {
+	h.proxy.mu.RLock()
+	pools := make(map[string]*backendPool, len(h.proxy.pools))
+	for id, pool := range h.proxy.pools {
+		pools[id] = pool
+	}
+	h.proxy.mu.RUnlock()
+
+	type check struct {
+		backend *backend
+		cfg     HealthCheckConfig
+	}
+
+	for containerID, pool := range pools {
+		pool.mu.RLock()
+		checks := make([]check, 0, len(pool.backends))
+		for _, b := range pool.backends {
+			cfg := pool.health
+			if b.health != nil {
+				cfg = *b.health
+			}
+			checks = append(checks, check{backend: b, cfg: cfg})
+		}
+		pool.mu.RUnlock()
+
+		for _, c := range checks {
+			b := c.backend
+			interval := c.cfg.Interval
+			if interval <= 0 {
+				interval = h.interval
+			}
+			if now.Sub(time.Unix(0, b.lastCheck.Load())) < interval {
+				continue
+			}
+
+			// Skip backends whose previous check has not finished yet
+			if !b.checking.CompareAndSwap(false, true) {
+				continue
+			}
+			b.lastCheck.Store(now.UnixNano())
+			go func(containerID string, c check) {
+				defer c.backend.checking.Store(false)
+				h.checkBackend(containerID, c.cfg, c.backend)
+			}(containerID, c)
+		}
+	}
+}
+
+// checkBackend runs one probe against a backend and updates its state
+func (h *HealthChecker) checkBackend(containerID string, cfg HealthCheckConfig, backend *backend) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancel()
+
+	var err error
+	if cfg.Type == ProbeTCP {
+		err = probeTCP(ctx, backend)
+	} else {
+		err = h.probeHTTP(ctx, cfg, backend)
+	}
+
+	h.recordResult(containerID, cfg, backend, err)
+}
+
+func probeTCP(ctx context.Context, backend *backend) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", backend.URL.Host)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (h *HealthChecker) probeHTTP(ctx context.Context, cfg HealthCheckConfig, backend *backend) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", backend.URL.String()+cfg.Path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !cfg.ExpectedStatus.contains(resp.StatusCode) {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	if cfg.bodyMatch != nil {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBody))
+		if err != nil {
+			return fmt.Errorf("failed to read body: %w", err)
+		}
+		if !cfg.bodyMatch.Match(body) {
+			return fmt.Errorf("body does not match %q", cfg.BodyMatch)
+		}
+	}
+
+	return nil
+}
+
+// recordResult applies the healthy/unhealthy thresholds to a probe result
+func (h *HealthChecker) recordResult(containerID string, cfg HealthCheckConfig, backend *backend, err error) {
+	if err != nil {
+		backend.healthyStreak.Store(0)
+		streak := backend.unhealthyStreak.Add(1)
+		if backend.Active.Load() && streak >= int64(cfg.UnhealthyThreshold) {
+			logrus.Warnf("Backend %s for container %s is unhealthy: %v", backend.URL, shortID(containerID), err)
+			backend.Active.Store(false)
+		}
+		return
+	}
+
+	backend.unhealthyStreak.Store(0)
+	streak := backend.healthyStreak.Add(1)
+	if !backend.Active.Load() && streak >= int64(cfg.HealthyThreshold) {
+		logrus.Infof("Backend %s for container %s is back online", backend.URL, shortID(containerID))
+		backend.Active.Store(true)
+	}
+}
+
+func (h *HealthChecker) Shutdown() {
+	close(h.stop)
+}
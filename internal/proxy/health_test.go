@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+func newHealthTestProxy(t *testing.T, handler http.HandlerFunc) (*ContainerProxy, *backend) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	p := NewContainerProxyWithOptions(RoundRobin, testOptions())
+	t.Cleanup(p.Shutdown)
+	addTestBackend(t, p, server)
+
+	return p, p.pools[testContainerID].backends[0]
+}
+
+func runChecks(p *ContainerProxy, b *backend, n int) {
+	h := &HealthChecker{proxy: p, client: http.DefaultClient}
+	pool := p.pools[testContainerID]
+	for i := 0; i < n; i++ {
+		h.checkBackend(testContainerID, pool.health, b)
+	}
+}
+
+func TestHealthCheck_CustomPathAndStatusRange(t *testing.T) {
+	p, b := newHealthTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ready" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	err := p.SetHealthCheck(testContainerID, HealthCheckConfig{
+		Path:               "/ready",
+		ExpectedStatus:     StatusRange{Min: 200, Max: 204},
+		UnhealthyThreshold: 1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to set health check: %v", err)
+	}
+
+	runChecks(p, b, 1)
+	if !b.Active.Load() {
+		t.Error("Backend returning 204 on /ready should stay active")
+	}
+
+	p.SetHealthCheck(testContainerID, HealthCheckConfig{Path: "/missing", UnhealthyThreshold: 1})
+	runChecks(p, b, 1)
+	if b.Active.Load() {
+		t.Error("Backend returning 404 should be marked inactive")
+	}
+}
+
+func TestHealthCheck_BodyMatch(t *testing.T) {
+	p, b := newHealthTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"degraded"}`))
+	})
+
+	p.SetHealthCheck(testContainerID, HealthCheckConfig{BodyMatch: `"status":"ok"`, UnhealthyThreshold: 1})
+	runChecks(p, b, 1)
+	if b.Active.Load() {
+		t.Error("Backend whose body does not match should be inactive")
+	}
+
+	if err := p.SetHealthCheck(testContainerID, HealthCheckConfig{BodyMatch: "("}); err == nil {
+		t.Error("Expected invalid body match to be rejected")
+	}
+}
+
+func TestHealthCheck_Thresholds(t *testing.T) {
+	healthy := false
+	p, b := newHealthTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	p.SetHealthCheck(testContainerID, HealthCheckConfig{HealthyThreshold: 2, UnhealthyThreshold: 3})
+
+	runChecks(p, b, 2)
+	if !b.Active.Load() {
+		t.Fatal("Backend should stay active below the unhealthy threshold")
+	}
+	runChecks(p, b, 1)
+	if b.Active.Load() {
+		t.Fatal("Backend should be inactive after reaching the unhealthy threshold")
+	}
+
+	healthy = true
+	runChecks(p, b, 1)
+	if b.Active.Load() {
+		t.Fatal("Backend should stay inactive below the healthy threshold")
+	}
+	runChecks(p, b, 1)
+	if !b.Active.Load() {
+		t.Error("Backend should be active after reaching the healthy threshold")
+	}
+}
+
+func TestHealthCheck_TCPProbe(t *testing.T) {
+	p := NewContainerProxyWithOptions(RoundRobin, testOptions())
+	defer p.Shutdown()
+
+	addr := startTCPEcho(t)
+	p.AddBackend(testContainerID, addr.IP.String(), addr.Port)
+	if err := p.SetHealthCheck(testContainerID, HealthCheckConfig{Type: ProbeTCP, HealthyThreshold: 1}); err != nil {
+		t.Fatalf("Failed to set health check: %v", err)
+	}
+
+	b := p.pools[testContainerID].backends[0]
+	b.Active.Store(false)
+	runChecks(p, b, 1)
+	if !b.Active.Load() {
+		t.Error("Backend accepting TCP connections should be active")
+	}
+}
+
+func TestHealthCheckFromContainer(t *testing.T) {
+	cfg := HealthCheckFromContainer(&types.HealthCheck{
+		Path:     "/health",
+		Interval: 15 * time.Second,
+		Timeout:  3 * time.Second,
+		Retries:  5,
+	})
+
+	if cfg.Type != ProbeHTTP || cfg.Path != "/health" {
+		t.Errorf("Expected HTTP check on /health, got %s %s", cfg.Type, cfg.Path)
+	}
+	if cfg.Interval != 15*time.Second || cfg.Timeout != 3*time.Second {
+		t.Errorf("Interval/timeout not derived: %v/%v", cfg.Interval, cfg.Timeout)
+	}
+	if cfg.UnhealthyThreshold != 5 {
+		t.Errorf("Expected unhealthy threshold 5, got %d", cfg.UnhealthyThreshold)
+	}
+
+	if cfg := HealthCheckFromContainer(&types.HealthCheck{Interval: time.Second}); cfg.Type != ProbeTCP {
+		t.Errorf("Expected TCP check without a path, got %s", cfg.Type)
+	}
+	if cfg := HealthCheckFromContainer(nil); cfg.Path != "/" {
+		t.Errorf("Expected default path, got %s", cfg.Path)
+	}
+}
+
+func TestHealthChecker_PerPoolInterval(t *testing.T) {
+	checks := make(chan struct{}, 100)
+	p, _ := newHealthTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		checks <- struct{}{}
+	})
+
+	p.SetHealthCheck(testContainerID, HealthCheckConfig{Interval: time.Hour})
+	h := &HealthChecker{proxy: p, client: http.DefaultClient}
+
+	now := time.Now()
+	h.checkAll(now)
+	h.checkAll(now.Add(time.Minute))
+
+	time.Sleep(200 * time.Millisecond)
+	if n := len(checks); n != 1 {
+		t.Errorf("Expected 1 check within the pool interval, got %d", n)
+	}
+}
+
+func TestAddServiceBackend_ChecksEachReplicaAsItsBundleAsks(t *testing.T) {
+	var newChecks atomic.Int64
+	oldVersion := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer oldVersion.Close()
+	newVersion := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ready" {
+			newChecks.Add(1)
+		}
+	}))
+	defer newVersion.Close()
+
+	p := NewContainerProxyWithOptions(RoundRobin, testOptions())
+	defer p.Shutdown()
+	for _, replica := range []struct {
+		server *httptest.Server
+		health *types.HealthCheck
+	}{
+		{oldVersion, &types.HealthCheck{Retries: 1}},
+		{newVersion, &types.HealthCheck{Path: "/ready", Retries: 1}},
+	} {
+		host, portStr, _ := net.SplitHostPort(replica.server.Listener.Addr().String())
+		port, _ := strconv.Atoi(portStr)
+		if err := p.AddServiceBackend("web", host, port, replica.health); err != nil {
+			t.Fatalf("AddServiceBackend failed: %v", err)
+		}
+	}
+
+	// The old replica keeps its TCP check rather than probing the new path
+	h := &HealthChecker{proxy: p, client: http.DefaultClient}
+	h.checkAll(time.Now())
+	time.Sleep(200 * time.Millisecond)
+
+	backends := p.pools["web"].backends
+	if !backends[0].Active.Load() || !backends[1].Active.Load() {
+		t.Errorf("Expected both versions to pass their own checks, got %t and %t", backends[0].Active.Load(), backends[1].Active.Load())
+	}
+	if newChecks.Load() != 1 {
+		t.Errorf("Expected the new replica to be probed on /ready, got %d probes", newChecks.Load())
+	}
+
+	// An explicit pool health check applies to every replica
+	if err := p.SetHealthCheck("web", HealthCheckConfig{Path: "/ready", UnhealthyThreshold: 1}); err != nil {
+		t.Fatalf("Failed to set health check: %v", err)
+	}
+	h.checkBackend("web", p.pools["web"].health, backends[0])
+	if backends[0].Active.Load() {
+		t.Error("Expected the old replica to fail the pool's /ready check")
+	}
+	if backends[0].health != nil || backends[1].health != nil {
+		t.Error("Expected the pool's health check to replace the bundles' checks")
+	}
+}
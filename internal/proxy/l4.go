@@ -0,0 +1,462 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// L4Options controls raw TCP and UDP proxying
+type L4Options struct {
+	// DialTimeout bounds connecting to a backend
+	DialTimeout time.Duration `yaml:"dial_timeout" json:"dial_timeout"`
+	// UDPIdleTimeout expires UDP sessions that saw no traffic
+	UDPIdleTimeout time.Duration `yaml:"udp_idle_timeout" json:"udp_idle_timeout"`
+}
+
+// maxDatagramSize is the largest UDP payload the proxy forwards
+const maxDatagramSize = 64 * 1024
+
+// dialBackend connects to a backend of the pool, trying other backends when a
+// connection attempt fails
+func (p *ContainerProxy) dialBackend(pool *backendPool, network string) (net.Conn, *backend, error) {
+	tried := make(map[*backend]bool)
+	var lastErr error
+
+	for attempt := 0; attempt <= p.opts.Retry.Attempts; attempt++ {
+		b := p.acquireBackend(pool, tried)
+		if b == nil {
+			break
+		}
+		tried[b] = true
+
+		if attempt > 0 {
+			pool.retries.Add(1)
+			b.retries.Add(1)
+		}
+
+		conn, err := net.DialTimeout(network, b.URL.Host, p.opts.L4.DialTimeout)
+		b.recordResult(err == nil, time.Now())
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		return conn, b, nil
+	}
+
+	if lastErr != nil {
+		return nil, nil, lastErr
+	}
+
+	pool.unavailable.Add(1)
+	return nil, nil, errNoBackend
+}
+
+// TCPProxy forwards raw TCP connections to a container's backend pool
+type TCPProxy struct {
+	proxy       *ContainerProxy
+	containerID string
+	listener    net.Listener
+	done        chan struct{}
+	closeOnce   sync.Once
+	wg          sync.WaitGroup
+}
+
+// ListenTCP starts a TCP listener on addr that balances connections across the
+// backends of containerID
+func (p *ContainerProxy) ListenTCP(addr, containerID string) (*TCPProxy, error) {
+	if _, err := p.getPool(containerID); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	t := &TCPProxy{
+		proxy:       p,
+		containerID: containerID,
+		listener:    listener,
+		done:        make(chan struct{}),
+	}
+
+	t.wg.Add(1)
+	go t.serve()
+
+	logrus.Infof("TCP proxy for container %s listening on %s", shortID(containerID), listener.Addr())
+	return t, nil
+}
+
+// Addr returns the listener's address
+func (t *TCPProxy) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+// Close stops accepting connections and waits for open ones to drain
+func (t *TCPProxy) Close() error {
+	var err error
+	t.closeOnce.Do(func() {
+		close(t.done)
+		err = t.listener.Close()
+	})
+	t.wg.Wait()
+	return err
+}
+
+func (t *TCPProxy) serve() {
+	defer t.wg.Done()
+
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			select {
+			case <-t.done:
+				return
+			case <-t.proxy.shutdown:
+				t.listener.Close()
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logrus.Errorf("Failed to accept TCP connection: %v", err)
+			continue
+		}
+
+		t.wg.Add(1)
+		go t.handle(conn)
+	}
+}
+
+func (t *TCPProxy) handle(client net.Conn) {
+	defer t.wg.Done()
+	defer client.Close()
+
+	pool, err := t.proxy.getPool(t.containerID)
+	if err != nil {
+		logrus.Warnf("TCP proxy for container %s: %v", shortID(t.containerID), errNoBackend)
+		return
+	}
+	pool.requests.Add(1)
+
+	upstream, b, err := t.proxy.dialBackend(pool, "tcp")
+	if err != nil {
+		logrus.Warnf("TCP proxy for container %s: %v", shortID(t.containerID), err)
+		return
+	}
+	defer upstream.Close()
+
+	b.Conn.Add(1)
+	defer b.Conn.Add(-1)
+
+	finished := make(chan struct{})
+	defer close(finished)
+	go t.drainOnRemoval(b, client, upstream, finished)
+
+	var copies sync.WaitGroup
+	copies.Add(2)
+	go func() {
+		defer copies.Done()
+		io.Copy(upstream, client)
+		closeWrite(upstream)
+	}()
+	go func() {
+		defer copies.Done()
+		io.Copy(client, upstream)
+		closeWrite(client)
+	}()
+	copies.Wait()
+}
+
+// drainOnRemoval lets a connection finish on its own. Removed backends have
+// already been drained, so their connections are closed right away; closing the
+// listener gives open connections the drain timeout to finish.
+func (t *TCPProxy) drainOnRemoval(b *backend, client, upstream net.Conn, finished chan struct{}) {
+	select {
+	case <-finished:
+		return
+	case <-b.removed:
+		logrus.Debugf("Closing TCP connection to removed backend %s", b.URL.Host)
+		client.Close()
+		upstream.Close()
+		return
+	case <-t.proxy.shutdown:
+		client.Close()
+		upstream.Close()
+		return
+	case <-t.done:
+	}
+
+	timer := time.NewTimer(t.proxy.opts.DrainTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-finished:
+	case <-timer.C:
+		logrus.Debugf("Closing TCP connection to %s after drain timeout", b.URL.Host)
+		client.Close()
+		upstream.Close()
+	}
+}
+
+func closeWrite(conn net.Conn) {
+	if tc, ok := conn.(interface{ CloseWrite() error }); ok {
+		tc.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+// UDPProxy forwards UDP datagrams to a container's backend pool, pinning each
+// client address to one backend for the lifetime of its session
+type UDPProxy struct {
+	proxy       *ContainerProxy
+	containerID string
+	conn        net.PacketConn
+	mu          sync.Mutex
+	sessions    map[string]*udpSession
+	done        chan struct{}
+	closeOnce   sync.Once
+	wg          sync.WaitGroup
+}
+
+type udpSession struct {
+	client     net.Addr
+	upstream   net.Conn
+	backend    *backend
+	lastActive atomic.Int64 // unix nanoseconds
+	closeOnce  sync.Once
+}
+
+// ListenUDP starts a UDP listener on addr that balances client sessions across
+// the backends of containerID
+func (p *ContainerProxy) ListenUDP(addr, containerID string) (*UDPProxy, error) {
+	if _, err := p.getPool(containerID); err != nil {
+		return nil, err
+	}
+
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	u := &UDPProxy{
+		proxy:       p,
+		containerID: containerID,
+		conn:        conn,
+		sessions:    make(map[string]*udpSession),
+		done:        make(chan struct{}),
+	}
+
+	u.wg.Add(2)
+	go u.serve()
+	go u.reap()
+
+	logrus.Infof("UDP proxy for container %s listening on %s", shortID(containerID), conn.LocalAddr())
+	return u, nil
+}
+
+// Addr returns the listener's address
+func (u *UDPProxy) Addr() net.Addr {
+	return u.conn.LocalAddr()
+}
+
+// Close stops the listener and ends all sessions
+func (u *UDPProxy) Close() error {
+	var err error
+	u.closeOnce.Do(func() {
+		close(u.done)
+		err = u.conn.Close()
+	})
+
+	u.mu.Lock()
+	for key, s := range u.sessions {
+		u.closeSession(key, s)
+	}
+	u.mu.Unlock()
+
+	u.wg.Wait()
+	return err
+}
+
+func (u *UDPProxy) serve() {
+	defer u.wg.Done()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := u.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-u.done:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logrus.Errorf("Failed to read UDP datagram: %v", err)
+			continue
+		}
+
+		s, err := u.session(addr)
+		if err != nil {
+			logrus.Warnf("UDP proxy for container %s: %v", shortID(u.containerID), err)
+			continue
+		}
+
+		s.lastActive.Store(time.Now().UnixNano())
+		if _, err := s.upstream.Write(buf[:n]); err != nil {
+			logrus.Debugf("Failed to forward UDP datagram to %s: %v", s.backend.URL.Host, err)
+		}
+	}
+}
+
+// session returns the session for a client address, creating it if needed.
+// Backends are dialed without holding u.mu, so that a slow dial does not
+// hold up the replies and expiry of other sessions.
+func (u *UDPProxy) session(addr net.Addr) (*udpSession, error) {
+	key := addr.String()
+
+	u.mu.Lock()
+	s, ok := u.sessions[key]
+	u.mu.Unlock()
+	if ok {
+		return s, nil
+	}
+
+	pool, err := u.proxy.getPool(u.containerID)
+	if err != nil {
+		return nil, errNoBackend
+	}
+	pool.requests.Add(1)
+	upstream, b, err := u.proxy.dialBackend(pool, "udp")
+	if err != nil {
+		return nil, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	// The proxy may have closed, or the client got a session, meanwhile
+	select {
+	case <-u.done:
+		upstream.Close()
+		return nil, net.ErrClosed
+	default:
+	}
+	if s, ok := u.sessions[key]; ok {
+		upstream.Close()
+		return s, nil
+	}
+
+	b.Conn.Add(1)
+	s = &udpSession{
+		client:   addr,
+		upstream: upstream,
+		backend:  b,
+	}
+	s.lastActive.Store(time.Now().UnixNano())
+	u.sessions[key] = s
+
+	u.wg.Add(1)
+	go u.reply(key, s)
+
+	return s, nil
+}
+
+// reply copies datagrams from the backend back to the client
+func (u *UDPProxy) reply(key string, s *udpSession) {
+	defer u.wg.Done()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, err := s.upstream.Read(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				// A refused datagram means nothing listens on the backend port
+				s.backend.recordResult(false, time.Now())
+			}
+			u.mu.Lock()
+			u.closeSession(key, s)
+			u.mu.Unlock()
+			return
+		}
+
+		s.lastActive.Store(time.Now().UnixNano())
+		if _, err := u.conn.WriteTo(buf[:n], s.client); err != nil {
+			logrus.Debugf("Failed to return UDP datagram to %s: %v", s.client, err)
+		}
+	}
+}
+
+// reap expires idle sessions and ends sessions of draining or removed backends
+func (u *UDPProxy) reap() {
+	defer u.wg.Done()
+
+	interval := u.proxy.opts.L4.UDPIdleTimeout / 2
+	if interval <= 0 || interval > time.Second*5 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-u.done:
+			return
+		case <-u.proxy.shutdown:
+			u.conn.Close()
+			return
+		case now := <-ticker.C:
+			u.expireSessions(now)
+		}
+	}
+}
+
+func (u *UDPProxy) expireSessions(now time.Time) {
+	opts := u.proxy.opts.L4
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for key, s := range u.sessions {
+		idle := now.Sub(time.Unix(0, s.lastActive.Load()))
+		if opts.UDPIdleTimeout > 0 && idle >= opts.UDPIdleTimeout {
+			u.closeSession(key, s)
+			continue
+		}
+
+		// A draining backend's sessions end as soon as they go quiet
+		if s.backend.draining.Load() && idle >= drainPollInterval {
+			u.closeSession(key, s)
+			continue
+		}
+
+		select {
+		case <-s.backend.removed:
+			logrus.Debugf("Closing UDP session %s of removed backend", s.client)
+			u.closeSession(key, s)
+		default:
+		}
+	}
+}
+
+// closeSession ends a session; u.mu must be held
+func (u *UDPProxy) closeSession(key string, s *udpSession) {
+	s.closeOnce.Do(func() {
+		if u.sessions[key] == s {
+			delete(u.sessions, key)
+		}
+		s.upstream.Close()
+		s.backend.Conn.Add(-1)
+	})
+}
@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func startTCPEcho(t *testing.T) *net.TCPAddr {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr)
+}
+
+func startUDPEcho(t *testing.T) *net.UDPAddr {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestTCPProxy_ForwardsAndBalances(t *testing.T) {
+	p := NewContainerProxyWithOptions(RoundRobin, testOptions())
+	defer p.Shutdown()
+
+	for i := 0; i < 2; i++ {
+		addr := startTCPEcho(t)
+		p.AddBackend(testContainerID, addr.IP.String(), addr.Port)
+	}
+
+	tp, err := p.ListenTCP("127.0.0.1:0", testContainerID)
+	if err != nil {
+		t.Fatalf("Failed to start TCP proxy: %v", err)
+	}
+	defer tp.Close()
+
+	for i := 0; i < 4; i++ {
+		conn, err := net.Dial("tcp", tp.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial proxy: %v", err)
+		}
+		conn.Write([]byte("ping\n"))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if err != nil || line != "ping\n" {
+			t.Fatalf("Expected echo, got %q (%v)", line, err)
+		}
+	}
+
+	for _, b := range p.Metrics()[0].Backends {
+		if b.Requests != 2 {
+			t.Errorf("Expected connections to be balanced, backend %s got %d", b.URL, b.Requests)
+		}
+	}
+}
+
+func TestTCPProxy_SkipsDeadBackend(t *testing.T) {
+	p := NewContainerProxyWithOptions(RoundRobin, testOptions())
+	defer p.Shutdown()
+
+	// Reserve a port and close it so nothing listens there
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	deadAddr := dead.Addr().(*net.TCPAddr)
+	dead.Close()
+
+	p.AddBackend(testContainerID, deadAddr.IP.String(), deadAddr.Port)
+	live := startTCPEcho(t)
+	p.AddBackend(testContainerID, live.IP.String(), live.Port)
+
+	tp, err := p.ListenTCP("127.0.0.1:0", testContainerID)
+	if err != nil {
+		t.Fatalf("Failed to start TCP proxy: %v", err)
+	}
+	defer tp.Close()
+
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", tp.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial proxy: %v", err)
+		}
+		conn.Write([]byte("hi\n"))
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		conn.Close()
+		if err != nil || line != "hi\n" {
+			t.Fatalf("Expected connection to fail over to live backend, got %q (%v)", line, err)
+		}
+	}
+}
+
+func TestTCPProxy_DrainsOnBackendRemoval(t *testing.T) {
+	opts := testOptions()
+	opts.DrainTimeout = 300 * time.Millisecond
+
+	p := NewContainerProxyWithOptions(RoundRobin, opts)
+	defer p.Shutdown()
+
+	addr := startTCPEcho(t)
+	p.AddBackend(testContainerID, addr.IP.String(), addr.Port)
+
+	tp, err := p.ListenTCP("127.0.0.1:0", testContainerID)
+	if err != nil {
+		t.Fatalf("Failed to start TCP proxy: %v", err)
+	}
+	defer tp.Close()
+
+	conn, err := net.Dial("tcp", tp.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	conn.Write([]byte("one\n"))
+	if line, _ := reader.ReadString('\n'); line != "one\n" {
+		t.Fatalf("Expected echo before removal, got %q", line)
+	}
+
+	removed := make(chan error, 1)
+	go func() {
+		removed <- p.RemoveBackend(testContainerID, addr.IP.String(), addr.Port)
+	}()
+
+	// The connection keeps working during the drain period
+	time.Sleep(50 * time.Millisecond)
+	conn.Write([]byte("two\n"))
+	if line, _ := reader.ReadString('\n'); line != "two\n" {
+		t.Fatalf("Expected echo during drain, got %q", line)
+	}
+
+	if err := <-removed; err != nil {
+		t.Fatalf("Failed to remove backend: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := reader.ReadString('\n'); err == nil {
+		t.Error("Expected connection to be closed after the drain timeout")
+	}
+}
+
+func TestUDPProxy_Forwards(t *testing.T) {
+	p := NewContainerProxyWithOptions(RoundRobin, testOptions())
+	defer p.Shutdown()
+
+	addr := startUDPEcho(t)
+	p.AddBackend(testContainerID, addr.IP.String(), addr.Port)
+
+	up, err := p.ListenUDP("127.0.0.1:0", testContainerID)
+	if err != nil {
+		t.Fatalf("Failed to start UDP proxy: %v", err)
+	}
+	defer up.Close()
+
+	conn, err := net.Dial("udp", up.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial proxy: %v", err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 64)
+	for i := 0; i < 3; i++ {
+		conn.Write([]byte("datagram"))
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "datagram" {
+			t.Fatalf("Expected echoed datagram, got %q (%v)", buf[:n], err)
+		}
+	}
+
+	// All datagrams from one client belong to one session
+	if reqs := p.Metrics()[0].Requests; reqs != 1 {
+		t.Errorf("Expected 1 UDP session, got %d", reqs)
+	}
+}
+
+func TestUDPProxy_NoSessionAfterClose(t *testing.T) {
+	p := NewContainerProxyWithOptions(RoundRobin, testOptions())
+	defer p.Shutdown()
+
+	addr := startUDPEcho(t)
+	p.AddBackend(testContainerID, addr.IP.String(), addr.Port)
+
+	up, err := p.ListenUDP("127.0.0.1:0", testContainerID)
+	if err != nil {
+		t.Fatalf("Failed to start UDP proxy: %v", err)
+	}
+	up.Close()
+
+	// A dial that finishes after the proxy closed must not leave a session
+	client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+	if _, err := up.session(client); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Expected no session on a closed proxy, got %v", err)
+	}
+	if n := len(up.sessions); n != 0 {
+		t.Errorf("Expected no sessions, got %d", n)
+	}
+	if conns := p.Metrics()[0].Backends[0].InFlight; conns != 0 {
+		t.Errorf("Expected no connections to the backend, got %d", conns)
+	}
+}
@@ -2,7 +2,9 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net/http"
 	"net/http/httputil"
@@ -12,11 +14,16 @@ import (
 	"time"
 
 	"github.com/sirupsen/logrus"
+
+	"github.com/zarigata/budgie/pkg/types"
 )
 
 type LoadBalancer interface {
 	AddBackend(containerID, ip string, port int) error
+	// RemoveBackend starts draining a backend and returns at once
 	RemoveBackend(containerID, ip string, port int) error
+	// DrainBackend drains a backend and blocks until it has been removed
+	DrainBackend(containerID, ip string, port int, timeout time.Duration) error
 	GetProxy(containerID string) (http.Handler, error)
 	StartHealthCheck(interval time.Duration)
 	Shutdown()
@@ -29,41 +36,107 @@ const (
 	LeastConn  LoadBalancerType = "least-connections"
 )
 
+// errNoBackend is returned when no backend in a pool can take a request
+var errNoBackend = errors.New("no backends available")
+
 type ContainerProxy struct {
-	mu       sync.RWMutex
-	pools    map[string]*backendPool
-	lbType   LoadBalancerType
-	health   *HealthChecker
-	shutdown chan struct{}
+	mu        sync.RWMutex
+	pools     map[string]*backendPool
+	routes    map[string]*Route
+	certs     *certStore
+	lbType    LoadBalancerType
+	opts      Options
+	transport http.RoundTripper
+	health    *HealthChecker
+	shutdown  chan struct{}
 }
 
 type backendPool struct {
 	backends []*backend
 	current  atomic.Uint64
+	health   HealthCheckConfig
 	mu       sync.RWMutex
+
+	requests    atomic.Int64
+	retries     atomic.Int64
+	unavailable atomic.Int64
 }
 
 type backend struct {
 	URL    *url.URL
 	Active atomic.Bool
 	Conn   atomic.Int64
-}
 
-type HealthChecker struct {
-	interval time.Duration
-	pools   map[string]*backendPool
-	stop     chan struct{}
+	breaker      *circuitBreaker
+	draining     atomic.Bool   // no new requests are sent to a draining backend
+	removed      chan struct{} // closed when the backend leaves its pool
+	removeOnce   sync.Once
+	ejectedUntil atomic.Int64 // unix nanoseconds
+	ejections    atomic.Int64
+
+	requests atomic.Int64
+	failures atomic.Int64
+	retries  atomic.Int64
+
+	// Counters reset on every outlier detection sweep
+	windowRequests atomic.Int64
+	windowFailures atomic.Int64
+
+	// Active health checks, as the backend's bundle asks or else as its pool does
+	health          *HealthCheckConfig
+	lastCheck       atomic.Int64 // unix nanoseconds
+	healthyStreak   atomic.Int64
+	unhealthyStreak atomic.Int64
+	checking        atomic.Bool
 }
 
 func NewContainerProxy(lbType LoadBalancerType) *ContainerProxy {
-	return &ContainerProxy{
-		pools:    make(map[string]*backendPool),
-		lbType:   lbType,
-		shutdown: make(chan struct{}),
+	return NewContainerProxyWithOptions(lbType, DefaultOptions())
+}
+
+// NewContainerProxyWithOptions creates a proxy with custom retry, circuit breaker,
+// outlier detection and TLS settings
+func NewContainerProxyWithOptions(lbType LoadBalancerType, opts Options) *ContainerProxy {
+	p := &ContainerProxy{
+		pools:     make(map[string]*backendPool),
+		routes:    make(map[string]*Route),
+		certs:     newCertStore(opts.TLS),
+		lbType:    lbType,
+		opts:      opts,
+		transport: http.DefaultTransport,
+		shutdown:  make(chan struct{}),
+	}
+
+	if opts.OutlierDetection.Interval > 0 {
+		go p.runOutlierDetection()
 	}
+
+	if opts.TLS.ReloadInterval > 0 {
+		go p.runCertReload()
+	}
+
+	return p
 }
 
 func (p *ContainerProxy) AddBackend(containerID, ip string, port int) error {
+	return p.addBackend(containerID, ip, port, nil)
+}
+
+// AddServiceBackend adds a replica of a service to the service's pool. The
+// replica is health checked the way its bundle asks, see
+// HealthCheckFromContainer, so that replicas of different versions are each
+// checked as their own version expects.
+func (p *ContainerProxy) AddServiceBackend(service, ip string, port int, hc *types.HealthCheck) error {
+	cfg := HealthCheckFromContainer(hc)
+	if err := cfg.compile(); err != nil {
+		return err
+	}
+	return p.addBackend(service, ip, port, &cfg)
+}
+
+// addBackend adds a backend to a container's pool, creating the pool if
+// needed. A non-nil health checks the backend instead of the pool's check.
+func (p *ContainerProxy) addBackend(containerID, ip string, port int, health *HealthCheckConfig) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -71,6 +144,7 @@ func (p *ContainerProxy) AddBackend(containerID, ip string, port int) error {
 	if !exists {
 		pool = &backendPool{
 			backends: make([]*backend, 0),
+			health:   DefaultHealthCheckConfig(),
 		}
 		p.pools[containerID] = pool
 	}
@@ -81,7 +155,10 @@ func (p *ContainerProxy) AddBackend(containerID, ip string, port int) error {
 	}
 
 	backend := &backend{
-		URL: url,
+		URL:     url,
+		breaker: newCircuitBreaker(p.opts.CircuitBreaker),
+		removed: make(chan struct{}),
+		health:  health,
 	}
 	backend.Active.Store(true)
 
@@ -89,93 +166,243 @@ func (p *ContainerProxy) AddBackend(containerID, ip string, port int) error {
 	pool.backends = append(pool.backends, backend)
 	pool.mu.Unlock()
 
-	logrus.Infof("Added backend %s:%d for container %s", ip, port, containerID[:12])
+	logrus.Infof("Added backend %s:%d for container %s", ip, port, shortID(containerID))
 
 	return nil
 }
 
+// RemoveBackend drains a backend and removes it once its in-flight requests
+// have finished or the drain timeout has passed
 func (p *ContainerProxy) RemoveBackend(containerID, ip string, port int) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	_, err := p.StartDrain(containerID, ip, port, p.opts.DrainTimeout)
+	return err
+}
+
+func (p *ContainerProxy) getPool(containerID string) (*backendPool, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 
 	pool, exists := p.pools[containerID]
 	if !exists {
-		return fmt.Errorf("container not found: %s", containerID)
-	}
-
-	targetURL := fmt.Sprintf("http://%s:%d", ip, port)
-
-	pool.mu.Lock()
-	defer pool.mu.Unlock()
-
-	for i, backend := range pool.backends {
-		if backend.URL.String() == targetURL {
-			pool.backends = append(pool.backends[:i], pool.backends[i+1:]...)
-			logrus.Infof("Removed backend %s:%d for container %s", ip, port, containerID[:12])
-			return nil
-		}
+		return nil, fmt.Errorf("container not found: %s", containerID)
 	}
-
-	return fmt.Errorf("backend not found")
+	return pool, nil
 }
 
 func (p *ContainerProxy) GetProxy(containerID string) (http.Handler, error) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	pool, exists := p.pools[containerID]
-	if !exists {
+	if _, exists := p.pools[containerID]; !exists {
 		return nil, fmt.Errorf("container not found: %s", containerID)
 	}
 
 	proxy := &httputil.ReverseProxy{
 		Director: func(req *http.Request) {
-			backend := p.selectBackend(pool)
-			if backend == nil {
-				// Cannot write error here; ModifyResponse will handle it
+			// The backend itself is chosen per attempt by the pool transport
+			req.URL.Scheme = "http"
+			req.Header.Set("X-Forwarded-For", req.RemoteAddr)
+			req.Header.Set("X-Forwarded-Host", req.Host)
+			if req.TLS != nil {
+				req.Header.Set("X-Forwarded-Proto", "https")
+			} else {
+				req.Header.Set("X-Forwarded-Proto", "http")
+			}
+		},
+		Transport: &poolTransport{
+			proxy:       p,
+			containerID: containerID,
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if errors.Is(err, errNoBackend) {
+				http.Error(w, "No backends available", http.StatusServiceUnavailable)
 				return
 			}
+			logrus.Warnf("Proxy error for container %s: %v", shortID(containerID), err)
+			http.Error(w, "Bad gateway", http.StatusBadGateway)
+		},
+	}
 
-			req.URL.Scheme = backend.URL.Scheme
-			req.URL.Host = backend.URL.Host
-			req.URL.Path = backend.URL.Path + req.URL.Path
+	return proxy, nil
+}
 
-			req.Header.Set("X-Forwarded-For", req.RemoteAddr)
-			req.Header.Set("X-Forwarded-Host", req.Host)
+// poolTransport sends a request to a backend of the container's pool,
+// retrying idempotent requests on other backends when a backend fails
+type poolTransport struct {
+	proxy       *ContainerProxy
+	containerID string
+}
 
-			// Store backend in context for connection tracking
-			ctx := context.WithValue(req.Context(), "backend", backend)
-			*req = *req.WithContext(ctx)
-		},
+func (t *poolTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	policy := t.proxy.opts.Retry
+
+	// The pool is looked up per request as it goes away with its last backend
+	pool, err := t.proxy.getPool(t.containerID)
+	if err != nil {
+		return nil, errNoBackend
+	}
+	pool.requests.Add(1)
+
+	attempts := 1
+	if policy.Attempts > 0 && isIdempotent(req) {
+		attempts += policy.Attempts
 	}
 
-	// Wrap to handle no-backend case and connection tracking
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		backend := p.selectBackend(pool)
-		if backend == nil {
-			http.Error(w, "No backends available", http.StatusServiceUnavailable)
-			return
+	tried := make(map[*backend]bool)
+	var lastResp *http.Response
+	var lastErr error
+
+	for attempt := 0; attempt < attempts; attempt++ {
+		b := t.proxy.acquireBackend(pool, tried)
+		if b == nil {
+			break
+		}
+		tried[b] = true
+
+		if attempt > 0 {
+			pool.retries.Add(1)
+			b.retries.Add(1)
+			logrus.Debugf("Retrying request %s %s on backend %s (attempt %d)", req.Method, req.URL.Path, b.URL, attempt+1)
+		}
+
+		resp, err := t.send(req, b)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if !policy.retryableStatus(resp.StatusCode) || attempt == attempts-1 {
+			if lastResp != nil {
+				lastResp.Body.Close()
+			}
+			return resp, nil
+		}
+
+		// Keep the response in case no other backend can take the retry
+		if lastResp != nil {
+			lastResp.Body.Close()
 		}
+		lastResp = resp
+	}
+
+	if lastResp != nil {
+		return lastResp, nil
+	}
 
-		backend.Conn.Add(1)
-		defer backend.Conn.Add(-1)
+	if lastErr != nil {
+		return nil, lastErr
+	}
 
-		proxy.ServeHTTP(w, r)
-	}), nil
+	pool.unavailable.Add(1)
+	return nil, errNoBackend
 }
 
-func (p *ContainerProxy) selectBackend(pool *backendPool) *backend {
+// send performs a single attempt against a backend and records its outcome
+func (t *poolTransport) send(req *http.Request, b *backend) (*http.Response, error) {
+	ctx := req.Context()
+	cancel := context.CancelFunc(func() {})
+	if timeout := t.proxy.opts.Retry.PerTryTimeout; timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	out := req.Clone(ctx)
+	out.URL.Scheme = b.URL.Scheme
+	out.URL.Host = b.URL.Host
+	out.URL.Path = b.URL.Path + req.URL.Path
+	if req.Body != nil && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		out.Body = body
+	}
+
+	b.Conn.Add(1)
+	resp, err := t.proxy.transport.RoundTrip(out)
+
+	success := err == nil && resp.StatusCode < http.StatusInternalServerError
+	b.recordResult(success, time.Now())
+
+	if err != nil {
+		b.Conn.Add(-1)
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &trackedBody{
+		ReadCloser: resp.Body,
+		onClose: func() {
+			b.Conn.Add(-1)
+			cancel()
+		},
+	}
+
+	return resp, nil
+}
+
+// recordResult feeds a request outcome to the circuit breaker and outlier counters
+func (b *backend) recordResult(success bool, now time.Time) {
+	b.requests.Add(1)
+	b.windowRequests.Add(1)
+	if !success {
+		b.failures.Add(1)
+		b.windowFailures.Add(1)
+	}
+	b.breaker.record(success, now)
+}
+
+// trackedBody runs a callback once the response body is closed
+type trackedBody struct {
+	io.ReadCloser
+	once    sync.Once
+	onClose func()
+}
+
+func (b *trackedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.onClose)
+	return err
+}
+
+// acquireBackend picks a backend that has not been tried yet and whose
+// circuit allows a request
+func (p *ContainerProxy) acquireBackend(pool *backendPool, tried map[*backend]bool) *backend {
+	now := time.Now()
+	exclude := make(map[*backend]bool, len(tried))
+	for b := range tried {
+		exclude[b] = true
+	}
+
+	for {
+		b := p.selectBackend(pool, exclude, now)
+		if b == nil {
+			return nil
+		}
+		if b.breaker.allow(now) {
+			return b
+		}
+		exclude[b] = true
+	}
+}
+
+// selectable reports whether a backend may be chosen for a new request
+func (b *backend) selectable(now time.Time) bool {
+	return b.Active.Load() && !b.draining.Load() && !b.isEjected(now) && b.breaker.ready(now)
+}
+
+func (p *ContainerProxy) selectBackend(pool *backendPool, exclude map[*backend]bool, now time.Time) *backend {
 	switch p.lbType {
 	case RoundRobin:
-		return p.roundRobinSelect(pool)
+		return p.roundRobinSelect(pool, exclude, now)
 	case LeastConn:
-		return p.leastConnSelect(pool)
+		return p.leastConnSelect(pool, exclude, now)
 	default:
-		return p.roundRobinSelect(pool)
+		return p.roundRobinSelect(pool, exclude, now)
 	}
 }
 
-func (p *ContainerProxy) roundRobinSelect(pool *backendPool) *backend {
+func (p *ContainerProxy) roundRobinSelect(pool *backendPool, exclude map[*backend]bool, now time.Time) *backend {
 	pool.mu.RLock()
 	defer pool.mu.RUnlock()
 
@@ -185,7 +412,7 @@ func (p *ContainerProxy) roundRobinSelect(pool *backendPool) *backend {
 
 	activeBackends := make([]*backend, 0)
 	for _, backend := range pool.backends {
-		if backend.Active.Load() {
+		if backend.selectable(now) && !exclude[backend] {
 			activeBackends = append(activeBackends, backend)
 		}
 	}
@@ -199,7 +426,7 @@ func (p *ContainerProxy) roundRobinSelect(pool *backendPool) *backend {
 	return activeBackends[idx]
 }
 
-func (p *ContainerProxy) leastConnSelect(pool *backendPool) *backend {
+func (p *ContainerProxy) leastConnSelect(pool *backendPool, exclude map[*backend]bool, now time.Time) *backend {
 	pool.mu.RLock()
 	defer pool.mu.RUnlock()
 
@@ -211,7 +438,7 @@ func (p *ContainerProxy) leastConnSelect(pool *backendPool) *backend {
 	minConn := int64(math.MaxInt64)
 
 	for _, backend := range pool.backends {
-		if !backend.Active.Load() {
+		if !backend.selectable(now) || exclude[backend] {
 			continue
 		}
 
@@ -225,75 +452,12 @@ func (p *ContainerProxy) leastConnSelect(pool *backendPool) *backend {
 	return selected
 }
 
-func (p *ContainerProxy) StartHealthCheck(interval time.Duration) {
-	p.health = &HealthChecker{
-		interval: interval,
-		pools:   p.pools,
-		stop:     make(chan struct{}),
-	}
-
-	go p.health.Run()
-}
-
-func (h *HealthChecker) Run() {
-	ticker := time.NewTicker(h.interval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-h.stop:
-			return
-
-		case <-ticker.C:
-			h.checkAll()
-		}
-	}
-}
-
-func (h *HealthChecker) checkAll() {
-	for containerID, pool := range h.pools {
-		pool.mu.RLock()
-		for _, backend := range pool.backends {
-			go h.checkBackend(containerID, backend)
-		}
-		pool.mu.RUnlock()
-	}
-}
-
-func (h *HealthChecker) checkBackend(containerID string, backend *backend) {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(ctx, "GET", backend.URL.String()+"/_health", nil)
-	if err != nil {
-		backend.Active.Store(false)
-		return
-	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		if backend.Active.Load() {
-			logrus.Warnf("Backend %s for container %s is unhealthy", backend.URL, containerID[:12])
-		}
-		backend.Active.Store(false)
-		return
-	}
-
-	defer resp.Body.Close()
-
-	if !backend.Active.Load() {
-		logrus.Infof("Backend %s for container %s is back online", backend.URL, containerID[:12])
-	}
-	backend.Active.Store(true)
-}
-
-func (h *HealthChecker) Shutdown() {
-	close(h.stop)
-}
-
+// Shutdown drains all backends, waiting up to the drain timeout for in-flight
+// requests, and then stops the proxy's background work and listeners
 func (p *ContainerProxy) Shutdown() {
-	close(p.shutdown)
 	if p.health != nil {
 		p.health.Shutdown()
 	}
+	p.drainAll(p.opts.DrainTimeout)
+	close(p.shutdown)
 }
@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testContainerID = "0123456789abcdef0123456789abcdef"
+
+func newTestBackend(t *testing.T, status int) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(strconv.Itoa(status)))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func addTestBackend(t *testing.T, p *ContainerProxy, server *httptest.Server) {
+	t.Helper()
+	host, portStr, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to parse server URL: %v", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+	if err := p.AddBackend(testContainerID, host, port); err != nil {
+		t.Fatalf("Failed to add backend: %v", err)
+	}
+}
+
+func testOptions() Options {
+	opts := DefaultOptions()
+	opts.OutlierDetection.Interval = 0
+	return opts
+}
+
+func serve(t *testing.T, p *ContainerProxy, method string) int {
+	t.Helper()
+	handler, err := p.GetProxy(testContainerID)
+	if err != nil {
+		t.Fatalf("Failed to get proxy: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, "/", nil))
+	return rec.Code
+}
+
+func TestProxy_RetriesIdempotentOnOtherBackend(t *testing.T) {
+	p := NewContainerProxyWithOptions(RoundRobin, testOptions())
+	defer p.Shutdown()
+
+	addTestBackend(t, p, newTestBackend(t, http.StatusServiceUnavailable))
+	addTestBackend(t, p, newTestBackend(t, http.StatusOK))
+
+	for i := 0; i < 4; i++ {
+		if code := serve(t, p, http.MethodGet); code != http.StatusOK {
+			t.Fatalf("Request %d: expected 200 after retry, got %d", i, code)
+		}
+	}
+
+	metrics := p.Metrics()
+	if len(metrics) != 1 || metrics[0].Retries == 0 {
+		t.Errorf("Expected retries to be recorded, got %+v", metrics)
+	}
+}
+
+func TestProxy_DoesNotRetryNonIdempotent(t *testing.T) {
+	p := NewContainerProxyWithOptions(RoundRobin, testOptions())
+	defer p.Shutdown()
+
+	addTestBackend(t, p, newTestBackend(t, http.StatusServiceUnavailable))
+	addTestBackend(t, p, newTestBackend(t, http.StatusOK))
+
+	codes := map[int]int{}
+	for i := 0; i < 4; i++ {
+		codes[serve(t, p, http.MethodPost)]++
+	}
+
+	if codes[http.StatusServiceUnavailable] == 0 {
+		t.Errorf("POST requests should not be retried, got %v", codes)
+	}
+	if p.Metrics()[0].Retries != 0 {
+		t.Errorf("Expected no retries for POST, got %d", p.Metrics()[0].Retries)
+	}
+}
+
+func TestProxy_NoBackendsReturns503(t *testing.T) {
+	p := NewContainerProxyWithOptions(RoundRobin, testOptions())
+	defer p.Shutdown()
+
+	backend := newTestBackend(t, http.StatusOK)
+	addTestBackend(t, p, backend)
+	p.pools[testContainerID].backends[0].Active.Store(false)
+
+	if code := serve(t, p, http.MethodGet); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 with no active backends, got %d", code)
+	}
+}
+
+func TestCircuitBreaker_OpensAndRecovers(t *testing.T) {
+	cb := newCircuitBreaker(CircuitBreakerConfig{
+		ConsecutiveFailures: 3,
+		OpenTimeout:         time.Second,
+		HalfOpenProbes:      1,
+	})
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !cb.allow(now) {
+			t.Fatalf("Circuit should be closed before threshold")
+		}
+		cb.record(false, now)
+	}
+
+	if state, opens := cb.snapshot(); state != CircuitOpen || opens != 1 {
+		t.Fatalf("Expected open circuit after 3 failures, got %s (%d opens)", state, opens)
+	}
+	if cb.allow(now.Add(500 * time.Millisecond)) {
+		t.Error("Open circuit should reject requests before timeout")
+	}
+
+	probeTime := now.Add(2 * time.Second)
+	if !cb.allow(probeTime) {
+		t.Fatal("Expected a half-open probe after timeout")
+	}
+	if cb.allow(probeTime) {
+		t.Error("Only one half-open probe should be allowed")
+	}
+
+	cb.record(true, probeTime)
+	if state, _ := cb.snapshot(); state != CircuitClosed {
+		t.Errorf("Expected closed circuit after successful probe, got %s", state)
+	}
+}
+
+func TestCircuitBreaker_FailedProbeReopens(t *testing.T) {
+	cb := newCircuitBreaker(CircuitBreakerConfig{ConsecutiveFailures: 1, OpenTimeout: time.Second})
+	now := time.Now()
+
+	cb.record(false, now)
+	probeTime := now.Add(2 * time.Second)
+	if !cb.allow(probeTime) {
+		t.Fatal("Expected a half-open probe after timeout")
+	}
+	cb.record(false, probeTime)
+
+	if state, opens := cb.snapshot(); state != CircuitOpen || opens != 2 {
+		t.Errorf("Expected circuit to reopen, got %s (%d opens)", state, opens)
+	}
+}
+
+func TestOutlierDetection_EjectsFailingBackend(t *testing.T) {
+	opts := testOptions()
+	opts.CircuitBreaker.ConsecutiveFailures = 0
+	opts.OutlierDetection.MinRequests = 4
+	opts.OutlierDetection.MaxEjectionPercent = 50
+
+	p := NewContainerProxyWithOptions(RoundRobin, opts)
+	defer p.Shutdown()
+
+	addTestBackend(t, p, newTestBackend(t, http.StatusOK))
+	addTestBackend(t, p, newTestBackend(t, http.StatusOK))
+
+	pool := p.pools[testContainerID]
+	bad, good := pool.backends[0], pool.backends[1]
+	for i := 0; i < 10; i++ {
+		bad.recordResult(false, time.Now())
+		good.recordResult(true, time.Now())
+	}
+
+	now := time.Now()
+	p.detectOutliers(now)
+
+	if !bad.isEjected(now) {
+		t.Error("Failing backend should be ejected")
+	}
+	if good.isEjected(now) {
+		t.Error("Healthy backend should not be ejected")
+	}
+	if b := p.selectBackend(pool, nil, now); b != good {
+		t.Error("Ejected backend should not be selected")
+	}
+	if !bad.isEjected(now.Add(opts.OutlierDetection.BaseEjectionTime - time.Second)) {
+		t.Error("Backend should stay ejected for the base ejection time")
+	}
+	if bad.isEjected(now.Add(opts.OutlierDetection.BaseEjectionTime + time.Second)) {
+		t.Error("Backend should return after the ejection time")
+	}
+}
+
+func TestOutlierDetection_RespectsMaxEjectionPercent(t *testing.T) {
+	opts := testOptions()
+	opts.OutlierDetection.MinRequests = 1
+	opts.OutlierDetection.MaxEjectionPercent = 50
+
+	p := NewContainerProxyWithOptions(RoundRobin, opts)
+	defer p.Shutdown()
+
+	addTestBackend(t, p, newTestBackend(t, http.StatusOK))
+	addTestBackend(t, p, newTestBackend(t, http.StatusOK))
+
+	pool := p.pools[testContainerID]
+	for _, b := range pool.backends {
+		b.recordResult(false, time.Now())
+	}
+
+	now := time.Now()
+	p.detectOutliers(now)
+
+	ejected := 0
+	for _, b := range pool.backends {
+		if b.isEjected(now) {
+			ejected++
+		}
+	}
+	if ejected != 1 {
+		t.Errorf("Expected exactly 1 ejected backend, got %d", ejected)
+	}
+}
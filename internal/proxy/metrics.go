@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// BackendMetrics is a point-in-time view of a single backend
+type BackendMetrics struct {
+	URL          string `json:"url"`
+	Active       bool   `json:"active"`
+	Ejected      bool   `json:"ejected"`
+	Draining     bool   `json:"draining"`
+	Circuit      string `json:"circuit"`
+	InFlight     int64  `json:"in_flight"`
+	Requests     int64  `json:"requests"`
+	Failures     int64  `json:"failures"`
+	Retries      int64  `json:"retries"`
+	CircuitOpens int64  `json:"circuit_opens"`
+	Ejections    int64  `json:"ejections"`
+}
+
+// PoolMetrics is a point-in-time view of a container's backend pool
+type PoolMetrics struct {
+	ContainerID string           `json:"container_id"`
+	Requests    int64            `json:"requests"`
+	Retries     int64            `json:"retries"`
+	Unavailable int64            `json:"unavailable"`
+	Backends    []BackendMetrics `json:"backends"`
+}
+
+// Metrics returns a snapshot of request, retry, circuit and ejection counters
+func (p *ContainerProxy) Metrics() []PoolMetrics {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	now := time.Now()
+	metrics := make([]PoolMetrics, 0, len(p.pools))
+
+	for containerID, pool := range p.pools {
+		pm := PoolMetrics{
+			ContainerID: containerID,
+			Requests:    pool.requests.Load(),
+			Retries:     pool.retries.Load(),
+			Unavailable: pool.unavailable.Load(),
+		}
+
+		pool.mu.RLock()
+		for _, b := range pool.backends {
+			state, opens := b.breaker.snapshot()
+			pm.Backends = append(pm.Backends, BackendMetrics{
+				URL:          b.URL.String(),
+				Active:       b.Active.Load(),
+				Ejected:      b.isEjected(now),
+				Draining:     b.draining.Load(),
+				Circuit:      state.String(),
+				InFlight:     b.Conn.Load(),
+				Requests:     b.requests.Load(),
+				Failures:     b.failures.Load(),
+				Retries:      b.retries.Load(),
+				CircuitOpens: opens,
+				Ejections:    b.ejections.Load(),
+			})
+		}
+		pool.mu.RUnlock()
+
+		metrics = append(metrics, pm)
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].ContainerID < metrics[j].ContainerID
+	})
+
+	return metrics
+}
+
+// Requests returns the number of requests and connections a container's pool
+// has handled, or 0 if the container has no pool
+func (p *ContainerProxy) Requests(containerID string) int64 {
+	p.mu.RLock()
+	pool, ok := p.pools[containerID]
+	p.mu.RUnlock()
+
+	if !ok {
+		return 0
+	}
+	return pool.requests.Load()
+}
+
+// MetricsHandler serves the proxy metrics as JSON
+func (p *ContainerProxy) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.Metrics())
+	})
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runOutlierDetection periodically ejects backends with a high failure ratio
+func (p *ContainerProxy) runOutlierDetection() {
+	ticker := time.NewTicker(p.opts.OutlierDetection.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.shutdown:
+			return
+		case now := <-ticker.C:
+			p.detectOutliers(now)
+		}
+	}
+}
+
+// detectOutliers evaluates the failures seen since the last sweep
+func (p *ContainerProxy) detectOutliers(now time.Time) {
+	cfg := p.opts.OutlierDetection
+
+	p.mu.RLock()
+	pools := make(map[string]*backendPool, len(p.pools))
+	for id, pool := range p.pools {
+		pools[id] = pool
+	}
+	p.mu.RUnlock()
+
+	for containerID, pool := range pools {
+		pool.mu.RLock()
+		backends := append([]*backend(nil), pool.backends...)
+		pool.mu.RUnlock()
+
+		maxEjected := len(backends) * cfg.MaxEjectionPercent / 100
+		ejected := 0
+		for _, b := range backends {
+			if b.isEjected(now) {
+				ejected++
+			}
+		}
+
+		for _, b := range backends {
+			requests := b.windowRequests.Swap(0)
+			failures := b.windowFailures.Swap(0)
+
+			if b.isEjected(now) || requests == 0 || requests < int64(cfg.MinRequests) {
+				continue
+			}
+
+			if float64(failures)/float64(requests) < cfg.FailureRatio {
+				continue
+			}
+
+			if ejected >= maxEjected {
+				logrus.Debugf("Not ejecting backend %s for container %s: ejection limit reached", b.URL, shortID(containerID))
+				continue
+			}
+
+			until := b.eject(now, cfg.BaseEjectionTime)
+			ejected++
+			logrus.Warnf("Ejected backend %s for container %s until %s (%d/%d requests failed)",
+				b.URL, shortID(containerID), until.Format(time.RFC3339), failures, requests)
+		}
+	}
+}
+
+// eject removes the backend from selection for a growing period of time
+func (b *backend) eject(now time.Time, base time.Duration) time.Time {
+	count := b.ejections.Add(1)
+	if count > maxEjectionMultiplier {
+		count = maxEjectionMultiplier
+	}
+
+	until := now.Add(base * time.Duration(count))
+	b.ejectedUntil.Store(until.UnixNano())
+	return until
+}
+
+// isEjected reports whether the backend is currently ejected
+func (b *backend) isEjected(now time.Time) bool {
+	return now.UnixNano() < b.ejectedUntil.Load()
+}
+
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,253 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried on other backends
+type RetryPolicy struct {
+	// Attempts is the number of retries after the first try (0 disables retries)
+	Attempts int `yaml:"attempts" json:"attempts"`
+	// PerTryTimeout bounds each individual attempt (0 means no extra timeout)
+	PerTryTimeout time.Duration `yaml:"per_try_timeout" json:"per_try_timeout"`
+	// RetryableStatuses lists backend status codes that trigger a retry
+	RetryableStatuses []int `yaml:"retryable_statuses" json:"retryable_statuses"`
+}
+
+// CircuitBreakerConfig controls per-backend circuit breaking
+type CircuitBreakerConfig struct {
+	// ConsecutiveFailures opens the circuit after this many 5xx or connect errors (0 disables)
+	ConsecutiveFailures int `yaml:"consecutive_failures" json:"consecutive_failures"`
+	// OpenTimeout is how long the circuit stays open before allowing probes
+	OpenTimeout time.Duration `yaml:"open_timeout" json:"open_timeout"`
+	// HalfOpenProbes is the number of successful probes required to close the circuit
+	HalfOpenProbes int `yaml:"half_open_probes" json:"half_open_probes"`
+}
+
+// OutlierDetectionConfig controls passive ejection of misbehaving backends
+type OutlierDetectionConfig struct {
+	// Interval between outlier sweeps (0 disables outlier detection)
+	Interval time.Duration `yaml:"interval" json:"interval"`
+	// MinRequests is the minimum request volume per interval before a backend is judged
+	MinRequests int `yaml:"min_requests" json:"min_requests"`
+	// FailureRatio ejects a backend when failures/requests reaches this value
+	FailureRatio float64 `yaml:"failure_ratio" json:"failure_ratio"`
+	// BaseEjectionTime is multiplied by the number of times a backend was ejected
+	BaseEjectionTime time.Duration `yaml:"base_ejection_time" json:"base_ejection_time"`
+	// MaxEjectionPercent caps the share of a pool that can be ejected at once
+	MaxEjectionPercent int `yaml:"max_ejection_percent" json:"max_ejection_percent"`
+}
+
+// Options holds the tunable behaviour of a ContainerProxy
+type Options struct {
+	Retry            RetryPolicy            `yaml:"retry" json:"retry"`
+	CircuitBreaker   CircuitBreakerConfig   `yaml:"circuit_breaker" json:"circuit_breaker"`
+	OutlierDetection OutlierDetectionConfig `yaml:"outlier_detection" json:"outlier_detection"`
+	TLS              TLSOptions             `yaml:"tls" json:"tls"`
+	L4               L4Options              `yaml:"l4" json:"l4"`
+	// DrainTimeout bounds how long removed backends and shutdown wait for in-flight requests
+	DrainTimeout time.Duration `yaml:"drain_timeout" json:"drain_timeout"`
+}
+
+// DefaultOptions returns proxy options with sensible defaults
+func DefaultOptions() Options {
+	return Options{
+		Retry: RetryPolicy{
+			Attempts:          2,
+			PerTryTimeout:     0,
+			RetryableStatuses: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		},
+		CircuitBreaker: CircuitBreakerConfig{
+			ConsecutiveFailures: 5,
+			OpenTimeout:         30 * time.Second,
+			HalfOpenProbes:      1,
+		},
+		OutlierDetection: OutlierDetectionConfig{
+			Interval:           10 * time.Second,
+			MinRequests:        10,
+			FailureRatio:       0.5,
+			BaseEjectionTime:   30 * time.Second,
+			MaxEjectionPercent: 50,
+		},
+		TLS: TLSOptions{
+			ReloadInterval: 30 * time.Second,
+			HTTPSPort:      443,
+		},
+		L4: L4Options{
+			DialTimeout:    5 * time.Second,
+			UDPIdleTimeout: time.Minute,
+		},
+		DrainTimeout: 30 * time.Second,
+	}
+}
+
+// maxEjectionMultiplier bounds how long repeated ejections can grow
+const maxEjectionMultiplier = 10
+
+// isIdempotent reports whether a request may safely be sent to another backend
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+
+	// The body must be replayable for a retry to be possible
+	return req.Body == nil || req.Body == http.NoBody || req.ContentLength == 0 || req.GetBody != nil
+}
+
+func (r RetryPolicy) retryableStatus(code int) bool {
+	for _, status := range r.RetryableStatuses {
+		if status == code {
+			return true
+		}
+	}
+	return false
+}
+
+// CircuitState is the state of a backend's circuit breaker
+type CircuitState int32
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+// String returns the string representation of the state
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// circuitBreaker tracks consecutive failures of a single backend
+type circuitBreaker struct {
+	cfg       CircuitBreakerConfig
+	mu        sync.Mutex
+	state     CircuitState
+	failures  int
+	openedAt  time.Time
+	probes    int // in-flight half-open probes
+	successes int // successful half-open probes
+	opens     int64
+}
+
+func newCircuitBreaker(cfg CircuitBreakerConfig) *circuitBreaker {
+	if cfg.HalfOpenProbes <= 0 {
+		cfg.HalfOpenProbes = 1
+	}
+	return &circuitBreaker{cfg: cfg}
+}
+
+func (cb *circuitBreaker) enabled() bool {
+	return cb.cfg.ConsecutiveFailures > 0
+}
+
+// ready reports whether the breaker would let a request through, without reserving a probe
+func (cb *circuitBreaker) ready(now time.Time) bool {
+	if !cb.enabled() {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitOpen:
+		return now.Sub(cb.openedAt) >= cb.cfg.OpenTimeout
+	case CircuitHalfOpen:
+		return cb.probes < cb.cfg.HalfOpenProbes
+	default:
+		return true
+	}
+}
+
+// allow lets a request through, moving an expired open circuit to half-open
+func (cb *circuitBreaker) allow(now time.Time) bool {
+	if !cb.enabled() {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitOpen:
+		if now.Sub(cb.openedAt) < cb.cfg.OpenTimeout {
+			return false
+		}
+		cb.state = CircuitHalfOpen
+		cb.probes = 0
+		cb.successes = 0
+		fallthrough
+	case CircuitHalfOpen:
+		if cb.probes >= cb.cfg.HalfOpenProbes {
+			return false
+		}
+		cb.probes++
+		return true
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of a request
+func (cb *circuitBreaker) record(success bool, now time.Time) {
+	if !cb.enabled() {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case CircuitHalfOpen:
+		if cb.probes > 0 {
+			cb.probes--
+		}
+		if !success {
+			cb.trip(now)
+			return
+		}
+		cb.successes++
+		if cb.successes >= cb.cfg.HalfOpenProbes {
+			cb.state = CircuitClosed
+			cb.failures = 0
+		}
+	case CircuitClosed:
+		if success {
+			cb.failures = 0
+			return
+		}
+		cb.failures++
+		if cb.failures >= cb.cfg.ConsecutiveFailures {
+			cb.trip(now)
+		}
+	}
+}
+
+func (cb *circuitBreaker) trip(now time.Time) {
+	cb.state = CircuitOpen
+	cb.openedAt = now
+	cb.failures = 0
+	cb.probes = 0
+	cb.successes = 0
+	cb.opens++
+}
+
+func (cb *circuitBreaker) snapshot() (CircuitState, int64) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state, cb.opens
+}
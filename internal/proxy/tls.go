@@ -0,0 +1,357 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	budgiesync "github.com/zarigata/budgie/internal/sync"
+)
+
+// TLSOptions controls TLS termination in the proxy
+type TLSOptions struct {
+	// CertDir holds the local CA and automatically issued certificates
+	CertDir string `yaml:"cert_dir" json:"cert_dir"`
+	// AutoCert issues certificates from the local budgie CA for routes without cert files
+	AutoCert bool `yaml:"auto_cert" json:"auto_cert"`
+	// ReloadInterval is how often certificate files are checked for changes (0 disables)
+	ReloadInterval time.Duration `yaml:"reload_interval" json:"reload_interval"`
+	// HTTPSPort is the public HTTPS port used when redirecting plain HTTP requests
+	HTTPSPort int `yaml:"https_port" json:"https_port"`
+}
+
+// renewBefore is how long before expiry an automatically issued certificate is renewed
+const renewBefore = 30 * 24 * time.Hour
+
+// Route maps an incoming host name to a container's backend pool
+type Route struct {
+	Host          string `yaml:"host" json:"host"`
+	ContainerID   string `yaml:"container_id" json:"container_id"`
+	CertFile      string `yaml:"cert_file" json:"cert_file"`
+	KeyFile       string `yaml:"key_file" json:"key_file"`
+	RedirectHTTPS bool   `yaml:"redirect_https" json:"redirect_https"`
+}
+
+// AddRoute registers a host route, loading its certificate if one is configured
+func (p *ContainerProxy) AddRoute(route Route) error {
+	route.Host = strings.ToLower(route.Host)
+	if route.Host == "" {
+		return fmt.Errorf("route host is required")
+	}
+	if route.ContainerID == "" {
+		return fmt.Errorf("route container ID is required")
+	}
+	if (route.CertFile == "") != (route.KeyFile == "") {
+		return fmt.Errorf("route %s: cert_file and key_file must be set together", route.Host)
+	}
+
+	if route.CertFile != "" {
+		if err := p.certs.load(route.Host, route.CertFile, route.KeyFile, false); err != nil {
+			return fmt.Errorf("route %s: %w", route.Host, err)
+		}
+	}
+
+	p.mu.Lock()
+	p.routes[route.Host] = &route
+	p.mu.Unlock()
+
+	logrus.Infof("Added route %s -> container %s", route.Host, shortID(route.ContainerID))
+	return nil
+}
+
+// RemoveRoute removes a host route and forgets its certificate
+func (p *ContainerProxy) RemoveRoute(host string) {
+	host = strings.ToLower(host)
+
+	p.mu.Lock()
+	delete(p.routes, host)
+	p.mu.Unlock()
+
+	p.certs.remove(host)
+}
+
+func (p *ContainerProxy) lookupRoute(host string) *Route {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if route, ok := p.routes[host]; ok {
+		return route
+	}
+	if i := strings.IndexByte(host, '.'); i > 0 {
+		return p.routes["*"+host[i:]]
+	}
+	return nil
+}
+
+// Handler returns an http.Handler that routes requests by host name, for use on
+// both the plain HTTP and the TLS listener
+func (p *ContainerProxy) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.ToLower(r.Host)
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+
+		route := p.lookupRoute(host)
+		if route == nil {
+			http.Error(w, "No route for host", http.StatusNotFound)
+			return
+		}
+
+		if r.TLS == nil && route.RedirectHTTPS {
+			target := "https://" + host
+			if port := p.opts.TLS.HTTPSPort; port != 0 && port != 443 {
+				target = "https://" + net.JoinHostPort(host, strconv.Itoa(port))
+			}
+			http.Redirect(w, r, target+r.URL.RequestURI(), http.StatusPermanentRedirect)
+			return
+		}
+
+		handler, err := p.GetProxy(route.ContainerID)
+		if err != nil {
+			http.Error(w, "No backends available", http.StatusServiceUnavailable)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// TLSConfig returns a TLS configuration that selects certificates by SNI
+func (p *ContainerProxy) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: p.getCertificate,
+	}
+}
+
+func (p *ContainerProxy) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := strings.ToLower(hello.ServerName)
+	if host == "" {
+		return nil, fmt.Errorf("client did not send a server name")
+	}
+
+	if cert := p.certs.lookup(host); cert != nil {
+		return cert, nil
+	}
+
+	// Only issue certificates for hosts we actually route. A wildcard route
+	// gets one wildcard certificate rather than one per name it matches, so
+	// that clients cannot grow the store by making names up.
+	if route := p.lookupRoute(host); p.opts.TLS.AutoCert && route != nil {
+		return p.certs.issue(route.Host)
+	}
+
+	return nil, fmt.Errorf("no certificate for %s", host)
+}
+
+// runCertReload periodically reloads changed certificate files
+func (p *ContainerProxy) runCertReload() {
+	ticker := time.NewTicker(p.opts.TLS.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.shutdown:
+			return
+		case <-ticker.C:
+			p.certs.reload(time.Now())
+		}
+	}
+}
+
+// certStore holds the certificates served by the proxy, keyed by host
+type certStore struct {
+	opts    TLSOptions
+	mu      sync.RWMutex
+	entries map[string]*certEntry
+	ca      *budgiesync.CA
+}
+
+type certEntry struct {
+	certFile string
+	keyFile  string
+	modTime  time.Time
+	notAfter time.Time
+	auto     bool
+	cert     *tls.Certificate
+}
+
+func newCertStore(opts TLSOptions) *certStore {
+	return &certStore{
+		opts:    opts,
+		entries: make(map[string]*certEntry),
+	}
+}
+
+func (s *certStore) load(host, certFile, keyFile string, auto bool) error {
+	entry, err := loadCertEntry(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	entry.auto = auto
+
+	s.mu.Lock()
+	s.entries[host] = entry
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *certStore) remove(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, host)
+}
+
+func (s *certStore) lookup(host string) *tls.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if entry, ok := s.entries[host]; ok {
+		return entry.cert
+	}
+	if i := strings.IndexByte(host, '.'); i > 0 {
+		if entry, ok := s.entries["*"+host[i:]]; ok {
+			return entry.cert
+		}
+	}
+	return nil
+}
+
+// issue returns a certificate for host signed by the local budgie CA
+func (s *certStore) issue(host string) (*tls.Certificate, error) {
+	if s.opts.CertDir == "" {
+		return nil, fmt.Errorf("automatic certificates require a cert directory")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Another handshake may have issued it while we waited for the lock
+	if entry, ok := s.entries[host]; ok {
+		return entry.cert, nil
+	}
+
+	certFile, keyFile := s.autoCertPaths(host)
+
+	// Reuse a certificate issued by a previous run if it is still fresh
+	if entry, err := loadCertEntry(certFile, keyFile); err == nil && time.Until(entry.notAfter) > renewBefore {
+		entry.auto = true
+		s.entries[host] = entry
+		return entry.cert, nil
+	}
+
+	if err := s.issueFiles(host, certFile, keyFile); err != nil {
+		return nil, err
+	}
+
+	entry, err := loadCertEntry(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	entry.auto = true
+	s.entries[host] = entry
+
+	return entry.cert, nil
+}
+
+// issueFiles writes a fresh CA-signed certificate for host; s.mu must be held
+func (s *certStore) issueFiles(host, certFile, keyFile string) error {
+	if s.ca == nil {
+		ca, err := budgiesync.LoadOrCreateCA(s.opts.CertDir)
+		if err != nil {
+			return fmt.Errorf("failed to load local CA: %w", err)
+		}
+		s.ca = ca
+	}
+
+	return s.ca.IssueCertificate([]string{host}, budgiesync.DefaultLeafValidity, certFile, keyFile)
+}
+
+func (s *certStore) autoCertPaths(host string) (string, string) {
+	name := strings.ReplaceAll(host, "*", "_wildcard")
+	dir := filepath.Join(s.opts.CertDir, "hosts")
+	return filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+}
+
+// reload picks up changed certificate files and renews expiring automatic certificates
+func (s *certStore) reload(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for host, entry := range s.entries {
+		if entry.auto && entry.notAfter.Sub(now) < renewBefore {
+			logrus.Infof("Renewing certificate for %s", host)
+			if err := s.issueFiles(host, entry.certFile, entry.keyFile); err != nil {
+				logrus.Errorf("Failed to renew certificate for %s: %v", host, err)
+				continue
+			}
+		} else if modTime, err := certModTime(entry.certFile, entry.keyFile); err != nil || !modTime.After(entry.modTime) {
+			continue
+		}
+
+		updated, err := loadCertEntry(entry.certFile, entry.keyFile)
+		if err != nil {
+			// Keep serving the old certificate until the files are valid again
+			logrus.Warnf("Failed to reload certificate for %s: %v", host, err)
+			continue
+		}
+		updated.auto = entry.auto
+		s.entries[host] = updated
+		logrus.Infof("Reloaded certificate for %s", host)
+	}
+}
+
+func loadCertEntry(certFile, keyFile string) (*certEntry, error) {
+	modTime, err := certModTime(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	cert.Leaf = leaf
+
+	return &certEntry{
+		certFile: certFile,
+		keyFile:  keyFile,
+		modTime:  modTime,
+		notAfter: leaf.NotAfter,
+		cert:     &cert,
+	}, nil
+}
+
+// certModTime returns the most recent modification time of a cert/key pair
+func certModTime(certFile, keyFile string) (time.Time, error) {
+	certInfo, err := os.Stat(certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
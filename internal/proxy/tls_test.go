@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	budgiesync "github.com/zarigata/budgie/internal/sync"
+)
+
+func newTLSTestProxy(t *testing.T) (*ContainerProxy, string) {
+	t.Helper()
+	certDir := t.TempDir()
+
+	opts := testOptions()
+	opts.TLS.CertDir = certDir
+	opts.TLS.AutoCert = true
+	opts.TLS.ReloadInterval = 0
+
+	p := NewContainerProxyWithOptions(RoundRobin, opts)
+	t.Cleanup(p.Shutdown)
+
+	addTestBackend(t, p, newTestBackend(t, http.StatusOK))
+	return p, certDir
+}
+
+func TestProxy_AutoCertTLSTermination(t *testing.T) {
+	p, certDir := newTLSTestProxy(t)
+
+	if err := p.AddRoute(Route{Host: "app.budgie.local", ContainerID: testContainerID}); err != nil {
+		t.Fatalf("Failed to add route: %v", err)
+	}
+
+	server := httptest.NewUnstartedServer(p.Handler())
+	server.TLS = p.TLSConfig()
+	server.StartTLS()
+	defer server.Close()
+
+	ca, err := budgiesync.LoadOrCreateCA(certDir)
+	if err != nil {
+		t.Fatalf("Failed to load CA: %v", err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: ca.CertPool(), ServerName: "app.budgie.local"},
+	}}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	req.Host = "app.budgie.local"
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("TLS request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200, got %d", resp.StatusCode)
+	}
+	if name := resp.TLS.PeerCertificates[0].Subject.CommonName; name != "app.budgie.local" {
+		t.Errorf("Expected certificate for app.budgie.local, got %s", name)
+	}
+}
+
+func TestProxy_NoCertificateForUnknownHost(t *testing.T) {
+	p, _ := newTLSTestProxy(t)
+
+	if _, err := p.getCertificate(&tls.ClientHelloInfo{ServerName: "unknown.local"}); err == nil {
+		t.Error("Expected no certificate to be issued for a host without a route")
+	}
+}
+
+func TestProxy_AutoCertIssuesOneCertificatePerWildcardRoute(t *testing.T) {
+	p, certDir := newTLSTestProxy(t)
+
+	if err := p.AddRoute(Route{Host: "*.budgie.local", ContainerID: testContainerID}); err != nil {
+		t.Fatalf("Failed to add route: %v", err)
+	}
+
+	var first *tls.Certificate
+	for _, host := range []string{"a.budgie.local", "b.budgie.local", "made-up.budgie.local"} {
+		cert, err := p.getCertificate(&tls.ClientHelloInfo{ServerName: host})
+		if err != nil {
+			t.Fatalf("Failed to get certificate for %s: %v", host, err)
+		}
+		if err := cert.Leaf.VerifyHostname(host); err != nil {
+			t.Errorf("Expected the certificate to cover %s: %v", host, err)
+		}
+		if first == nil {
+			first = cert
+		} else if cert != first {
+			t.Errorf("Expected %s to share the wildcard certificate", host)
+		}
+	}
+
+	if n := len(p.certs.entries); n != 1 {
+		t.Errorf("Expected one cached certificate, got %d", n)
+	}
+	files, _ := filepath.Glob(filepath.Join(certDir, "hosts", "*.crt"))
+	if len(files) != 1 {
+		t.Errorf("Expected one issued certificate file, got %v", files)
+	}
+}
+
+func TestProxy_RedirectsToHTTPS(t *testing.T) {
+	p, _ := newTLSTestProxy(t)
+	p.opts.TLS.HTTPSPort = 8443
+
+	p.AddRoute(Route{Host: "secure.local", ContainerID: testContainerID, RedirectHTTPS: true})
+	p.AddRoute(Route{Host: "plain.local", ContainerID: testContainerID})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "http://secure.local/path?q=1", nil)
+	p.Handler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPermanentRedirect {
+		t.Fatalf("Expected redirect, got %d", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); loc != "https://secure.local:8443/path?q=1" {
+		t.Errorf("Unexpected redirect location: %s", loc)
+	}
+
+	rec = httptest.NewRecorder()
+	p.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://plain.local/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected plain HTTP route to be proxied, got %d", rec.Code)
+	}
+}
+
+func TestCertStore_ReloadsChangedFiles(t *testing.T) {
+	certDir := t.TempDir()
+	ca, err := budgiesync.LoadOrCreateCA(certDir)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+
+	certFile := filepath.Join(certDir, "site.crt")
+	keyFile := filepath.Join(certDir, "site.key")
+	if err := ca.IssueCertificate([]string{"site.local"}, time.Hour, certFile, keyFile); err != nil {
+		t.Fatalf("Failed to issue certificate: %v", err)
+	}
+
+	store := newCertStore(TLSOptions{})
+	if err := store.load("site.local", certFile, keyFile, false); err != nil {
+		t.Fatalf("Failed to load certificate: %v", err)
+	}
+	before := store.lookup("site.local")
+
+	// Make sure the new files get a later modification time
+	time.Sleep(20 * time.Millisecond)
+	if err := ca.IssueCertificate([]string{"site.local"}, 2*time.Hour, certFile, keyFile); err != nil {
+		t.Fatalf("Failed to reissue certificate: %v", err)
+	}
+	store.reload(time.Now())
+
+	after := store.lookup("site.local")
+	if after == before || after.Leaf.NotAfter.Equal(before.Leaf.NotAfter) {
+		t.Error("Expected certificate to be reloaded after the files changed")
+	}
+}
@@ -3,6 +3,7 @@ package runtime
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"syscall"
@@ -23,9 +24,12 @@ type Runtime interface {
 	Create(ctx context.Context, ctr *types.Container) error
 	Start(ctx context.Context, id string) error
 	Stop(ctx context.Context, id string, timeout time.Duration) error
+	Pause(ctx context.Context, id string) error
+	Resume(ctx context.Context, id string) error
 	Delete(ctx context.Context, id string) error
 	Exists(id string) bool
 	Status(ctx context.Context, id string) (string, error)
+	Stats(ctx context.Context, id string) (*ContainerStats, error)
 	Logs(ctx context.Context, id string, follow bool, tail int) (LogReader, error)
 	Exec(ctx context.Context, id string, cmd []string, stdin bool) (int, error)
 	ExecWithOptions(ctx context.Context, id string, opts ExecOptions) (int, error)
@@ -51,13 +55,15 @@ type LogReader interface {
 
 // ExecOptions configures command execution in a container
 type ExecOptions struct {
-	Cmd         []string // Command and arguments to execute
-	Interactive bool     // Keep STDIN open
-	TTY         bool     // Allocate a pseudo-TTY
-	Detach      bool     // Run in background
-	User        string   // User to run as (username or UID)
-	WorkDir     string   // Working directory inside container
-	Env         []string // Environment variables (KEY=VALUE format)
+	Cmd         []string  // Command and arguments to execute
+	Interactive bool      // Keep STDIN open
+	TTY         bool      // Allocate a pseudo-TTY
+	Detach      bool      // Run in background
+	User        string    // User to run as (username or UID)
+	WorkDir     string    // Working directory inside container
+	Env         []string  // Environment variables (KEY=VALUE format)
+	Stdout      io.Writer // Non-interactive output; defaults to os.Stdout
+	Stderr      io.Writer // Non-interactive errors; defaults to os.Stderr
 }
 
 type containerdRuntime struct {
@@ -291,6 +297,47 @@ func (r *containerdRuntime) Stop(ctx context.Context, id string, timeout time.Du
 	return nil
 }
 
+// Pause freezes the processes of a container's task
+func (r *containerdRuntime) Pause(ctx context.Context, id string) error {
+	task, err := r.task(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := task.Pause(ctx); err != nil {
+		return fmt.Errorf("failed to pause task: %w", err)
+	}
+
+	logrus.Infof("Paused container %s", id[:12])
+	return nil
+}
+
+// Resume thaws the processes of a task paused by Pause
+func (r *containerdRuntime) Resume(ctx context.Context, id string) error {
+	task, err := r.task(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := task.Resume(ctx); err != nil {
+		return fmt.Errorf("failed to resume task: %w", err)
+	}
+
+	logrus.Infof("Resumed container %s", id[:12])
+	return nil
+}
+
+// task loads the running task of a container
+func (r *containerdRuntime) task(ctx context.Context, id string) (containerd.Task, error) {
+	container, err := r.client.LoadContainer(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load container: %w", err)
+	}
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("container %s has no running task: %w", id[:12], err)
+	}
+	return task, nil
+}
+
 func (r *containerdRuntime) Delete(ctx context.Context, id string) error {
 	container, err := r.client.LoadContainer(ctx, id)
 	if err != nil {
@@ -526,7 +573,14 @@ func (r *containerdRuntime) ExecWithOptions(ctx context.Context, id string, opts
 	if opts.Interactive {
 		ioCreator = cio.NewCreator(cio.WithStdio)
 	} else {
-		ioCreator = cio.NewCreator(cio.WithStreams(nil, os.Stdout, os.Stderr))
+		stdout, stderr := opts.Stdout, opts.Stderr
+		if stdout == nil {
+			stdout = os.Stdout
+		}
+		if stderr == nil {
+			stderr = os.Stderr
+		}
+		ioCreator = cio.NewCreator(cio.WithStreams(nil, stdout, stderr))
 	}
 
 	process, err := task.Exec(ctx, execID, &execSpec, ioCreator)
@@ -0,0 +1,147 @@
+package runtime
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cgroupRoot is where the cgroup filesystem is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+// ContainerStats is a sample of a container's cgroup resource usage
+type ContainerStats struct {
+	CPUUsage    time.Duration `json:"cpu_usage"`    // Cumulative CPU time
+	MemoryUsage int64         `json:"memory_usage"` // Bytes
+	MemoryLimit int64         `json:"memory_limit"` // Bytes, 0 when unlimited
+	Timestamp   time.Time     `json:"timestamp"`
+}
+
+func (r *containerdRuntime) Stats(ctx context.Context, id string) (*ContainerStats, error) {
+	container, err := r.client.LoadContainer(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load container: %w", err)
+	}
+
+	task, err := container.Task(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("container is not running: %w", err)
+	}
+
+	return readCgroupStats(filepath.Join("/proc", strconv.Itoa(int(task.Pid())), "cgroup"))
+}
+
+// readCgroupStats reads the usage of the cgroup a process belongs to, given
+// its /proc/<pid>/cgroup file. Both cgroup v2 and v1 hierarchies are supported.
+func readCgroupStats(procCgroup string) (*ContainerStats, error) {
+	paths, err := parseProcCgroup(procCgroup)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &ContainerStats{Timestamp: time.Now()}
+
+	// cgroup v2: a single unified hierarchy
+	if path, ok := paths[""]; ok {
+		dir := filepath.Join(cgroupRoot, path)
+		usec, err := readKeyedValue(filepath.Join(dir, "cpu.stat"), "usage_usec")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CPU usage: %w", err)
+		}
+		stats.CPUUsage = time.Duration(usec) * time.Microsecond
+
+		if stats.MemoryUsage, err = readIntFile(filepath.Join(dir, "memory.current")); err != nil {
+			return nil, fmt.Errorf("failed to read memory usage: %w", err)
+		}
+		stats.MemoryLimit, _ = readIntFile(filepath.Join(dir, "memory.max"))
+		return stats, nil
+	}
+
+	cpuPath, ok := paths["cpuacct"]
+	if !ok {
+		return nil, fmt.Errorf("no cpuacct cgroup found")
+	}
+	nsec, err := readIntFile(filepath.Join(cgroupRoot, "cpuacct", cpuPath, "cpuacct.usage"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CPU usage: %w", err)
+	}
+	stats.CPUUsage = time.Duration(nsec)
+
+	memPath, ok := paths["memory"]
+	if !ok {
+		return nil, fmt.Errorf("no memory cgroup found")
+	}
+	memDir := filepath.Join(cgroupRoot, "memory", memPath)
+	if stats.MemoryUsage, err = readIntFile(filepath.Join(memDir, "memory.usage_in_bytes")); err != nil {
+		return nil, fmt.Errorf("failed to read memory usage: %w", err)
+	}
+	stats.MemoryLimit, _ = readIntFile(filepath.Join(memDir, "memory.limit_in_bytes"))
+	// v1 reports "unlimited" as a huge page-aligned number
+	if stats.MemoryLimit >= 1<<62 {
+		stats.MemoryLimit = 0
+	}
+
+	return stats, nil
+}
+
+// parseProcCgroup maps each controller to its cgroup path; the v2 unified
+// hierarchy is stored under the empty controller name
+func parseProcCgroup(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cgroup membership: %w", err)
+	}
+	defer f.Close()
+
+	paths := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Format: hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[1] == "" {
+			paths[""] = parts[2]
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+		}
+	}
+
+	return paths, scanner.Err()
+}
+
+// readIntFile reads a file holding a single integer; "max" reads as 0
+func readIntFile(path string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// readKeyedValue reads one "key value" line from a flat-keyed cgroup file
+func readKeyedValue(path, key string) (int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == key {
+			return strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("%s not found in %s", key, path)
+}
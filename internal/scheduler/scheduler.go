@@ -0,0 +1,138 @@
+// Package scheduler picks the node a container should run on, based on the
+// capacity nodes advertise through discovery.
+package scheduler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/internal/placement"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// cpuQuotaPeriod is the CFS period CPU quotas are expressed against, in microseconds
+const cpuQuotaPeriod = 100000
+
+// Request describes what a container needs from a node
+type Request struct {
+	Service   string
+	Resources *types.ResourceLimits
+	Placement *types.PlacementConfig
+}
+
+// RequestFor builds a scheduling request from a container configuration,
+// adding extra label constraints to the bundle's placement
+func RequestFor(ctr *types.Container, constraints []string) Request {
+	return Request{
+		Service:   ctr.Name,
+		Resources: ctr.Resources,
+		Placement: placement.WithConstraints(ctr.Placement, constraints),
+	}
+}
+
+// Services maps node IDs to the services they run and their instance counts
+type Services map[string]map[string]int
+
+// Add records an instance of service on a node
+func (s Services) Add(nodeID, service string) {
+	if s[nodeID] == nil {
+		s[nodeID] = make(map[string]int)
+	}
+	s[nodeID][service]++
+}
+
+// CPURequest returns the cores a container's resources ask for
+func CPURequest(resources *types.ResourceLimits) float64 {
+	if resources == nil || resources.CPUQuota <= 0 {
+		return 0
+	}
+	return float64(resources.CPUQuota) / cpuQuotaPeriod
+}
+
+// MemoryRequest returns the bytes a container's resources ask for
+func MemoryRequest(resources *types.ResourceLimits) int64 {
+	if resources == nil {
+		return 0
+	}
+	return resources.MemoryLimit
+}
+
+// Placement is a node chosen for a request
+type Placement struct {
+	Node  discovery.NodeInfo
+	Score float64
+}
+
+// Schedule picks the best node for a request. running holds the services each
+// node already runs, for affinity rules and to spread replicas across nodes.
+func Schedule(nodes []discovery.NodeInfo, req Request, running Services) (*Placement, error) {
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no nodes available")
+	}
+
+	var candidates []Placement
+	var rejected []string
+
+	for _, node := range nodes {
+		if reason := fits(node, req, running[node.ID]); reason != "" {
+			rejected = append(rejected, fmt.Sprintf("%s: %s", node.ID, reason))
+			continue
+		}
+		candidates = append(candidates, Placement{Node: node, Score: score(node, req, running[node.ID][req.Service])})
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no node can run %s (%s)", req.Service, strings.Join(rejected, "; "))
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].Node.ID < candidates[j].Node.ID
+	})
+
+	return &candidates[0], nil
+}
+
+// fits returns why a node cannot take the request, or "" if it can
+func fits(node discovery.NodeInfo, req Request, services map[string]int) string {
+	if node.Cordoned {
+		return "cordoned"
+	}
+
+	if err := placement.Check(req.Placement, node.Labels, services); err != nil {
+		return err.Error()
+	}
+
+	if mem := MemoryRequest(req.Resources); mem > 0 && mem > node.MemoryAvailable {
+		return fmt.Sprintf("needs %d bytes of memory, %d available", mem, node.MemoryAvailable)
+	}
+
+	if cpu := CPURequest(req.Resources); cpu > 0 && node.CPUs > 0 {
+		if free := float64(node.CPUs) - node.CPUReserved; cpu > free {
+			return fmt.Sprintf("needs %.2f CPUs, %.2f free", cpu, free)
+		}
+	}
+
+	return ""
+}
+
+// score ranks nodes that fit. Spreading replicas dominates, then free memory
+// and CPU after placement, then how busy the node is.
+func score(node discovery.NodeInfo, req Request, replicas int) float64 {
+	s := -100 * float64(replicas)
+
+	if node.MemoryTotal > 0 {
+		free := node.MemoryAvailable - MemoryRequest(req.Resources)
+		s += 10 * float64(free) / float64(node.MemoryTotal)
+	}
+	if node.CPUs > 0 {
+		free := float64(node.CPUs) - node.CPUReserved - CPURequest(req.Resources)
+		s += 10 * free / float64(node.CPUs)
+	}
+
+	return s - 0.1*float64(node.Containers)
+}
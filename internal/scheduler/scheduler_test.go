@@ -0,0 +1,131 @@
+package scheduler
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zarigata/budgie/internal/discovery"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+const gib = 1 << 30
+
+func testNodes() []discovery.NodeInfo {
+	return []discovery.NodeInfo{
+		{ID: "small", CPUs: 2, MemoryTotal: 4 * gib, MemoryAvailable: 1 * gib, Labels: map[string]string{"zone": "a"}},
+		{ID: "large", CPUs: 8, MemoryTotal: 32 * gib, MemoryAvailable: 24 * gib, Labels: map[string]string{"zone": "b", "disk": "ssd"}},
+		{ID: "busy", CPUs: 4, CPUReserved: 3.5, MemoryTotal: 16 * gib, MemoryAvailable: 12 * gib, Labels: map[string]string{"zone": "a"}},
+	}
+}
+
+func TestSchedule_PrefersFreeCapacity(t *testing.T) {
+	placement, err := Schedule(testNodes(), Request{Service: "web"}, nil)
+	if err != nil {
+		t.Fatalf("Schedule failed: %v", err)
+	}
+	if placement.Node.ID != "large" {
+		t.Errorf("Expected the node with most free capacity, got %s", placement.Node.ID)
+	}
+}
+
+func TestSchedule_HonorsResources(t *testing.T) {
+	req := Request{Service: "db", Resources: &types.ResourceLimits{MemoryLimit: 30 * gib}}
+	if _, err := Schedule(testNodes(), req, nil); err == nil {
+		t.Error("Expected no node to fit 30GiB")
+	}
+
+	req = Request{Service: "db", Resources: &types.ResourceLimits{CPUQuota: 100000}}
+	nodes := testNodes()[2:]
+	_, err := Schedule(nodes, req, nil)
+	if err == nil || !strings.Contains(err.Error(), "CPUs") {
+		t.Errorf("Expected a CPU shortage to be reported, got %v", err)
+	}
+}
+
+func TestSchedule_SkipsCordonedNodes(t *testing.T) {
+	nodes := testNodes()
+	nodes[1].Cordoned = true
+
+	placement, err := Schedule(nodes, Request{Service: "web"}, nil)
+	if err != nil {
+		t.Fatalf("Schedule failed: %v", err)
+	}
+	if placement.Node.ID == "large" {
+		t.Error("Expected the cordoned node to be skipped")
+	}
+
+	_, err = Schedule(nodes[1:2], Request{Service: "web"}, nil)
+	if err == nil || !strings.Contains(err.Error(), "cordoned") {
+		t.Errorf("Expected a cordoned node to be reported, got %v", err)
+	}
+}
+
+func TestSchedule_HonorsConstraints(t *testing.T) {
+	req := Request{Service: "web", Placement: &types.PlacementConfig{Constraints: []string{"zone==a"}}}
+	placement, err := Schedule(testNodes(), req, nil)
+	if err != nil {
+		t.Fatalf("Schedule failed: %v", err)
+	}
+	if placement.Node.Labels["zone"] != "a" {
+		t.Errorf("Expected a node in zone a, got %s", placement.Node.ID)
+	}
+
+	req.Placement = &types.PlacementConfig{Constraints: []string{"gpu==true"}}
+	if _, err := Schedule(testNodes(), req, nil); err == nil {
+		t.Error("Expected no node to match gpu=true")
+	}
+}
+
+func TestSchedule_SpreadsReplicas(t *testing.T) {
+	running := Services{}
+	running.Add("large", "web")
+	placement, err := Schedule(testNodes(), Request{Service: "web"}, running)
+	if err != nil {
+		t.Fatalf("Schedule failed: %v", err)
+	}
+	if placement.Node.ID == "large" {
+		t.Error("Expected the second replica to go to another node")
+	}
+}
+
+func TestSchedule_NoNodes(t *testing.T) {
+	if _, err := Schedule(nil, Request{Service: "web"}, nil); err == nil {
+		t.Error("Expected an error without nodes")
+	}
+}
+
+func TestSchedule_Affinity(t *testing.T) {
+	running := Services{}
+	running.Add("small", "db")
+
+	req := Request{Service: "api", Placement: &types.PlacementConfig{Affinity: []string{"db"}}}
+	placement, err := Schedule(testNodes(), req, running)
+	if err != nil {
+		t.Fatalf("Schedule failed: %v", err)
+	}
+	if placement.Node.ID != "small" {
+		t.Errorf("Expected api next to db on small, got %s", placement.Node.ID)
+	}
+
+	req.Placement = &types.PlacementConfig{AntiAffinity: []string{"db"}}
+	running.Add("large", "db")
+	placement, err = Schedule(testNodes(), req, running)
+	if err != nil {
+		t.Fatalf("Schedule failed: %v", err)
+	}
+	if placement.Node.ID != "busy" {
+		t.Errorf("Expected api away from db, got %s", placement.Node.ID)
+	}
+}
+
+func TestRequestFor_MergesConstraints(t *testing.T) {
+	ctr := &types.Container{Name: "web", Placement: &types.PlacementConfig{Constraints: []string{"arch==arm64"}}}
+	req := RequestFor(ctr, []string{"disk==ssd"})
+
+	if len(req.Placement.Constraints) != 2 {
+		t.Errorf("Expected 2 constraints, got %v", req.Placement.Constraints)
+	}
+	if len(ctr.Placement.Constraints) != 1 {
+		t.Errorf("Expected the container placement to be unchanged, got %v", ctr.Placement.Constraints)
+	}
+}
@@ -0,0 +1,109 @@
+package sync
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// minBurst is the least a Limiter lets through at once
+const minBurst = 4 << 10
+
+// Limiter caps the combined bandwidth of the connections it is applied to
+// with a token bucket. Tokens accumulate at the rate up to a tenth of a
+// second's worth, so short bursts are smoothed out but idle time is not
+// saved up. A nil Limiter does not limit.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter allowing bytesPerSecond, or nil when
+// bytesPerSecond is not positive
+func NewLimiter(bytesPerSecond int64) *Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	burst := float64(bytesPerSecond) / 10
+	if burst < minBurst {
+		burst = minBurst
+	}
+	return &Limiter{
+		rate:   float64(bytesPerSecond),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// Conn limits reads from and writes to rw
+func (l *Limiter) Conn(rw io.ReadWriter) io.ReadWriter {
+	if l == nil {
+		return rw
+	}
+	return &limitedConn{rw: rw, limiter: l}
+}
+
+// wait takes n tokens, sleeping until they have accumulated. Tokens may be
+// taken ahead of time, which makes later callers wait longer, so the
+// connections sharing the limiter stay under the rate together.
+func (l *Limiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// chunk is how much a limited connection reads or writes at once
+func (l *Limiter) chunk() int {
+	return int(l.burst)
+}
+
+// limitedConn is a connection whose traffic is limited
+type limitedConn struct {
+	rw      io.ReadWriter
+	limiter *Limiter
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	if len(p) > c.limiter.chunk() {
+		p = p[:c.limiter.chunk()]
+	}
+	n, err := c.rw.Read(p)
+	if n > 0 {
+		c.limiter.wait(n)
+	}
+	return n, err
+}
+
+func (c *limitedConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > c.limiter.chunk() {
+			chunk = chunk[:c.limiter.chunk()]
+		}
+		c.limiter.wait(len(chunk))
+		n, err := c.rw.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
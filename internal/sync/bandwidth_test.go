@@ -0,0 +1,49 @@
+package sync
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// discard is a connection that drops what is written to it
+type discard struct {
+	io.Reader
+	io.Writer
+}
+
+func TestLimiter_CapsCombinedBandwidth(t *testing.T) {
+	const rate = 1 << 20
+	l := NewLimiter(rate)
+
+	// Two connections share the limit
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := l.Conn(discard{Writer: io.Discard})
+			conn.Write(make([]byte, rate/4))
+		}()
+	}
+	wg.Wait()
+
+	// Half a second of data, less the initial burst of a tenth of a second
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond {
+		t.Errorf("Expected %d bytes at %d bytes/s to take at least 350ms, took %v", rate/2, rate, elapsed)
+	}
+}
+
+func TestLimiter_NilDoesNotLimit(t *testing.T) {
+	l := NewLimiter(0)
+	if l != nil {
+		t.Fatalf("Expected no limiter for a zero rate, got %+v", l)
+	}
+
+	rw := discard{Writer: io.Discard}
+	if conn := l.Conn(rw); conn != io.ReadWriter(rw) {
+		t.Error("Expected a nil limiter to return the connection unchanged")
+	}
+}
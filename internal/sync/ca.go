@@ -0,0 +1,197 @@
+package sync
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	caCertName = "budgie-ca.crt"
+	caKeyName  = "budgie-ca.key"
+
+	// DefaultLeafValidity is how long certificates issued by the local CA are valid
+	DefaultLeafValidity = 90 * 24 * time.Hour
+)
+
+// CA is a local certificate authority used to issue certificates for budgie services
+type CA struct {
+	Cert     *x509.Certificate
+	CertFile string
+	key      *ecdsa.PrivateKey
+}
+
+// LoadOrCreateCA loads the local budgie CA from certDir, creating it if needed
+func LoadOrCreateCA(certDir string) (*CA, error) {
+	if err := os.MkdirAll(certDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cert directory: %w", err)
+	}
+
+	certFile := filepath.Join(certDir, caCertName)
+	keyFile := filepath.Join(certDir, caKeyName)
+
+	if _, err := os.Stat(certFile); err == nil {
+		return loadCA(certFile, keyFile)
+	}
+
+	logrus.Info("Generating local budgie certificate authority...")
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA private key: %w", err)
+	}
+
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "localhost"
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{"Budgie"},
+			CommonName:   fmt.Sprintf("Budgie Local CA (%s)", hostname),
+		},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour), // 10 years
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+
+	if err := writeCertAndKey(certFile, keyFile, certDER, privateKey); err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+
+	logrus.Infof("Generated local CA certificate: %s", certFile)
+	return &CA{Cert: cert, CertFile: certFile, key: privateKey}, nil
+}
+
+func loadCA(certFile, keyFile string) (*CA, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA certificate: %w", err)
+	}
+
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported CA key type in %s", keyFile)
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+
+	if !cert.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate", certFile)
+	}
+
+	return &CA{Cert: cert, CertFile: certFile, key: key}, nil
+}
+
+// IssueCertificate issues a leaf certificate for the given DNS names and IPs,
+// writing it to certFile and keyFile
+func (ca *CA) IssueCertificate(hosts []string, validFor time.Duration, certFile, keyFile string) error {
+	if len(hosts) == 0 {
+		return fmt.Errorf("at least one host is required")
+	}
+	if validFor <= 0 {
+		validFor = DefaultLeafValidity
+	}
+
+	if err := os.MkdirAll(filepath.Dir(certFile), 0700); err != nil {
+		return fmt.Errorf("failed to create cert directory: %w", err)
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate private key: %w", err)
+	}
+
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: pkix.Name{
+			Organization: []string{"Budgie"},
+			CommonName:   hosts[0],
+		},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(validFor),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, ca.Cert, &privateKey.PublicKey, ca.key)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate: %w", err)
+	}
+
+	if err := writeCertAndKey(certFile, keyFile, certDER, privateKey); err != nil {
+		return err
+	}
+
+	logrus.Infof("Issued certificate for %v: %s", hosts, certFile)
+	return nil
+}
+
+// CertPool returns a pool containing the CA certificate
+func (ca *CA) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	return pool
+}
+
+// PEM returns the CA certificate in PEM form, for distribution to clients
+func (ca *CA) PEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Cert.Raw})
+}
+
+func newSerialNumber() (*big.Int, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serialNumber, nil
+}
@@ -0,0 +1,348 @@
+package sync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"math"
+)
+
+const (
+	// MinBlockSize and MaxBlockSize bound the block size of file signatures
+	MinBlockSize = 2 << 10
+	MaxBlockSize = 128 << 10
+
+	// strongHashSize is how much of the SHA-256 of a block is kept
+	strongHashSize = 16
+
+	// maxLiteral bounds the literal data of a single delta operation
+	maxLiteral = 64 << 10
+)
+
+// BlockSignature identifies one block of a file the receiver already has
+type BlockSignature struct {
+	Weak   uint32 // Rolling checksum
+	Strong []byte // Truncated SHA-256
+}
+
+// FileBlocks is the block signature of the receiver's copy of a file. A file
+// the receiver does not have has no blocks.
+type FileBlocks struct {
+	Path      string
+	BlockSize int
+	Blocks    []BlockSignature
+	Offset    int64 // Bytes of the file kept from an interrupted push; the delta resumes there
+}
+
+// DeltaOp is one step of rebuilding a file: copy Count blocks of the
+// receiver's copy starting at Block, or, when Count is 0, write Data
+type DeltaOp struct {
+	Block int
+	Count int
+	Data  []byte
+}
+
+// BlockSizeFor picks a block size for a file, growing with the square root
+// of its size like rsync so that signatures stay small for large files
+func BlockSizeFor(size int64) int {
+	bs := int(math.Sqrt(float64(size)))
+	bs = (bs + 1023) &^ 1023
+	if bs < MinBlockSize {
+		return MinBlockSize
+	}
+	if bs > MaxBlockSize {
+		return MaxBlockSize
+	}
+	return bs
+}
+
+// ComputeBlocks computes the block signatures of r
+func ComputeBlocks(r io.Reader, blockSize int) ([]BlockSignature, error) {
+	var blocks []BlockSignature
+	buf := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			blocks = append(blocks, BlockSignature{
+				Weak:   weakChecksum(buf[:n]),
+				Strong: strongHash(buf[:n]),
+			})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return blocks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ComputeDelta compares r against the block signatures of the receiver's
+// copy and emits the operations that turn that copy into r. Only data the
+// receiver lacks is emitted as literals; r is read once and only a buffer of
+// two blocks and a literal is held in memory.
+func ComputeDelta(r io.Reader, sig FileBlocks, emit func(DeltaOp) error) error {
+	return computeDelta(r, sig, func(op DeltaOp, _ int64) error { return emit(op) })
+}
+
+// computeDelta is ComputeDelta, also passing emit the number of bytes of r
+// each operation stands for
+func computeDelta(r io.Reader, sig FileBlocks, emit func(op DeltaOp, size int64) error) error {
+	e := &deltaEncoder{emit: emit, pending: -1}
+
+	blockSize := sig.BlockSize
+	if len(sig.Blocks) == 0 || blockSize <= 0 {
+		// Nothing to match against, so everything is literal
+		buf := make([]byte, maxLiteral)
+		for {
+			n, err := io.ReadFull(r, buf)
+			if n > 0 {
+				if err := e.literal(buf[:n]); err != nil {
+					return err
+				}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return e.flush()
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	index := make(map[uint32][]int, len(sig.Blocks))
+	for i, b := range sig.Blocks {
+		index[b.Weak] = append(index[b.Weak], i)
+	}
+
+	// buf[start:pos] is literal data not yet passed to the encoder and
+	// buf[pos:pos+blockSize] the window being matched. Both are moved to the
+	// front of buf when it is refilled, which leaves room for at least a
+	// block since the literal data is passed on once it reaches maxLiteral.
+	buf := make([]byte, maxLiteral+2*blockSize)
+	var start, pos, end int
+	eof := false
+	fill := func() error {
+		// The window slides on by reading the byte after it
+		if pos+blockSize < end || eof {
+			return nil
+		}
+		end = copy(buf, buf[start:end])
+		pos -= start
+		start = 0
+		n, err := io.ReadFull(r, buf[end:])
+		end += n
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			eof = true
+			return nil
+		}
+		return err
+	}
+	literal := func() error {
+		if pos == start {
+			return nil
+		}
+		err := e.literal(buf[start:pos])
+		start = pos
+		return err
+	}
+
+	if err := fill(); err != nil {
+		return err
+	}
+	var roll rollingChecksum
+	rolled := false
+	for pos+blockSize <= end {
+		window := buf[pos : pos+blockSize]
+		if !rolled {
+			roll.init(window)
+			rolled = true
+		}
+		if i, ok := match(index, sig.Blocks, roll.sum(), window); ok {
+			if err := literal(); err != nil {
+				return err
+			}
+			if err := e.copyBlock(i, blockSize); err != nil {
+				return err
+			}
+			pos += blockSize
+			start, rolled = pos, false
+		} else {
+			// Slide the window by one byte, which becomes literal data
+			if pos+blockSize == end {
+				if err := fill(); err != nil {
+					return err
+				}
+				if pos+blockSize == end {
+					break
+				}
+			}
+			roll.roll(buf[pos], buf[pos+blockSize])
+			pos++
+			if pos-start >= maxLiteral {
+				if err := literal(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := fill(); err != nil {
+			return err
+		}
+	}
+	if err := literal(); err != nil {
+		return err
+	}
+
+	// The tail may still match the receiver's short last block
+	if window := buf[pos:end]; len(window) > 0 {
+		last := len(sig.Blocks) - 1
+		if b := sig.Blocks[last]; b.Weak == weakChecksum(window) && bytes.Equal(b.Strong, strongHash(window)) {
+			if err := e.copyBlock(last, len(window)); err != nil {
+				return err
+			}
+		} else if err := e.literal(window); err != nil {
+			return err
+		}
+	}
+	return e.flush()
+}
+
+// ApplyDelta writes the file described by ops to w, copying blocks from
+// basis, the receiver's current copy
+func ApplyDelta(basis io.ReaderAt, blockSize int, ops []DeltaOp, w io.Writer) error {
+	for _, op := range ops {
+		if err := applyOp(basis, blockSize, op, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyOp performs a single delta operation
+func applyOp(basis io.ReaderAt, blockSize int, op DeltaOp, w io.Writer) error {
+	if op.Count == 0 {
+		_, err := w.Write(op.Data)
+		return err
+	}
+	if basis == nil || op.Block < 0 || op.Count < 0 {
+		return fmt.Errorf("invalid block reference %d+%d", op.Block, op.Count)
+	}
+
+	offset := int64(op.Block) * int64(blockSize)
+	length := int64(op.Count) * int64(blockSize)
+	n, err := io.Copy(w, io.NewSectionReader(basis, offset, length))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("block %d is past the end of the file", op.Block)
+	}
+	return nil
+}
+
+// match looks up a window among the receiver's blocks, confirming the weak
+// checksum with the strong hash
+func match(index map[uint32][]int, blocks []BlockSignature, weak uint32, window []byte) (int, bool) {
+	candidates, ok := index[weak]
+	if !ok {
+		return 0, false
+	}
+	strong := strongHash(window)
+	for _, i := range candidates {
+		if bytes.Equal(blocks[i].Strong, strong) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// deltaEncoder coalesces delta operations before emitting them: runs of
+// consecutive blocks become one copy and literal bytes are buffered
+type deltaEncoder struct {
+	emit    func(DeltaOp, int64) error
+	pending int // First block of the pending copy run, or -1
+	count   int
+	size    int64 // Bytes the pending copy run stands for
+	data    []byte
+}
+
+func (e *deltaEncoder) copyBlock(i, size int) error {
+	if len(e.data) > 0 {
+		if err := e.flush(); err != nil {
+			return err
+		}
+	}
+	if e.pending >= 0 && e.pending+e.count == i {
+		e.count++
+		e.size += int64(size)
+		return nil
+	}
+	if err := e.flush(); err != nil {
+		return err
+	}
+	e.pending, e.count, e.size = i, 1, int64(size)
+	return nil
+}
+
+func (e *deltaEncoder) literal(p []byte) error {
+	if e.pending >= 0 {
+		if err := e.flush(); err != nil {
+			return err
+		}
+	}
+	e.data = append(e.data, p...)
+	if len(e.data) >= maxLiteral {
+		return e.flush()
+	}
+	return nil
+}
+
+func (e *deltaEncoder) flush() error {
+	switch {
+	case e.pending >= 0:
+		op, size := DeltaOp{Block: e.pending, Count: e.count}, e.size
+		e.pending, e.count, e.size = -1, 0, 0
+		return e.emit(op, size)
+	case len(e.data) > 0:
+		op := DeltaOp{Data: e.data}
+		e.data = nil
+		return e.emit(op, int64(len(op.Data)))
+	}
+	return nil
+}
+
+// rollingChecksum is the rsync weak checksum of a window, which can be
+// moved one byte along in constant time
+type rollingChecksum struct {
+	a, b uint32
+	n    uint32
+}
+
+func (r *rollingChecksum) init(window []byte) {
+	r.a, r.b, r.n = 0, 0, uint32(len(window))
+	for i, c := range window {
+		r.a += uint32(c)
+		r.b += uint32(len(window)-i) * uint32(c)
+	}
+}
+
+func (r *rollingChecksum) roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.n*uint32(out)
+}
+
+func (r *rollingChecksum) sum() uint32 {
+	return (r.a & 0xffff) | (r.b << 16)
+}
+
+func weakChecksum(block []byte) uint32 {
+	var r rollingChecksum
+	r.init(block)
+	return r.sum()
+}
+
+func strongHash(block []byte) []byte {
+	sum := sha256.Sum256(block)
+	return sum[:strongHashSize]
+}
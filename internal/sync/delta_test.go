@@ -0,0 +1,403 @@
+package sync
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func randomData(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// roundTrip computes a delta from basis to target and applies it, returning
+// the rebuilt data and the literal bytes the delta carried
+func roundTrip(t *testing.T, basis, target []byte) ([]byte, int) {
+	t.Helper()
+
+	blockSize := BlockSizeFor(int64(len(target)))
+	blocks, err := ComputeBlocks(bytes.NewReader(basis), blockSize)
+	if err != nil {
+		t.Fatalf("ComputeBlocks failed: %v", err)
+	}
+
+	var ops []DeltaOp
+	literal := 0
+	sig := FileBlocks{Path: "f", BlockSize: blockSize, Blocks: blocks}
+	err = ComputeDelta(bytes.NewReader(target), sig, func(op DeltaOp) error {
+		ops = append(ops, op)
+		literal += len(op.Data)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ComputeDelta failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := ApplyDelta(bytes.NewReader(basis), blockSize, ops, &out); err != nil {
+		t.Fatalf("ApplyDelta failed: %v", err)
+	}
+	return out.Bytes(), literal
+}
+
+func TestDelta_RoundTrip(t *testing.T) {
+	basis := randomData(300_000, 1)
+	flipped := append([]byte(nil), basis...)
+	flipped[150_000] ^= 0xff
+	rewritten := append([]byte(nil), basis...)
+	copy(rewritten[50_000:], randomData(200_000, 4))
+
+	tests := []struct {
+		name       string
+		basis      []byte
+		target     []byte
+		maxLiteral int
+	}{
+		{"identical", basis, basis, 0},
+		{"one byte changed", basis, flipped, MaxBlockSize},
+		{"long run changed", basis, rewritten, 200_000 + 2*MaxBlockSize},
+		{"insert at start", basis, append([]byte("new header"), basis...), MaxBlockSize},
+		{"append", basis, append(append([]byte(nil), basis...), randomData(1000, 2)...), MaxBlockSize},
+		{"truncate", basis, basis[:200_001], MaxBlockSize},
+		{"no basis", nil, basis, len(basis)},
+		{"empty target", basis, []byte{}, 0},
+		{"short file", []byte("abc"), []byte("abd"), 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, literal := roundTrip(t, tt.basis, tt.target)
+			if !bytes.Equal(got, tt.target) {
+				t.Fatalf("Rebuilt file differs: got %d bytes, want %d", len(got), len(tt.target))
+			}
+			if literal > tt.maxLiteral {
+				t.Errorf("Expected at most %d literal bytes, got %d", tt.maxLiteral, literal)
+			}
+		})
+	}
+}
+
+func TestRollingChecksum_MatchesFreshChecksum(t *testing.T) {
+	data := randomData(4096, 3)
+	const window = 1024
+
+	var r rollingChecksum
+	r.init(data[:window])
+	for i := 1; i+window <= len(data); i++ {
+		r.roll(data[i-1], data[i+window-1])
+		if want := weakChecksum(data[i : i+window]); r.sum() != want {
+			t.Fatalf("Rolled checksum at offset %d is %x, want %x", i, r.sum(), want)
+		}
+	}
+}
+
+// BenchmarkComputeDelta_ChangedFile encodes a 32 MiB file whose middle
+// quarter the receiver does not have
+func BenchmarkComputeDelta_ChangedFile(b *testing.B) {
+	const size = 32 << 20
+	basis := randomData(size, 5)
+	target := append([]byte(nil), basis...)
+	copy(target[size/2:], randomData(size/4, 6))
+
+	blockSize := BlockSizeFor(size)
+	blocks, err := ComputeBlocks(bytes.NewReader(basis), blockSize)
+	if err != nil {
+		b.Fatalf("ComputeBlocks failed: %v", err)
+	}
+	sig := FileBlocks{Path: "f", BlockSize: blockSize, Blocks: blocks}
+
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := ComputeDelta(bytes.NewReader(target), sig, func(DeltaOp) error { return nil })
+		if err != nil {
+			b.Fatalf("ComputeDelta failed: %v", err)
+		}
+	}
+}
+
+// countingConn counts the bytes written to a connection
+type countingConn struct {
+	net.Conn
+	written *int64
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(c.written, int64(n))
+	return n, err
+}
+
+// syncDirs pushes src to dst over an in-memory connection and returns the
+// bytes sent in each direction
+func syncDirs(t *testing.T, src, dst string) (sent, received int64) {
+	t.Helper()
+
+	sender, err := NewSyncManager(src)
+	if err != nil {
+		t.Fatalf("Failed to create sender: %v", err)
+	}
+	receiver, err := NewSyncManager(dst)
+	if err != nil {
+		t.Fatalf("Failed to create receiver: %v", err)
+	}
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	errc := make(chan error, 1)
+	go func() { errc <- receiver.ReceiveVolume(countingConn{b, &received}) }()
+
+	if err := sender.SendVolume(countingConn{a, &sent}, Destination{}); err != nil {
+		t.Fatalf("SendVolume failed: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("ReceiveVolume failed: %v", err)
+	}
+	return atomic.LoadInt64(&sent), atomic.LoadInt64(&received)
+}
+
+func TestSendVolume_TransfersOnlyChangedBlocks(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	const size = 4 << 20
+
+	data := randomData(size, 4)
+	path := filepath.Join(src, "db", "data.sqlite")
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	// The first sync has to send the whole file
+	sent, _ := syncDirs(t, src, dst)
+	if sent < size {
+		t.Errorf("Expected the initial sync to send at least %d bytes, sent %d", size, sent)
+	}
+
+	// Change one byte; the sender's copy is newer than the receiver's
+	data[size/2] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(path, future, future)
+
+	sent, received := syncDirs(t, src, dst)
+	got, err := os.ReadFile(filepath.Join(dst, "db", "data.sqlite"))
+	if err != nil {
+		t.Fatalf("Failed to read synced file: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("Synced file differs from the source")
+	}
+
+	// One changed block is resent, plus signatures in the other direction
+	if sent > 2*MaxBlockSize {
+		t.Errorf("Expected a one-byte change to send at most %d bytes, sent %d", 2*MaxBlockSize, sent)
+	}
+	if received > size/50 {
+		t.Errorf("Expected block signatures to be small, received %d bytes", received)
+	}
+	t.Logf("One-byte change of a %d byte file: sent %d bytes, signatures %d bytes", size, sent, received)
+}
+
+// cutConn fails writes once limit bytes have been written, as if the
+// connection broke
+type cutConn struct {
+	net.Conn
+	limit int64
+}
+
+func (c *cutConn) Write(p []byte) (int, error) {
+	if int64(len(p)) > c.limit {
+		n, _ := c.Conn.Write(p[:c.limit])
+		c.limit = 0
+		c.Conn.Close()
+		return n, io.ErrClosedPipe
+	}
+	c.limit -= int64(len(p))
+	return c.Conn.Write(p)
+}
+
+func TestSendVolume_ResumesInterruptedFile(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	const size = 4 * maxDeltaBatch
+
+	data := randomData(size, 5)
+	if err := os.WriteFile(filepath.Join(src, "big"), data, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	sender, err := NewSyncManager(src)
+	if err != nil {
+		t.Fatalf("Failed to create sender: %v", err)
+	}
+	receiver, err := NewSyncManager(dst)
+	if err != nil {
+		t.Fatalf("Failed to create receiver: %v", err)
+	}
+
+	// Break the connection partway through the file
+	a, b := net.Pipe()
+	errc := make(chan error, 1)
+	go func() { errc <- receiver.ReceiveVolume(b) }()
+	if err := sender.SendVolume(&cutConn{Conn: a, limit: size * 5 / 8}, Destination{}); err == nil {
+		t.Fatal("Expected the interrupted push to fail")
+	}
+	if err := <-errc; err == nil {
+		t.Fatal("Expected the interrupted receive to fail")
+	}
+	b.Close()
+	assertMissing(t, filepath.Join(dst, "big"))
+
+	// The next push only sends what is missing
+	sent, _ := syncDirs(t, src, dst)
+	if sent > size/2+maxDeltaBatch {
+		t.Errorf("Expected the resumed push to send at most %d bytes, sent %d", size/2+maxDeltaBatch, sent)
+	}
+	got, err := os.ReadFile(filepath.Join(dst, "big"))
+	if err != nil {
+		t.Fatalf("Failed to read synced file: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("Resumed file differs from the source")
+	}
+	entries, _ := os.ReadDir(dst)
+	if len(entries) != 1 {
+		t.Errorf("Expected only the synced file to be left, got %d entries", len(entries))
+	}
+}
+
+func TestReceiveVolume_IgnoresPartialOfOtherVersion(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	tree(t, src, map[string]string{"file": "new version"})
+
+	sender, err := NewSyncManager(src)
+	if err != nil {
+		t.Fatalf("Failed to create sender: %v", err)
+	}
+	files, err := sender.Signatures()
+	if err != nil {
+		t.Fatalf("Failed to compute signatures: %v", err)
+	}
+
+	// A partial copy of an older version is not resumed from
+	old := files[0]
+	old.Checksum = bytes.Repeat([]byte{1}, 32)
+	tree(t, dst, map[string]string{filepath.Base(partialPath(filepath.Join(dst, "file"), old)): "stale"})
+
+	syncDirs(t, src, dst)
+	assertContent(t, filepath.Join(dst, "file"), "new version")
+	if _, err := os.Stat(partialPath(filepath.Join(dst, "file"), old)); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale partial copy to be removed, got %v", err)
+	}
+}
+
+func TestSendChanges_RemovesPartialsOfSupersededVersions(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	tree(t, src, map[string]string{"file": "new version"})
+	tree(t, dst, map[string]string{"file": "old", "gone": "x"})
+
+	sender, _ := NewSyncManager(src)
+	receiver, _ := NewSyncManager(dst)
+	files, err := sender.Signatures()
+	if err != nil {
+		t.Fatalf("Failed to compute signatures: %v", err)
+	}
+
+	// Partial pushes never prune, so partial copies of versions that will
+	// not be resumed are removed as the push reaches their entries
+	old := files[0]
+	old.Checksum = bytes.Repeat([]byte{1}, 32)
+	stale := partialPath(filepath.Join(dst, "file"), old)
+	orphan := partialPath(filepath.Join(dst, "gone"), old)
+	tree(t, dst, map[string]string{filepath.Base(stale): "stale", filepath.Base(orphan): "stale"})
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	errc := make(chan error, 1)
+	go func() { errc <- receiver.ReceiveVolume(b) }()
+	if err := sender.SendChanges(a, Destination{}, []string{"file", "gone"}); err != nil {
+		t.Fatalf("SendChanges failed: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("ReceiveVolume failed: %v", err)
+	}
+
+	assertContent(t, filepath.Join(dst, "file"), "new version")
+	assertMissing(t, stale)
+	assertMissing(t, orphan)
+}
+
+func TestSendVolume_SkipsFilesBeingReceived(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	partialID := "0102030405060708"
+	tree(t, src, map[string]string{
+		"file":                              "data",
+		".file.budgie-123456":               "in flight",
+		".file.budgie-partial-" + partialID: "interrupted",
+	})
+	tree(t, dst, map[string]string{
+		".file.budgie-654321":               "in flight",
+		".gone.budgie-partial-" + partialID: "interrupted",
+	})
+
+	sender, _ := NewSyncManager(src)
+	files, err := sender.Signatures()
+	if err != nil {
+		t.Fatalf("Failed to compute signatures: %v", err)
+	}
+	if len(files) != 1 || files[0].Path != "file" {
+		t.Errorf("Expected only the file to be listed, got %+v", files)
+	}
+
+	// The receiver leaves what another push is receiving for a kept entry,
+	// but not what was left of a removed one
+	syncDirs(t, src, dst)
+	assertContent(t, filepath.Join(dst, "file"), "data")
+	assertMissing(t, filepath.Join(dst, ".file.budgie-123456"))
+	assertContent(t, filepath.Join(dst, ".file.budgie-654321"), "in flight")
+	assertMissing(t, filepath.Join(dst, ".gone.budgie-partial-"+partialID))
+}
+
+func TestReceiveVolume_RejectsPathsOutsideVolume(t *testing.T) {
+	receiver, err := NewSyncManager(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create receiver: %v", err)
+	}
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	errc := make(chan error, 1)
+	go func() { errc <- receiver.ReceiveVolume(b) }()
+
+	proto := NewProtocol(a)
+	if _, err := proto.Handshake(NewHello(a, "node-a")); err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	if err := proto.SendSignatureRequest("", "", []FileSignature{{Path: "../escape", Size: 1}}); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	msg, err := proto.Receive()
+	if err != nil {
+		t.Fatalf("Failed to receive reply: %v", err)
+	}
+	if msg.Type != MsgError {
+		t.Errorf("Expected an error reply, got message type %d", msg.Type)
+	}
+	if err := <-errc; err == nil {
+		t.Error("Expected ReceiveVolume to fail")
+	}
+}
@@ -0,0 +1,298 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// EntryType is the kind of a volume entry
+type EntryType uint8
+
+const (
+	EntryFile     EntryType = iota // Regular file, sent as a delta
+	EntryDir                       // Directory, created empty
+	EntrySymlink                   // Symlink to Target, which is not followed
+	EntryHardlink                  // Hard link to the file at Target
+)
+
+// modeBits are the parts of a file mode that are synced
+const modeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// inode identifies a file for hardlink detection
+type inode struct {
+	dev, ino uint64
+}
+
+// statInfo is what a platform's stat adds to os.FileInfo
+type statInfo struct {
+	uid, gid int
+	id       inode
+	links    uint64
+}
+
+// scanner builds the signatures of a volume's entries, remembering files
+// with several links so that later links to them become hardlink entries
+type scanner struct {
+	root  string
+	files map[inode]FileSignature
+	known map[string]FileSignature // Earlier signatures, whose checksums are reused for unchanged files
+}
+
+// Files being received are written next to their entry, as
+// .<name>.budgie-<random>, and kept as .<name>.budgie-partial-<checksum>
+// when the push is interrupted
+const (
+	tempInfix    = ".budgie-"
+	partialInfix = ".budgie-partial-"
+)
+
+// tempEntry returns the name of the entry a file being received is for, or
+// false if name is not such a file
+func tempEntry(name string) (string, bool) {
+	i := strings.LastIndex(name, tempInfix)
+	if !strings.HasPrefix(name, ".") || i < 2 {
+		return "", false
+	}
+	return name[1:i], true
+}
+
+// isTempFile reports whether name is a file being received, which is never
+// synced itself
+func isTempFile(name string) bool {
+	_, ok := tempEntry(name)
+	return ok
+}
+
+// entry returns the signature of the entry at path, or false for entries
+// that are not synced, such as sockets and devices
+func (sc *scanner) entry(path string, info os.FileInfo) (FileSignature, bool, error) {
+	relPath, err := filepath.Rel(sc.root, path)
+	if err != nil {
+		return FileSignature{}, false, err
+	}
+	if isTempFile(info.Name()) {
+		return FileSignature{}, false, nil
+	}
+
+	sig := FileSignature{
+		Path:    relPath,
+		ModTime: info.ModTime().UnixNano(),
+		Mode:    info.Mode() & modeBits,
+		UID:     -1,
+		GID:     -1,
+	}
+	st, hasStat := sysStat(info)
+	if hasStat {
+		sig.UID, sig.GID = st.uid, st.gid
+	}
+
+	switch {
+	case info.IsDir():
+		sig.Type = EntryDir
+	case info.Mode()&os.ModeSymlink != 0:
+		sig.Type = EntrySymlink
+		if sig.Target, err = os.Readlink(path); err != nil {
+			return sig, false, err
+		}
+	case info.Mode().IsRegular():
+		if hasStat && st.links > 1 {
+			if first, ok := sc.files[st.id]; ok {
+				sig.Type = EntryHardlink
+				sig.Target = first.Path
+				sig.Size, sig.Checksum = first.Size, first.Checksum
+				return sig, true, nil
+			}
+		}
+		sig.Size = info.Size()
+		if k, ok := sc.known[relPath]; ok && k.Type == EntryFile && k.Size == sig.Size && k.ModTime == sig.ModTime && len(k.Checksum) > 0 {
+			sig.Checksum = k.Checksum
+		} else if sig.Checksum, err = fileHash(path); err != nil {
+			return sig, false, err
+		}
+		if hasStat && st.links > 1 {
+			sc.files[st.id] = sig
+		}
+	default:
+		return sig, false, nil
+	}
+	return sig, true, nil
+}
+
+// entryType returns the type an existing local entry would be sent as,
+// ignoring hardlinks
+func entryType(info os.FileInfo) (EntryType, bool) {
+	switch {
+	case info.IsDir():
+		return EntryDir, true
+	case info.Mode()&os.ModeSymlink != 0:
+		return EntrySymlink, true
+	case info.Mode().IsRegular():
+		return EntryFile, true
+	}
+	return 0, false
+}
+
+// sameKind reports whether a local entry can be updated in place to match
+// sig rather than being removed first
+func sameKind(info os.FileInfo, sig FileSignature) bool {
+	t, ok := entryType(info)
+	if !ok {
+		return false
+	}
+	if sig.Type == EntryHardlink {
+		return t == EntryFile
+	}
+	return t == sig.Type
+}
+
+// prepareEntry makes room for an entry, removing a local entry of another
+// kind, and creates directories and symlinks, which carry no data
+func (s *SyncManager) prepareEntry(localPath string, sig FileSignature) error {
+	info, err := os.Lstat(localPath)
+	switch {
+	case err == nil && !sameKind(info, sig):
+		if err := os.RemoveAll(localPath); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", sig.Path, err)
+		}
+		info = nil
+	case err == nil:
+	case os.IsNotExist(err):
+		info = nil
+	default:
+		return err
+	}
+
+	switch sig.Type {
+	case EntryDir:
+		if info == nil {
+			return os.MkdirAll(localPath, 0755)
+		}
+	case EntrySymlink:
+		if info != nil {
+			if target, err := os.Readlink(localPath); err == nil && target == sig.Target {
+				return nil
+			}
+			if err := os.Remove(localPath); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			return err
+		}
+		return os.Symlink(sig.Target, localPath)
+	}
+	return nil
+}
+
+// link makes a hardlink entry point at the same file as its target
+func (s *SyncManager) link(sig FileSignature) error {
+	localPath, err := s.localFile(sig.Path)
+	if err != nil {
+		return err
+	}
+	target, err := s.localFile(sig.Target)
+	if err != nil {
+		return err
+	}
+
+	targetInfo, err := os.Lstat(target)
+	if err != nil {
+		return fmt.Errorf("failed to link %s: %w", sig.Path, err)
+	}
+	if info, err := os.Lstat(localPath); err == nil {
+		if os.SameFile(info, targetInfo) {
+			return nil
+		}
+		if err := os.RemoveAll(localPath); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	if err := os.Link(target, localPath); err != nil {
+		return fmt.Errorf("failed to link %s: %w", sig.Path, err)
+	}
+	return nil
+}
+
+// prune removes the local entries that the sender does not have, which are
+// tombstones for entries deleted on the sender. Returns the removed paths.
+func (s *SyncManager) prune(keep map[string]bool) ([]string, error) {
+	var removed []string
+	err := filepath.Walk(s.localPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == s.localPath {
+			return nil
+		}
+		relPath, err := filepath.Rel(s.localPath, path)
+		if err != nil {
+			return err
+		}
+		if keep[relPath] {
+			return nil
+		}
+		// Files other pushes are receiving, or may resume, are left alone
+		// unless their entry is removed
+		if entry, ok := tempEntry(info.Name()); ok && keep[filepath.Join(filepath.Dir(relPath), entry)] {
+			return nil
+		}
+
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", relPath, err)
+		}
+		removed = append(removed, relPath)
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// applyMetadata gives local entries the sender's mode, ownership and
+// modification time. Entries are visited deepest first, so that setting a
+// directory's time is not undone by changes to its children.
+func (s *SyncManager) applyMetadata(entries []FileSignature) error {
+	sorted := append([]FileSignature(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return strings.Count(sorted[i].Path, string(filepath.Separator)) > strings.Count(sorted[j].Path, string(filepath.Separator))
+	})
+
+	chown := os.Geteuid() == 0
+	for _, sig := range sorted {
+		localPath, err := s.localFile(sig.Path)
+		if err != nil {
+			return err
+		}
+		info, err := os.Lstat(localPath)
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", sig.Path, err)
+		}
+		symlink := info.Mode()&os.ModeSymlink != 0
+
+		if chown && sig.UID >= 0 && sig.GID >= 0 {
+			if st, ok := sysStat(info); !ok || st.uid != sig.UID || st.gid != sig.GID {
+				if err := os.Lchown(localPath, sig.UID, sig.GID); err != nil {
+					return fmt.Errorf("failed to set owner of %s: %w", sig.Path, err)
+				}
+			}
+		}
+		if !symlink && info.Mode()&modeBits != sig.Mode {
+			if err := os.Chmod(localPath, sig.Mode); err != nil {
+				return fmt.Errorf("failed to set mode of %s: %w", sig.Path, err)
+			}
+		}
+		if info.ModTime().UnixNano() != sig.ModTime {
+			if err := lchtimes(localPath, sig.ModTime); err != nil {
+				return fmt.Errorf("failed to set time of %s: %w", sig.Path, err)
+			}
+		}
+	}
+	return nil
+}
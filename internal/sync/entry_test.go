@@ -0,0 +1,237 @@
+package sync
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// tree writes files into dir, creating their parents
+func tree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+}
+
+func assertMissing(t *testing.T, path string) {
+	t.Helper()
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed, got %v", path, err)
+	}
+}
+
+func assertContent(t *testing.T, path, want string) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", path, err)
+	}
+	if string(data) != want {
+		t.Errorf("Expected %s to contain %q, got %q", path, want, data)
+	}
+}
+
+func TestSendVolume_PropagatesDeletionsAndRenames(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	tree(t, src, map[string]string{
+		"keep.txt":       "keep",
+		"old-name.txt":   "renamed",
+		"logs/a.log":     "a",
+		"logs/old/b.log": "b",
+	})
+	syncDirs(t, src, dst)
+
+	os.Rename(filepath.Join(src, "old-name.txt"), filepath.Join(src, "new-name.txt"))
+	os.RemoveAll(filepath.Join(src, "logs", "old"))
+	os.Remove(filepath.Join(src, "logs", "a.log"))
+
+	// Entries only the replica has are removed as well
+	tree(t, dst, map[string]string{"stray/file": "x"})
+
+	syncDirs(t, src, dst)
+
+	assertContent(t, filepath.Join(dst, "keep.txt"), "keep")
+	assertContent(t, filepath.Join(dst, "new-name.txt"), "renamed")
+	assertMissing(t, filepath.Join(dst, "old-name.txt"))
+	assertMissing(t, filepath.Join(dst, "logs", "a.log"))
+	assertMissing(t, filepath.Join(dst, "logs", "old"))
+	assertMissing(t, filepath.Join(dst, "stray"))
+	if info, err := os.Stat(filepath.Join(dst, "logs")); err != nil || !info.IsDir() {
+		t.Errorf("Expected the now empty logs directory to be kept, got %v", err)
+	}
+}
+
+func TestSendVolume_PreservesSymlinks(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	tree(t, src, map[string]string{"data/current.db": "db"})
+	os.Symlink("data/current.db", filepath.Join(src, "db"))
+	os.Symlink("/nonexistent/elsewhere", filepath.Join(src, "dangling"))
+	os.Symlink("data", filepath.Join(src, "dir-link"))
+
+	// The replica has a directory where the primary has a symlink
+	tree(t, dst, map[string]string{"dir-link/file": "x"})
+
+	syncDirs(t, src, dst)
+
+	for name, want := range map[string]string{
+		"db":       "data/current.db",
+		"dangling": "/nonexistent/elsewhere",
+		"dir-link": "data",
+	} {
+		target, err := os.Readlink(filepath.Join(dst, name))
+		if err != nil {
+			t.Errorf("Expected %s to be a symlink: %v", name, err)
+			continue
+		}
+		if target != want {
+			t.Errorf("Expected %s to point to %q, got %q", name, want, target)
+		}
+	}
+	assertContent(t, filepath.Join(dst, "db"), "db")
+}
+
+func TestSendVolume_PreservesHardlinks(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	tree(t, src, map[string]string{"a": "shared"})
+	if err := os.Link(filepath.Join(src, "a"), filepath.Join(src, "b")); err != nil {
+		t.Skipf("Hardlinks are not supported: %v", err)
+	}
+
+	syncDirs(t, src, dst)
+
+	a, err := os.Stat(filepath.Join(dst, "a"))
+	if err != nil {
+		t.Fatalf("Failed to stat a: %v", err)
+	}
+	b, err := os.Stat(filepath.Join(dst, "b"))
+	if err != nil {
+		t.Fatalf("Failed to stat b: %v", err)
+	}
+	if !os.SameFile(a, b) {
+		t.Error("Expected a and b to be the same file")
+	}
+	assertContent(t, filepath.Join(dst, "b"), "shared")
+}
+
+func TestSendVolume_PreservesModesTimesAndEmptyDirs(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	tree(t, src, map[string]string{"bin/run.sh": "#!/bin/sh", "secret": "s"})
+	os.Chmod(filepath.Join(src, "bin", "run.sh"), 0750)
+	os.Chmod(filepath.Join(src, "secret"), 0600)
+	os.MkdirAll(filepath.Join(src, "empty", "nested"), 0755)
+	os.Chmod(filepath.Join(src, "empty"), 0700)
+
+	past := time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC)
+	for _, name := range []string{"bin/run.sh", "secret", "empty/nested", "empty", "bin"} {
+		os.Chtimes(filepath.Join(src, name), past, past)
+	}
+
+	syncDirs(t, src, dst)
+
+	for name, mode := range map[string]os.FileMode{
+		"bin/run.sh":   0750,
+		"secret":       0600,
+		"empty":        0700 | os.ModeDir,
+		"empty/nested": 0755 | os.ModeDir,
+	} {
+		info, err := os.Stat(filepath.Join(dst, name))
+		if err != nil {
+			t.Errorf("Expected %s to exist: %v", name, err)
+			continue
+		}
+		if got := info.Mode() & (modeBits | os.ModeDir); got != mode {
+			t.Errorf("Expected %s to have mode %v, got %v", name, mode, got)
+		}
+		if !info.ModTime().Equal(past) {
+			t.Errorf("Expected %s to have time %v, got %v", name, past, info.ModTime())
+		}
+	}
+}
+
+func TestSendVolume_PreservesOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Changing ownership requires root")
+	}
+	src, dst := t.TempDir(), t.TempDir()
+	tree(t, src, map[string]string{"owned": "x"})
+	os.Symlink("owned", filepath.Join(src, "link"))
+	os.Chown(filepath.Join(src, "owned"), 1234, 5678)
+	os.Lchown(filepath.Join(src, "link"), 4321, 8765)
+
+	syncDirs(t, src, dst)
+
+	for name, want := range map[string][2]int{"owned": {1234, 5678}, "link": {4321, 8765}} {
+		info, err := os.Lstat(filepath.Join(dst, name))
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", name, err)
+		}
+		st, ok := sysStat(info)
+		if !ok {
+			t.Skip("Ownership is not available on this platform")
+		}
+		if st.uid != want[0] || st.gid != want[1] {
+			t.Errorf("Expected %s to be owned by %d:%d, got %d:%d", name, want[0], want[1], st.uid, st.gid)
+		}
+	}
+}
+
+func TestSendVolume_UnchangedVolumeSendsNoData(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	tree(t, src, map[string]string{"big": string(randomData(1<<20, 5))})
+
+	syncDirs(t, src, dst)
+	sent, _ := syncDirs(t, src, dst)
+
+	// Times are synced, so the second sync only sends the file list
+	if sent > 4096 {
+		t.Errorf("Expected an unchanged volume to send only its file list, sent %d bytes", sent)
+	}
+}
+
+func TestReceiveVolume_RejectsPathsThroughSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	dst := t.TempDir()
+	receiver, err := NewSyncManager(dst)
+	if err != nil {
+		t.Fatalf("Failed to create receiver: %v", err)
+	}
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	errc := make(chan error, 1)
+	go func() { errc <- receiver.ReceiveVolume(b) }()
+
+	proto := NewProtocol(a)
+	if _, err := proto.Handshake(NewHello(a, "node-a")); err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	files := []FileSignature{
+		{Path: "escape", Type: EntrySymlink, Target: outside},
+		{Path: filepath.Join("escape", "planted"), Size: 1},
+	}
+	if err := proto.SendSignatureRequest("", "", files); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	msg, err := proto.Receive()
+	if err != nil {
+		t.Fatalf("Failed to receive reply: %v", err)
+	}
+	if msg.Type != MsgError {
+		t.Errorf("Expected an error reply, got message type %d", msg.Type)
+	}
+	if err := <-errc; err == nil {
+		t.Error("Expected ReceiveVolume to fail")
+	}
+	assertMissing(t, filepath.Join(outside, "planted"))
+}
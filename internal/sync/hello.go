@@ -0,0 +1,161 @@
+package sync
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// ProtocolVersion is the version of the sync protocol this node speaks
+	ProtocolVersion = 2
+	// MinProtocolVersion is the oldest version this node can talk to.
+	// Version 1 had no hello and cannot be told apart from garbage.
+	MinProtocolVersion = 2
+)
+
+// Features a peer can support, listed in its hello
+const (
+	FeatureCompression = "zstd"    // Switching the connection to zstd after the hello
+	FeatureDeltas      = "deltas"  // Sending files as deltas against the receiver's copy
+	FeatureChunking    = "chunked" // Streaming files in bounded batches that can resume
+)
+
+// Auth values of a hello
+const (
+	AuthTLS  = "tls"
+	AuthNone = "none"
+)
+
+// Hello opens every sync connection. The dialing side sends its hello
+// first and the accepting side answers with its own, or with an error if it
+// cannot talk to the dialer.
+type Hello struct {
+	Version    int
+	MinVersion int
+	NodeID     string
+	Features   []string
+	Auth       string // How the sender sees the connection, AuthTLS or AuthNone
+}
+
+// NewHello returns the hello of the node nodeID for conn, with every
+// feature we support
+func NewHello(conn io.ReadWriter, nodeID string) Hello {
+	auth := AuthNone
+	if _, ok := conn.(*tls.Conn); ok {
+		auth = AuthTLS
+	}
+	return Hello{
+		Version:    ProtocolVersion,
+		MinVersion: MinProtocolVersion,
+		NodeID:     nodeID,
+		Features:   []string{FeatureCompression, FeatureDeltas, FeatureChunking},
+		Auth:       auth,
+	}
+}
+
+// Supports reports whether the hello lists feature
+func (h Hello) Supports(feature string) bool {
+	for _, f := range h.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// Handshake exchanges hellos as the dialing side and returns the peer's.
+// The peer must support the required features. The connection is
+// compressed afterwards if both sides support it.
+func (p *Protocol) Handshake(local Hello, required ...string) (Hello, error) {
+	if err := p.Send(Message{Type: MsgHello, Payload: local}); err != nil {
+		return Hello{}, fmt.Errorf("failed to send hello: %w", err)
+	}
+
+	msg, err := p.Receive()
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return Hello{}, fmt.Errorf("peer closed the connection during the handshake; it may run a budgie older than protocol version %d or require TLS", MinProtocolVersion)
+	}
+	if err != nil {
+		return Hello{}, fmt.Errorf("failed to receive hello: %w", err)
+	}
+	peer, ok := msg.Payload.(Hello)
+	if msg.Type != MsgHello || !ok {
+		return Hello{}, fmt.Errorf("peer rejected the handshake: %w", unexpectedMessage(msg, MsgHello))
+	}
+
+	if err := p.negotiate(local, peer); err != nil {
+		return peer, err
+	}
+	if err := compatible(local, peer, required); err != nil {
+		// Tell the peer why, so that both sides log the reason
+		p.SendError(426, err.Error())
+		return peer, err
+	}
+	return peer, nil
+}
+
+// AcceptHandshake exchanges hellos as the accepting side and returns the
+// peer's. A peer that does not start with a hello, or that we cannot talk
+// to, is sent an error instead of our hello.
+func (p *Protocol) AcceptHandshake(local Hello, required ...string) (Hello, error) {
+	msg, err := p.Receive()
+	if err != nil {
+		return Hello{}, fmt.Errorf("failed to receive hello: %w", err)
+	}
+	peer, ok := msg.Payload.(Hello)
+	if msg.Type != MsgHello || !ok {
+		reason := fmt.Sprintf("peer did not start with a hello: upgrade budgie on it to protocol version %d or later", MinProtocolVersion)
+		p.SendError(426, reason)
+		return Hello{}, errors.New(reason)
+	}
+
+	if err := compatible(local, peer, required); err != nil {
+		p.SendError(426, err.Error())
+		return peer, err
+	}
+	if err := p.Send(Message{Type: MsgHello, Payload: local}); err != nil {
+		return peer, fmt.Errorf("failed to send hello: %w", err)
+	}
+	if err := p.negotiate(local, peer); err != nil {
+		return peer, err
+	}
+
+	// The dialer checks our hello too and may still turn us down
+	return peer, nil
+}
+
+// negotiate switches the connection to the features both hellos list
+func (p *Protocol) negotiate(local, peer Hello) error {
+	if local.Supports(FeatureCompression) && peer.Supports(FeatureCompression) {
+		if err := p.compress(); err != nil {
+			return fmt.Errorf("failed to compress connection: %w", err)
+		}
+	}
+	return nil
+}
+
+// compatible reports why we cannot talk to peer, if we cannot
+func compatible(local, peer Hello, required []string) error {
+	if peer.Version < local.MinVersion || local.Version < peer.MinVersion {
+		return fmt.Errorf("incompatible protocol versions: %s speaks %d (supports %d to %d), we speak %d (support %d to %d); upgrade the older node",
+			peer.NodeID, peer.Version, peer.MinVersion, peer.Version, local.Version, local.MinVersion, local.Version)
+	}
+
+	var missing []string
+	for _, feature := range required {
+		if !peer.Supports(feature) {
+			missing = append(missing, feature)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s does not support required features: %s", peer.NodeID, strings.Join(missing, ", "))
+	}
+
+	if peer.Auth != local.Auth {
+		return fmt.Errorf("%s sees the connection with auth %q, we see %q; is a proxy between us?", peer.NodeID, peer.Auth, local.Auth)
+	}
+	return nil
+}
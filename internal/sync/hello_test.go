@@ -0,0 +1,172 @@
+package sync
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// handshake runs a handshake over net.Pipe with the given hellos and
+// returns both sides' results
+func handshake(t *testing.T, dialer, acceptor Hello, required ...string) (*Protocol, *Protocol, error, error) {
+	t.Helper()
+	a, b := net.Pipe()
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+
+	server := NewProtocol(b)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := server.AcceptHandshake(acceptor)
+		if err == nil {
+			// The dialer may still turn us down after our hello
+			if msg, rerr := server.Receive(); rerr == nil {
+				err = unexpectedMessage(msg, MsgAck)
+			}
+		}
+		b.Close()
+		errc <- err
+	}()
+
+	client := NewProtocol(a)
+	_, err := client.Handshake(dialer, required...)
+	a.Close()
+	return client, server, err, <-errc
+}
+
+func TestHandshake_CompressesWhenBothSupportIt(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	server := NewProtocol(b)
+	received := make(chan Message, 1)
+	go func() {
+		peer, err := server.AcceptHandshake(NewHello(b, "node-b"))
+		if err != nil || peer.Version != ProtocolVersion || peer.NodeID != "node-a" {
+			t.Errorf("Expected a version %d peer named node-a, got %+v and %v", ProtocolVersion, peer, err)
+		}
+		msg, _ := server.Receive()
+		received <- msg
+	}()
+
+	client := NewProtocol(a)
+	peer, err := client.Handshake(NewHello(a, "node-a"), FeatureDeltas, FeatureChunking)
+	if err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	if peer.NodeID != "node-b" {
+		t.Errorf("Expected the peer to be node-b, got %q", peer.NodeID)
+	}
+	if err := client.SendAck(true, "compressed"); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+
+	msg := <-received
+	if ack, ok := msg.Payload.(AckMessage); !ok || ack.Message != "compressed" {
+		t.Errorf("Expected the acknowledgment after the handshake, got %+v", msg)
+	}
+	if !client.Compressed() || !server.Compressed() {
+		t.Errorf("Expected both sides to be compressed, got %v and %v", client.Compressed(), server.Compressed())
+	}
+}
+
+func TestHandshake_StaysUncompressedWithoutPeerSupport(t *testing.T) {
+	dialer := NewHello(nil, "node-a")
+	dialer.Features = []string{FeatureDeltas, FeatureChunking}
+
+	client, server, err, serverErr := handshake(t, dialer, NewHello(nil, "node-b"))
+	if err != nil || serverErr != nil {
+		t.Fatalf("Expected the handshake to succeed, got %v and %v", err, serverErr)
+	}
+	if client.Compressed() || server.Compressed() {
+		t.Error("Expected the connection to stay uncompressed")
+	}
+}
+
+func TestHandshake_RejectsNewerPeer(t *testing.T) {
+	dialer := NewHello(nil, "node-a")
+	dialer.Version, dialer.MinVersion = ProtocolVersion+2, ProtocolVersion+1
+
+	_, _, err, serverErr := handshake(t, dialer, NewHello(nil, "node-b"))
+	if serverErr == nil || !strings.Contains(serverErr.Error(), "incompatible protocol versions") {
+		t.Errorf("Expected the acceptor to reject the newer dialer, got %v", serverErr)
+	}
+	if err == nil || !strings.Contains(err.Error(), "incompatible protocol versions") {
+		t.Errorf("Expected the dialer to learn why, got %v", err)
+	}
+}
+
+func TestHandshake_RejectsOlderPeer(t *testing.T) {
+	acceptor := NewHello(nil, "node-b")
+	acceptor.Version, acceptor.MinVersion = MinProtocolVersion-1, MinProtocolVersion-1
+
+	_, _, err, serverErr := handshake(t, NewHello(nil, "node-a"), acceptor)
+	if serverErr == nil {
+		t.Error("Expected the older acceptor to reject us")
+	}
+	if err == nil || !strings.Contains(err.Error(), "incompatible protocol versions") {
+		t.Errorf("Expected a version error, got %v", err)
+	}
+}
+
+func TestHandshake_AcceptsOverlappingVersions(t *testing.T) {
+	acceptor := NewHello(nil, "node-b")
+	acceptor.Version = ProtocolVersion + 1
+
+	if _, _, err, serverErr := handshake(t, NewHello(nil, "node-a"), acceptor); err != nil || serverErr != nil {
+		t.Errorf("Expected a newer peer that still supports us to be accepted, got %v and %v", err, serverErr)
+	}
+}
+
+func TestHandshake_RequiresFeatures(t *testing.T) {
+	acceptor := NewHello(nil, "node-b")
+	acceptor.Features = []string{FeatureCompression}
+
+	_, _, err, serverErr := handshake(t, NewHello(nil, "node-a"), acceptor, FeatureDeltas, FeatureChunking)
+	if err == nil || !strings.Contains(err.Error(), "deltas, chunked") {
+		t.Errorf("Expected the missing features to be named, got %v", err)
+	}
+	if serverErr == nil || !strings.Contains(serverErr.Error(), "does not support required features") {
+		t.Errorf("Expected the acceptor to hear why it was turned down, got %v", serverErr)
+	}
+}
+
+func TestHandshake_RejectsAuthMismatch(t *testing.T) {
+	dialer := NewHello(nil, "node-a")
+	dialer.Auth = AuthTLS
+
+	if _, _, err, _ := handshake(t, dialer, NewHello(nil, "node-b")); err == nil || !strings.Contains(err.Error(), "auth") {
+		t.Errorf("Expected an auth mismatch, got %v", err)
+	}
+}
+
+func TestAcceptHandshake_RejectsPeerWithoutHello(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := NewProtocol(b).AcceptHandshake(NewHello(b, "node-b"))
+		errc <- err
+	}()
+
+	// A version 1 peer starts straight into a request
+	client := NewProtocol(a)
+	if err := client.SendSignatureRequest("", "", nil); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	msg, err := client.Receive()
+	if err != nil {
+		t.Fatalf("Failed to receive reply: %v", err)
+	}
+	if e, ok := msg.Payload.(ErrorMessage); !ok || !strings.Contains(e.Message, "upgrade budgie") {
+		t.Errorf("Expected an upgrade error, got %+v", msg)
+	}
+	if err := <-errc; err == nil {
+		t.Error("Expected AcceptHandshake to fail")
+	}
+}
@@ -1,8 +1,11 @@
 package sync
 
 import (
+	"bufio"
 	"encoding/gob"
 	"io"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 // MessageType represents the type of sync message
@@ -14,9 +17,14 @@ const (
 	MsgSignatureResponse
 	MsgDeltaRequest
 	MsgDeltaResponse
-	MsgFileTransfer
+	MsgFileTransfer // No longer sent: file data is streamed as delta literals
 	MsgAck
 	MsgError
+	MsgGossip // Cluster membership exchange, see internal/cluster
+	MsgManifestRequest
+	MsgManifestResponse
+	MsgHello // Opens every connection, see Hello
+	MsgPullRequest
 )
 
 // Message represents a sync protocol message
@@ -25,15 +33,27 @@ type Message struct {
 	Payload interface{}
 }
 
-// SignatureRequest requests file signatures from a peer
+// SignatureRequest lists the files of a sender's volume and asks the
+// receiver for the block signatures of those it needs. A sync server
+// receives it into the volume the container mounts at VolumeTarget.
 type SignatureRequest struct {
-	ContainerID string
-	VolumePath  string
+	ContainerID  string
+	VolumeTarget string
+	Files        []FileSignature
+	Partial      bool     // Files lists only changed entries, not the whole volume
+	Deleted      []string // Tombstones of a partial push: entries removed since
+
+	// Pushes of bidirectional volumes carry versions, see Versioning
+	Versioned   bool
+	Origin      string          // Node ID of the sender
+	FromPrimary bool            // Whether the sender holds the primary
+	Tombstones  []FileSignature // Deleted entries with their versions
 }
 
-// SignatureResponse contains file signatures
+// SignatureResponse contains the block signatures of the receiver's copies
+// of the files it needs
 type SignatureResponse struct {
-	Signatures []FileSignature
+	Files []FileBlocks
 }
 
 // DeltaRequest requests specific files
@@ -41,10 +61,33 @@ type DeltaRequest struct {
 	Files []string
 }
 
-// FileTransfer contains file data
-type FileTransfer struct {
-	Meta FileSignature
-	Data []byte
+// ManifestRequest asks a peer for the signatures of a container's volume,
+// for comparing it with a replica
+type ManifestRequest struct {
+	ContainerID  string
+	VolumeTarget string
+}
+
+// PullRequest asks a sync server to push a container's volume to us. An
+// empty VolumeTarget asks for the first rw volume, which replicas mirror.
+type PullRequest struct {
+	ContainerID  string
+	VolumeTarget string
+}
+
+// ManifestResponse lists the files of a volume
+type ManifestResponse struct {
+	Files []FileSignature
+}
+
+// DeltaResponse carries delta operations for one file. A file is streamed
+// in responses of bounded size, each starting where the previous one ended
+// in the rebuilt file; the last one has Done set.
+type DeltaResponse struct {
+	Path   string
+	Offset int64 // Where the output of Ops starts in the rebuilt file
+	Ops    []DeltaOp
+	Done   bool
 }
 
 // AckMessage acknowledges receipt
@@ -61,21 +104,33 @@ type ErrorMessage struct {
 
 // Protocol handles sync message encoding/decoding
 type Protocol struct {
+	w       io.Writer
+	r       *bufio.Reader // Kept so that nothing read ahead is lost when compression starts
 	encoder *gob.Encoder
 	decoder *gob.Decoder
+	zw      *zstd.Encoder // Set once the connection is compressed
 }
 
 // NewProtocol creates a new protocol handler
 func NewProtocol(rw io.ReadWriter) *Protocol {
+	r := bufio.NewReader(rw)
 	return &Protocol{
+		w:       rw,
+		r:       r,
 		encoder: gob.NewEncoder(rw),
-		decoder: gob.NewDecoder(rw),
+		decoder: gob.NewDecoder(r),
 	}
 }
 
 // Send sends a message
 func (p *Protocol) Send(msg Message) error {
-	return p.encoder.Encode(msg)
+	if err := p.encoder.Encode(msg); err != nil {
+		return err
+	}
+	if p.zw != nil {
+		return p.zw.Flush()
+	}
+	return nil
 }
 
 // Receive receives a message
@@ -85,44 +140,77 @@ func (p *Protocol) Receive() (Message, error) {
 	return msg, err
 }
 
-// SendSignatureRequest sends a signature request
-func (p *Protocol) SendSignatureRequest(containerID, volumePath string) error {
+// compress switches both directions of the connection to zstd, with small
+// windows to keep memory use low. Each message is flushed as it is sent.
+func (p *Protocol) compress() error {
+	zw, err := zstd.NewWriter(p.w,
+		zstd.WithEncoderLevel(zstd.SpeedFastest),
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithWindowSize(1<<20),
+		zstd.WithLowerEncoderMem(true))
+	if err != nil {
+		return err
+	}
+	zr, err := zstd.NewReader(p.r,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderLowmem(true),
+		zstd.WithDecoderMaxWindow(8<<20))
+	if err != nil {
+		return err
+	}
+
+	p.zw = zw
+	p.encoder = gob.NewEncoder(zw)
+	p.decoder = gob.NewDecoder(zr)
+	return nil
+}
+
+// Compressed reports whether the connection is compressed
+func (p *Protocol) Compressed() bool {
+	return p.zw != nil
+}
+
+// SendSignatureRequest sends a signature request listing the sender's files
+func (p *Protocol) SendSignatureRequest(containerID, volumeTarget string, files []FileSignature) error {
 	return p.Send(Message{
 		Type: MsgSignatureRequest,
 		Payload: SignatureRequest{
-			ContainerID: containerID,
-			VolumePath:  volumePath,
+			ContainerID:  containerID,
+			VolumeTarget: volumeTarget,
+			Files:        files,
 		},
 	})
 }
 
-// SendSignatures sends file signatures
-func (p *Protocol) SendSignatures(signatures []FileSignature) error {
+// SendSignatures sends the block signatures of the files the receiver needs
+func (p *Protocol) SendSignatures(files []FileBlocks) error {
 	return p.Send(Message{
 		Type: MsgSignatureResponse,
 		Payload: SignatureResponse{
-			Signatures: signatures,
+			Files: files,
 		},
 	})
 }
 
-// SendDeltaRequest requests specific files
-func (p *Protocol) SendDeltaRequest(files []string) error {
+// SendDelta sends delta operations for a file, whose output starts at offset
+func (p *Protocol) SendDelta(path string, offset int64, ops []DeltaOp, done bool) error {
 	return p.Send(Message{
-		Type: MsgDeltaRequest,
-		Payload: DeltaRequest{
-			Files: files,
+		Type: MsgDeltaResponse,
+		Payload: DeltaResponse{
+			Path:   path,
+			Offset: offset,
+			Ops:    ops,
+			Done:   done,
 		},
 	})
 }
 
-// SendFile sends a file
-func (p *Protocol) SendFile(meta FileSignature, data []byte) error {
+// SendDeltaRequest requests specific files
+func (p *Protocol) SendDeltaRequest(files []string) error {
 	return p.Send(Message{
-		Type: MsgFileTransfer,
-		Payload: FileTransfer{
-			Meta: meta,
-			Data: data,
+		Type: MsgDeltaRequest,
+		Payload: DeltaRequest{
+			Files: files,
 		},
 	})
 }
@@ -154,7 +242,11 @@ func init() {
 	gob.Register(SignatureRequest{})
 	gob.Register(SignatureResponse{})
 	gob.Register(DeltaRequest{})
-	gob.Register(FileTransfer{})
+	gob.Register(DeltaResponse{})
+	gob.Register(ManifestRequest{})
+	gob.Register(ManifestResponse{})
+	gob.Register(PullRequest{})
+	gob.Register(Hello{})
 	gob.Register(AckMessage{})
 	gob.Register(ErrorMessage{})
 }
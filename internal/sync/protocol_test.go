@@ -0,0 +1,54 @@
+package sync
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSendVolume_CompressesAndReportsProgress(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	data := []byte(strings.Repeat("INSERT INTO log VALUES ('request served');\n", 100000))
+	if err := os.WriteFile(filepath.Join(src, "log.sql"), data, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	sender, err := NewSyncManager(src)
+	if err != nil {
+		t.Fatalf("Failed to create sender: %v", err)
+	}
+	receiver, err := NewSyncManager(dst)
+	if err != nil {
+		t.Fatalf("Failed to create receiver: %v", err)
+	}
+	var done, total int64
+	receiver.SetProgress(func(d, t int64) { done, total = d, t })
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	var sent int64
+	errc := make(chan error, 1)
+	go func() { errc <- receiver.ReceiveVolume(b) }()
+	if err := sender.SendVolume(countingConn{a, &sent}, Destination{}); err != nil {
+		t.Fatalf("SendVolume failed: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("ReceiveVolume failed: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dst, "log.sql"))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Expected the file to be synced, got %d bytes and %v", len(got), err)
+	}
+	if sent > int64(len(data))/10 {
+		t.Errorf("Expected compression to send under %d bytes, sent %d", len(data)/10, sent)
+	}
+	if total != int64(len(data)) || done != total {
+		t.Errorf("Expected progress to reach %d bytes, got %d of %d", len(data), done, total)
+	}
+}
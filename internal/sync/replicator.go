@@ -0,0 +1,413 @@
+package sync
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultDebounce is how long a replicator waits for more changes
+	DefaultDebounce = 500 * time.Millisecond
+
+	// DefaultMaxDelay bounds how long changes wait while a volume keeps changing
+	DefaultMaxDelay = 5 * time.Second
+
+	// maxJournal bounds the changes kept for peers that are behind. A peer
+	// whose checkpoint has been trimmed gets a full sync instead.
+	maxJournal = 100_000
+)
+
+// Dialer connects to the sync server at addr
+type Dialer func(addr string) (net.Conn, error)
+
+// Replicator pushes the changes to a volume to its peers as they happen.
+// Changes are journaled under increasing sequence numbers and a peer's
+// checkpoint is the last one it acknowledged, so a peer that could not be
+// reached catches up from its checkpoint once it can.
+type Replicator struct {
+	mgr      *SyncManager
+	target   string // Where the volume is mounted, which peers route pushes by
+	watcher  *VolumeWatcher
+	dial     Dialer
+	debounce time.Duration
+	maxDelay time.Duration
+
+	mu      sync.Mutex
+	seq     uint64
+	base    uint64 // Last sequence trimmed from the journal while a peer needed it
+	journal []change
+	peers   map[string]*peer
+
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// change is a journaled change to a volume entry
+type change struct {
+	seq  uint64
+	path string // Volume-relative, or "." when the whole volume may have changed
+	at   time.Time
+}
+
+// peer is a replica a volume is pushed to
+type peer struct {
+	addr        string
+	checkpoint  uint64
+	syncing     bool      // Receiving its initial full sync
+	behindSince time.Time // Time of the oldest change it has not acknowledged
+	lastSync    time.Time
+	lastErr     string
+}
+
+// ReplicationStatus reports how far a peer is behind a volume
+type ReplicationStatus struct {
+	Peer       string        `json:"peer"`
+	Address    string        `json:"address"`
+	Checkpoint uint64        `json:"checkpoint"`
+	Pending    uint64        `json:"pending"` // Changes not yet acknowledged
+	Lag        time.Duration `json:"lag"`     // Age of the oldest of them
+	LastSync   time.Time     `json:"last_sync,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// NewReplicator watches the volume at path, mounted at target, and pushes
+// its changes to peers, waiting for debounce without changes, or at most
+// maxDelay, to batch them.
+// Zero durations use the defaults.
+func NewReplicator(path, target string, debounce, maxDelay time.Duration, dial Dialer) (*Replicator, error) {
+	mgr, err := NewSyncManager(path)
+	if err != nil {
+		return nil, err
+	}
+	if debounce <= 0 {
+		debounce = DefaultDebounce
+	}
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxDelay
+	}
+
+	r := &Replicator{
+		mgr:      mgr,
+		target:   target,
+		dial:     dial,
+		debounce: debounce,
+		maxDelay: maxDelay,
+		peers:    make(map[string]*peer),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+
+	r.watcher, err = NewVolumeWatcher(path, r.record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch %s: %w", path, err)
+	}
+
+	r.wg.Add(1)
+	go r.run()
+
+	return r, nil
+}
+
+// EnableVersioning makes the replicator push a bidirectional volume, see
+// SyncManager.EnableVersioning. Call it before adding peers.
+func (r *Replicator) EnableVersioning(v Versioning) error {
+	return r.mgr.EnableVersioning(v)
+}
+
+// SetNodeID names this node to peers. Call it before adding peers.
+func (r *Replicator) SetNodeID(id string) {
+	r.mgr.SetNodeID(id)
+}
+
+// SetLimiter limits the bandwidth of the pushes to peers. Call it before
+// adding peers.
+func (r *Replicator) SetLimiter(l *Limiter) {
+	r.mgr.SetLimiter(l)
+}
+
+// AddPeer sends the whole volume to a peer, the container id on the node at
+// addr, and then keeps it up to date. Changes made during the initial sync
+// are sent after it.
+func (r *Replicator) AddPeer(id, addr string) error {
+	r.mu.Lock()
+	p := &peer{addr: addr, checkpoint: r.seq, syncing: true}
+	r.peers[id] = p
+	r.mu.Unlock()
+
+	err := r.send(id, addr, nil, true)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.peers[id] != p {
+		return nil // Removed or re-added meanwhile
+	}
+	if err != nil {
+		delete(r.peers, id)
+		return err
+	}
+	p.syncing = false
+	p.lastSync = time.Now()
+	p.behindSince = r.oldestAfter(p.checkpoint)
+	r.signal()
+	return nil
+}
+
+// RemovePeer stops pushing to a peer
+func (r *Replicator) RemovePeer(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.peers, id)
+}
+
+// HasPeer returns true if changes are pushed to the peer
+func (r *Replicator) HasPeer(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.peers[id]
+	return ok
+}
+
+// Status returns the replication status of every peer, sorted by peer
+func (r *Replicator) Status() []ReplicationStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var statuses []ReplicationStatus
+	for id, p := range r.peers {
+		st := ReplicationStatus{
+			Peer:       id,
+			Address:    p.addr,
+			Checkpoint: p.checkpoint,
+			Pending:    r.seq - p.checkpoint,
+			LastSync:   p.lastSync,
+			Error:      p.lastErr,
+		}
+		if !p.behindSince.IsZero() {
+			st.Lag = time.Since(p.behindSince)
+		}
+		statuses = append(statuses, st)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Peer < statuses[j].Peer })
+	return statuses
+}
+
+// Close stops watching the volume and pushing to peers
+func (r *Replicator) Close() {
+	close(r.done)
+	r.watcher.Close()
+	r.wg.Wait()
+}
+
+// record journals a change reported by the watcher
+func (r *Replicator) record(path string) {
+	relPath, err := filepath.Rel(r.mgr.localPath, path)
+	if err != nil || !filepath.IsLocal(relPath) && relPath != "." {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.seq++
+	r.journal = append(r.journal, change{seq: r.seq, path: relPath, at: now})
+	for _, p := range r.peers {
+		if p.behindSince.IsZero() {
+			p.behindSince = now
+		}
+	}
+
+	// Peers that are far behind get a full sync rather than the journal
+	if len(r.journal) > maxJournal {
+		drop := len(r.journal) - maxJournal/2
+		r.base = r.journal[drop-1].seq
+		r.journal = append([]change(nil), r.journal[drop:]...)
+	}
+
+	r.signal()
+}
+
+func (r *Replicator) signal() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Replicator) run() {
+	defer r.wg.Done()
+
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-r.wake:
+			if !r.settle() {
+				return
+			}
+		case <-retry:
+		}
+
+		retry = nil
+		if !r.flush() {
+			retry = time.After(r.maxDelay)
+		}
+	}
+}
+
+// settle waits until the volume has not changed for the debounce time, or
+// for at most maxDelay. Returns false if the replicator was closed.
+func (r *Replicator) settle() bool {
+	deadline := time.Now().Add(r.maxDelay)
+	for {
+		wait := r.debounce
+		if left := time.Until(deadline); left < wait {
+			wait = left
+		}
+		if wait <= 0 {
+			return true
+		}
+
+		select {
+		case <-r.done:
+			return false
+		case <-r.wake:
+		case <-time.After(wait):
+			return true
+		}
+	}
+}
+
+// flush pushes the changes each peer has not acknowledged, concurrently.
+// Returns true if every peer is up to date.
+func (r *Replicator) flush() bool {
+	type job struct {
+		id    string
+		addr  string
+		paths []string
+		full  bool
+	}
+
+	r.mu.Lock()
+	seq := r.seq
+	var jobs []job
+	for id, p := range r.peers {
+		if p.syncing || p.checkpoint >= seq {
+			continue
+		}
+		j := job{id: id, addr: p.addr, full: p.checkpoint < r.base}
+		if !j.full {
+			j.paths, j.full = r.changesSince(p.checkpoint)
+		}
+		jobs = append(jobs, j)
+	}
+	r.mu.Unlock()
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(jobs))
+	for i, j := range jobs {
+		wg.Add(1)
+		go func(i int, j job) {
+			defer wg.Done()
+			errs[i] = r.send(j.id, j.addr, j.paths, j.full)
+		}(i, j)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	caughtUp := true
+	for i, j := range jobs {
+		p, ok := r.peers[j.id]
+		if !ok {
+			continue
+		}
+		if errs[i] != nil {
+			p.lastErr = errs[i].Error()
+			caughtUp = false
+			logrus.Warnf("Failed to replicate %s to %s: %v", r.mgr.localPath, j.addr, errs[i])
+			continue
+		}
+		if seq > p.checkpoint {
+			p.checkpoint = seq
+		}
+		p.lastSync = time.Now()
+		p.lastErr = ""
+		p.behindSince = r.oldestAfter(p.checkpoint)
+		logrus.Debugf("Replicated %s to %s up to change %d", r.mgr.localPath, j.addr, p.checkpoint)
+	}
+	r.trim()
+
+	return caughtUp
+}
+
+// changesSince returns the paths changed after a checkpoint, or full if the
+// whole volume has to be sent
+func (r *Replicator) changesSince(checkpoint uint64) (paths []string, full bool) {
+	seen := make(map[string]bool)
+	for _, c := range r.journal {
+		if c.seq <= checkpoint || seen[c.path] {
+			continue
+		}
+		if c.path == "." {
+			return nil, true
+		}
+		seen[c.path] = true
+		paths = append(paths, c.path)
+	}
+	return paths, false
+}
+
+// oldestAfter returns the time of the oldest change after a checkpoint, or
+// zero if there is none
+func (r *Replicator) oldestAfter(checkpoint uint64) time.Time {
+	for _, c := range r.journal {
+		if c.seq > checkpoint {
+			return c.at
+		}
+	}
+	if checkpoint < r.base {
+		return time.Now()
+	}
+	return time.Time{}
+}
+
+// trim drops the changes every peer has acknowledged
+func (r *Replicator) trim() {
+	min := r.seq
+	for _, p := range r.peers {
+		if p.checkpoint < min {
+			min = p.checkpoint
+		}
+	}
+
+	i := 0
+	for i < len(r.journal) && r.journal[i].seq <= min {
+		i++
+	}
+	r.journal = append([]change(nil), r.journal[i:]...)
+}
+
+// send pushes a volume, or only the given paths of it, to a peer
+func (r *Replicator) send(id, addr string, paths []string, full bool) error {
+	conn, err := r.dial(addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	dest := Destination{ContainerID: id, VolumeTarget: r.target}
+	if full {
+		return r.mgr.SendVolume(conn, dest)
+	}
+	return r.mgr.SendChanges(conn, dest, paths)
+}
@@ -0,0 +1,174 @@
+package sync
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pipeDialer connects a replicator to a receiver of dst over in-memory
+// connections, counting them and failing while down is set
+type pipeDialer struct {
+	receiver *SyncManager
+	dials    int32
+	down     atomic.Bool
+}
+
+func (d *pipeDialer) dial(addr string) (net.Conn, error) {
+	if d.down.Load() {
+		return nil, errors.New("connection refused")
+	}
+	atomic.AddInt32(&d.dials, 1)
+	a, b := net.Pipe()
+	go func() {
+		defer b.Close()
+		d.receiver.ReceiveVolume(b)
+	}()
+	return a, nil
+}
+
+func newTestReplicator(t *testing.T, src, dst string) (*Replicator, *pipeDialer) {
+	t.Helper()
+	receiver, err := NewSyncManager(dst)
+	if err != nil {
+		t.Fatalf("Failed to create receiver: %v", err)
+	}
+	d := &pipeDialer{receiver: receiver}
+
+	r, err := NewReplicator(src, "/data", 50*time.Millisecond, 200*time.Millisecond, d.dial)
+	if err != nil {
+		t.Fatalf("Failed to create replicator: %v", err)
+	}
+	t.Cleanup(r.Close)
+
+	if err := r.AddPeer("replica", "pipe"); err != nil {
+		t.Fatalf("Initial sync failed: %v", err)
+	}
+	return r, d
+}
+
+// waitFor polls cond until it holds or a few seconds have passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func contentIs(path, want string) func() bool {
+	return func() bool {
+		data, err := os.ReadFile(path)
+		return err == nil && string(data) == want
+	}
+}
+
+func missing(path string) func() bool {
+	return func() bool {
+		_, err := os.Lstat(path)
+		return os.IsNotExist(err)
+	}
+}
+
+func TestReplicator_PushesChanges(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	tree(t, src, map[string]string{"config.yml": "v1", "old.log": "x"})
+	r, _ := newTestReplicator(t, src, dst)
+	assertContent(t, filepath.Join(dst, "config.yml"), "v1")
+
+	os.WriteFile(filepath.Join(src, "config.yml"), []byte("v2"), 0644)
+	os.Remove(filepath.Join(src, "old.log"))
+	os.MkdirAll(filepath.Join(src, "new", "nested"), 0755)
+	os.WriteFile(filepath.Join(src, "new", "nested", "file"), []byte("n"), 0644)
+
+	waitFor(t, "changes to arrive", func() bool {
+		return contentIs(filepath.Join(dst, "config.yml"), "v2")() &&
+			contentIs(filepath.Join(dst, "new", "nested", "file"), "n")() &&
+			missing(filepath.Join(dst, "old.log"))()
+	})
+	waitFor(t, "the replica to catch up", func() bool {
+		st := r.Status()
+		return len(st) == 1 && st[0].Pending == 0 && st[0].Lag == 0
+	})
+}
+
+func TestReplicator_BatchesChanges(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	_, d := newTestReplicator(t, src, dst)
+	initial := atomic.LoadInt32(&d.dials)
+
+	for i := 0; i < 50; i++ {
+		os.WriteFile(filepath.Join(src, "counter"), []byte{byte(i)}, 0644)
+	}
+
+	waitFor(t, "the last write", contentIs(filepath.Join(dst, "counter"), string([]byte{49})))
+	if pushes := atomic.LoadInt32(&d.dials) - initial; pushes > 3 {
+		t.Errorf("Expected 50 quick writes to be batched, got %d pushes", pushes)
+	}
+}
+
+func TestReplicator_ResumesFromCheckpoint(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	tree(t, src, map[string]string{"a": "1"})
+	r, d := newTestReplicator(t, src, dst)
+	checkpoint := r.Status()[0].Checkpoint
+
+	// The replica cannot be reached while the primary keeps changing
+	d.down.Store(true)
+	os.WriteFile(filepath.Join(src, "a"), []byte("2"), 0644)
+	os.WriteFile(filepath.Join(src, "b"), []byte("new"), 0644)
+
+	waitFor(t, "the failed push", func() bool {
+		st := r.Status()[0]
+		return st.Error != "" && st.Pending > 0 && st.Lag > 0
+	})
+	if st := r.Status()[0]; st.Checkpoint != checkpoint {
+		t.Errorf("Expected the checkpoint to stay at %d while down, got %d", checkpoint, st.Checkpoint)
+	}
+
+	d.down.Store(false)
+	waitFor(t, "the replica to catch up", func() bool {
+		st := r.Status()[0]
+		return st.Pending == 0 && st.Error == ""
+	})
+	assertContent(t, filepath.Join(dst, "a"), "2")
+	assertContent(t, filepath.Join(dst, "b"), "new")
+}
+
+func TestSendChanges_LeavesOtherEntriesAlone(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	tree(t, src, map[string]string{"changed": "new", "dir/removed": "x"})
+	tree(t, dst, map[string]string{"changed": "old", "dir/removed": "x", "replica-only": "y"})
+
+	// Same size, so the replica's copy must have another time to be replaced
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dst, "changed"), past, past)
+
+	sender, _ := NewSyncManager(src)
+	receiver, _ := NewSyncManager(dst)
+	os.Remove(filepath.Join(src, "dir", "removed"))
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	errc := make(chan error, 1)
+	go func() { errc <- receiver.ReceiveVolume(b) }()
+
+	if err := sender.SendChanges(a, Destination{}, []string{"changed", filepath.Join("dir", "removed")}); err != nil {
+		t.Fatalf("SendChanges failed: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("ReceiveVolume failed: %v", err)
+	}
+
+	assertContent(t, filepath.Join(dst, "changed"), "new")
+	assertMissing(t, filepath.Join(dst, "dir", "removed"))
+	assertContent(t, filepath.Join(dst, "replica-only"), "y")
+}
@@ -1,10 +1,11 @@
 package sync
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
-	"os"
-	"path/filepath"
 	"sync"
 
 	"github.com/sirupsen/logrus"
@@ -15,14 +16,27 @@ const (
 	DefaultSyncPort = 18733
 )
 
+// VolumeLookup returns the path of a local container's volume mounted at
+// target, for serving manifests
+type VolumeLookup func(containerID, target string) (string, error)
+
 // Server handles incoming sync requests
 type Server struct {
 	listener   net.Listener
-	volumePaths map[string]string // containerID -> volume path
+	containers map[string]map[string]registeredVolume // containerID -> volume target -> volume
+	lookup     VolumeLookup
+	limiter    *Limiter
+	nodeID     string // Names us in hellos
 	mu         sync.RWMutex
 	done       chan struct{}
 }
 
+// registeredVolume is a local volume that receives pushes
+type registeredVolume struct {
+	path       string
+	versioning *Versioning // Set for bidirectional volumes
+}
+
 // NewServer creates a new sync server
 func NewServer(port int) (*Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
@@ -31,25 +45,85 @@ func NewServer(port int) (*Server, error) {
 	}
 
 	return &Server{
-		listener:    listener,
-		volumePaths: make(map[string]string),
-		done:        make(chan struct{}),
+		listener:   listener,
+		containers: make(map[string]map[string]registeredVolume),
+		done:       make(chan struct{}),
 	}, nil
 }
 
-// RegisterVolume registers a container's volume for sync
-func (s *Server) RegisterVolume(containerID, volumePath string) {
+// RegisterVolume registers the volume a container mounts at target for sync
+func (s *Server) RegisterVolume(containerID, target, volumePath string) {
+	s.register(containerID, target, registeredVolume{path: volumePath})
+}
+
+// RegisterBidirectionalVolume registers a container's volume for sync in
+// both directions: pushes to it are applied by version, see Versioning
+func (s *Server) RegisterBidirectionalVolume(containerID, target, volumePath string, v Versioning) {
+	s.register(containerID, target, registeredVolume{path: volumePath, versioning: &v})
+}
+
+func (s *Server) register(containerID, target string, vol registeredVolume) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.volumePaths[containerID] = volumePath
-	logrus.Infof("Registered volume for container %s at %s", containerID[:12], volumePath)
+	if s.containers[containerID] == nil {
+		s.containers[containerID] = make(map[string]registeredVolume)
+	}
+	s.containers[containerID][target] = vol
+	logrus.Infof("Registered volume %s of container %s at %s", target, shortID(containerID), vol.path)
+}
+
+// SetVolumeLookup lets the server describe local container volumes to
+// peers verifying their replicas, and send them to peers pulling them. Only
+// peers with a client certificate of the cluster CA are served.
+func (s *Server) SetVolumeLookup(lookup VolumeLookup) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lookup = lookup
 }
 
-// UnregisterVolume removes a container's volume from sync
+// SetLimiter limits the bandwidth of the connections the server accepts
+func (s *Server) SetLimiter(l *Limiter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limiter = l
+}
+
+// SetNodeID names this node to the peers that connect
+func (s *Server) SetNodeID(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodeID = id
+}
+
+// UnregisterVolume removes every volume of a container from sync
 func (s *Server) UnregisterVolume(containerID string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	delete(s.volumePaths, containerID)
+	delete(s.containers, containerID)
+}
+
+// volume returns the registered volume a container mounts at target
+func (s *Server) volume(containerID, target string) (registeredVolume, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	volumes, ok := s.containers[containerID]
+	if !ok {
+		return registeredVolume{}, fmt.Errorf("unknown container %q", containerID)
+	}
+	vol, ok := volumes[target]
+	if !ok {
+		return registeredVolume{}, fmt.Errorf("container %s has no volume at %q", shortID(containerID), target)
+	}
+	return vol, nil
+}
+
+// shortID shortens a container ID for logs
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
 }
 
 // Start starts accepting connections
@@ -78,128 +152,198 @@ func (s *Server) Start() {
 	}
 }
 
-// handleConnection handles a single sync connection
+// handleConnection handles a single sync connection: a volume pushed to
+// us, or a request for the manifest of a local volume
 func (s *Server) handleConnection(conn net.Conn) {
 	defer conn.Close()
 
 	remoteAddr := conn.RemoteAddr().String()
 	logrus.Infof("New sync connection from %s", remoteAddr)
 
-	// Get the default volume path (first registered)
 	s.mu.RLock()
-	var volumePath string
-	for _, path := range s.volumePaths {
-		volumePath = path
-		break
-	}
+	limiter, nodeID := s.limiter, s.nodeID
 	s.mu.RUnlock()
 
-	if volumePath == "" {
-		logrus.Warn("No volumes registered for sync")
+	proto := NewProtocol(limiter.Conn(conn))
+	peer, err := proto.AcceptHandshake(NewHello(conn, nodeID))
+	if err != nil {
+		logrus.Warnf("Sync handshake with %s failed: %v", remoteAddr, err)
 		return
 	}
+	logrus.Debugf("Sync peer %s at %s speaks protocol version %d with %v", peer.NodeID, remoteAddr, peer.Version, peer.Features)
 
-	// Create sync manager and receive data
-	mgr, err := NewSyncManager(volumePath)
+	msg, err := proto.Receive()
 	if err != nil {
-		logrus.Errorf("Failed to create sync manager: %v", err)
+		logrus.Errorf("Failed to receive message: %v", err)
 		return
 	}
 
-	if err := mgr.ReceiveVolume(conn); err != nil {
-		logrus.Errorf("Failed to receive volume data: %v", err)
-		return
+	switch req := msg.Payload.(type) {
+	case SignatureRequest:
+		if s.authorizePeer(proto, conn, req.ContainerID, remoteAddr) {
+			s.handlePush(proto, req, remoteAddr)
+		}
+	case ManifestRequest:
+		if s.authorizePeer(proto, conn, req.ContainerID, remoteAddr) {
+			s.handleManifest(proto, req)
+		}
+	case PullRequest:
+		if s.authorizePeer(proto, conn, req.ContainerID, remoteAddr) {
+			s.handlePull(proto, req, remoteAddr)
+		}
+	default:
+		proto.SendError(400, "unexpected message type")
 	}
-
-	logrus.Infof("Sync completed from %s", remoteAddr)
 }
 
-// handleConnectionWithProtocol handles connection using the sync protocol
-func (s *Server) handleConnectionWithProtocol(conn net.Conn) {
-	defer conn.Close()
+// verifiedPeer returns the common name of the client certificate a peer
+// connected with, which the TLS handshake verified against the cluster CA
+func verifiedPeer(conn net.Conn) (string, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", errors.New("syncing volumes requires tls.enabled with a CA and a client certificate")
+	}
+	chains := tlsConn.ConnectionState().VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return "", errors.New("syncing volumes requires a client certificate signed by the cluster CA")
+	}
+	return chains[0][0].Subject.CommonName, nil
+}
 
-	remoteAddr := conn.RemoteAddr().String()
-	logrus.Infof("New sync connection from %s", remoteAddr)
+// authorizePeer checks that the peer may sync a local container's volumes,
+// sending it a 403 if not. Manifests and pulls hand out a volume's contents
+// and pushes overwrite it, so only peers holding a certificate of the cluster
+// CA get to do either.
+func (s *Server) authorizePeer(proto *Protocol, conn net.Conn, containerID, remoteAddr string) bool {
+	peer, err := verifiedPeer(conn)
+	if err != nil {
+		logrus.Warnf("Refused to sync volumes of %s with %s: %v", shortID(containerID), remoteAddr, err)
+		proto.SendError(403, err.Error())
+		return false
+	}
+	logrus.Debugf("Syncing volumes of %s with %s at %s", shortID(containerID), peer, remoteAddr)
+	return true
+}
 
-	proto := NewProtocol(conn)
+// handlePush receives a volume into the registered volume it is for
+func (s *Server) handlePush(proto *Protocol, req SignatureRequest, remoteAddr string) {
+	vol, err := s.volume(req.ContainerID, req.VolumeTarget)
+	if err != nil {
+		logrus.Warnf("Rejected push from %s: %v", remoteAddr, err)
+		proto.SendError(404, err.Error())
+		return
+	}
 
-	// Receive initial message
-	msg, err := proto.Receive()
+	mgr, err := NewSyncManager(vol.path)
 	if err != nil {
-		logrus.Errorf("Failed to receive message: %v", err)
+		logrus.Errorf("Failed to create sync manager: %v", err)
+		proto.SendError(500, err.Error())
 		return
 	}
+	if vol.versioning != nil {
+		if err := mgr.EnableVersioning(*vol.versioning); err != nil {
+			logrus.Errorf("Failed to enable versioning: %v", err)
+			proto.SendError(500, err.Error())
+			return
+		}
+	}
 
-	switch msg.Type {
-	case MsgSignatureRequest:
-		s.handleSignatureRequest(proto, msg.Payload.(SignatureRequest))
-	case MsgDeltaRequest:
-		s.handleDeltaRequest(proto, msg.Payload.(DeltaRequest))
-	default:
-		proto.SendError(400, "unexpected message type")
+	if err := mgr.receive(proto, req); err != nil {
+		logrus.Errorf("Failed to receive volume %s of %s: %v", req.VolumeTarget, shortID(req.ContainerID), err)
+		return
 	}
+
+	logrus.Infof("Sync of volume %s of %s completed from %s", req.VolumeTarget, shortID(req.ContainerID), remoteAddr)
 }
 
-// handleSignatureRequest handles a signature request
-func (s *Server) handleSignatureRequest(proto *Protocol, req SignatureRequest) {
+// localVolume returns the path of a local container volume, which is either
+// registered or found by the volume lookup
+func (s *Server) localVolume(containerID, target string) (string, error) {
+	vol, err := s.volume(containerID, target)
+	if err == nil {
+		return vol.path, nil
+	}
+
 	s.mu.RLock()
-	volumePath, ok := s.volumePaths[req.ContainerID]
+	lookup := s.lookup
 	s.mu.RUnlock()
 
-	if !ok {
-		proto.SendError(404, "container not found")
+	if lookup == nil {
+		return "", err
+	}
+	return lookup(containerID, target)
+}
+
+// handleManifest sends the signatures of a local container volume
+func (s *Server) handleManifest(proto *Protocol, req ManifestRequest) {
+	path, err := s.localVolume(req.ContainerID, req.VolumeTarget)
+	if err != nil {
+		proto.SendError(404, err.Error())
 		return
 	}
 
-	fullPath := filepath.Join(volumePath, req.VolumePath)
-	mgr, err := NewSyncManager(fullPath)
+	mgr, err := NewSyncManager(path)
 	if err != nil {
 		proto.SendError(500, err.Error())
 		return
 	}
-
-	signatures, err := mgr.collectSignatures()
+	files, err := mgr.Signatures()
 	if err != nil {
 		proto.SendError(500, err.Error())
 		return
 	}
 
-	proto.SendSignatures(signatures)
+	proto.Send(Message{Type: MsgManifestResponse, Payload: ManifestResponse{Files: files}})
 }
 
-// handleDeltaRequest handles a delta/file request
-func (s *Server) handleDeltaRequest(proto *Protocol, req DeltaRequest) {
-	// Get first registered volume
-	s.mu.RLock()
-	var volumePath string
-	for _, path := range s.volumePaths {
-		volumePath = path
-		break
+// handlePull pushes a local container volume to the peer asking for it
+func (s *Server) handlePull(proto *Protocol, req PullRequest, remoteAddr string) {
+	path, err := s.localVolume(req.ContainerID, req.VolumeTarget)
+	if err != nil {
+		logrus.Warnf("Rejected pull from %s: %v", remoteAddr, err)
+		proto.SendError(404, err.Error())
+		return
 	}
-	s.mu.RUnlock()
 
-	if volumePath == "" {
-		proto.SendError(404, "no volumes registered")
+	mgr, err := NewSyncManager(path)
+	if err != nil {
+		proto.SendError(500, err.Error())
 		return
 	}
-
-	for _, relPath := range req.Files {
-		fullPath := filepath.Join(volumePath, relPath)
-		data, err := readFileData(fullPath)
-		if err != nil {
-			logrus.Errorf("Failed to read file %s: %v", relPath, err)
-			continue
-		}
-
-		proto.SendFile(FileSignature{Path: relPath, Size: int64(len(data))}, data)
+	files, err := mgr.Signatures()
+	if err != nil {
+		proto.SendError(500, err.Error())
+		return
 	}
 
-	proto.SendAck(true, "transfer complete")
+	if err := mgr.send(proto, SignatureRequest{Files: files}); err != nil {
+		logrus.Errorf("Failed to send %s to %s: %v", path, remoteAddr, err)
+		return
+	}
+	logrus.Infof("Sent volume %s of %s to %s", req.VolumeTarget, shortID(req.ContainerID), remoteAddr)
 }
 
-// readFileData reads entire file into memory
-func readFileData(path string) ([]byte, error) {
-	return os.ReadFile(path)
+// RequestManifest asks the sync server on conn for the signatures of a
+// container volume, naming us as nodeID
+func RequestManifest(conn io.ReadWriter, nodeID, containerID, volumeTarget string) ([]FileSignature, error) {
+	proto := NewProtocol(conn)
+	if _, err := proto.Handshake(NewHello(conn, nodeID)); err != nil {
+		return nil, fmt.Errorf("sync handshake failed: %w", err)
+	}
+	req := ManifestRequest{ContainerID: containerID, VolumeTarget: volumeTarget}
+	if err := proto.Send(Message{Type: MsgManifestRequest, Payload: req}); err != nil {
+		return nil, fmt.Errorf("failed to request manifest: %w", err)
+	}
+
+	msg, err := proto.Receive()
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive manifest: %w", err)
+	}
+	resp, ok := msg.Payload.(ManifestResponse)
+	if msg.Type != MsgManifestResponse || !ok {
+		return nil, unexpectedMessage(msg, MsgManifestResponse)
+	}
+	return resp.Files, nil
 }
 
 // Stop stops the sync server
@@ -0,0 +1,236 @@
+package sync
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	server, err := NewServer(0)
+	if err != nil {
+		t.Fatalf("Failed to create sync server: %v", err)
+	}
+	go server.Start()
+	t.Cleanup(func() { server.Stop() })
+	return server, server.Addr().String()
+}
+
+// testTLS returns mutual TLS settings for a server and a client, with
+// certificates from a fresh cluster CA
+func testTLS(t *testing.T) (server, client TLSConfig) {
+	t.Helper()
+	certDir := t.TempDir()
+	ca, err := LoadOrCreateCA(certDir)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	issue := func(host string) TLSConfig {
+		certFile := filepath.Join(certDir, host+".crt")
+		keyFile := filepath.Join(certDir, host+".key")
+		if err := ca.IssueCertificate([]string{host}, time.Hour, certFile, keyFile); err != nil {
+			t.Fatalf("Failed to issue certificate: %v", err)
+		}
+		return TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile, CAFile: ca.CertFile}
+	}
+	return issue("127.0.0.1"), issue("node-b")
+}
+
+// startTLSTestServer starts a sync server over mutual TLS and returns a
+// client with a certificate of the same CA
+func startTLSTestServer(t *testing.T) (*Server, string, *TLSClient) {
+	t.Helper()
+	serverTLS, clientTLS := testTLS(t)
+	server, err := NewTLSServer(0, serverTLS)
+	if err != nil {
+		t.Fatalf("Failed to create sync server: %v", err)
+	}
+	go server.Start()
+	t.Cleanup(func() { server.Stop() })
+
+	client, err := NewTLSClient(clientTLS)
+	if err != nil {
+		t.Fatalf("Failed to create sync client: %v", err)
+	}
+	port := server.Addr().(*net.TCPAddr).Port
+	return server.Server, net.JoinHostPort("127.0.0.1", fmt.Sprint(port)), client
+}
+
+func TestServer_RoutesPushesByContainerAndVolume(t *testing.T) {
+	server, addr, client := startTLSTestServer(t)
+
+	// Each container mounts two volumes, all pushed at once
+	type volume struct {
+		dest     Destination
+		src, dst string
+	}
+	var volumes []volume
+	for _, id := range []string{"ctr-a", "ctr-b", "ctr-c"} {
+		for _, target := range []string{"/data", "/logs"} {
+			v := volume{
+				dest: Destination{ContainerID: id, VolumeTarget: target},
+				src:  t.TempDir(),
+				dst:  t.TempDir(),
+			}
+			tree(t, v.src, map[string]string{"owner": id + target})
+			server.RegisterVolume(id, target, v.dst)
+			volumes = append(volumes, v)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(volumes))
+	for i, v := range volumes {
+		wg.Add(1)
+		go func(i int, v volume) {
+			defer wg.Done()
+			mgr, err := NewSyncManager(v.src)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			errs[i] = mgr.SyncVolume(client, addr, v.dest)
+		}(i, v)
+	}
+	wg.Wait()
+
+	for i, v := range volumes {
+		if errs[i] != nil {
+			t.Errorf("Expected push to %v to succeed, got %v", v.dest, errs[i])
+			continue
+		}
+		assertContent(t, filepath.Join(v.dst, "owner"), v.dest.ContainerID+v.dest.VolumeTarget)
+	}
+}
+
+func TestServer_RejectsUnknownDestinations(t *testing.T) {
+	server, addr, client := startTLSTestServer(t)
+	dst := t.TempDir()
+	server.RegisterVolume("ctr-a", "/data", dst)
+
+	src := t.TempDir()
+	tree(t, src, map[string]string{"file": "data"})
+	mgr, err := NewSyncManager(src)
+	if err != nil {
+		t.Fatalf("Failed to create sync manager: %v", err)
+	}
+
+	tests := []struct {
+		dest Destination
+		want string
+	}{
+		{Destination{ContainerID: "ctr-b", VolumeTarget: "/data"}, "unknown container"},
+		{Destination{ContainerID: "ctr-a", VolumeTarget: "/logs"}, "has no volume"},
+		{Destination{}, "unknown container"},
+	}
+	for _, tt := range tests {
+		err := mgr.SyncVolume(client, addr, tt.dest)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Expected push to %v to fail with %q, got %v", tt.dest, tt.want, err)
+		}
+	}
+	assertMissing(t, filepath.Join(dst, "file"))
+
+	server.UnregisterVolume("ctr-a")
+	err = mgr.SyncVolume(client, addr, Destination{ContainerID: "ctr-a", VolumeTarget: "/data"})
+	if err == nil || !strings.Contains(err.Error(), "peer error 404") {
+		t.Errorf("Expected push to an unregistered container to fail, got %v", err)
+	}
+}
+
+func TestServer_ServesPulls(t *testing.T) {
+	server, addr, client := startTLSTestServer(t)
+	primary := t.TempDir()
+	tree(t, primary, map[string]string{"db/data": "rows"})
+	server.SetVolumeLookup(func(containerID, target string) (string, error) {
+		if containerID != "primary" {
+			return "", fmt.Errorf("unknown container %q", containerID)
+		}
+		return primary, nil
+	})
+
+	replica := t.TempDir()
+	mgr, err := NewSyncManager(replica)
+	if err != nil {
+		t.Fatalf("Failed to create sync manager: %v", err)
+	}
+
+	conn, err := client.Dial(addr, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	if err := mgr.PullVolume(conn, "primary", ""); err != nil {
+		t.Fatalf("PullVolume failed: %v", err)
+	}
+	assertContent(t, filepath.Join(replica, "db", "data"), "rows")
+
+	conn2, err := client.Dial(addr, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn2.Close()
+	if err := mgr.PullVolume(conn2, "other", ""); err == nil || !strings.Contains(err.Error(), "unknown container") {
+		t.Errorf("Expected pulling an unknown container to fail, got %v", err)
+	}
+}
+
+func TestServer_RefusesPeersWithoutClientCertificate(t *testing.T) {
+	server, addr := startTestServer(t)
+	primary := t.TempDir()
+	tree(t, primary, map[string]string{"secret": "rows"})
+	server.SetVolumeLookup(func(containerID, target string) (string, error) {
+		return primary, nil
+	})
+
+	replica := t.TempDir()
+	mgr, err := NewSyncManager(replica)
+	if err != nil {
+		t.Fatalf("Failed to create sync manager: %v", err)
+	}
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+	if err := mgr.PullVolume(conn, "primary", ""); err == nil || !strings.Contains(err.Error(), "peer error 403") {
+		t.Errorf("Expected a pull over plain TCP to be refused, got %v", err)
+	}
+	assertMissing(t, filepath.Join(replica, "secret"))
+
+	conn2, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn2.Close()
+	if _, err := RequestManifest(conn2, "node-b", "primary", ""); err == nil || !strings.Contains(err.Error(), "peer error 403") {
+		t.Errorf("Expected a manifest request over plain TCP to be refused, got %v", err)
+	}
+
+	// Pushes would overwrite a registered volume
+	dst := t.TempDir()
+	server.RegisterVolume("replica", "/data", dst)
+	conn3, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn3.Close()
+	if err := mgr.SendVolume(conn3, Destination{ContainerID: "replica", VolumeTarget: "/data"}); err == nil || !strings.Contains(err.Error(), "peer error 403") {
+		t.Errorf("Expected a push over plain TCP to be refused, got %v", err)
+	}
+	assertMissing(t, filepath.Join(dst, "secret"))
+}
+
+func TestNewTLSServer_RequiresCA(t *testing.T) {
+	serverTLS, _ := testTLS(t)
+	serverTLS.CAFile = ""
+	if _, err := NewTLSServer(0, serverTLS); err == nil {
+		t.Error("Expected a TLS sync server without a CA to be refused")
+	}
+}
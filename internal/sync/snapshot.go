@@ -0,0 +1,411 @@
+package sync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// Snapshot is a point-in-time copy of a container's rw volumes. Its
+// manifest lists each volume's entries as sync signatures, and the content
+// of every file is stored once per SHA-256 among the store's objects, so a
+// snapshot can be compared with a live volume or sent to a peer like one.
+type Snapshot struct {
+	ID          string
+	ContainerID string
+	Name        string // Container name when the snapshot was taken
+	Created     time.Time
+	Paused      bool // Whether the container was paused while it was taken
+	Volumes     []SnapshotVolume
+}
+
+// SnapshotVolume is the content of one volume in a snapshot
+type SnapshotVolume struct {
+	Target string
+	Source string
+	Files  []FileSignature
+}
+
+// Size returns the size of the files in the snapshot, counting hardlinked
+// files once
+func (s *Snapshot) Size() int64 {
+	var size int64
+	for _, vol := range s.Volumes {
+		for _, sig := range vol.Files {
+			if sig.Type == EntryFile {
+				size += sig.Size
+			}
+		}
+	}
+	return size
+}
+
+// RetentionPolicy decides which snapshots Prune removes. The newest
+// snapshot of a container is always kept.
+type RetentionPolicy struct {
+	Keep   int           // Newest snapshots kept per container, 0 for unlimited
+	MaxAge time.Duration // Age after which snapshots are removed, 0 for unlimited
+}
+
+// SnapshotStore keeps snapshots under a directory: their manifests in
+// manifests/ and file contents in objects/, named after their SHA-256
+type SnapshotStore struct {
+	root string
+}
+
+// snapshotIDSize is how many hex digits of a manifest's hash name it
+const snapshotIDSize = 12
+
+// NewSnapshotStore opens the snapshot store at root, creating it if needed
+func NewSnapshotStore(root string) (*SnapshotStore, error) {
+	for _, dir := range []string{"manifests", "objects"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0700); err != nil {
+			return nil, fmt.Errorf("failed to create snapshot store: %w", err)
+		}
+	}
+	return &SnapshotStore{root: root}, nil
+}
+
+// Create snapshots the rw volumes of ctr. Files are stored as they are when
+// they are read, so the caller pauses the container first if the snapshot
+// must be consistent across files; paused is recorded in the snapshot.
+func (st *SnapshotStore) Create(ctr *types.Container, paused bool) (*Snapshot, error) {
+	snap := &Snapshot{
+		ContainerID: ctr.ID,
+		Name:        ctr.Name,
+		Created:     time.Now().UTC(),
+		Paused:      paused,
+	}
+
+	for _, vol := range ctr.Volumes {
+		if vol.Mode != "rw" {
+			continue
+		}
+		source, err := filepath.Abs(vol.Source)
+		if err != nil {
+			return nil, err
+		}
+		mgr, err := NewSyncManager(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to snapshot volume %s: %w", vol.Target, err)
+		}
+		files, err := mgr.Signatures()
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan volume %s: %w", vol.Target, err)
+		}
+
+		for i, sig := range files {
+			if sig.Type != EntryFile {
+				continue
+			}
+			if files[i], err = st.store(filepath.Join(source, sig.Path), sig); err != nil {
+				return nil, fmt.Errorf("failed to store %s of volume %s: %w", sig.Path, vol.Target, err)
+			}
+		}
+		snap.Volumes = append(snap.Volumes, SnapshotVolume{Target: vol.Target, Source: source, Files: files})
+	}
+	if len(snap.Volumes) == 0 {
+		return nil, fmt.Errorf("container %s has no rw volumes", ctr.ShortID())
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	snap.ID = hex.EncodeToString(sum[:])[:snapshotIDSize]
+
+	if err := st.save(snap); err != nil {
+		return nil, err
+	}
+	return snap, nil
+}
+
+// objectPath returns where the content with the given SHA-256 is stored
+func (st *SnapshotStore) objectPath(checksum []byte) string {
+	name := hex.EncodeToString(checksum)
+	return filepath.Join(st.root, "objects", name[:2], name)
+}
+
+// store copies a file into the objects unless its content is there
+// already. The file may have changed since sig was taken, in which case
+// the signature of what was copied is returned.
+func (st *SnapshotStore) store(path string, sig FileSignature) (FileSignature, error) {
+	if len(sig.Checksum) == sha256.Size {
+		if _, err := os.Stat(st.objectPath(sig.Checksum)); err == nil {
+			return sig, nil
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return sig, err
+	}
+	defer f.Close()
+
+	tmp, err := os.CreateTemp(filepath.Join(st.root, "objects"), ".object-*")
+	if err != nil {
+		return sig, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), f)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return sig, err
+	}
+
+	if sum := h.Sum(nil); !bytes.Equal(sum, sig.Checksum) {
+		logrus.Debugf("%s changed while it was snapshotted", path)
+		sig.Checksum, sig.Size = sum, size
+	}
+	object := st.objectPath(sig.Checksum)
+	if err := os.MkdirAll(filepath.Dir(object), 0700); err != nil {
+		return sig, err
+	}
+	if err := os.Chmod(tmp.Name(), 0400); err != nil {
+		return sig, err
+	}
+	return sig, os.Rename(tmp.Name(), object)
+}
+
+// manifestPath returns where the manifest of a snapshot is stored
+func (st *SnapshotStore) manifestPath(id string) string {
+	return filepath.Join(st.root, "manifests", id+".json")
+}
+
+// save writes the manifest of a snapshot
+func (st *SnapshotStore) save(snap *Snapshot) error {
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := st.manifestPath(snap.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// List returns the snapshots of a container, or of every container if
+// containerID is empty, oldest first
+func (st *SnapshotStore) List(containerID string) ([]*Snapshot, error) {
+	paths, err := filepath.Glob(filepath.Join(st.root, "manifests", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var snaps []*Snapshot
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var snap Snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if containerID == "" || snap.ContainerID == containerID {
+			snaps = append(snaps, &snap)
+		}
+	}
+
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Created.Before(snaps[j].Created) })
+	return snaps, nil
+}
+
+// Get returns the snapshot with the given ID or ID prefix
+func (st *SnapshotStore) Get(id string) (*Snapshot, error) {
+	snaps, err := st.List("")
+	if err != nil {
+		return nil, err
+	}
+
+	var found *Snapshot
+	for _, snap := range snaps {
+		if !strings.HasPrefix(snap.ID, id) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("snapshot ID %s is ambiguous", id)
+		}
+		found = snap
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no such snapshot: %s", id)
+	}
+	return found, nil
+}
+
+// Restore makes the volumes of ctr match a snapshot. Volumes are matched by
+// target, and entries that are not in the snapshot are removed.
+func (st *SnapshotStore) Restore(snap *Snapshot, ctr *types.Container) error {
+	sources := make(map[string]string, len(ctr.Volumes))
+	for _, vol := range ctr.Volumes {
+		sources[vol.Target] = vol.Source
+	}
+
+	for _, vol := range snap.Volumes {
+		source, ok := sources[vol.Target]
+		if !ok {
+			return fmt.Errorf("container %s has no volume at %s", ctr.ShortID(), vol.Target)
+		}
+		if err := os.MkdirAll(source, 0755); err != nil {
+			return err
+		}
+		mgr, err := NewSyncManager(source)
+		if err != nil {
+			return err
+		}
+		if err := st.restoreVolume(mgr, vol.Files); err != nil {
+			return fmt.Errorf("failed to restore volume %s: %w", vol.Target, err)
+		}
+	}
+	return nil
+}
+
+// restoreVolume makes the volume of mgr hold files, like a push of them
+// would, with the content of changed files coming from the objects
+func (st *SnapshotStore) restoreVolume(mgr *SyncManager, files []FileSignature) error {
+	keep := make(map[string]bool, len(files))
+	var links []FileSignature
+	for _, sig := range files {
+		localPath, err := mgr.localFile(sig.Path)
+		if err != nil {
+			return err
+		}
+		for p := filepath.Clean(sig.Path); p != "."; p = filepath.Dir(p) {
+			keep[p] = true
+		}
+		if err := mgr.prepareEntry(localPath, sig); err != nil {
+			return fmt.Errorf("failed to create %s: %w", sig.Path, err)
+		}
+		if sig.Type == EntryHardlink {
+			links = append(links, sig)
+			continue
+		}
+		if sig.Type != EntryFile || !needsUpdate(localPath, sig) {
+			continue
+		}
+		if err := st.restoreFile(localPath, sig); err != nil {
+			return err
+		}
+	}
+
+	return mgr.finishEntries(files, links, keep)
+}
+
+// restoreFile replaces the file at localPath with the stored content of sig,
+// which must still match its checksum
+func (st *SnapshotStore) restoreFile(localPath string, sig FileSignature) error {
+	object, err := os.Open(st.objectPath(sig.Checksum))
+	if err != nil {
+		return fmt.Errorf("snapshot content of %s is missing: %w", sig.Path, err)
+	}
+	defer object.Close()
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".budgie-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), object)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore %s: %w", sig.Path, err)
+	}
+	if sum := h.Sum(nil); !bytes.Equal(sum, sig.Checksum) {
+		return fmt.Errorf("snapshot content of %s is corrupt: got %x, want %x", sig.Path, sum, sig.Checksum)
+	}
+	if err := os.Rename(tmp.Name(), localPath); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", sig.Path, err)
+	}
+	return nil
+}
+
+// Prune removes the snapshots the policy does not keep, and the objects no
+// remaining snapshot refers to. Returns the removed snapshots.
+func (st *SnapshotStore) Prune(policy RetentionPolicy) ([]*Snapshot, error) {
+	snaps, err := st.List("")
+	if err != nil {
+		return nil, err
+	}
+
+	// Count each container's snapshots from its newest
+	var removed []*Snapshot
+	seen := make(map[string]int)
+	now := time.Now()
+	for i := len(snaps) - 1; i >= 0; i-- {
+		snap := snaps[i]
+		n := seen[snap.ContainerID]
+		seen[snap.ContainerID]++
+		if n == 0 {
+			continue
+		}
+		if policy.Keep > 0 && n >= policy.Keep || policy.MaxAge > 0 && now.Sub(snap.Created) > policy.MaxAge {
+			if err := os.Remove(st.manifestPath(snap.ID)); err != nil {
+				return removed, err
+			}
+			removed = append(removed, snap)
+		}
+	}
+
+	if len(removed) > 0 {
+		if err := st.collect(); err != nil {
+			return removed, fmt.Errorf("failed to remove unused snapshot content: %w", err)
+		}
+	}
+	return removed, nil
+}
+
+// collect removes the objects that no snapshot refers to
+func (st *SnapshotStore) collect() error {
+	snaps, err := st.List("")
+	if err != nil {
+		return err
+	}
+	used := make(map[string]bool)
+	for _, snap := range snaps {
+		for _, vol := range snap.Volumes {
+			for _, sig := range vol.Files {
+				if sig.Type == EntryFile {
+					used[hex.EncodeToString(sig.Checksum)] = true
+				}
+			}
+		}
+	}
+
+	objects := filepath.Join(st.root, "objects")
+	return filepath.Walk(objects, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// Objects being created start with a dot
+		if info.IsDir() || used[info.Name()] || strings.HasPrefix(info.Name(), ".") {
+			return nil
+		}
+		return os.Remove(path)
+	})
+}
@@ -0,0 +1,157 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// snapshotContainer returns a container with an rw volume at vol
+func snapshotContainer(id, vol string) *types.Container {
+	return &types.Container{
+		ID:      id,
+		Name:    "db",
+		Volumes: []types.VolumeMapping{{Source: vol, Target: "/data", Mode: "rw"}},
+	}
+}
+
+func countObjects(t *testing.T, root string) int {
+	t.Helper()
+	var n int
+	filepath.Walk(filepath.Join(root, "objects"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+func TestSnapshot_RestoresVolume(t *testing.T) {
+	vol, root := t.TempDir(), t.TempDir()
+	tree(t, vol, map[string]string{
+		"db.sqlite":        "rows",
+		"conf/app.yaml":    "port: 80",
+		"conf/backup.yaml": "port: 80",
+	})
+	if err := os.Symlink("conf/app.yaml", filepath.Join(vol, "current")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	store, err := NewSnapshotStore(root)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	ctr := snapshotContainer("c1", vol)
+	snap, err := store.Create(ctr, false)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if len(snap.ID) != snapshotIDSize || snap.Size() != int64(len("rows")+2*len("port: 80")) {
+		t.Errorf("Unexpected snapshot %s of %d bytes", snap.ID, snap.Size())
+	}
+	// Identical files are stored once
+	if n := countObjects(t, root); n != 2 {
+		t.Errorf("Expected 2 objects, got %d", n)
+	}
+
+	// A risky upgrade changes, adds and removes files
+	tree(t, vol, map[string]string{"db.sqlite": "migrated", "new.log": "x"})
+	os.RemoveAll(filepath.Join(vol, "conf"))
+
+	got, err := store.Get(snap.ID[:6])
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := store.Restore(got, ctr); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+
+	assertContent(t, filepath.Join(vol, "db.sqlite"), "rows")
+	assertContent(t, filepath.Join(vol, "conf", "backup.yaml"), "port: 80")
+	assertContent(t, filepath.Join(vol, "current"), "port: 80")
+	assertMissing(t, filepath.Join(vol, "new.log"))
+
+	mgr, _ := NewSyncManager(vol)
+	now, err := mgr.Signatures()
+	if err != nil {
+		t.Fatalf("Failed to scan volume: %v", err)
+	}
+	if diffs := CompareSignatures(snap.Volumes[0].Files, now); len(diffs) > 0 {
+		t.Errorf("Expected the volume to match the snapshot, got %v", diffs)
+	}
+}
+
+func TestSnapshot_RestoreRejectsCorruptContent(t *testing.T) {
+	vol, root := t.TempDir(), t.TempDir()
+	tree(t, vol, map[string]string{"db.sqlite": "rows"})
+
+	store, _ := NewSnapshotStore(root)
+	ctr := snapshotContainer("c1", vol)
+	snap, err := store.Create(ctr, false)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	object := store.objectPath(snap.Volumes[0].Files[0].Checksum)
+	os.Chmod(object, 0600)
+	if err := os.WriteFile(object, []byte("rots"), 0600); err != nil {
+		t.Fatalf("Failed to corrupt object: %v", err)
+	}
+	tree(t, vol, map[string]string{"db.sqlite": "migrated"})
+
+	if err := store.Restore(snap, ctr); err == nil {
+		t.Error("Expected Restore to fail on corrupt content")
+	}
+	assertContent(t, filepath.Join(vol, "db.sqlite"), "migrated")
+}
+
+func TestSnapshot_PruneKeepsNewestAndCollectsObjects(t *testing.T) {
+	vol, root := t.TempDir(), t.TempDir()
+	store, _ := NewSnapshotStore(root)
+	ctr := snapshotContainer("c1", vol)
+
+	var snaps []*Snapshot
+	for _, data := range []string{"v1", "v2", "v3"} {
+		tree(t, vol, map[string]string{"db.sqlite": data})
+		snap, err := store.Create(ctr, false)
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		snaps = append(snaps, snap)
+	}
+	other, err := store.Create(snapshotContainer("c2", vol), false)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	removed, err := store.Prune(RetentionPolicy{Keep: 2})
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if len(removed) != 1 || removed[0].ID != snaps[0].ID {
+		t.Fatalf("Expected only the oldest snapshot to be removed, got %d", len(removed))
+	}
+	if n := countObjects(t, root); n != 2 {
+		t.Errorf("Expected the content of v1 to be removed, got %d objects", n)
+	}
+
+	// The newest snapshot of a container survives any age limit
+	removed, err = store.Prune(RetentionPolicy{MaxAge: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if len(removed) != 1 || removed[0].ID != snaps[1].ID {
+		t.Errorf("Expected the older remaining snapshot to be removed, got %d", len(removed))
+	}
+	left, err := store.List("")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(left) != 2 || left[0].ID != snaps[2].ID || left[1].ID != other.ID {
+		t.Errorf("Expected the newest snapshot of each container to be kept, got %d", len(left))
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !linux && !darwin
+
+package sync
+
+import (
+	"os"
+	"time"
+)
+
+// sysStat is not available here, so ownership and hardlinks are not synced
+func sysStat(info os.FileInfo) (statInfo, bool) {
+	return statInfo{}, false
+}
+
+// lchtimes sets the modification time of a path. Symlinks are left alone,
+// since their times cannot be set without following them.
+func lchtimes(path string, mtime int64) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink != 0 {
+		return err
+	}
+	t := time.Unix(0, mtime)
+	return os.Chtimes(path, t, t)
+}
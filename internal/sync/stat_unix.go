@@ -0,0 +1,30 @@
+//go:build linux || darwin
+
+package sync
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// sysStat returns the owner, inode and link count of a file
+func sysStat(info os.FileInfo) (statInfo, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return statInfo{}, false
+	}
+	return statInfo{
+		uid:   int(st.Uid),
+		gid:   int(st.Gid),
+		id:    inode{dev: uint64(st.Dev), ino: uint64(st.Ino)},
+		links: uint64(st.Nlink),
+	}, true
+}
+
+// lchtimes sets the modification time of a path without following symlinks
+func lchtimes(path string, mtime int64) error {
+	ts := unix.NsecToTimespec(mtime)
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW)
+}
@@ -9,7 +9,6 @@ import (
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
-	"math/big"
 	"net"
 	"os"
 	"path/filepath"
@@ -94,9 +93,9 @@ func GenerateSelfSignedCert(certDir string) (certFile, keyFile string, err error
 	}
 
 	// Create certificate template
-	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	serialNumber, err := newSerialNumber()
 	if err != nil {
-		return "", "", fmt.Errorf("failed to generate serial number: %w", err)
+		return "", "", err
 	}
 
 	hostname, _ := os.Hostname()
@@ -125,37 +124,46 @@ func GenerateSelfSignedCert(certDir string) (certFile, keyFile string, err error
 		return "", "", fmt.Errorf("failed to create certificate: %w", err)
 	}
 
+	if err := writeCertAndKey(certFile, keyFile, certDER, privateKey); err != nil {
+		return "", "", err
+	}
+
+	logrus.Infof("Generated self-signed certificate: %s", certFile)
+	return certFile, keyFile, nil
+}
+
+// writeCertAndKey writes a DER certificate and its private key as PEM files
+func writeCertAndKey(certFile, keyFile string, certDER []byte, privateKey *ecdsa.PrivateKey) error {
 	// Write certificate to file
 	certOut, err := os.Create(certFile)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create cert file: %w", err)
+		return fmt.Errorf("failed to create cert file: %w", err)
 	}
 	if err := pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: certDER}); err != nil {
 		certOut.Close()
-		return "", "", fmt.Errorf("failed to encode certificate: %w", err)
+		return fmt.Errorf("failed to encode certificate: %w", err)
 	}
 	certOut.Close()
 
 	// Write private key to file
 	keyOut, err := os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create key file: %w", err)
+		return fmt.Errorf("failed to create key file: %w", err)
 	}
 
 	privateKeyBytes, err := x509.MarshalECPrivateKey(privateKey)
 	if err != nil {
 		keyOut.Close()
-		return "", "", fmt.Errorf("failed to marshal private key: %w", err)
+		return fmt.Errorf("failed to marshal private key: %w", err)
 	}
 
 	if err := pem.Encode(keyOut, &pem.Block{Type: "EC PRIVATE KEY", Bytes: privateKeyBytes}); err != nil {
 		keyOut.Close()
-		return "", "", fmt.Errorf("failed to encode private key: %w", err)
+		return fmt.Errorf("failed to encode private key: %w", err)
 	}
 	keyOut.Close()
 
-	logrus.Infof("Generated self-signed certificate: %s", certFile)
-	return certFile, keyFile, nil
+	return nil
 }
 
 func getLocalIPAddresses() []net.IP {
@@ -197,7 +205,8 @@ type TLSServer struct {
 	tlsConfig *tls.Config
 }
 
-// NewTLSServer creates a new TLS-enabled sync server
+// NewTLSServer creates a new TLS-enabled sync server. With TLS enabled, it
+// needs a CA file and accepts only peers presenting a certificate of that CA.
 func NewTLSServer(port int, tlsCfg TLSConfig) (*TLSServer, error) {
 	tlsConfig, err := NewTLSConfig(tlsCfg)
 	if err != nil {
@@ -208,6 +217,12 @@ func NewTLSServer(port int, tlsCfg TLSConfig) (*TLSServer, error) {
 	addr := fmt.Sprintf(":%d", port)
 
 	if tlsConfig != nil {
+		// Peers sync volumes only with a certificate of the cluster CA
+		if tlsConfig.ClientCAs == nil {
+			return nil, fmt.Errorf("sync server needs a CA file to verify peer certificates")
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+
 		listener, err = tls.Listen("tcp", addr, tlsConfig)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create TLS listener: %w", err)
@@ -221,9 +236,9 @@ func NewTLSServer(port int, tlsCfg TLSConfig) (*TLSServer, error) {
 	}
 
 	server := &Server{
-		listener:    listener,
-		volumePaths: make(map[string]string),
-		done:        make(chan struct{}),
+		listener:   listener,
+		containers: make(map[string]map[string]registeredVolume),
+		done:       make(chan struct{}),
 	}
 
 	return &TLSServer{
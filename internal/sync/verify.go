@@ -0,0 +1,41 @@
+package sync
+
+import (
+	"bytes"
+	"sort"
+)
+
+// FileDiff is a file that differs between a primary's volume and a replica's
+type FileDiff struct {
+	Path   string
+	Reason string // "missing", "extra" or "content"
+}
+
+// CompareSignatures compares the entries of a replica's volume with its
+// primary's by type, link target and content hash. Metadata such as modes
+// and modification times is ignored.
+func CompareSignatures(primary, replica []FileSignature) []FileDiff {
+	have := make(map[string]FileSignature, len(replica))
+	for _, sig := range replica {
+		have[sig.Path] = sig
+	}
+
+	var diffs []FileDiff
+	for _, want := range primary {
+		got, ok := have[want.Path]
+		delete(have, want.Path)
+		switch {
+		case !ok:
+			diffs = append(diffs, FileDiff{Path: want.Path, Reason: "missing"})
+		case got.Type != want.Type || got.Target != want.Target ||
+			got.Size != want.Size || !bytes.Equal(got.Checksum, want.Checksum):
+			diffs = append(diffs, FileDiff{Path: want.Path, Reason: "content"})
+		}
+	}
+	for path := range have {
+		diffs = append(diffs, FileDiff{Path: path, Reason: "extra"})
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+	return diffs
+}
@@ -0,0 +1,114 @@
+package sync
+
+import (
+	"crypto/sha256"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReceiveVolume_RejectsCorruptFile(t *testing.T) {
+	dst := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dst, "a.txt"), []byte("old"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	receiver, err := NewSyncManager(dst)
+	if err != nil {
+		t.Fatalf("Failed to create receiver: %v", err)
+	}
+
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	errc := make(chan error, 1)
+	go func() { errc <- receiver.ReceiveVolume(b) }()
+
+	// Announce "abc" but send "abd", as a corrupted transfer would
+	sum := sha256.Sum256([]byte("abc"))
+	proto := NewProtocol(a)
+	if _, err := proto.Handshake(NewHello(a, "node-a")); err != nil {
+		t.Fatalf("Handshake failed: %v", err)
+	}
+	sig := FileSignature{Path: "a.txt", Size: 3, ModTime: time.Now().Add(time.Minute).UnixNano(), Checksum: sum[:]}
+	if err := proto.SendSignatureRequest("", "", []FileSignature{sig}); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	if _, err := proto.Receive(); err != nil {
+		t.Fatalf("Failed to receive signatures: %v", err)
+	}
+	if err := proto.SendDelta("a.txt", 0, []DeltaOp{{Data: []byte("abd")}}, true); err != nil {
+		t.Fatalf("Failed to send delta: %v", err)
+	}
+
+	msg, err := proto.Receive()
+	if err != nil {
+		t.Fatalf("Failed to receive acknowledgment: %v", err)
+	}
+	if ack, ok := msg.Payload.(AckMessage); !ok || ack.Success {
+		t.Errorf("Expected a failed acknowledgment, got %+v", msg.Payload)
+	}
+	if err := <-errc; err == nil {
+		t.Error("Expected ReceiveVolume to fail on a checksum mismatch")
+	}
+
+	// The old copy is kept and no temporary file is left behind
+	entries, _ := os.ReadDir(dst)
+	if len(entries) != 1 {
+		t.Errorf("Expected only a.txt to remain, got %d entries", len(entries))
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "a.txt")); string(data) != "old" {
+		t.Errorf("Expected the old copy to be kept, got %q", data)
+	}
+}
+
+func TestSignatures_HashWholeFile(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 10_000)
+	os.WriteFile(filepath.Join(dir, "f"), data, 0644)
+
+	mgr, _ := NewSyncManager(dir)
+	before, err := mgr.Signatures()
+	if err != nil {
+		t.Fatalf("Signatures failed: %v", err)
+	}
+
+	// A change in the middle, which the first and last KB would not show
+	data[5000] = 1
+	os.WriteFile(filepath.Join(dir, "f"), data, 0644)
+	after, _ := mgr.Signatures()
+
+	want := sha256.Sum256(data)
+	if string(after[0].Checksum) != string(want[:]) {
+		t.Errorf("Expected the SHA-256 of the file, got %x", after[0].Checksum)
+	}
+	if string(before[0].Checksum) == string(after[0].Checksum) {
+		t.Error("Expected a change in the middle of the file to change its checksum")
+	}
+}
+
+func TestCompareSignatures(t *testing.T) {
+	primary := []FileSignature{
+		{Path: "same", Size: 1, Checksum: []byte{1}},
+		{Path: "changed", Size: 1, Checksum: []byte{2}},
+		{Path: "missing", Size: 1, Checksum: []byte{3}},
+	}
+	replica := []FileSignature{
+		{Path: "same", Size: 1, Checksum: []byte{1}, ModTime: 42},
+		{Path: "changed", Size: 1, Checksum: []byte{9}},
+		{Path: "extra", Size: 1, Checksum: []byte{4}},
+	}
+
+	diffs := CompareSignatures(primary, replica)
+	want := []FileDiff{{"changed", "content"}, {"extra", "extra"}, {"missing", "missing"}}
+	if len(diffs) != len(want) {
+		t.Fatalf("Expected %d diffs, got %+v", len(want), diffs)
+	}
+	for i := range want {
+		if diffs[i] != want[i] {
+			t.Errorf("Diff %d: expected %+v, got %+v", i, want[i], diffs[i])
+		}
+	}
+}
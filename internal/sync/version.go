@@ -0,0 +1,547 @@
+package sync
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+// tombstoneTTL is how long deletions are remembered. A peer that has been
+// away for longer may bring deleted entries back.
+const tombstoneTTL = 30 * 24 * time.Hour
+
+// VersionVector counts the changes each node has made to a volume entry. A
+// version that has seen every change of another comes after it; two versions
+// that each have changes the other lacks are concurrent.
+type VersionVector map[string]uint64
+
+// Ordering is how two versions relate
+type Ordering int
+
+const (
+	Equal      Ordering = iota
+	Before              // The other version has seen all of this one's changes and more
+	After               // This version has seen all of the other's changes and more
+	Concurrent          // Both have changes the other has not seen
+)
+
+// Compare returns how v relates to o
+func (v VersionVector) Compare(o VersionVector) Ordering {
+	before, after := false, false
+	for node, n := range v {
+		switch {
+		case n > o[node]:
+			after = true
+		case n < o[node]:
+			before = true
+		}
+	}
+	for node, n := range o {
+		if _, ok := v[node]; !ok && n > 0 {
+			before = true
+		}
+	}
+
+	switch {
+	case before && after:
+		return Concurrent
+	case before:
+		return Before
+	case after:
+		return After
+	}
+	return Equal
+}
+
+// Merge returns a version that has seen the changes of both v and o
+func (v VersionVector) Merge(o VersionVector) VersionVector {
+	merged := make(VersionVector, len(v))
+	for node, n := range v {
+		merged[node] = n
+	}
+	for node, n := range o {
+		if n > merged[node] {
+			merged[node] = n
+		}
+	}
+	return merged
+}
+
+// bump returns a copy of v with one more change by node
+func (v VersionVector) bump(node string) VersionVector {
+	next := v.Merge(nil)
+	next[node]++
+	return next
+}
+
+// Conflict is an entry that was changed on both sides, and how it was resolved
+type Conflict struct {
+	Path     string
+	Strategy types.ConflictStrategy
+	Winner   string // Node whose version was kept at Path
+	Loser    string
+	Copy     string // Where keep-both saved the losing version
+}
+
+// conflictCopy returns the path a losing version from node is saved at
+func conflictCopy(path, node string) string {
+	return path + ".conflict-" + node
+}
+
+// Versioning makes a volume bidirectional. Entries carry version vectors,
+// so that a receiver only applies changes it has not seen and detects
+// entries that were changed on both sides.
+type Versioning struct {
+	NodeID     string                 // This node in version vectors
+	IndexPath  string                 // File the versions are kept in, outside the volume
+	Strategy   types.ConflictStrategy // Empty means last-writer-wins
+	Primary    bool                   // Whether this node holds the primary
+	OnConflict func(Conflict)         // Called for every conflict resolved on receipt
+}
+
+// EnableVersioning makes the volume bidirectional: pushes carry versions
+// and tombstones, and received entries are applied only if they are newer
+// than ours, or win a conflict with ours
+func (s *SyncManager) EnableVersioning(v Versioning) error {
+	if v.NodeID == "" {
+		return fmt.Errorf("versioning requires a node ID")
+	}
+	strategy, err := types.ParseConflictStrategy(string(v.Strategy))
+	if err != nil {
+		return err
+	}
+	v.Strategy = strategy
+
+	index, err := openIndex(v.IndexPath)
+	if err != nil {
+		return fmt.Errorf("failed to open version index: %w", err)
+	}
+	s.versions = &versioning{Versioning: v, index: index}
+	return nil
+}
+
+// versioning is the version state of a bidirectional volume
+type versioning struct {
+	Versioning
+	index *versionIndex
+}
+
+// versionEntry is the last known version of an entry
+type versionEntry struct {
+	Sig     FileSignature `json:"sig"`
+	Deleted bool          `json:"deleted,omitempty"`
+}
+
+// versionIndex holds the versions of a volume's entries, including the
+// tombstones of deleted ones. It is shared by everything syncing the volume
+// in this process.
+type versionIndex struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]*versionEntry
+}
+
+var (
+	indexesMu sync.Mutex
+	indexes   = make(map[string]*versionIndex)
+)
+
+// openIndex loads the version index stored at path, or returns the one
+// already loaded
+func openIndex(path string) (*versionIndex, error) {
+	indexesMu.Lock()
+	defer indexesMu.Unlock()
+
+	if ix, ok := indexes[path]; ok {
+		return ix, nil
+	}
+
+	ix := &versionIndex{path: path, entries: make(map[string]*versionEntry)}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &ix.entries); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	indexes[path] = ix
+	return ix, nil
+}
+
+// save writes the index. Callers hold mu.
+func (ix *versionIndex) save() error {
+	data, err := json.Marshal(ix.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(ix.path), 0700); err != nil {
+		return err
+	}
+	tmp := ix.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ix.path)
+}
+
+// known returns the last scanned signatures of the entries that exist, so
+// that unchanged files need not be hashed again
+func (ix *versionIndex) known() map[string]FileSignature {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	known := make(map[string]FileSignature, len(ix.entries))
+	for path, e := range ix.entries {
+		if !e.Deleted {
+			known[path] = e.Sig
+		}
+	}
+	return known
+}
+
+// sameContent reports whether two signatures describe the same data, which
+// is what versions count changes of. Times and ownership are left out, since
+// they differ between nodes that cannot set them.
+func sameContent(a, b FileSignature) bool {
+	return a.Type == b.Type && a.Size == b.Size && a.Target == b.Target && bytes.Equal(a.Checksum, b.Checksum)
+}
+
+// observe records the local state of an entry, counting a change by this
+// node if its content or mode changed. Callers hold the index lock.
+func (v *versioning) observe(sig FileSignature) FileSignature {
+	e := v.index.entries[sig.Path]
+	switch {
+	case e == nil:
+		sig.Version = VersionVector(nil).bump(v.NodeID)
+	case e.Deleted || !sameContent(e.Sig, sig) || e.Sig.Mode != sig.Mode:
+		sig.Version = e.Sig.Version.bump(v.NodeID)
+	default:
+		sig.Version = e.Sig.Version
+	}
+	v.index.entries[sig.Path] = &versionEntry{Sig: sig}
+	return sig
+}
+
+// observeRemoved turns the entry at path into a tombstone, counting the
+// deletion as a change by this node. Callers hold the index lock.
+func (v *versioning) observeRemoved(path string, now time.Time) {
+	e := v.index.entries[path]
+	if e == nil || e.Deleted {
+		return
+	}
+	v.index.entries[path] = &versionEntry{
+		Sig: FileSignature{
+			Path:    path,
+			Type:    e.Sig.Type,
+			ModTime: now.UnixNano(),
+			UID:     -1,
+			GID:     -1,
+			Version: e.Sig.Version.bump(v.NodeID),
+		},
+		Deleted: true,
+	}
+}
+
+// within reports whether path is one of roots or below one of them
+func within(path string, roots []string) bool {
+	for _, root := range roots {
+		if path == root || strings.HasPrefix(path, root+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// request records scanned entries in the index and builds a push of them
+// with their versions. Indexed entries below roots, or anywhere for a full
+// scan, that were not scanned have been deleted; the tombstones in the same
+// range are sent along.
+func (v *versioning) request(scanned []FileSignature, roots []string, full bool) (SignatureRequest, error) {
+	v.index.mu.Lock()
+	defer v.index.mu.Unlock()
+
+	req := SignatureRequest{
+		Partial:     true, // Entries only the receiver has are new there, not deleted here
+		Versioned:   true,
+		Origin:      v.NodeID,
+		FromPrimary: v.Primary,
+	}
+
+	seen := make(map[string]bool, len(scanned))
+	for _, sig := range scanned {
+		seen[sig.Path] = true
+		req.Files = append(req.Files, v.observe(sig))
+	}
+
+	now := time.Now()
+	for path, e := range v.index.entries {
+		if !full && !within(path, roots) {
+			continue
+		}
+		if !seen[path] {
+			v.observeRemoved(path, now)
+			e = v.index.entries[path]
+		}
+		if !e.Deleted {
+			continue
+		}
+		if full && now.Sub(time.Unix(0, e.Sig.ModTime)) > tombstoneTTL {
+			delete(v.index.entries, path)
+			continue
+		}
+		req.Tombstones = append(req.Tombstones, e.Sig)
+	}
+	sort.Slice(req.Tombstones, func(i, j int) bool { return pathLess(req.Tombstones[i].Path, req.Tombstones[j].Path) })
+
+	if err := v.index.save(); err != nil {
+		return req, fmt.Errorf("failed to save version index: %w", err)
+	}
+	return req, nil
+}
+
+// versionPlan is what the receiver of a bidirectional push does with it
+type versionPlan struct {
+	files     []FileSignature          // Entries to apply, by their local path
+	from      map[string]string        // Local path -> sender's path, for entries received under another name
+	fetch     map[string]bool          // Local paths of files whose data is needed
+	copies    map[string]string        // Local entries to save as a conflict copy before they are replaced
+	removed   []string                 // Entries to remove, deepest first
+	updates   map[string]*versionEntry // Versions to record once the push is complete
+	conflicts []Conflict
+}
+
+// plan compares a push with our versions of its entries, after recording
+// local changes to them. Newer entries are applied, older ones ignored and
+// concurrent ones resolved by the strategy.
+func (v *versioning) plan(s *SyncManager, req SignatureRequest) (*versionPlan, error) {
+	sc := &scanner{root: s.localPath, files: make(map[inode]FileSignature), known: v.index.known()}
+
+	paths := make([]string, 0, len(req.Files)+len(req.Tombstones))
+	for _, sig := range req.Files {
+		paths = append(paths, sig.Path)
+	}
+	for _, sig := range req.Tombstones {
+		paths = append(paths, sig.Path)
+	}
+
+	var scanned []FileSignature
+	present := make(map[string]bool, len(paths))
+	for _, relPath := range paths {
+		localPath, err := s.localFile(relPath)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Lstat(localPath)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		present[relPath] = true
+		sig, ok, err := sc.entry(localPath, info)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			scanned = append(scanned, sig)
+		}
+	}
+
+	v.index.mu.Lock()
+	defer v.index.mu.Unlock()
+
+	now := time.Now()
+	for _, sig := range scanned {
+		v.observe(sig)
+	}
+	for _, relPath := range paths {
+		if !present[relPath] {
+			v.observeRemoved(relPath, now)
+		}
+	}
+
+	p := &versionPlan{
+		from:    make(map[string]string),
+		fetch:   make(map[string]bool),
+		copies:  make(map[string]string),
+		updates: make(map[string]*versionEntry),
+	}
+	for _, remote := range req.Files {
+		v.planEntry(p, req, remote, false)
+	}
+	for _, remote := range req.Tombstones {
+		v.planEntry(p, req, remote, true)
+	}
+	sort.SliceStable(p.removed, func(i, j int) bool {
+		return strings.Count(p.removed[i], string(filepath.Separator)) > strings.Count(p.removed[j], string(filepath.Separator))
+	})
+
+	return p, nil
+}
+
+// planEntry decides what to do with one entry of a push. Callers hold the
+// index lock.
+func (v *versioning) planEntry(p *versionPlan, req SignatureRequest, remote FileSignature, deleted bool) {
+	local := v.index.entries[remote.Path]
+	if local == nil {
+		v.take(p, local, remote, deleted)
+		return
+	}
+
+	switch remote.Version.Compare(local.Sig.Version) {
+	case Equal, Before:
+		return
+	case After:
+		v.take(p, local, remote, deleted)
+		return
+	}
+
+	// Both sides changed the entry. Identical changes are not a conflict.
+	merged := local.Sig.Version.Merge(remote.Version)
+	if local.Deleted == deleted && (deleted || sameContent(local.Sig, remote)) {
+		kept := *local
+		kept.Sig.Version = merged
+		p.updates[remote.Path] = &kept
+		return
+	}
+
+	c := Conflict{Path: remote.Path, Strategy: v.Strategy}
+	if v.remoteWins(local, remote, deleted, req) {
+		c.Winner, c.Loser = req.Origin, v.NodeID
+		if v.Strategy == types.KeepBoth && !local.Deleted && local.Sig.Type != EntryDir {
+			c.Copy = conflictCopy(remote.Path, v.NodeID)
+			p.copies[remote.Path] = c.Copy
+		}
+		remote.Version = merged
+		v.take(p, local, remote, deleted)
+	} else {
+		c.Winner, c.Loser = v.NodeID, req.Origin
+		kept := *local
+		kept.Sig.Version = merged
+		p.updates[remote.Path] = &kept
+
+		if v.Strategy == types.KeepBoth && !deleted && remote.Type != EntryDir && remote.Type != EntryHardlink {
+			c.Copy = conflictCopy(remote.Path, req.Origin)
+			cp := remote
+			cp.Path, cp.Version = c.Copy, nil
+			p.files = append(p.files, cp)
+			p.from[cp.Path] = remote.Path
+			p.fetch[cp.Path] = cp.Type == EntryFile
+		}
+	}
+	p.conflicts = append(p.conflicts, c)
+}
+
+// take applies the sender's version of an entry
+func (v *versioning) take(p *versionPlan, local *versionEntry, remote FileSignature, deleted bool) {
+	p.updates[remote.Path] = &versionEntry{Sig: remote, Deleted: deleted}
+	if deleted {
+		if local != nil && !local.Deleted {
+			p.removed = append(p.removed, remote.Path)
+		}
+		return
+	}
+
+	p.files = append(p.files, remote)
+	if remote.Type == EntryFile {
+		p.fetch[remote.Path] = local == nil || local.Deleted || !sameContent(local.Sig, remote)
+	}
+}
+
+// remoteWins resolves a conflict, the same way on both sides
+func (v *versioning) remoteWins(local *versionEntry, remote FileSignature, deleted bool, req SignatureRequest) bool {
+	switch v.Strategy {
+	case types.PrimaryWins:
+		if req.FromPrimary {
+			return true
+		}
+		if v.Primary {
+			return false
+		}
+	case types.KeepBoth:
+		// Keeping both means a change always wins over a deletion
+		if deleted != local.Deleted {
+			return local.Deleted
+		}
+	}
+
+	if remote.ModTime != local.Sig.ModTime {
+		return remote.ModTime > local.Sig.ModTime
+	}
+	return req.Origin < v.NodeID
+}
+
+// commit records the versions of a completed push and reports its conflicts
+func (v *versioning) commit(p *versionPlan) error {
+	v.index.mu.Lock()
+	for path, e := range p.updates {
+		v.index.entries[path] = e
+	}
+	err := v.index.save()
+	v.index.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to save version index: %w", err)
+	}
+
+	for _, c := range p.conflicts {
+		if v.OnConflict != nil {
+			v.OnConflict(c)
+		}
+	}
+	return nil
+}
+
+// saveCopy keeps a local entry that is about to be replaced under another
+// name. Files are linked, so that the original can still serve as the
+// basis of the delta.
+func (s *SyncManager) saveCopy(relPath, copyPath string) error {
+	src, err := s.localFile(relPath)
+	if err != nil {
+		return err
+	}
+	dst, err := s.localFile(copyPath)
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	os.RemoveAll(dst)
+	if info.Mode().IsRegular() && os.Link(src, dst) == nil {
+		return nil
+	}
+	return os.Rename(src, dst)
+}
+
+// removeVersioned removes an entry deleted by the sender. Directories are
+// only removed when empty, since entries the sender does not know about are
+// new here rather than deleted there.
+func removeVersioned(localPath string) error {
+	info, err := os.Lstat(localPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		if entries, err := os.ReadDir(localPath); err != nil || len(entries) > 0 {
+			return err
+		}
+	}
+	return os.Remove(localPath)
+}
@@ -0,0 +1,239 @@
+package sync
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zarigata/budgie/pkg/types"
+)
+
+func TestVersionVector_Compare(t *testing.T) {
+	tests := []struct {
+		a, b VersionVector
+		want Ordering
+	}{
+		{nil, nil, Equal},
+		{VersionVector{"a": 1}, VersionVector{"a": 1}, Equal},
+		{VersionVector{"a": 1}, VersionVector{"a": 2}, Before},
+		{VersionVector{"a": 1}, VersionVector{"a": 1, "b": 1}, Before},
+		{VersionVector{"a": 2, "b": 1}, VersionVector{"a": 1}, After},
+		{VersionVector{"a": 2}, VersionVector{"a": 1, "b": 1}, Concurrent},
+		{VersionVector{"a": 1}, VersionVector{"b": 1}, Concurrent},
+	}
+
+	for _, tt := range tests {
+		if got := tt.a.Compare(tt.b); got != tt.want {
+			t.Errorf("Expected %v compared with %v to be %d, got %d", tt.a, tt.b, tt.want, got)
+		}
+	}
+}
+
+// bidirectionalNode is a replica of a bidirectional volume
+type bidirectionalNode struct {
+	mgr       *SyncManager
+	dir       string
+	conflicts []Conflict
+}
+
+func newBidirectionalNode(t *testing.T, id string, primary bool, strategy types.ConflictStrategy) *bidirectionalNode {
+	t.Helper()
+	n := &bidirectionalNode{dir: t.TempDir()}
+	mgr, err := NewSyncManager(n.dir)
+	if err != nil {
+		t.Fatalf("Failed to create sync manager: %v", err)
+	}
+	err = mgr.EnableVersioning(Versioning{
+		NodeID:     id,
+		IndexPath:  filepath.Join(t.TempDir(), "index.json"),
+		Strategy:   strategy,
+		Primary:    primary,
+		OnConflict: func(c Conflict) { n.conflicts = append(n.conflicts, c) },
+	})
+	if err != nil {
+		t.Fatalf("Failed to enable versioning: %v", err)
+	}
+	n.mgr = mgr
+	return n
+}
+
+// push sends the whole volume of from to to
+func push(t *testing.T, from, to *bidirectionalNode) {
+	t.Helper()
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	errc := make(chan error, 1)
+	go func() { errc <- to.mgr.ReceiveVolume(b) }()
+
+	if err := from.mgr.SendVolume(a, Destination{}); err != nil {
+		t.Fatalf("SendVolume failed: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("ReceiveVolume failed: %v", err)
+	}
+}
+
+// write writes a file with the given modification time
+func write(t *testing.T, dir, name, data string, mtime time.Time) {
+	t.Helper()
+	tree(t, dir, map[string]string{name: data})
+	if err := os.Chtimes(filepath.Join(dir, name), mtime, mtime); err != nil {
+		t.Fatalf("Failed to set time: %v", err)
+	}
+}
+
+// conflicting makes both nodes change doc after it was synced, b later than a
+func conflicting(t *testing.T, a, b *bidirectionalNode) {
+	t.Helper()
+	start := time.Now().Add(-time.Hour)
+	write(t, a.dir, "doc", "original", start)
+	push(t, a, b)
+
+	write(t, a.dir, "doc", "from a", start.Add(time.Minute))
+	write(t, b.dir, "doc", "from b!", start.Add(2*time.Minute))
+}
+
+func TestBidirectional_MergesChangesFromBothSides(t *testing.T) {
+	a := newBidirectionalNode(t, "node-a", true, "")
+	b := newBidirectionalNode(t, "node-b", false, "")
+	tree(t, a.dir, map[string]string{"shared": "v1", "old": "x"})
+	push(t, a, b)
+
+	tree(t, a.dir, map[string]string{"shared": "v2"})
+	os.Remove(filepath.Join(a.dir, "old"))
+	tree(t, b.dir, map[string]string{"uploads/b.jpg": "jpeg"})
+
+	push(t, a, b)
+	push(t, b, a)
+
+	for _, dir := range []string{a.dir, b.dir} {
+		assertContent(t, filepath.Join(dir, "shared"), "v2")
+		assertContent(t, filepath.Join(dir, "uploads", "b.jpg"), "jpeg")
+		assertMissing(t, filepath.Join(dir, "old"))
+	}
+	if len(a.conflicts)+len(b.conflicts) > 0 {
+		t.Errorf("Expected no conflicts, got %v and %v", a.conflicts, b.conflicts)
+	}
+}
+
+func TestBidirectional_IgnoresOlderVersions(t *testing.T) {
+	a := newBidirectionalNode(t, "node-a", true, "")
+	b := newBidirectionalNode(t, "node-b", false, "")
+	tree(t, a.dir, map[string]string{"shared": "v1"})
+	push(t, a, b)
+
+	// b's change descends from what a has, so a's stale copy does not undo it
+	write(t, b.dir, "shared", "v2", time.Now().Add(-time.Hour))
+	push(t, a, b)
+	assertContent(t, filepath.Join(b.dir, "shared"), "v2")
+
+	push(t, b, a)
+	assertContent(t, filepath.Join(a.dir, "shared"), "v2")
+	if len(a.conflicts)+len(b.conflicts) > 0 {
+		t.Errorf("Expected no conflicts, got %v and %v", a.conflicts, b.conflicts)
+	}
+}
+
+func TestBidirectional_LastWriterWins(t *testing.T) {
+	a := newBidirectionalNode(t, "node-a", true, types.LastWriterWins)
+	b := newBidirectionalNode(t, "node-b", false, types.LastWriterWins)
+	conflicting(t, a, b)
+
+	push(t, a, b)
+	push(t, b, a)
+
+	assertContent(t, filepath.Join(a.dir, "doc"), "from b!")
+	assertContent(t, filepath.Join(b.dir, "doc"), "from b!")
+	if len(b.conflicts) != 1 || b.conflicts[0].Winner != "node-b" || b.conflicts[0].Loser != "node-a" {
+		t.Fatalf("Expected b to record a conflict won by node-b, got %v", b.conflicts)
+	}
+	if b.conflicts[0].Path != "doc" || b.conflicts[0].Strategy != types.LastWriterWins {
+		t.Errorf("Expected a last-writer-wins conflict on doc, got %+v", b.conflicts[0])
+	}
+
+	// Once resolved, the versions agree
+	push(t, a, b)
+	push(t, b, a)
+	if len(a.conflicts)+len(b.conflicts) != 1 {
+		t.Errorf("Expected the conflict to be resolved once, got %v and %v", a.conflicts, b.conflicts)
+	}
+}
+
+func TestBidirectional_PrimaryWins(t *testing.T) {
+	a := newBidirectionalNode(t, "node-a", true, types.PrimaryWins)
+	b := newBidirectionalNode(t, "node-b", false, types.PrimaryWins)
+	conflicting(t, a, b)
+
+	// The replica's change is more recent, but the primary's is kept
+	push(t, b, a)
+	push(t, a, b)
+
+	assertContent(t, filepath.Join(a.dir, "doc"), "from a")
+	assertContent(t, filepath.Join(b.dir, "doc"), "from a")
+	if len(a.conflicts) != 1 || a.conflicts[0].Winner != "node-a" {
+		t.Errorf("Expected a to record a conflict won by node-a, got %v", a.conflicts)
+	}
+}
+
+func TestBidirectional_KeepBoth(t *testing.T) {
+	// The losing version is kept whichever side learns of the conflict first
+	for _, loserFirst := range []bool{true, false} {
+		a := newBidirectionalNode(t, "node-a", true, types.KeepBoth)
+		b := newBidirectionalNode(t, "node-b", false, types.KeepBoth)
+		conflicting(t, a, b)
+
+		if loserFirst {
+			push(t, a, b)
+			push(t, b, a)
+		} else {
+			push(t, b, a)
+			push(t, a, b)
+		}
+
+		for _, dir := range []string{a.dir, b.dir} {
+			assertContent(t, filepath.Join(dir, "doc"), "from b!")
+			assertContent(t, filepath.Join(dir, "doc.conflict-node-a"), "from a")
+		}
+		conflicts := append(a.conflicts, b.conflicts...)
+		if len(conflicts) != 1 || conflicts[0].Copy != "doc.conflict-node-a" || conflicts[0].Winner != "node-b" {
+			t.Errorf("Expected a conflict won by node-b and copied to doc.conflict-node-a, got %v", conflicts)
+		}
+	}
+}
+
+func TestBidirectional_KeepBothKeepsChangesOverDeletions(t *testing.T) {
+	a := newBidirectionalNode(t, "node-a", true, types.KeepBoth)
+	b := newBidirectionalNode(t, "node-b", false, types.KeepBoth)
+	tree(t, a.dir, map[string]string{"doc": "v1"})
+	push(t, a, b)
+
+	os.Remove(filepath.Join(a.dir, "doc"))
+	tree(t, b.dir, map[string]string{"doc": "edited"})
+
+	push(t, a, b)
+	push(t, b, a)
+
+	assertContent(t, filepath.Join(a.dir, "doc"), "edited")
+	assertContent(t, filepath.Join(b.dir, "doc"), "edited")
+}
+
+func TestBidirectional_ConcurrentCopiesMerge(t *testing.T) {
+	a := newBidirectionalNode(t, "node-a", true, types.KeepBoth)
+	b := newBidirectionalNode(t, "node-b", false, types.KeepBoth)
+
+	// Both sides started with the same data, for example from a one-way sync
+	for _, dir := range []string{a.dir, b.dir} {
+		tree(t, dir, map[string]string{"same": "data"})
+	}
+	push(t, a, b)
+	push(t, b, a)
+
+	if len(a.conflicts)+len(b.conflicts) > 0 {
+		t.Errorf("Expected identical entries not to conflict, got %v and %v", a.conflicts, b.conflicts)
+	}
+	assertMissing(t, filepath.Join(b.dir, "same.conflict-node-a"))
+}
@@ -1,29 +1,44 @@
 package sync
 
 import (
-	"encoding/gob"
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"net"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/sirupsen/logrus"
 	"github.com/zarigata/budgie/pkg/types"
 )
 
-// FileSignature represents a file's sync signature
+// FileSignature represents a volume entry's sync signature
 type FileSignature struct {
 	Path     string
+	Type     EntryType
 	Size     int64
 	ModTime  int64
-	Checksum []byte
+	Mode     os.FileMode // Permission, setuid, setgid and sticky bits
+	UID      int         // -1 when unknown
+	GID      int
+	Target   string        // Symlink target, or the path a hardlink links to
+	Checksum []byte        // SHA-256 of the whole file
+	Version  VersionVector // Only for bidirectional volumes
 }
 
 // SyncManager handles volume synchronization between nodes
 type SyncManager struct {
 	localPath string
+	versions  *versioning // Set for bidirectional volumes
+	limiter   *Limiter
+	progress  func(done, total int64)
+	nodeID    string // Names us in hellos
 }
 
 // NewSyncManager creates a new sync manager for the given path
@@ -36,77 +51,348 @@ func NewSyncManager(localPath string) (*SyncManager, error) {
 	}, nil
 }
 
-// SendVolume sends volume signatures and data over the connection
-func (s *SyncManager) SendVolume(conn net.Conn) error {
-	encoder := gob.NewEncoder(conn)
+// SetLimiter limits the bandwidth of the connections the manager syncs over
+func (s *SyncManager) SetLimiter(l *Limiter) {
+	s.limiter = l
+}
+
+// SetNodeID names this node to the peers the manager syncs with
+func (s *SyncManager) SetNodeID(id string) {
+	s.nodeID = id
+}
+
+// SetProgress reports the progress of file data sent or received: done of
+// total bytes of the files being transferred
+func (s *SyncManager) SetProgress(fn func(done, total int64)) {
+	s.progress = fn
+}
+
+// protocol starts the sync protocol on conn, within our bandwidth limit
+func (s *SyncManager) protocol(conn io.ReadWriter) *Protocol {
+	return NewProtocol(s.limiter.Conn(conn))
+}
+
+// hello returns our hello for conn. A bidirectional volume names us as in
+// its version vectors unless a node ID is set.
+func (s *SyncManager) hello(conn io.ReadWriter) Hello {
+	nodeID := s.nodeID
+	if nodeID == "" && s.versions != nil {
+		nodeID = s.versions.NodeID
+	}
+	return NewHello(conn, nodeID)
+}
 
-	// First, send all file signatures
-	signatures, err := s.collectSignatures()
+// dial opens the sync protocol on conn as the dialing side. Pushes and
+// pulls rely on the peer taking deltas sent in chunks.
+func (s *SyncManager) dial(conn io.ReadWriter) (*Protocol, error) {
+	proto := s.protocol(conn)
+	if _, err := proto.Handshake(s.hello(conn), FeatureDeltas, FeatureChunking); err != nil {
+		return nil, fmt.Errorf("sync handshake failed: %w", err)
+	}
+	return proto, nil
+}
+
+// report passes the progress of a transfer on
+func (s *SyncManager) report(done, total int64) {
+	if s.progress != nil {
+		s.progress(done, total)
+	}
+}
+
+// maxDeltaBatch bounds the literal data sent in one delta message
+const maxDeltaBatch = 1 << 20
+
+// Destination identifies the volume a push is for, on a node that receives
+// the volumes of several containers
+type Destination struct {
+	ContainerID  string
+	VolumeTarget string
+}
+
+// SendVolume pushes the volume to a receiver, sending only the blocks of
+// changed files that the receiver's copies lack
+func (s *SyncManager) SendVolume(conn io.ReadWriter, dest Destination) error {
+	signatures, err := s.signatures(s.newScanner())
 	if err != nil {
 		return fmt.Errorf("failed to collect signatures: %w", err)
 	}
 
-	// Send signature count
-	if err := encoder.Encode(len(signatures)); err != nil {
-		return fmt.Errorf("failed to send signature count: %w", err)
+	if s.versions == nil {
+		return s.push(conn, dest, SignatureRequest{Files: signatures})
+	}
+	req, err := s.versions.request(signatures, nil, true)
+	if err != nil {
+		return err
+	}
+	return s.push(conn, dest, req)
+}
+
+// SendChanges pushes the entries at the given volume-relative paths, and
+// everything below those that are directories. Paths that no longer exist
+// are sent as tombstones, and the receiver leaves other entries alone.
+func (s *SyncManager) SendChanges(conn io.ReadWriter, dest Destination, paths []string) error {
+	req := SignatureRequest{Partial: true}
+	entries := make(map[string]FileSignature)
+	sc := s.newScanner()
+
+	for _, relPath := range paths {
+		if isTempFile(filepath.Base(relPath)) {
+			continue
+		}
+		path, err := s.localFile(relPath)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			req.Deleted = append(req.Deleted, relPath)
+			continue
+		}
+
+		err = filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil // Removed while walking; a later change sends it
+			}
+			if err != nil {
+				return err
+			}
+			sig, ok, err := sc.entry(path, info)
+			if err != nil || !ok {
+				return err
+			}
+			entries[sig.Path] = sig
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to collect signatures: %w", err)
+		}
+	}
+
+	// Parents are sent as well, since adding and removing entries changes
+	// their times
+	for _, relPath := range append(append([]string(nil), paths...), req.Deleted...) {
+		for dir := filepath.Dir(relPath); dir != "."; dir = filepath.Dir(dir) {
+			if _, ok := entries[dir]; ok {
+				continue
+			}
+			info, err := os.Lstat(filepath.Join(s.localPath, dir))
+			if err != nil {
+				continue
+			}
+			if sig, ok, err := sc.entry(filepath.Join(s.localPath, dir), info); err == nil && ok {
+				entries[dir] = sig
+			}
+		}
+	}
+
+	for _, sig := range entries {
+		req.Files = append(req.Files, sig)
 	}
+	sort.Slice(req.Files, func(i, j int) bool { return pathLess(req.Files[i].Path, req.Files[j].Path) })
 
-	// Send each signature
-	for _, sig := range signatures {
-		if err := encoder.Encode(sig); err != nil {
-			return fmt.Errorf("failed to send signature for %s: %w", sig.Path, err)
+	if s.versions != nil {
+		var err error
+		if req, err = s.versions.request(req.Files, paths, false); err != nil {
+			return err
 		}
-		logrus.Debugf("Sent signature for %s", sig.Path)
 	}
+	return s.push(conn, dest, req)
+}
+
+// newScanner creates a scanner of the volume. Bidirectional volumes reuse
+// the checksums of files that have not changed since they were indexed.
+func (s *SyncManager) newScanner() *scanner {
+	sc := &scanner{root: s.localPath, files: make(map[inode]FileSignature)}
+	if s.versions != nil {
+		sc.known = s.versions.index.known()
+	}
+	return sc
+}
+
+// pathLess orders paths as a walk visits them, so that directories come
+// before their contents
+func pathLess(a, b string) bool {
+	return strings.ReplaceAll(a, string(filepath.Separator), "\x00") < strings.ReplaceAll(b, string(filepath.Separator), "\x00")
+}
 
-	// Receive list of files needed
-	decoder := gob.NewDecoder(conn)
-	var neededFiles []string
-	if err := decoder.Decode(&neededFiles); err != nil {
-		return fmt.Errorf("failed to receive needed files list: %w", err)
+// push opens the connection and sends req to the receiver of dest
+func (s *SyncManager) push(conn io.ReadWriter, dest Destination, req SignatureRequest) error {
+	proto, err := s.dial(conn)
+	if err != nil {
+		return err
 	}
 
-	// Send each needed file
-	for _, relPath := range neededFiles {
-		fullPath := filepath.Join(s.localPath, relPath)
-		if err := s.sendFile(conn, fullPath, relPath); err != nil {
-			return fmt.Errorf("failed to send file %s: %w", relPath, err)
+	req.ContainerID, req.VolumeTarget = dest.ContainerID, dest.VolumeTarget
+	return s.send(proto, req)
+}
+
+// send sends a file list and then the deltas of the files the receiver asks for
+func (s *SyncManager) send(proto *Protocol, req SignatureRequest) error {
+	if err := proto.Send(Message{Type: MsgSignatureRequest, Payload: req}); err != nil {
+		return fmt.Errorf("failed to send file list: %w", err)
+	}
+
+	msg, err := proto.Receive()
+	if err != nil {
+		return fmt.Errorf("failed to receive block signatures: %w", err)
+	}
+	resp, ok := msg.Payload.(SignatureResponse)
+	if msg.Type != MsgSignatureResponse || !ok {
+		return unexpectedMessage(msg, MsgSignatureResponse)
+	}
+
+	// What is left of the requested files is what the transfer sends
+	var total, done int64
+	sizes := make(map[string]int64, len(resp.Files))
+	for _, sig := range req.Files {
+		sizes[sig.Path] = sig.Size
+	}
+	for _, blocks := range resp.Files {
+		total += sizes[blocks.Path] - blocks.Offset
+	}
+	s.report(0, total)
+
+	for _, blocks := range resp.Files {
+		err := s.sendDelta(proto, blocks, func(n int64) {
+			done += n
+			s.report(done, total)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to send file %s: %w", blocks.Path, err)
 		}
-		logrus.Debugf("Sent file %s", relPath)
+		logrus.Debugf("Sent delta for %s", blocks.Path)
+	}
+
+	if err := proto.SendAck(true, "sync complete"); err != nil {
+		return fmt.Errorf("failed to finish sync: %w", err)
+	}
+	msg, err = proto.Receive()
+	if err != nil {
+		return fmt.Errorf("failed to receive acknowledgment: %w", err)
+	}
+	ack, ok := msg.Payload.(AckMessage)
+	if msg.Type != MsgAck || !ok {
+		return unexpectedMessage(msg, MsgAck)
+	}
+	if !ack.Success {
+		return fmt.Errorf("receiver failed: %s", ack.Message)
 	}
 
 	return nil
 }
 
-// collectSignatures walks the directory and collects file signatures
-func (s *SyncManager) collectSignatures() ([]FileSignature, error) {
-	var signatures []FileSignature
+// sendDelta streams the delta between a local file and the receiver's copy
+// in batches of bounded size, starting where an interrupted push of the file
+// left off. sent is called with the bytes of the file each batch covers.
+func (s *SyncManager) sendDelta(proto *Protocol, blocks FileBlocks, sent func(int64)) error {
+	path, err := s.localFile(blocks.Path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-	err := filepath.Walk(s.localPath, func(path string, info os.FileInfo, err error) error {
+	offset := blocks.Offset
+	if offset > 0 {
+		info, err := f.Stat()
 		if err != nil {
 			return err
 		}
+		if offset > info.Size() {
+			return fmt.Errorf("cannot resume at byte %d of a %d byte file", offset, info.Size())
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		logrus.Debugf("Resuming %s at byte %d", blocks.Path, offset)
+	}
 
-		if info.IsDir() {
+	var batch []DeltaOp
+	start, size := offset, 0
+	err = computeDelta(f, blocks, func(op DeltaOp, n int64) error {
+		batch = append(batch, op)
+		size += len(op.Data)
+		offset += n
+		if size < maxDeltaBatch {
 			return nil
 		}
+		if err := proto.SendDelta(blocks.Path, start, batch, false); err != nil {
+			return err
+		}
+		sent(offset - start)
+		batch, start, size = nil, offset, 0
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		relPath, err := filepath.Rel(s.localPath, path)
+	if err := proto.SendDelta(blocks.Path, start, batch, true); err != nil {
+		return err
+	}
+	sent(offset - start)
+	return nil
+}
+
+// localFile resolves a volume-relative path from a peer, refusing paths
+// that leave the volume, directly or through a symlink
+func (s *SyncManager) localFile(relPath string) (string, error) {
+	if !filepath.IsLocal(relPath) {
+		return "", fmt.Errorf("invalid path %q", relPath)
+	}
+
+	dir := s.localPath
+	for _, part := range strings.Split(filepath.Dir(relPath), string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
 		if err != nil {
-			return err
+			break
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("invalid path %q: %s is a symlink", relPath, part)
 		}
+	}
+	return filepath.Join(s.localPath, relPath), nil
+}
 
-		// Simple checksum using first/last bytes and size
-		checksum, err := quickChecksum(path, info.Size())
+// unexpectedMessage describes a message that is not the one expected,
+// including the peer's error if it sent one
+func unexpectedMessage(msg Message, want MessageType) error {
+	if e, ok := msg.Payload.(ErrorMessage); ok && msg.Type == MsgError {
+		return fmt.Errorf("peer error %d: %s", e.Code, e.Message)
+	}
+	return fmt.Errorf("unexpected message type %d, expected %d", msg.Type, want)
+}
+
+// Signatures walks the volume and returns the signature of every entry.
+// Symlinks are not followed, and a file with several links is listed once
+// as a file and then as hardlinks to it.
+func (s *SyncManager) Signatures() ([]FileSignature, error) {
+	return s.signatures(&scanner{root: s.localPath, files: make(map[inode]FileSignature)})
+}
+
+func (s *SyncManager) signatures(sc *scanner) ([]FileSignature, error) {
+	var signatures []FileSignature
+	err := filepath.Walk(s.localPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
+		if path == s.localPath {
+			return nil
+		}
 
-		sig := FileSignature{
-			Path:     relPath,
-			Size:     info.Size(),
-			ModTime:  info.ModTime().UnixNano(),
-			Checksum: checksum,
+		sig, ok, err := sc.entry(path, info)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			logrus.Debugf("Skipping %s: unsupported file type %s", path, info.Mode().Type())
+			return nil
 		}
 		signatures = append(signatures, sig)
 
@@ -116,172 +402,530 @@ func (s *SyncManager) collectSignatures() ([]FileSignature, error) {
 	return signatures, err
 }
 
-// quickChecksum creates a fast checksum for file comparison
-func quickChecksum(path string, size int64) ([]byte, error) {
+// fileHash returns the SHA-256 of a file's contents
+func fileHash(path string) ([]byte, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	// Read first 1KB
-	first := make([]byte, 1024)
-	n1, _ := f.Read(first)
-	first = first[:n1]
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
 
-	// Read last 1KB
-	last := make([]byte, 1024)
-	if size > 1024 {
-		f.Seek(-1024, io.SeekEnd)
+// ReceiveVolume receives a volume pushed by SendVolume. Changed files are
+// rebuilt from their current copy and the sender's delta in a temporary
+// file, which replaces the copy only if its SHA-256 matches the sender's.
+func (s *SyncManager) ReceiveVolume(conn io.ReadWriter) error {
+	proto := s.protocol(conn)
+	if _, err := proto.AcceptHandshake(s.hello(conn)); err != nil {
+		return fmt.Errorf("sync handshake failed: %w", err)
 	}
-	n2, _ := f.Read(last)
-	last = last[:n2]
 
-	// Combine for checksum
-	checksum := make([]byte, 0, len(first)+len(last)+8)
-	checksum = append(checksum, first...)
-	checksum = append(checksum, last...)
+	msg, err := proto.Receive()
+	if err != nil {
+		return fmt.Errorf("failed to receive file list: %w", err)
+	}
+	req, ok := msg.Payload.(SignatureRequest)
+	if msg.Type != MsgSignatureRequest || !ok {
+		proto.SendError(400, "expected a signature request")
+		return unexpectedMessage(msg, MsgSignatureRequest)
+	}
 
-	return checksum, nil
+	return s.receive(proto, req)
 }
 
-// sendFile sends a single file over the connection
-func (s *SyncManager) sendFile(conn net.Conn, fullPath, relPath string) error {
-	f, err := os.Open(fullPath)
+// PullVolume asks the sync server on conn to push the volume a container
+// mounts at volumeTarget to us, and receives it like ReceiveVolume
+func (s *SyncManager) PullVolume(conn io.ReadWriter, containerID, volumeTarget string) error {
+	proto, err := s.dial(conn)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	info, err := f.Stat()
+	pull := PullRequest{ContainerID: containerID, VolumeTarget: volumeTarget}
+	if err := proto.Send(Message{Type: MsgPullRequest, Payload: pull}); err != nil {
+		return fmt.Errorf("failed to request volume: %w", err)
+	}
+	msg, err := proto.Receive()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to receive file list: %w", err)
 	}
+	req, ok := msg.Payload.(SignatureRequest)
+	if msg.Type != MsgSignatureRequest || !ok {
+		return unexpectedMessage(msg, MsgSignatureRequest)
+	}
+
+	return s.receive(proto, req)
+}
 
-	encoder := gob.NewEncoder(conn)
+// receive completes a push whose file list has been received
+func (s *SyncManager) receive(proto *Protocol, req SignatureRequest) error {
+	// A bidirectional volume only takes the entries that are newer than ours
+	// or win a conflict with ours
+	var plan *versionPlan
+	remove := os.RemoveAll
+	if req.Versioned && s.versions != nil {
+		var err error
+		if plan, err = s.versions.plan(s, req); err != nil {
+			proto.SendError(400, err.Error())
+			return err
+		}
+		req.Files, req.Deleted = plan.files, plan.removed
+		remove = removeVersioned
 
-	// Send file metadata
-	meta := FileSignature{
-		Path:    relPath,
-		Size:    info.Size(),
-		ModTime: info.ModTime().UnixNano(),
+		for relPath, copyPath := range plan.copies {
+			if err := s.saveCopy(relPath, copyPath); err != nil {
+				proto.SendError(500, err.Error())
+				return fmt.Errorf("failed to keep conflicting %s: %w", relPath, err)
+			}
+		}
 	}
-	if err := encoder.Encode(meta); err != nil {
-		return err
+
+	// Partial copies of an entry are only kept for the version it is
+	// resumed at, keyed by the entry's local path
+	resumed := make(map[string]string)
+
+	// Remove the entries a partial push has tombstones for
+	for _, relPath := range req.Deleted {
+		localPath, err := s.localFile(relPath)
+		if err != nil {
+			proto.SendError(400, err.Error())
+			return err
+		}
+		resumed[localPath] = ""
+		if err := remove(localPath); err != nil {
+			proto.SendError(500, err.Error())
+			return fmt.Errorf("failed to remove %s: %w", relPath, err)
+		}
 	}
 
-	// Send file content
-	_, err = io.Copy(conn, f)
-	return err
-}
+	// Create directories and symlinks, determine which files we need and
+	// describe our copies of them
+	keep := make(map[string]bool, len(req.Files))
+	needed := make(map[string]FileSignature)
+	blockIndex := make(map[string]FileBlocks)
+	var blocks []FileBlocks
+	var links []FileSignature
+	for _, sig := range req.Files {
+		localPath, err := s.localFile(sig.Path)
+		if err != nil {
+			proto.SendError(400, err.Error())
+			return err
+		}
+		for p := filepath.Clean(sig.Path); p != "."; p = filepath.Dir(p) {
+			keep[p] = true
+		}
+		resumed[localPath] = ""
+		if err := s.prepareEntry(localPath, sig); err != nil {
+			proto.SendError(500, err.Error())
+			return fmt.Errorf("failed to create %s: %w", sig.Path, err)
+		}
+		if sig.Type == EntryHardlink {
+			links = append(links, sig)
+			continue
+		}
+		if sig.Type != EntryFile {
+			continue
+		}
+		if plan != nil && !plan.fetch[sig.Path] || plan == nil && !needsUpdate(localPath, sig) {
+			continue
+		}
+
+		// Conflict copies are received under another name than the
+		// sender's, and built from our copy of the original
+		wirePath := sig.Path
+		if plan != nil && plan.from[sig.Path] != "" {
+			wirePath = plan.from[sig.Path]
+		}
+		basis, err := s.localFile(wirePath)
+		if err != nil {
+			proto.SendError(400, err.Error())
+			return err
+		}
+		fb, err := fileBlocks(basis, sig)
+		if err != nil {
+			proto.SendError(500, err.Error())
+			return fmt.Errorf("failed to compute signature of %s: %w", sig.Path, err)
+		}
+		fb.Path = wirePath
+		fb.Offset = partialSize(localPath, sig)
+		if fb.Offset > 0 {
+			resumed[localPath] = partialPath(localPath, sig)
+		}
+		needed[wirePath] = sig
+		blockIndex[wirePath] = fb
+		blocks = append(blocks, fb)
+	}
+
+	removeStalePartials(resumed)
 
-// ReceiveVolume receives volume data from a sync sender
-func (s *SyncManager) ReceiveVolume(conn net.Conn) error {
-	decoder := gob.NewDecoder(conn)
+	if err := proto.SendSignatures(blocks); err != nil {
+		return fmt.Errorf("failed to send block signatures: %w", err)
+	}
 
-	// Receive signature count
-	var count int
-	if err := decoder.Decode(&count); err != nil {
-		return fmt.Errorf("failed to receive signature count: %w", err)
+	var total, done int64
+	for _, fb := range blocks {
+		total += needed[fb.Path].Size - fb.Offset
 	}
+	s.report(0, total)
 
-	// Receive signatures and determine which files we need
-	var neededFiles []string
-	for i := 0; i < count; i++ {
-		var sig FileSignature
-		if err := decoder.Decode(&sig); err != nil {
-			return fmt.Errorf("failed to receive signature: %w", err)
+	var current *rebuild
+	defer func() {
+		if current != nil {
+			current.abort()
 		}
+	}()
 
-		// Check if we need this file
-		localPath := filepath.Join(s.localPath, sig.Path)
-		if needsUpdate(localPath, sig) {
-			neededFiles = append(neededFiles, sig.Path)
+	for {
+		msg, err := proto.Receive()
+		if err != nil {
+			// Keep what we have of the current file for the next push
+			if current != nil {
+				current.suspend()
+				current = nil
+			}
+			return fmt.Errorf("failed to receive delta: %w", err)
+		}
+
+		switch payload := msg.Payload.(type) {
+		case DeltaResponse:
+			if current == nil {
+				sig, ok := needed[payload.Path]
+				if !ok {
+					proto.SendAck(false, "unrequested file "+payload.Path)
+					return fmt.Errorf("received unrequested file %s", payload.Path)
+				}
+				delete(needed, payload.Path)
+				if current, err = s.startRebuild(sig, blockIndex[payload.Path]); err != nil {
+					proto.SendAck(false, err.Error())
+					return err
+				}
+			} else if current.blocks.Path != payload.Path {
+				proto.SendAck(false, "interleaved file "+payload.Path)
+				return fmt.Errorf("received %s before %s was complete", payload.Path, current.blocks.Path)
+			}
+
+			if err := current.apply(payload.Offset, payload.Ops); err != nil {
+				proto.SendAck(false, err.Error())
+				return fmt.Errorf("failed to apply delta to %s: %w", payload.Path, err)
+			}
+			done += current.written - payload.Offset
+			s.report(done, total)
+			if payload.Done {
+				err := current.finish()
+				current = nil
+				if err != nil {
+					proto.SendAck(false, err.Error())
+					return err
+				}
+				logrus.Debugf("Received file %s", payload.Path)
+			}
+
+		case AckMessage:
+			if current != nil || len(needed) > 0 {
+				proto.SendAck(false, "sync ended with files missing")
+				return fmt.Errorf("sender finished with %d files missing", len(needed))
+			}
+			if req.Partial {
+				keep = nil
+			}
+			if err := s.finishEntries(req.Files, links, keep); err != nil {
+				proto.SendAck(false, err.Error())
+				return err
+			}
+			if plan != nil {
+				if err := s.versions.commit(plan); err != nil {
+					proto.SendAck(false, err.Error())
+					return err
+				}
+			}
+			return proto.SendAck(true, "sync complete")
+
+		default:
+			return unexpectedMessage(msg, MsgDeltaResponse)
 		}
 	}
+}
 
-	// Send list of needed files
-	encoder := gob.NewEncoder(conn)
-	if err := encoder.Encode(neededFiles); err != nil {
-		return fmt.Errorf("failed to send needed files list: %w", err)
+// finishEntries completes a push once file data has been received: hardlinks
+// are made, entries not in keep are removed unless keep is nil, and metadata
+// is set
+func (s *SyncManager) finishEntries(entries, links []FileSignature, keep map[string]bool) error {
+	for _, sig := range links {
+		if err := s.link(sig); err != nil {
+			return err
+		}
 	}
 
-	// Receive each needed file
-	for range neededFiles {
-		if err := s.receiveFile(conn); err != nil {
-			return fmt.Errorf("failed to receive file: %w", err)
+	if keep != nil {
+		removed, err := s.prune(keep)
+		if err != nil {
+			return err
+		}
+		for _, path := range removed {
+			logrus.Debugf("Removed %s", path)
 		}
 	}
 
-	return nil
+	return s.applyMetadata(entries)
 }
 
-// needsUpdate checks if a local file needs to be updated
-func needsUpdate(localPath string, remoteSig FileSignature) bool {
-	info, err := os.Stat(localPath)
+// fileBlocks computes the block signature of our copy of a file, which has
+// no blocks if we do not have it
+func fileBlocks(localPath string, sig FileSignature) (FileBlocks, error) {
+	fb := FileBlocks{Path: sig.Path, BlockSize: BlockSizeFor(sig.Size)}
+
+	f, err := os.Open(localPath)
 	if os.IsNotExist(err) {
-		return true
+		return fb, nil
 	}
 	if err != nil {
-		return true
+		return fb, err
+	}
+	defer f.Close()
+
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		return fb, err
 	}
+	fb.Blocks, err = ComputeBlocks(f, fb.BlockSize)
+	return fb, err
+}
 
-	// Compare size and mod time
-	if info.Size() != remoteSig.Size {
-		return true
+// rebuild writes a new version of a file from our copy and a delta
+type rebuild struct {
+	sig     FileSignature
+	blocks  FileBlocks
+	target  string
+	basis   *os.File // nil when we have no copy
+	tmp     *os.File
+	hash    hash.Hash // Of everything written to tmp
+	written int64
+}
+
+// partialIDSize is how much of a file's checksum names its partial copy
+const partialIDSize = 8
+
+// partialPath is where the data received by an interrupted push of sig to
+// target is kept. It is named after the sender's checksum, so that only a
+// push of the same version resumes from it.
+func partialPath(target string, sig FileSignature) string {
+	name := fmt.Sprintf(".%s%s%x", filepath.Base(target), partialInfix, sig.Checksum[:partialIDSize])
+	return filepath.Join(filepath.Dir(target), name)
+}
+
+// removeStalePartials removes the partial copies interrupted pushes left of
+// the entries in resumed, except the one each entry was resumed from
+func removeStalePartials(resumed map[string]string) {
+	dirs := make(map[string]bool)
+	for target := range resumed {
+		dirs[filepath.Dir(target)] = true
 	}
-	if info.ModTime().UnixNano() < remoteSig.ModTime {
-		return true
+
+	for dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name := e.Name()
+			entry, ok := tempEntry(name)
+			if !ok || !strings.Contains(name, partialInfix) {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			if keep, ok := resumed[filepath.Join(dir, entry)]; !ok || keep == path {
+				continue
+			}
+			if err := os.Remove(path); err == nil {
+				logrus.Debugf("Removed stale partial copy %s", path)
+			}
+		}
 	}
+}
 
-	return false
+// partialSize returns how many bytes of sig an interrupted push left at
+// target, which a push of the same version resumes after
+func partialSize(target string, sig FileSignature) int64 {
+	if len(sig.Checksum) < partialIDSize {
+		return 0
+	}
+	info, err := os.Lstat(partialPath(target, sig))
+	if err != nil || !info.Mode().IsRegular() || info.Size() > sig.Size {
+		return 0
+	}
+	return info.Size()
 }
 
-// receiveFile receives a single file from the connection
-func (s *SyncManager) receiveFile(conn net.Conn) error {
-	decoder := gob.NewDecoder(conn)
+// startRebuild opens our copy of a file and a temporary file next to the
+// entry it becomes, which is sig's path. When fb resumes an interrupted
+// push, the temporary file is the partial one it left.
+func (s *SyncManager) startRebuild(sig FileSignature, fb FileBlocks) (*rebuild, error) {
+	target, err := s.localFile(sig.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return nil, err
+	}
 
-	// Receive file metadata
-	var meta FileSignature
-	if err := decoder.Decode(&meta); err != nil {
-		return err
+	r := &rebuild{sig: sig, blocks: fb, target: target, hash: sha256.New()}
+	mode := sig.Mode.Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	if len(fb.Blocks) > 0 {
+		basis, err := s.localFile(fb.Path)
+		if err != nil {
+			return nil, err
+		}
+		if r.basis, err = os.Open(basis); err != nil {
+			return nil, err
+		}
 	}
 
-	// Create directory if needed
-	fullPath := filepath.Join(s.localPath, meta.Path)
-	dir := filepath.Dir(fullPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
+	if fb.Offset > 0 {
+		if err := r.resume(fb.Offset); err != nil {
+			r.abort()
+			return nil, fmt.Errorf("failed to resume %s: %w", sig.Path, err)
+		}
+	} else if r.tmp, err = os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".budgie-*"); err != nil {
+		r.abort()
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
 	}
+	if err := r.tmp.Chmod(mode); err != nil {
+		r.abort()
+		return nil, err
+	}
+	return r, nil
+}
 
-	// Create file
-	f, err := os.Create(fullPath)
+// resume continues writing the partial file an interrupted push left,
+// hashing the offset bytes it keeps
+func (r *rebuild) resume(offset int64) error {
+	f, err := os.OpenFile(partialPath(r.target, r.sig), os.O_RDWR, 0)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	r.tmp = f
+	if _, err := io.CopyN(r.hash, f, offset); err != nil {
+		return err
+	}
+	if err := f.Truncate(offset); err != nil {
+		return err
+	}
+	r.written = offset
+	return nil
+}
 
-	// Receive content
-	_, err = io.CopyN(f, conn, meta.Size)
-	if err != nil {
+// apply writes the output of ops, which the sender says starts at offset
+func (r *rebuild) apply(offset int64, ops []DeltaOp) error {
+	if offset != r.written {
+		return fmt.Errorf("expected data at byte %d, got %d", r.written, offset)
+	}
+
+	var basis io.ReaderAt
+	if r.basis != nil {
+		basis = r.basis
+	}
+	if err := ApplyDelta(basis, r.blocks.BlockSize, ops, io.MultiWriter(r.tmp, r.hash)); err != nil {
 		return err
 	}
+	r.written, _ = r.tmp.Seek(0, io.SeekCurrent)
+	return nil
+}
 
-	logrus.Debugf("Received file %s (%d bytes)", meta.Path, meta.Size)
+// finish verifies the rebuilt file and replaces our copy with it
+func (r *rebuild) finish() error {
+	if r.basis != nil {
+		r.basis.Close()
+	}
+	if err := r.tmp.Close(); err != nil {
+		os.Remove(r.tmp.Name())
+		return err
+	}
+	if sum := r.hash.Sum(nil); len(r.sig.Checksum) > 0 && !bytes.Equal(sum, r.sig.Checksum) {
+		os.Remove(r.tmp.Name())
+		return fmt.Errorf("checksum mismatch for %s: got %x, want %x", r.sig.Path, sum, r.sig.Checksum)
+	}
+	if err := os.Rename(r.tmp.Name(), r.target); err != nil {
+		os.Remove(r.tmp.Name())
+		return fmt.Errorf("failed to replace %s: %w", r.blocks.Path, err)
+	}
 	return nil
 }
 
-// SyncVolume synchronizes a local volume with a remote node
-func (s *SyncManager) SyncVolume(srcPath, dstAddr string) error {
-	conn, err := net.Dial("tcp", dstAddr)
+// suspend keeps the data received so far when a push is interrupted, for
+// the next push of the same version to resume from
+func (r *rebuild) suspend() {
+	if r.written == 0 || len(r.sig.Checksum) < partialIDSize {
+		r.abort()
+		return
+	}
+	if r.basis != nil {
+		r.basis.Close()
+	}
+	r.tmp.Close()
+
+	partial := partialPath(r.target, r.sig)
+	if r.tmp.Name() != partial {
+		if err := os.Rename(r.tmp.Name(), partial); err != nil {
+			os.Remove(r.tmp.Name())
+			return
+		}
+	}
+	logrus.Debugf("Kept %d bytes of %s to resume", r.written, r.sig.Path)
+}
+
+// abort discards the rebuilt file, leaving our copy untouched
+func (r *rebuild) abort() {
+	if r.basis != nil {
+		r.basis.Close()
+	}
+	if r.tmp != nil {
+		r.tmp.Close()
+		os.Remove(r.tmp.Name())
+	}
+}
+
+// needsUpdate checks if a local file needs to be updated. Modification
+// times are synced, so a copy with another time has changed since.
+func needsUpdate(localPath string, remoteSig FileSignature) bool {
+	info, err := os.Lstat(localPath)
+	if os.IsNotExist(err) {
+		return true
+	}
+	if err != nil || !info.Mode().IsRegular() {
+		return true
+	}
+
+	// Compare size and mod time
+	if info.Size() != remoteSig.Size {
+		return true
+	}
+	if info.ModTime().UnixNano() != remoteSig.ModTime {
+		return true
+	}
+
+	return false
+}
+
+// SyncVolume pushes the volume to the sync server at dstAddr, which only
+// accepts pushes from clients with a certificate of the cluster CA
+func (s *SyncManager) SyncVolume(client *TLSClient, dstAddr string, dest Destination) error {
+	conn, err := client.Dial(dstAddr, 30*time.Second)
 	if err != nil {
 		return fmt.Errorf("failed to connect: %w", err)
 	}
 	defer conn.Close()
 
-	logrus.Infof("Syncing volume %s to %s", srcPath, dstAddr)
+	logrus.Infof("Syncing volume %s to %s", s.localPath, dstAddr)
 
-	if err := s.SendVolume(conn); err != nil {
+	if err := s.SendVolume(conn, dest); err != nil {
 		return err
 	}
 
@@ -289,8 +933,9 @@ func (s *SyncManager) SyncVolume(srcPath, dstAddr string) error {
 	return nil
 }
 
-// SyncContainerData synchronizes all rw volumes for a container
-func (s *SyncManager) SyncContainerData(ctr *types.Container, remoteIP string) error {
+// SyncContainerData synchronizes all rw volumes for a container to the
+// container remoteID on the node at remoteIP
+func (s *SyncManager) SyncContainerData(client *TLSClient, ctr *types.Container, remoteIP, remoteID string) error {
 	for _, vol := range ctr.Volumes {
 		if vol.Mode == "rw" {
 			localVolPath := vol.Source
@@ -306,7 +951,8 @@ func (s *SyncManager) SyncContainerData(ctr *types.Container, remoteIP string) e
 				return fmt.Errorf("failed to create sync manager for %s: %w", vol.Target, err)
 			}
 
-			if err := mgr.SyncVolume(localVolPath, syncAddr); err != nil {
+			dest := Destination{ContainerID: remoteID, VolumeTarget: vol.Target}
+			if err := mgr.SyncVolume(client, syncAddr, dest); err != nil {
 				return fmt.Errorf("failed to sync volume %s: %w", vol.Target, err)
 			}
 		}
@@ -317,14 +963,15 @@ func (s *SyncManager) SyncContainerData(ctr *types.Container, remoteIP string) e
 
 // VolumeWatcher watches for volume changes
 type VolumeWatcher struct {
-	manager   *SyncManager
-	watcher   *fsnotify.Watcher
-	done      chan struct{}
-	OnChange  func(path string)
+	manager  *SyncManager
+	watcher  *fsnotify.Watcher
+	done     chan struct{}
+	OnChange func(path string) // Called with the volume root when events were lost
 }
 
-// NewVolumeWatcher creates a new volume watcher
-func NewVolumeWatcher(path string) (*VolumeWatcher, error) {
+// NewVolumeWatcher creates a volume watcher that calls onChange with the
+// path of every entry that is created, written, removed, renamed or chmodded
+func NewVolumeWatcher(path string, onChange func(path string)) (*VolumeWatcher, error) {
 	manager, err := NewSyncManager(path)
 	if err != nil {
 		return nil, err
@@ -335,32 +982,37 @@ func NewVolumeWatcher(path string) (*VolumeWatcher, error) {
 		return nil, err
 	}
 
+	vw := &VolumeWatcher{
+		manager:  manager,
+		watcher:  watcher,
+		done:     make(chan struct{}),
+		OnChange: onChange,
+	}
+
 	// Add all directories recursively
-	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return watcher.Add(p)
-		}
-		return nil
-	})
-	if err != nil {
+	if err := vw.addTree(path); err != nil {
 		watcher.Close()
 		return nil, err
 	}
 
-	vw := &VolumeWatcher{
-		manager: manager,
-		watcher: watcher,
-		done:    make(chan struct{}),
-	}
-
 	go vw.watch()
 
 	return vw, nil
 }
 
+// addTree watches a directory and every directory below it
+func (vw *VolumeWatcher) addTree(root string) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return vw.watcher.Add(p)
+		}
+		return nil
+	})
+}
+
 func (vw *VolumeWatcher) watch() {
 	for {
 		select {
@@ -372,18 +1024,26 @@ func (vw *VolumeWatcher) watch() {
 				return
 			}
 
-			if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove) != 0 {
-				logrus.Infof("Volume changed: %s", event.Name)
-				if vw.OnChange != nil {
-					vw.OnChange(event.Name)
-				}
+			// Files being received are synced once they replace their entry
+			if isTempFile(filepath.Base(event.Name)) {
+				continue
 			}
 
-			// Add new directories to watch
+			// Watch new directories, including any created inside them
+			// before the watch was added
 			if event.Op&fsnotify.Create != 0 {
-				info, err := os.Stat(event.Name)
+				info, err := os.Lstat(event.Name)
 				if err == nil && info.IsDir() {
-					vw.watcher.Add(event.Name)
+					if err := vw.addTree(event.Name); err != nil {
+						logrus.Debugf("Failed to watch %s: %v", event.Name, err)
+					}
+				}
+			}
+
+			if event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Remove|fsnotify.Rename|fsnotify.Chmod) != 0 {
+				logrus.Debugf("Volume changed: %s", event.Name)
+				if vw.OnChange != nil {
+					vw.OnChange(event.Name)
 				}
 			}
 
@@ -392,6 +1052,9 @@ func (vw *VolumeWatcher) watch() {
 				return
 			}
 			logrus.Errorf("Watcher error: %v", err)
+			if errors.Is(err, fsnotify.ErrEventOverflow) && vw.OnChange != nil {
+				vw.OnChange(vw.manager.localPath)
+			}
 		}
 	}
 }
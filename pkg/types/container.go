@@ -3,6 +3,8 @@ package types
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -23,6 +25,14 @@ func (s ContainerState) String() string {
 	return string(s)
 }
 
+// ReplicationRole is the data role of a container within its replica set
+type ReplicationRole string
+
+const (
+	RolePrimary ReplicationRole = "primary"
+	RoleReplica ReplicationRole = "replica"
+)
+
 // PortMapping defines a port mapping between container and host
 type PortMapping struct {
 	ContainerPort int    `yaml:"container_port" json:"container_port"`
@@ -32,9 +42,43 @@ type PortMapping struct {
 
 // VolumeMapping defines a volume mount
 type VolumeMapping struct {
-	Source string `yaml:"source" json:"source"`
-	Target string `yaml:"target" json:"target"`
-	Mode   string `yaml:"mode" json:"mode"` // "rw" or "ro"
+	Source   string           `yaml:"source" json:"source"`
+	Target   string           `yaml:"target" json:"target"`
+	Mode     string           `yaml:"mode" json:"mode"`                             // "rw" or "ro"
+	Sync     string           `yaml:"sync,omitempty" json:"sync,omitempty"`         // "one-way" (default) or "bidirectional"
+	Conflict ConflictStrategy `yaml:"conflict,omitempty" json:"conflict,omitempty"` // Strategy for bidirectional conflicts
+}
+
+// Volume sync modes
+const (
+	SyncOneWay        = "one-way"       // The primary pushes to replicas
+	SyncBidirectional = "bidirectional" // Every replica's changes reach the others
+)
+
+// ConflictStrategy decides which version of an entry changed on both sides
+// of a bidirectional volume is kept
+type ConflictStrategy string
+
+const (
+	LastWriterWins ConflictStrategy = "last-writer-wins" // The most recent change
+	PrimaryWins    ConflictStrategy = "primary-wins"     // The primary's change
+	KeepBoth       ConflictStrategy = "keep-both"        // The most recent change, with the other saved as a .conflict-<node> copy
+)
+
+// ParseConflictStrategy parses a strategy name; empty means last-writer-wins
+func ParseConflictStrategy(name string) (ConflictStrategy, error) {
+	switch s := ConflictStrategy(name); s {
+	case "":
+		return LastWriterWins, nil
+	case LastWriterWins, PrimaryWins, KeepBoth:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown conflict strategy %q", name)
+}
+
+// Bidirectional returns true if changes made on replicas are synced too
+func (v VolumeMapping) Bidirectional() bool {
+	return v.Mode == "rw" && v.Sync == SyncBidirectional
 }
 
 // HealthCheck defines health check configuration
@@ -47,8 +91,35 @@ type HealthCheck struct {
 
 // ReplicasConfig defines replica configuration
 type ReplicasConfig struct {
-	Min int `yaml:"min" json:"min"`
-	Max int `yaml:"max" json:"max"`
+	Min       int              `yaml:"min" json:"min"`
+	Max       int              `yaml:"max" json:"max"`
+	Desired   *int             `yaml:"-" json:"desired,omitempty"` // Set by `budgie scale` and the autoscaler
+	Autoscale *AutoscaleConfig `yaml:"autoscale" json:"autoscale,omitempty"`
+}
+
+// AutoscaleConfig defines metric targets for scaling between min and max replicas
+type AutoscaleConfig struct {
+	TargetCPUPercent        float64       `yaml:"target_cpu_percent" json:"target_cpu_percent"`                 // Average CPU per replica, percent of one core
+	TargetMemoryPercent     float64       `yaml:"target_memory_percent" json:"target_memory_percent"`           // Average memory per replica, percent of its limit
+	TargetRequestsPerSecond float64       `yaml:"target_requests_per_second" json:"target_requests_per_second"` // Requests per second per replica
+	ScaleUpCooldown         time.Duration `yaml:"scale_up_cooldown" json:"scale_up_cooldown"`
+	ScaleDownCooldown       time.Duration `yaml:"scale_down_cooldown" json:"scale_down_cooldown"`
+	ScaleUpStabilization    time.Duration `yaml:"scale_up_stabilization" json:"scale_up_stabilization"`
+	ScaleDownStabilization  time.Duration `yaml:"scale_down_stabilization" json:"scale_down_stabilization"`
+}
+
+// PlacementConfig restricts which nodes a container may run on
+type PlacementConfig struct {
+	Constraints  []string `yaml:"constraints" json:"constraints,omitempty"`     // Node label expressions, e.g. "arch==arm64"
+	Affinity     []string `yaml:"affinity" json:"affinity,omitempty"`           // Services that must already run on the node
+	AntiAffinity []string `yaml:"anti_affinity" json:"anti_affinity,omitempty"` // Services that must not run on the node
+}
+
+// UpdateConfig defines how replicas are replaced during a rolling update
+type UpdateConfig struct {
+	MaxSurge       int           `yaml:"max_surge" json:"max_surge"`             // Extra replicas allowed above the desired count
+	MaxUnavailable int           `yaml:"max_unavailable" json:"max_unavailable"` // Replicas allowed to be down at once
+	HealthTimeout  time.Duration `yaml:"health_timeout" json:"health_timeout"`   // Time a new replica has to become healthy
 }
 
 // ResourceLimits defines resource constraints for containers
@@ -76,30 +147,35 @@ type ImageConfig struct {
 
 // Container represents a budgie container
 type Container struct {
-	ID            string          `json:"id"`
-	Name          string          `json:"name"`
-	State         ContainerState  `json:"state"`
-	Image         ImageConfig     `json:"image"`
-	Ports         []PortMapping   `json:"ports"`
-	Volumes       []VolumeMapping `json:"volumes"`
-	Env           []string        `json:"env"`
-	Health        *HealthCheck    `json:"health_check,omitempty"`
-	Replicas      *ReplicasConfig `json:"replicas,omitempty"`
-	Resources     *ResourceLimits `json:"resources,omitempty"`
-	RestartPolicy *RestartPolicy  `json:"restart_policy,omitempty"`
-	DependsOn     []string        `json:"depends_on,omitempty"`
-	Network       string          `json:"network,omitempty"`
-	NetworkConfig *NetworkConfig  `json:"network_config,omitempty"`
+	ID            string           `json:"id"`
+	Name          string           `json:"name"`
+	State         ContainerState   `json:"state"`
+	Image         ImageConfig      `json:"image"`
+	Ports         []PortMapping    `json:"ports"`
+	Volumes       []VolumeMapping  `json:"volumes"`
+	Env           []string         `json:"env"`
+	Health        *HealthCheck     `json:"health_check,omitempty"`
+	Replicas      *ReplicasConfig  `json:"replicas,omitempty"`
+	Update        *UpdateConfig    `json:"update,omitempty"`
+	Placement     *PlacementConfig `json:"placement,omitempty"`
+	Resources     *ResourceLimits  `json:"resources,omitempty"`
+	RestartPolicy *RestartPolicy   `json:"restart_policy,omitempty"`
+	DependsOn     []string         `json:"depends_on,omitempty"`
+	Network       string           `json:"network,omitempty"`
+	NetworkConfig *NetworkConfig   `json:"network_config,omitempty"`
 
 	// Runtime fields
-	BundlePath   string    `json:"-"`                       // Path to .bun file
-	NodeID       string    `json:"node_id"`                 // Primary node ID
-	Peers        []string  `json:"peers"`                   // Replica node IDs
-	CreatedAt    time.Time `json:"created_at"`
-	StartedAt    time.Time `json:"started_at"`
-	ExitedAt     time.Time `json:"exited_at,omitempty"`
-	Pid          int       `json:"pid"`                     // Container process ID
-	RestartCount int       `json:"restart_count,omitempty"` // Number of times container has been restarted
+	BundlePath   string          `json:"-"`                     // Path to .bun file
+	NodeID       string          `json:"node_id"`               // Node running the container
+	Peers        []string        `json:"peers"`                 // Replica node IDs; for a replica, its primary's node ID
+	Role         ReplicationRole `json:"role,omitempty"`        // Empty for containers that were never replicated, which act as primary
+	ReplicaSet   string          `json:"replica_set,omitempty"` // ID of the container the replica set started from
+	Epoch        uint64          `json:"epoch,omitempty"`       // Failover generation; the primary with the highest epoch wins
+	CreatedAt    time.Time       `json:"created_at"`
+	StartedAt    time.Time       `json:"started_at"`
+	ExitedAt     time.Time       `json:"exited_at,omitempty"`
+	Pid          int             `json:"pid"`                     // Container process ID
+	RestartCount int             `json:"restart_count,omitempty"` // Number of times container has been restarted
 }
 
 // NetworkConfig defines network settings for a container
@@ -153,3 +229,33 @@ func (c *Container) IsRunning() bool {
 func (c *Container) IsStopped() bool {
 	return c.State == StateStopped
 }
+
+// IsReplica returns true if the container follows a primary on another node
+func (c *Container) IsReplica() bool {
+	return c.Role == RoleReplica
+}
+
+// HasBidirectionalVolumes returns true if the container has a volume that
+// its replicas write to as well
+func (c *Container) HasBidirectionalVolumes() bool {
+	for _, vol := range c.Volumes {
+		if vol.Bidirectional() {
+			return true
+		}
+	}
+	return false
+}
+
+// ReplicaSetID returns the ID shared by a primary and its replicas
+func (c *Container) ReplicaSetID() string {
+	if c.ReplicaSet != "" {
+		return c.ReplicaSet
+	}
+	return c.ID
+}
+
+// ServiceName returns the service a container belongs to. Replicas joined
+// with chirp are named <service>-replica.
+func (c *Container) ServiceName() string {
+	return strings.TrimSuffix(c.Name, "-replica")
+}
@@ -2,8 +2,10 @@ package e2e
 
 import (
 	"context"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
@@ -115,14 +117,25 @@ func TestVolumeSync(t *testing.T) {
 		}
 	}
 
-	// Start sync server
-	server, err := sync.NewServer(0) // Use any available port
+	// Start sync server over mutual TLS, which pushes require
+	certDir := t.TempDir()
+	ca, err := sync.LoadOrCreateCA(certDir)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	certFile, keyFile := filepath.Join(certDir, "node.crt"), filepath.Join(certDir, "node.key")
+	if err := ca.IssueCertificate([]string{"127.0.0.1"}, time.Hour, certFile, keyFile); err != nil {
+		t.Fatalf("Failed to issue certificate: %v", err)
+	}
+	tlsCfg := sync.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile, CAFile: ca.CertFile}
+
+	server, err := sync.NewTLSServer(0, tlsCfg) // Use any available port
 	if err != nil {
 		t.Fatalf("Failed to create sync server: %v", err)
 	}
 	defer server.Stop()
 
-	server.RegisterVolume("test-container", dstDir)
+	server.RegisterVolume("test-container", "/data", dstDir)
 	go server.Start()
 
 	// Give server time to start
@@ -134,9 +147,14 @@ func TestVolumeSync(t *testing.T) {
 		t.Fatalf("Failed to create source sync manager: %v", err)
 	}
 
+	client, err := sync.NewTLSClient(tlsCfg)
+	if err != nil {
+		t.Fatalf("Failed to create sync client: %v", err)
+	}
+
 	// Get server address
-	addr := server.Addr().String()
-	if err := srcManager.SyncVolume(srcDir, addr); err != nil {
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(server.Addr().(*net.TCPAddr).Port))
+	if err := srcManager.SyncVolume(client, addr, sync.Destination{ContainerID: "test-container", VolumeTarget: "/data"}); err != nil {
 		t.Fatalf("Sync failed: %v", err)
 	}
 
@@ -174,7 +192,7 @@ func TestSyncServer(t *testing.T) {
 	}
 	defer server.Stop()
 
-	server.RegisterVolume("test", tmpDir)
+	server.RegisterVolume("test", "/data", tmpDir)
 	go server.Start()
 
 	// Verify server is listening